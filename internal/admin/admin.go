@@ -0,0 +1,244 @@
+// Package admin exposes an authenticated admin API driving Argotails imperatively. It implements
+// the unary JSON flavor of the Connect protocol (https://connectrpc.com/docs/protocol) only, so it
+// can be called by the Connect clients generated from admin.proto configured with the JSON codec
+// (e.g. connect.WithProtoJSON()), by `buf curl` or by a plain HTTP client; the binary protobuf
+// codec is not supported.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/chezmoidotsh/argotails/internal/api"
+)
+
+// ServicePath is the path prefix of the admin service procedures.
+const ServicePath = "/argotails.admin.v1.AdminService/"
+
+// ErrNotFound is returned (wrapped) by the backend when the requested device does not exist; it
+// is reported with the not_found code.
+var ErrNotFound = errors.New("not found")
+
+type (
+	// Backend performs the operations of the admin service.
+	Backend interface {
+		// ForceSync reconciles the Tailscale device with the given name, hostname or ID and
+		// returns its secret as "namespace/name"; it fails with the failed_precondition code
+		// while the mutations are paused.
+		ForceSync(ctx context.Context, device string) (string, error)
+		// EvaluateFilter returns whether the Tailscale device with the given name, hostname or ID
+		// matches the device filters and, if so, its secret as "namespace/name".
+		EvaluateFilter(ctx context.Context, device string) (EvaluateFilterResponse, error)
+		// SetPaused pauses or resumes the mutations on behalf of the admin API; they stay paused
+		// while paused by another source (e.g. the pause ConfigMap).
+		SetPaused(paused bool)
+		// Paused returns true if the mutations are paused.
+		Paused() bool
+		// SyncStatus returns the state of the time-based synchronization loop.
+		SyncStatus() api.SyncStatus
+		// SetVerbosity changes the log verbosity (a V-level or a level name), restoring the
+		// configured one after the given duration if positive.
+		SetVerbosity(verbosity string, duration time.Duration) (LogLevelResponse, error)
+		// Verbosity returns the current log verbosity.
+		Verbosity() LogLevelResponse
+	}
+
+	// DeviceRequest is the request of the procedures targeting a Tailscale device.
+	DeviceRequest struct {
+		// Device is the name, hostname or ID of the Tailscale device.
+		Device string `json:"device"`
+	}
+
+	// ForceSyncResponse is the response of ForceSync.
+	ForceSyncResponse struct {
+		Secret string `json:"secret"`
+	}
+
+	// EvaluateFilterResponse is the response of EvaluateFilter.
+	EvaluateFilterResponse struct {
+		Device  string `json:"device"`
+		Matched bool   `json:"matched"`
+		Secret  string `json:"secret,omitempty"`
+	}
+
+	// PauseResponse is the response of Pause and Resume.
+	PauseResponse struct {
+		Paused bool `json:"paused"`
+	}
+
+	// SetLogLevelRequest is the request of SetLogLevel.
+	SetLogLevelRequest struct {
+		// Verbosity is the V-level (0 to 128) or the level name (e.g. "debug") to log at.
+		Verbosity string `json:"verbosity"`
+		// Duration is how long the verbosity is kept before restoring the configured one, as a Go
+		// duration (e.g. "10m"); empty to keep it.
+		Duration string `json:"duration,omitempty"`
+	}
+
+	// LogLevelResponse is the response of SetLogLevel and GetLogLevel.
+	LogLevelResponse struct {
+		Verbosity int    `json:"verbosity"`
+		Until     string `json:"until,omitempty"`
+	}
+
+	// StatusResponse is the response of GetStatus.
+	StatusResponse struct {
+		Paused bool           `json:"paused"`
+		Sync   api.SyncStatus `json:"sync"`
+	}
+
+	// Error is an error of the Connect protocol.
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	// procedure is a unary procedure of the admin service.
+	procedure func(ctx context.Context, raw []byte) (any, error)
+)
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+// status returns the HTTP status of the error, as defined by the Connect protocol.
+func (e *Error) status() int {
+	switch e.Code {
+	case "invalid_argument", "failed_precondition":
+		return http.StatusBadRequest
+	case "unauthenticated":
+		return http.StatusUnauthorized
+	case "not_found":
+		return http.StatusNotFound
+	case "unimplemented":
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// NewHandler returns the HTTP handler of the admin service, serving the procedures under
+// ServicePath. Requests must be authenticated with the given bearer token.
+func NewHandler(backend Backend, token string) http.Handler {
+	procedures := map[string]procedure{
+		"ForceSync": withDevice(func(ctx context.Context, device string) (any, error) {
+			secret, err := backend.ForceSync(ctx, device)
+			return ForceSyncResponse{Secret: secret}, err
+		}),
+		"EvaluateFilter": withDevice(func(ctx context.Context, device string) (any, error) {
+			return backend.EvaluateFilter(ctx, device)
+		}),
+		"Pause": func(context.Context, []byte) (any, error) {
+			backend.SetPaused(true)
+			return PauseResponse{Paused: backend.Paused()}, nil
+		},
+		"Resume": func(context.Context, []byte) (any, error) {
+			backend.SetPaused(false)
+			return PauseResponse{Paused: backend.Paused()}, nil
+		},
+		"GetStatus": func(context.Context, []byte) (any, error) {
+			return StatusResponse{Paused: backend.Paused(), Sync: backend.SyncStatus()}, nil
+		},
+		"SetLogLevel": func(_ context.Context, raw []byte) (any, error) {
+			var req SetLogLevelRequest
+			if err := json.Unmarshal(raw, &req); err != nil {
+				return nil, &Error{Code: "invalid_argument", Message: fmt.Sprintf("invalid request: %s", err)}
+			}
+			var duration time.Duration
+			if req.Duration != "" {
+				var err error
+				if duration, err = time.ParseDuration(req.Duration); err != nil || duration < 0 {
+					return nil, &Error{Code: "invalid_argument", Message: fmt.Sprintf("invalid duration %q", req.Duration)}
+				}
+			}
+			return backend.SetVerbosity(req.Verbosity, duration)
+		},
+		"GetLogLevel": func(context.Context, []byte) (any, error) {
+			return backend.Verbosity(), nil
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := ctrllog.FromContext(r.Context())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			w.Header().Set("Accept-Post", "application/json")
+			http.Error(w, "415 Unsupported Media Type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			writeError(w, &Error{Code: "unauthenticated", Message: "invalid bearer token"})
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, ServicePath)
+		call, exists := procedures[name]
+		if !exists {
+			writeError(w, &Error{Code: "unimplemented", Message: fmt.Sprintf("unknown procedure %q", name)})
+			return
+		}
+
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			writeError(w, &Error{Code: "invalid_argument", Message: fmt.Sprintf("invalid request: %s", err)})
+			return
+		}
+
+		log = log.WithValues("procedure", name)
+		res, err := call(ctrllog.IntoContext(r.Context(), log), raw)
+		if err != nil {
+			var cerr *Error
+			switch {
+			case errors.As(err, &cerr):
+			case errors.Is(err, ErrNotFound):
+				cerr = &Error{Code: "not_found", Message: err.Error()}
+			default:
+				log.Error(err, "Admin procedure failed")
+				cerr = &Error{Code: "internal", Message: err.Error()}
+			}
+			writeError(w, cerr)
+			return
+		}
+
+		log.V(1).Info("Admin procedure called")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Error(err, "Failed to encode admin response")
+		}
+	})
+}
+
+// withDevice decodes the DeviceRequest of the procedures targeting a Tailscale device.
+func withDevice(call func(ctx context.Context, device string) (any, error)) procedure {
+	return func(ctx context.Context, raw []byte) (any, error) {
+		var req DeviceRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, &Error{Code: "invalid_argument", Message: fmt.Sprintf("invalid request: %s", err)}
+		}
+		if req.Device == "" {
+			return nil, &Error{Code: "invalid_argument", Message: "device is required"}
+		}
+		return call(ctx, req.Device)
+	}
+}
+
+// writeError writes the given error as a Connect protocol error.
+func writeError(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status())
+	_ = json.NewEncoder(w).Encode(err)
+}
@@ -0,0 +1,139 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/admin"
+	"github.com/chezmoidotsh/argotails/internal/api"
+)
+
+type fakeBackend struct {
+	paused    bool
+	synced    []string
+	verbosity int
+	duration  time.Duration
+}
+
+func (b *fakeBackend) ForceSync(_ context.Context, device string) (string, error) {
+	switch device {
+	case "a.fake.ts.net":
+		b.synced = append(b.synced, device)
+		return "argocd/a.fake.ts.net", nil
+	case "broken.fake.ts.net":
+		return "", errors.New("boom")
+	}
+	return "", fmt.Errorf("%w: device %q", admin.ErrNotFound, device)
+}
+
+func (b *fakeBackend) EvaluateFilter(_ context.Context, device string) (admin.EvaluateFilterResponse, error) {
+	return admin.EvaluateFilterResponse{Device: device, Matched: true, Secret: "argocd/" + device}, nil
+}
+
+func (b *fakeBackend) SetPaused(paused bool) { b.paused = paused }
+func (b *fakeBackend) Paused() bool          { return b.paused }
+func (b *fakeBackend) SyncStatus() api.SyncStatus {
+	return api.SyncStatus{Interval: api.Duration(time.Minute)}
+}
+
+func (b *fakeBackend) SetVerbosity(verbosity string, duration time.Duration) (admin.LogLevelResponse, error) {
+	if verbosity != "4" {
+		return admin.LogLevelResponse{}, &admin.Error{Code: "invalid_argument", Message: "invalid verbosity"}
+	}
+	b.verbosity, b.duration = 4, duration
+	return b.Verbosity(), nil
+}
+
+func (b *fakeBackend) Verbosity() admin.LogLevelResponse {
+	return admin.LogLevelResponse{Verbosity: b.verbosity}
+}
+
+func TestHandler(t *testing.T) {
+	backend := &fakeBackend{}
+	handler := admin.NewHandler(backend, "token")
+	call := func(procedure, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, admin.ServicePath+procedure, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) map[string]any {
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	rec := call("GetStatus", "invalid", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "unauthenticated", decode(rec)["code"])
+
+	rec = call("ForceSync", "token", `{"device":"a.fake.ts.net"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "argocd/a.fake.ts.net", decode(rec)["secret"])
+	assert.Equal(t, []string{"a.fake.ts.net"}, backend.synced)
+
+	rec = call("ForceSync", "token", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid_argument", decode(rec)["code"])
+
+	rec = call("ForceSync", "token", `{"device":"unknown"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "not_found", decode(rec)["code"])
+
+	rec = call("ForceSync", "token", `{"device":"broken.fake.ts.net"}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "internal", decode(rec)["code"])
+
+	rec = call("Pause", "token", `{}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, backend.paused)
+
+	rec = call("GetStatus", "token", `{}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	res := decode(rec)
+	assert.Equal(t, true, res["paused"])
+	assert.Equal(t, "1m0s", res["sync"].(map[string]any)["interval"])
+
+	rec = call("Resume", "token", `{}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, backend.paused)
+
+	rec = call("EvaluateFilter", "token", `{"device":"b.fake.ts.net"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, decode(rec)["matched"])
+
+	rec = call("SetLogLevel", "token", `{"verbosity":"4","duration":"10m"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 4.0, decode(rec)["verbosity"])
+	assert.Equal(t, 10*time.Minute, backend.duration)
+
+	rec = call("SetLogLevel", "token", `{"verbosity":"4","duration":"soon"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = call("SetLogLevel", "token", `{"verbosity":"verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = call("GetLogLevel", "token", `{}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 4.0, decode(rec)["verbosity"])
+
+	rec = call("Unknown", "token", `{}`)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	req := httptest.NewRequest(http.MethodPost, admin.ServicePath+"GetStatus", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/proto")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
@@ -0,0 +1,107 @@
+// Package api exposes a read-only HTTP API on the state of the ArgoCD clusters managed by Argotails.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+type (
+	// Cluster is the representation of a managed ArgoCD cluster.
+	Cluster struct {
+		Name          string             `json:"name"`
+		Namespace     string             `json:"namespace"`
+		Server        string             `json:"server"`
+		Pending       bool               `json:"pending"`
+		Device        Device             `json:"device"`
+		LastReconcile *reconciler.Status `json:"lastReconcile,omitempty"`
+	}
+
+	// Device is the Tailscale device metadata of a managed ArgoCD cluster.
+	Device struct {
+		ID        string   `json:"id"`
+		Hostname  string   `json:"hostname"`
+		Tailnet   string   `json:"tailnet,omitempty"`
+		Address   string   `json:"address,omitempty"`
+		Addresses []string `json:"addresses,omitempty"`
+		OS        string   `json:"os"`
+		Version   string   `json:"version"`
+		Tags      []string `json:"tags"`
+	}
+)
+
+// NewClustersHandler returns an HTTP handler listing, as JSON, all ArgoCD clusters managed by the
+// controller inside the given namespace along with their last reconciliation status.
+func NewClustersHandler(reader client.Reader, namespace, managedBy string, statuses *reconciler.StatusRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := ctrllog.FromContext(r.Context())
+
+		var secrets corev1.SecretList
+		err := reader.List(r.Context(), &secrets,
+			client.InNamespace(namespace),
+			client.MatchingLabels{"apps.kubernetes.io/managed-by": managedBy},
+		)
+		if err != nil {
+			log.Error(err, "Failed to list managed secrets")
+			http.Error(w, "500 Failed to list managed clusters", http.StatusInternalServerError)
+			return
+		}
+
+		clusters := make([]Cluster, 0, len(secrets.Items))
+		for _, secret := range secrets.Items {
+			clusters = append(clusters, toCluster(secret, statuses))
+		}
+		slices.SortFunc(clusters, func(a, b Cluster) int { return strings.Compare(a.Name, b.Name) })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(clusters); err != nil {
+			log.Error(err, "Failed to encode managed clusters")
+		}
+	})
+}
+
+// toCluster converts a managed secret into its API representation.
+func toCluster(secret corev1.Secret, statuses *reconciler.StatusRecorder) Cluster {
+	cluster := Cluster{
+		Name:      secret.Name,
+		Namespace: secret.Namespace,
+		Server:    string(secret.Data[argocd.KeyServer]),
+		Pending:   secret.Annotations[reconciler.AnnotationApproved] == "false",
+		Device: Device{
+			ID:       secret.Annotations[reconciler.AnnotationDeviceID],
+			Hostname: secret.Annotations[reconciler.AnnotationDeviceHostname],
+			Tailnet:  secret.Annotations[reconciler.AnnotationDeviceTailnet],
+			Address:  secret.Annotations[reconciler.AnnotationDeviceAddress],
+			OS:       secret.Labels[reconciler.LabelDeviceOS],
+			Version:  secret.Labels[reconciler.LabelDeviceVersion],
+			Tags:     []string{},
+		},
+	}
+
+	if addresses := secret.Annotations[reconciler.AnnotationDeviceAddresses]; addresses != "" {
+		cluster.Device.Addresses = strings.Split(addresses, ",")
+	}
+
+	for label := range secret.Labels {
+		if tag, found := strings.CutPrefix(label, reconciler.LabelDeviceTagsPrefix); found {
+			cluster.Device.Tags = append(cluster.Device.Tags, "tag:"+tag)
+		}
+	}
+	slices.Sort(cluster.Device.Tags)
+
+	if statuses != nil {
+		if status, ok := statuses.Get(secret.Name); ok {
+			cluster.LastReconcile = &status
+		}
+	}
+	return cluster
+}
@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/chezmoidotsh/argotails/internal/api"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+func TestClustersHandler(t *testing.T) {
+	ks := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "B.fake.ts.net",
+				Namespace: "argocd",
+				Annotations: map[string]string{
+					reconciler.AnnotationDeviceID:       "device-b",
+					reconciler.AnnotationDeviceHostname: "B",
+					reconciler.AnnotationApproved:       "false",
+				},
+				Labels: map[string]string{"apps.kubernetes.io/managed-by": "argotails"},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "A.fake.ts.net",
+				Namespace: "argocd",
+				Annotations: map[string]string{
+					reconciler.AnnotationDeviceID:        "device-a",
+					reconciler.AnnotationDeviceHostname:  "A",
+					reconciler.AnnotationDeviceTailnet:   "fake.ts.net",
+					reconciler.AnnotationDeviceAddress:   "100.64.0.1",
+					reconciler.AnnotationDeviceAddresses: "100.64.0.1,fd7a:115c:a1e0::1",
+				},
+				Labels: map[string]string{
+					"apps.kubernetes.io/managed-by":           "argotails",
+					reconciler.LabelDeviceOS:                  "linux",
+					reconciler.LabelDeviceVersion:             "v1.2.3",
+					reconciler.LabelDeviceTagsPrefix + "tag2": "",
+					reconciler.LabelDeviceTagsPrefix + "tag1": "",
+				},
+			},
+			Data: map[string][]byte{"server": []byte("https://A.fake.ts.net")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "unmanaged",
+				Namespace: "argocd",
+			},
+		},
+	).Build()
+
+	statuses := reconciler.NewStatusRecorder()
+	statuses.Record("A.fake.ts.net", nil)
+	statuses.Record("B.fake.ts.net", errors.New("failure"))
+
+	rec := httptest.NewRecorder()
+	api.NewClustersHandler(ks, "argocd", "argotails", statuses).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/clusters", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var clusters []api.Cluster
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &clusters))
+	require.Len(t, clusters, 2)
+
+	assert.Equal(t, "A.fake.ts.net", clusters[0].Name)
+	assert.Equal(t, "https://A.fake.ts.net", clusters[0].Server)
+	assert.False(t, clusters[0].Pending)
+	assert.Equal(t, api.Device{
+		ID:        "device-a",
+		Hostname:  "A",
+		Tailnet:   "fake.ts.net",
+		Address:   "100.64.0.1",
+		Addresses: []string{"100.64.0.1", "fd7a:115c:a1e0::1"},
+		OS:        "linux",
+		Version:   "v1.2.3",
+		Tags:      []string{"tag:tag1", "tag:tag2"},
+	}, clusters[0].Device)
+	require.NotNil(t, clusters[0].LastReconcile)
+	assert.Empty(t, clusters[0].LastReconcile.Error)
+
+	assert.Equal(t, "B.fake.ts.net", clusters[1].Name)
+	assert.True(t, clusters[1].Pending)
+	require.NotNil(t, clusters[1].LastReconcile)
+	assert.Equal(t, "failure", clusters[1].LastReconcile.Error)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// PluginPath is the path of the ArgoCD ApplicationSet plugin generator endpoint.
+const PluginPath = "/api/v1/getparams.execute"
+
+type (
+	// PluginRequest is the request sent by the ArgoCD ApplicationSet plugin generator.
+	PluginRequest struct {
+		ApplicationSetName string `json:"applicationSetName"`
+		Input              struct {
+			Parameters PluginInput `json:"parameters"`
+		} `json:"input"`
+	}
+
+	// PluginInput are the parameters given by the ApplicationSet to the plugin generator.
+	PluginInput struct {
+		// Tags only keeps the devices having all the given tags.
+		Tags []string `json:"tags,omitempty"`
+		// Online only keeps the devices connected to the Tailscale control plane.
+		Online bool `json:"online,omitempty"`
+	}
+
+	// PluginResponse is the response expected by the ArgoCD ApplicationSet plugin generator.
+	PluginResponse struct {
+		Output struct {
+			Parameters []PluginParameters `json:"parameters"`
+		} `json:"output"`
+	}
+
+	// PluginParameters are the generator parameters of a Tailscale device.
+	PluginParameters struct {
+		Name          string   `json:"name"`
+		Server        string   `json:"server"`
+		SecretName    string   `json:"secretName"`
+		ID            string   `json:"id"`
+		Hostname      string   `json:"hostname"`
+		OS            string   `json:"os"`
+		ClientVersion string   `json:"clientVersion"`
+		Addresses     []string `json:"addresses"`
+		Tags          []string `json:"tags"`
+		Online        bool     `json:"online"`
+	}
+
+	// DeviceLister lists the Tailscale devices.
+	DeviceLister func(ctx context.Context) ([]tailscale.Device, error)
+)
+
+// NewPluginHandler returns an HTTP handler implementing the ArgoCD ApplicationSet plugin generator
+// contract, returning the live Tailscale devices matching the given filter as generator
+// parameters. Requests must be authenticated with the given bearer token.
+func NewPluginHandler(list DeviceLister, match func(tailscale.Device) bool, secretName func(tailscale.Device) string, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := ctrllog.FromContext(r.Context())
+
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req PluginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("400 Invalid plugin request: %s", err), http.StatusBadRequest)
+			return
+		}
+		log = log.WithValues("applicationset", req.ApplicationSetName)
+
+		devices, err := list(r.Context())
+		if err != nil {
+			log.Error(err, "Failed to list Tailscale devices")
+			http.Error(w, "500 Failed to list Tailscale devices", http.StatusInternalServerError)
+			return
+		}
+
+		var res PluginResponse
+		res.Output.Parameters = []PluginParameters{}
+		for _, device := range devices {
+			if !match(device) || !req.Input.Parameters.match(device) {
+				continue
+			}
+			res.Output.Parameters = append(res.Output.Parameters, toPluginParameters(device, secretName))
+		}
+		slices.SortFunc(res.Output.Parameters, func(a, b PluginParameters) int { return strings.Compare(a.Name, b.Name) })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Error(err, "Failed to encode plugin response")
+		}
+	})
+}
+
+// match returns true when the device matches the ApplicationSet parameters.
+func (in PluginInput) match(device tailscale.Device) bool {
+	if in.Online && !device.ConnectedToControl {
+		return false
+	}
+	for _, tag := range in.Tags {
+		if !slices.Contains(device.Tags, "tag:"+strings.TrimPrefix(tag, "tag:")) {
+			return false
+		}
+	}
+	return true
+}
+
+// toPluginParameters converts a Tailscale device into its generator parameters.
+func toPluginParameters(device tailscale.Device, secretName func(tailscale.Device) string) PluginParameters {
+	params := PluginParameters{
+		Name:          device.Name,
+		Server:        fmt.Sprintf("https://%s", device.Name),
+		SecretName:    secretName(device),
+		ID:            device.NodeID,
+		Hostname:      device.Hostname,
+		OS:            device.OS,
+		ClientVersion: device.ClientVersion,
+		Addresses:     device.Addresses,
+		Tags:          device.Tags,
+		Online:        device.ConnectedToControl,
+	}
+	if params.Addresses == nil {
+		params.Addresses = []string{}
+	}
+	if params.Tags == nil {
+		params.Tags = []string{}
+	}
+	return params
+}
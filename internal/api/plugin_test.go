@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/api"
+)
+
+func TestPluginHandler(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "b.fake.ts.net", NodeID: "device-b", Tags: []string{"tag:k8s"}},
+		{Name: "a.fake.ts.net", NodeID: "device-a", Hostname: "a", OS: "linux", Tags: []string{"tag:k8s", "tag:prod"}, ConnectedToControl: true},
+		{Name: "ignored.fake.ts.net", NodeID: "device-c"},
+	}
+	handler := api.NewPluginHandler(
+		func(context.Context) ([]tailscale.Device, error) { return devices, nil },
+		func(device tailscale.Device) bool { return len(device.Tags) > 0 },
+		func(device tailscale.Device) string { return "ts-" + device.NodeID },
+		"token",
+	)
+	execute := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, api.PluginPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := execute("invalid", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = execute("token", `{"applicationSetName":"clusters","input":{"parameters":{}}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res api.PluginResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Output.Parameters, 2)
+	assert.Equal(t, api.PluginParameters{
+		Name:       "a.fake.ts.net",
+		Server:     "https://a.fake.ts.net",
+		SecretName: "ts-device-a",
+		ID:         "device-a",
+		Hostname:   "a",
+		OS:         "linux",
+		Addresses:  []string{},
+		Tags:       []string{"tag:k8s", "tag:prod"},
+		Online:     true,
+	}, res.Output.Parameters[0])
+	assert.Equal(t, "b.fake.ts.net", res.Output.Parameters[1].Name)
+
+	// The ApplicationSet can narrow down the devices.
+	rec = execute("token", `{"input":{"parameters":{"tags":["prod"],"online":true}}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	res = api.PluginResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Output.Parameters, 1)
+	assert.Equal(t, "a.fake.ts.net", res.Output.Parameters[0].Name)
+
+	rec = execute("token", `invalid`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
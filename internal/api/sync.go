@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+type (
+	// SyncTracker keeps track of the runs of the time-based synchronization loop. It is safe for
+	// concurrent use.
+	SyncTracker struct {
+		mu       sync.RWMutex
+		interval time.Duration
+		status   SyncStatus
+	}
+
+	// SyncStatus is the state of the time-based synchronization loop.
+	SyncStatus struct {
+		// Interval is the time between two synchronizations.
+		Interval Duration `json:"interval"`
+		// LastRun is the last finished synchronization, if any.
+		LastRun *SyncRun `json:"lastRun,omitempty"`
+		// LastSuccess is when the last successful synchronization finished, if any.
+		LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+		// NextRun is when the next synchronization is scheduled.
+		NextRun *time.Time `json:"nextRun,omitempty"`
+		// Lag is the time elapsed since the last successful synchronization (or since the loop
+		// started) beyond the interval; a positive lag means the synchronization is late.
+		Lag Duration `json:"lag"`
+		// Lagging is true when the lag exceeds the interval, i.e. at least one synchronization has
+		// been missed or failed.
+		Lagging bool `json:"lagging"`
+	}
+
+	// SyncRun is a run of the time-based synchronization loop.
+	SyncRun struct {
+		Start    time.Time `json:"start"`
+		Duration Duration  `json:"duration"`
+		Error    string    `json:"error,omitempty"`
+	}
+
+	// Duration is a time.Duration encoded as a human-readable string (e.g. "1m30s").
+	Duration time.Duration
+)
+
+// MarshalJSON encodes the duration as a human-readable string.
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+// NewSyncTracker creates a tracker of a synchronization loop running every interval.
+func NewSyncTracker(interval time.Duration) *SyncTracker {
+	started := time.Now()
+	return &SyncTracker{
+		interval: interval,
+		status:   SyncStatus{Interval: Duration(interval), NextRun: &started},
+	}
+}
+
+// Schedule records when the next synchronization will run.
+func (t *SyncTracker) Schedule(next time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.NextRun = &next
+}
+
+// Record records a finished synchronization.
+func (t *SyncTracker) Record(start time.Time, err error) {
+	end := time.Now()
+	run := SyncRun{Start: start, Duration: Duration(end.Sub(start))}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastRun = &run
+	if err == nil {
+		t.status.LastSuccess = &end
+	}
+}
+
+// Status returns the current state of the synchronization loop.
+func (t *SyncTracker) Status() SyncStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status := t.status
+	reference := status.NextRun
+	if status.LastSuccess != nil {
+		next := status.LastSuccess.Add(t.interval)
+		reference = &next
+	}
+	if reference != nil {
+		if lag := time.Now().Sub(*reference); lag > 0 {
+			status.Lag = Duration(lag)
+		}
+	}
+	status.Lagging = time.Duration(status.Lag) > t.interval
+	return status
+}
+
+// NewSyncStatusHandler returns an HTTP handler reporting, as JSON, the state of the time-based
+// synchronization loop. It answers 503 Service Unavailable when the synchronization is lagging.
+func NewSyncStatusHandler(tracker *SyncTracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := tracker.Status()
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.Lagging {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			ctrllog.FromContext(r.Context()).Error(err, "Failed to encode synchronization status")
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/api"
+)
+
+func TestSyncStatusHandler(t *testing.T) {
+	tracker := api.NewSyncTracker(time.Hour)
+	start := time.Now()
+	tracker.Record(start, nil)
+	tracker.Record(start, errors.New("failure"))
+	tracker.Schedule(start.Add(time.Hour))
+
+	rec := httptest.NewRecorder()
+	api.NewSyncStatusHandler(tracker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/sync", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var status map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "1h0m0s", status["interval"])
+	assert.Equal(t, "failure", status["lastRun"].(map[string]any)["error"])
+	assert.NotEmpty(t, status["lastSuccess"])
+	assert.NotEmpty(t, status["nextRun"])
+	assert.Equal(t, "0s", status["lag"])
+	assert.Equal(t, false, status["lagging"])
+}
+
+func TestSyncStatusHandler_Lagging(t *testing.T) {
+	tracker := api.NewSyncTracker(time.Millisecond)
+	tracker.Record(time.Now(), nil)
+	time.Sleep(5 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	api.NewSyncStatusHandler(tracker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/sync", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.True(t, tracker.Status().Lagging)
+}
@@ -0,0 +1,63 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionDeviceOnline tells whether the Tailscale device is connected to the control plane.
+	ConditionDeviceOnline = "DeviceOnline"
+	// ConditionSecretSynced tells whether the ArgoCD cluster secret matches the Tailscale device.
+	ConditionSecretSynced = "SecretSynced"
+	// ConditionServiceSynced tells whether the Kubernetes service matches the Tailscale device.
+	ConditionServiceSynced = "ServiceSynced"
+	// ConditionAPIServerReachable tells whether ArgoCD is able to reach the cluster API server.
+	ConditionAPIServerReachable = "APIServerReachable"
+)
+
+// TailscaleClusterSpec describes the Tailscale device registered as ArgoCD cluster.
+type TailscaleClusterSpec struct {
+	// DeviceID is the Tailscale node ID of the device.
+	DeviceID string `json:"deviceID"`
+	// DeviceName is the MagicDNS name of the device.
+	DeviceName string `json:"deviceName"`
+	// SecretName is the name of the ArgoCD cluster secret.
+	SecretName string `json:"secretName"`
+	// ServiceName is the name of the Kubernetes service, if any.
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// TailscaleClusterStatus reports the state of a Tailscale device registered as ArgoCD cluster.
+type TailscaleClusterStatus struct {
+	// Conditions are the latest observations of the cluster state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TailscaleCluster reports the state of a Tailscale device registered as ArgoCD cluster. It is
+// created and maintained by Argotails; editing it has no effect.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=tsc
+// +kubebuilder:printcolumn:name="Device",type=string,JSONPath=`.spec.deviceName`
+// +kubebuilder:printcolumn:name="Online",type=string,JSONPath=`.status.conditions[?(@.type=="DeviceOnline")].status`
+// +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="SecretSynced")].status`
+// +kubebuilder:printcolumn:name="Reachable",type=string,JSONPath=`.status.conditions[?(@.type=="APIServerReachable")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type TailscaleCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TailscaleClusterSpec   `json:"spec,omitempty"`
+	Status TailscaleClusterStatus `json:"status,omitempty"`
+}
+
+// TailscaleClusterList contains a list of TailscaleCluster.
+//
+// +kubebuilder:object:root=true
+type TailscaleClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []TailscaleCluster `json:"items"`
+}
@@ -0,0 +1,99 @@
+// Package argocd contains a minimal client of the ArgoCD API and the schema of the ArgoCD cluster
+// secrets, the single place tracking the changes of this schema.
+package argocd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// ConnectionStatusSuccessful is the connection status of a reachable cluster.
+	ConnectionStatusSuccessful = "Successful"
+	// ConnectionStatusFailed is the connection status of an unreachable cluster.
+	ConnectionStatusFailed = "Failed"
+	// ConnectionStatusUnknown is the connection status of a cluster not yet contacted by ArgoCD.
+	ConnectionStatusUnknown = "Unknown"
+)
+
+type (
+	// Client is a minimal client of the ArgoCD API.
+	Client struct {
+		baseURL *url.URL
+		token   string
+		http    *http.Client
+	}
+
+	// Cluster is an ArgoCD cluster as returned by the ArgoCD API.
+	Cluster struct {
+		Name   string      `json:"name"`
+		Server string      `json:"server"`
+		Info   ClusterInfo `json:"info"`
+	}
+
+	// ClusterInfo contains the information ArgoCD has on a cluster.
+	ClusterInfo struct {
+		ConnectionState ConnectionState `json:"connectionState"`
+	}
+
+	// ConnectionState is the state of the connection between ArgoCD and a cluster.
+	ConnectionState struct {
+		Status      string     `json:"status"`
+		Message     string     `json:"message"`
+		AttemptedAt *time.Time `json:"attemptedAt,omitempty"`
+	}
+)
+
+// NewClient creates a new ArgoCD API client authenticated with the given token.
+func NewClient(baseURL *url.URL, token string, insecure bool) (*Client, error) {
+	if baseURL == nil {
+		return nil, fmt.Errorf("ArgoCD server URL is required")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("ArgoCD token is required")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // explicitly requested
+	}
+
+	return &Client{
+		baseURL: baseURL,
+		token:   token,
+		http:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// ListClusters lists all clusters known by ArgoCD.
+func (c *Client) ListClusters(ctx context.Context) ([]Cluster, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL.JoinPath("/api/v1/clusters").String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ArgoCD clusters: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list ArgoCD clusters: unexpected status %s", resp.Status)
+	}
+
+	var list struct {
+		Items []Cluster `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode ArgoCD clusters: %w", err)
+	}
+	return list.Items, nil
+}
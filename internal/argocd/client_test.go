@@ -0,0 +1,51 @@
+package argocd_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+func TestClient_ListClusters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/api/v1/clusters", r.URL.Path)
+		_, _ = w.Write([]byte(`{"items":[{"name":"A.fake.ts.net","server":"https://A.fake.ts.net","info":{"connectionState":{"status":"Failed","message":"timeout"}}}]}`))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c, err := argocd.NewClient(srvURL, "token", false)
+	require.NoError(t, err)
+
+	clusters, err := c.ListClusters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "https://A.fake.ts.net", clusters[0].Server)
+	assert.Equal(t, argocd.ConnectionStatusFailed, clusters[0].Info.ConnectionState.Status)
+	assert.Equal(t, "timeout", clusters[0].Info.ConnectionState.Message)
+
+	c, err = argocd.NewClient(srvURL, "invalid", false)
+	require.NoError(t, err)
+	_, err = c.ListClusters(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewClient_Error(t *testing.T) {
+	_, err := argocd.NewClient(nil, "token", false)
+	assert.Error(t, err)
+
+	_, err = argocd.NewClient(&url.URL{Scheme: "https", Host: "argocd"}, "", false)
+	assert.Error(t, err)
+}
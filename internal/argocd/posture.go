@@ -0,0 +1,103 @@
+package argocd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// PostureSyncer reports the ArgoCD connection state of every managed cluster back into Tailscale,
+// as a device posture attribute, turning the Tailscale admin console into a fleet health dashboard.
+type PostureSyncer struct {
+	// ArgoCD is the ArgoCD API client.
+	ArgoCD *Client
+	// Tailscale is the Tailscale device API.
+	Tailscale tsutils.DeviceAPI
+	// Reader reads the managed secrets.
+	Reader client.Reader
+	// Namespace is the namespace where the managed secrets live.
+	Namespace string
+	// ManagedBy is the controller name.
+	ManagedBy string
+	// Attribute is the posture attribute key (e.g. "custom:argocdConnection"); empty to only call
+	// OnState without reporting anything to Tailscale.
+	Attribute string
+	// DeviceIDAnnotation is the annotation holding the Tailscale device ID on managed secrets.
+	DeviceIDAnnotation string
+	// OnState, if set, is called with the ArgoCD connection state of every managed secret, even
+	// when unchanged.
+	OnState func(ctx context.Context, secret corev1.Secret, state string) error
+
+	mu       sync.Mutex
+	reported map[string]string
+}
+
+// Sync reports the current ArgoCD connection state of all managed clusters. Only the devices whose
+// state changed since the last report are updated.
+func (p *PostureSyncer) Sync(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx)
+
+	clusters, err := p.ArgoCD.ListClusters(ctx)
+	if err != nil {
+		return err
+	}
+	states := make(map[string]string, len(clusters))
+	for _, cluster := range clusters {
+		states[cluster.Server] = cluster.Info.ConnectionState.Status
+	}
+
+	var secrets corev1.SecretList
+	err = p.Reader.List(ctx, &secrets,
+		client.InNamespace(p.Namespace),
+		client.MatchingLabels{"apps.kubernetes.io/managed-by": p.ManagedBy},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to list managed secrets: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reported == nil {
+		p.reported = map[string]string{}
+	}
+
+	var errs *multierror.Error
+	for _, secret := range secrets.Items {
+		deviceID := secret.Annotations[p.DeviceIDAnnotation]
+		if deviceID == "" {
+			continue
+		}
+
+		state, known := states[string(secret.Data[KeyServer])]
+		if !known || state == "" {
+			state = ConnectionStatusUnknown
+		}
+		if p.OnState != nil {
+			if err := p.OnState(ctx, secret, state); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("failed to report connection state of cluster %q: %w", secret.Name, err))
+			}
+		}
+		if p.Attribute == "" || p.reported[deviceID] == state {
+			continue
+		}
+
+		log.V(2).Info("Reporting ArgoCD connection state to Tailscale", "device", map[string]any{"id": deviceID, "name": secret.Name}, "state", state)
+		err := p.Tailscale.SetAttributes(ctx, deviceID, map[string]tailscale.DevicePostureAttributeRequest{
+			p.Attribute: {Value: state, Comment: "ArgoCD connection state reported by " + p.ManagedBy},
+		})
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to set posture attribute of device %q: %w", deviceID, err))
+			continue
+		}
+		p.reported[deviceID] = state
+	}
+	return errs.ErrorOrNil()
+}
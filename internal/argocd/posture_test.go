@@ -0,0 +1,90 @@
+package argocd_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestPostureSyncer_Sync(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"items":[
+			{"server":"https://A.fake.ts.net","info":{"connectionState":{"status":"Successful"}}},
+			{"server":"https://B.fake.ts.net","info":{"connectionState":{"status":"Failed"}}}
+		]}`))
+	}))
+	defer argo.Close()
+	argoURL, err := url.Parse(argo.URL)
+	require.NoError(t, err)
+	argoClient, err := argocd.NewClient(argoURL, "token", false)
+	require.NoError(t, err)
+
+	reported := map[string]any{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req tailscale.DevicePostureAttributeRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reported[r.URL.Path] = req.Value
+	}))
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	secret := func(name, id string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "argocd",
+				Annotations: map[string]string{"device.tailscale.com/id": id},
+				Labels:      map[string]string{"apps.kubernetes.io/managed-by": "argotails"},
+			},
+			Data: map[string][]byte{"server": []byte("https://" + name)},
+		}
+	}
+
+	syncer := &argocd.PostureSyncer{
+		ArgoCD:             argoClient,
+		Tailscale:          tsutils.NewDeviceAPI(&tailscale.Client{Tailnet: "fake.ts.net", HTTP: ts.Client(), BaseURL: tsURL}),
+		Reader:             fake.NewClientBuilder().WithObjects(secret("A.fake.ts.net", "a"), secret("B.fake.ts.net", "b"), secret("C.fake.ts.net", "c")).Build(),
+		Namespace:          "argocd",
+		ManagedBy:          "argotails",
+		Attribute:          "custom:argocdConnection",
+		DeviceIDAnnotation: "device.tailscale.com/id",
+	}
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	assert.Equal(t, map[string]any{
+		"/api/v2/device/a/attributes/custom:argocdConnection": "Successful",
+		"/api/v2/device/b/attributes/custom:argocdConnection": "Failed",
+		"/api/v2/device/c/attributes/custom:argocdConnection": "Unknown",
+	}, reported)
+
+	// Unchanged states are not reported again.
+	reported = map[string]any{}
+	require.NoError(t, syncer.Sync(context.Background()))
+	assert.Empty(t, reported)
+
+	// Without attribute, the states are only passed to OnState.
+	states := map[string]string{}
+	syncer.Attribute = ""
+	syncer.OnState = func(_ context.Context, secret corev1.Secret, state string) error {
+		states[secret.Name] = state
+		return nil
+	}
+	syncer.Reader = fake.NewClientBuilder().WithObjects(secret("B.fake.ts.net", "b2")).Build()
+	require.NoError(t, syncer.Sync(context.Background()))
+	assert.Empty(t, reported)
+	assert.Equal(t, map[string]string{"B.fake.ts.net": "Failed"}, states)
+}
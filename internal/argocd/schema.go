@@ -0,0 +1,234 @@
+package argocd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Schema of the ArgoCD declarative cluster secrets
+// (https://argo-cd.readthedocs.io/en/stable/operator-manual/declarative-setup/#clusters).
+const (
+	// LabelSecretType is the label identifying the ArgoCD secrets by type.
+	LabelSecretType = "argocd.argoproj.io/secret-type"
+	// SecretTypeCluster is the LabelSecretType value of the cluster secrets.
+	SecretTypeCluster = "cluster"
+
+	// KeyName is the cluster name displayed by ArgoCD.
+	KeyName = "name"
+	// KeyServer is the URL of the Kubernetes API server of the cluster.
+	KeyServer = "server"
+	// KeyConfig is the JSON ClusterConfig used to connect to the cluster.
+	KeyConfig = "config"
+	// KeyShard is the application controller shard managing the cluster.
+	KeyShard = "shard"
+	// KeyProject is the ArgoCD project the cluster is scoped to.
+	KeyProject = "project"
+	// KeyNamespaces is the comma separated list of namespaces ArgoCD is restricted to.
+	KeyNamespaces = "namespaces"
+	// KeyClusterResources allows the management of cluster-wide resources when KeyNamespaces is set.
+	KeyClusterResources = "clusterResources"
+)
+
+// ClusterKeys are the secret data entries defined by the ArgoCD cluster secret schema.
+var ClusterKeys = []string{KeyName, KeyServer, KeyConfig, KeyShard, KeyProject, KeyNamespaces, KeyClusterResources}
+
+type (
+	// ClusterSecret is the data of an ArgoCD cluster secret.
+	ClusterSecret struct {
+		Name             string
+		Server           string
+		Config           ClusterConfig
+		Shard            *int64
+		Project          string
+		Namespaces       []string
+		ClusterResources bool
+	}
+
+	// ClusterConfig is how ArgoCD connects to a cluster.
+	ClusterConfig struct {
+		Username           string              `json:"username,omitempty"`
+		Password           string              `json:"password,omitempty"`
+		BearerToken        string              `json:"bearerToken,omitempty"`
+		TLSClientConfig    TLSClientConfig     `json:"tlsClientConfig"`
+		AWSAuthConfig      *AWSAuthConfig      `json:"awsAuthConfig,omitempty"`
+		ExecProviderConfig *ExecProviderConfig `json:"execProviderConfig,omitempty"`
+		ProxyURL           string              `json:"proxyUrl,omitempty"`
+		DisableCompression bool                `json:"disableCompression,omitempty"`
+	}
+
+	// TLSClientConfig is the TLS configuration used to connect to a cluster.
+	TLSClientConfig struct {
+		Insecure   bool   `json:"insecure"`
+		ServerName string `json:"serverName,omitempty"`
+		CertData   []byte `json:"certData,omitempty"`
+		KeyData    []byte `json:"keyData,omitempty"`
+		CAData     []byte `json:"caData,omitempty"`
+	}
+
+	// AWSAuthConfig authenticates to an EKS cluster with IAM.
+	AWSAuthConfig struct {
+		ClusterName string `json:"clusterName,omitempty"`
+		RoleARN     string `json:"roleARN,omitempty"`
+		Profile     string `json:"profile,omitempty"`
+	}
+
+	// ExecProviderConfig authenticates to a cluster with the credentials returned by a command.
+	ExecProviderConfig struct {
+		Command     string            `json:"command,omitempty"`
+		Args        []string          `json:"args,omitempty"`
+		Env         map[string]string `json:"env,omitempty"`
+		APIVersion  string            `json:"apiVersion,omitempty"`
+		InstallHint string            `json:"installHint,omitempty"`
+	}
+)
+
+// ParseClusterConfig parses and validates the JSON ClusterConfig of a cluster secret.
+func ParseClusterConfig(raw string) (ClusterConfig, error) {
+	var config ClusterConfig
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return ClusterConfig{}, fmt.Errorf("invalid cluster config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return ClusterConfig{}, err
+	}
+	return config, nil
+}
+
+// Marshal returns the JSON representation of the configuration, as stored in the KeyConfig entry.
+func (c ClusterConfig) Marshal() (string, error) {
+	raw, err := json.Marshal(c)
+	return string(raw), err
+}
+
+// Validate returns an error if the configuration would be rejected or misused by ArgoCD.
+func (c ClusterConfig) Validate() error {
+	var errs *multierror.Error
+	if (len(c.TLSClientConfig.CertData) == 0) != (len(c.TLSClientConfig.KeyData) == 0) {
+		errs = multierror.Append(errs, errors.New("tlsClientConfig.certData and tlsClientConfig.keyData must be set together"))
+	}
+	if c.TLSClientConfig.Insecure && len(c.TLSClientConfig.CAData) > 0 {
+		errs = multierror.Append(errs, errors.New("tlsClientConfig.insecure cannot be set with tlsClientConfig.caData"))
+	}
+	if (c.Username == "") != (c.Password == "") {
+		errs = multierror.Append(errs, errors.New("username and password must be set together"))
+	}
+
+	var methods []string
+	if c.BearerToken != "" {
+		methods = append(methods, "bearerToken")
+	}
+	if c.AWSAuthConfig != nil {
+		methods = append(methods, "awsAuthConfig")
+		if c.AWSAuthConfig.ClusterName == "" {
+			errs = multierror.Append(errs, errors.New("awsAuthConfig.clusterName is required"))
+		}
+	}
+	if c.ExecProviderConfig != nil {
+		methods = append(methods, "execProviderConfig")
+		if c.ExecProviderConfig.Command == "" {
+			errs = multierror.Append(errs, errors.New("execProviderConfig.command is required"))
+		}
+	}
+	if len(methods) > 1 {
+		errs = multierror.Append(errs, fmt.Errorf("only one authentication method can be set, got %s", strings.Join(methods, ", ")))
+	}
+	if c.ProxyURL != "" {
+		if _, err := url.Parse(c.ProxyURL); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid proxyUrl: %w", err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// ParseClusterSecret parses and validates the data of an ArgoCD cluster secret.
+func ParseClusterSecret(data map[string][]byte) (ClusterSecret, error) {
+	secret := ClusterSecret{
+		Name:    string(data[KeyName]),
+		Server:  string(data[KeyServer]),
+		Project: string(data[KeyProject]),
+	}
+	if raw, exists := data[KeyConfig]; exists {
+		if err := json.Unmarshal(raw, &secret.Config); err != nil {
+			return ClusterSecret{}, fmt.Errorf("invalid %s entry: %w", KeyConfig, err)
+		}
+	}
+	if raw, exists := data[KeyShard]; exists {
+		shard, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return ClusterSecret{}, fmt.Errorf("invalid %s entry: %w", KeyShard, err)
+		}
+		secret.Shard = &shard
+	}
+	if raw := string(data[KeyNamespaces]); raw != "" {
+		secret.Namespaces = strings.Split(raw, ",")
+	}
+	if raw, exists := data[KeyClusterResources]; exists {
+		clusterResources, err := strconv.ParseBool(string(raw))
+		if err != nil {
+			return ClusterSecret{}, fmt.Errorf("invalid %s entry: %w", KeyClusterResources, err)
+		}
+		secret.ClusterResources = clusterResources
+	}
+	return secret, secret.Validate()
+}
+
+// Data returns the secret data entries of the cluster secret; the optional entries are only set
+// when they differ from the ArgoCD defaults.
+func (s ClusterSecret) Data() (map[string]string, error) {
+	config, err := s.Config.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{
+		KeyName:   s.Name,
+		KeyServer: s.Server,
+		KeyConfig: config,
+	}
+	if s.Shard != nil {
+		data[KeyShard] = strconv.FormatInt(*s.Shard, 10)
+	}
+	if s.Project != "" {
+		data[KeyProject] = s.Project
+	}
+	if len(s.Namespaces) > 0 {
+		data[KeyNamespaces] = strings.Join(s.Namespaces, ",")
+	}
+	if s.ClusterResources {
+		data[KeyClusterResources] = "true"
+	}
+	return data, nil
+}
+
+// Validate returns an error if the cluster secret would be rejected or misused by ArgoCD.
+func (s ClusterSecret) Validate() error {
+	var errs *multierror.Error
+	if s.Name == "" {
+		errs = multierror.Append(errs, fmt.Errorf("%s is required", KeyName))
+	}
+	if u, err := url.Parse(s.Server); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = multierror.Append(errs, fmt.Errorf("%s must be an absolute URL, got %q", KeyServer, s.Server))
+	}
+	if s.Shard != nil && *s.Shard < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("%s must not be negative", KeyShard))
+	}
+	for _, namespace := range s.Namespaces {
+		if strings.TrimSpace(namespace) == "" {
+			errs = multierror.Append(errs, fmt.Errorf("%s must not contain empty namespaces", KeyNamespaces))
+			break
+		}
+	}
+	if s.ClusterResources && len(s.Namespaces) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("%s requires %s", KeyClusterResources, KeyNamespaces))
+	}
+	if err := s.Config.Validate(); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("invalid %s entry: %w", KeyConfig, err))
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,94 @@
+package argocd_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+func TestClusterSecret_Data(t *testing.T) {
+	data, err := argocd.ClusterSecret{Name: "laptop", Server: "https://laptop.fake.ts.net"}.Data()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name":   "laptop",
+		"server": "https://laptop.fake.ts.net",
+		"config": `{"tlsClientConfig":{"insecure":false}}`,
+	}, data)
+
+	shard := int64(2)
+	data, err = argocd.ClusterSecret{
+		Name:             "laptop",
+		Server:           "https://laptop.fake.ts.net",
+		Config:           argocd.ClusterConfig{BearerToken: "token", TLSClientConfig: argocd.TLSClientConfig{ServerName: "kubernetes"}},
+		Shard:            &shard,
+		Project:          "homelab",
+		Namespaces:       []string{"default", "kube-system"},
+		ClusterResources: true,
+	}.Data()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name":             "laptop",
+		"server":           "https://laptop.fake.ts.net",
+		"config":           `{"bearerToken":"token","tlsClientConfig":{"insecure":false,"serverName":"kubernetes"}}`,
+		"shard":            "2",
+		"project":          "homelab",
+		"namespaces":       "default,kube-system",
+		"clusterResources": "true",
+	}, data)
+}
+
+func TestParseClusterSecret(t *testing.T) {
+	secret, err := argocd.ParseClusterSecret(map[string][]byte{
+		"name":             []byte("laptop"),
+		"server":           []byte("https://laptop.fake.ts.net"),
+		"config":           []byte(`{"execProviderConfig":{"command":"argocd-k8s-auth","args":["gcp"]},"tlsClientConfig":{"insecure":true}}`),
+		"shard":            []byte("1"),
+		"namespaces":       []byte("default"),
+		"clusterResources": []byte("true"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "laptop", secret.Name)
+	assert.Equal(t, "argocd-k8s-auth", secret.Config.ExecProviderConfig.Command)
+	assert.True(t, secret.Config.TLSClientConfig.Insecure)
+	assert.Equal(t, int64(1), *secret.Shard)
+	assert.Equal(t, []string{"default"}, secret.Namespaces)
+	assert.True(t, secret.ClusterResources)
+
+	tests := map[string]map[string][]byte{
+		"missing name":      {"server": []byte("https://laptop.fake.ts.net")},
+		"relative server":   {"name": []byte("laptop"), "server": []byte("laptop.fake.ts.net")},
+		"invalid config":    {"name": []byte("laptop"), "server": []byte("https://laptop.fake.ts.net"), "config": []byte(`{`)},
+		"invalid shard":     {"name": []byte("laptop"), "server": []byte("https://laptop.fake.ts.net"), "shard": []byte("one")},
+		"negative shard":    {"name": []byte("laptop"), "server": []byte("https://laptop.fake.ts.net"), "shard": []byte("-1")},
+		"cluster resources": {"name": []byte("laptop"), "server": []byte("https://laptop.fake.ts.net"), "clusterResources": []byte("true")},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := argocd.ParseClusterSecret(data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseClusterConfig(t *testing.T) {
+	config, err := argocd.ParseClusterConfig(`{"awsAuthConfig":{"clusterName":"prod","roleARN":"arn:aws:iam::1:role/argocd"},"tlsClientConfig":{"caData":"Y2E="}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", config.AWSAuthConfig.ClusterName)
+	assert.Equal(t, []byte("ca"), config.TLSClientConfig.CAData)
+
+	for _, raw := range []string{
+		`not json`,
+		`{"tlsClientConfig":{"certData":"Y2VydA=="}}`,
+		`{"tlsClientConfig":{"insecure":true,"caData":"Y2E="}}`,
+		`{"username":"admin"}`,
+		`{"bearerToken":"token","execProviderConfig":{"command":"auth"}}`,
+		`{"awsAuthConfig":{}}`,
+		`{"execProviderConfig":{"args":["gcp"]}}`,
+	} {
+		_, err := argocd.ParseClusterConfig(raw)
+		assert.Error(t, err, raw)
+	}
+}
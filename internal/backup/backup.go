@@ -0,0 +1,244 @@
+// Package backup archives the secrets and services managed by Argotails into a (optionally
+// encrypted) tarball, and reads them back to recreate them after a disaster.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrPassphraseRequired is returned when reading an encrypted backup without passphrase.
+var ErrPassphraseRequired = errors.New("the backup is encrypted, a passphrase is required")
+
+// magic prefixes the encrypted backups, followed by the PBKDF2 salt, the AES-GCM nonce and the
+// encrypted tarball.
+var magic = []byte("argotails-backup-v1\n")
+
+const saltSize = 16
+
+// Iterations is the number of PBKDF2-SHA256 iterations used to derive the encryption key from the
+// passphrase.
+var Iterations = 600_000
+
+// Backup is the content of a backup.
+type Backup struct {
+	Secrets  []corev1.Secret
+	Services []corev1.Service
+}
+
+// Objects returns the objects of the backup, secrets first.
+func (b Backup) Objects() []client.Object {
+	objects := make([]client.Object, 0, len(b.Secrets)+len(b.Services))
+	for i := range b.Secrets {
+		objects = append(objects, &b.Secrets[i])
+	}
+	for i := range b.Services {
+		objects = append(objects, &b.Services[i])
+	}
+	return objects
+}
+
+// Write writes the backup as a gzipped tarball, with one YAML manifest per object, encrypted with
+// AES-256-GCM when a passphrase is given. The fields managed by the API server are removed, so the
+// objects can be created again as is.
+func Write(w io.Writer, b Backup, passphrase []byte) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	entries := map[string][]byte{}
+	for _, secret := range b.Secrets {
+		secret = *secret.DeepCopy()
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		cleanObjectMeta(&secret.ObjectMeta)
+		if err := addEntry(entries, "secrets", &secret); err != nil {
+			return err
+		}
+	}
+	for _, service := range b.Services {
+		service = *service.DeepCopy()
+		service.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+		cleanObjectMeta(&service.ObjectMeta)
+		service.Spec.ClusterIP = ""
+		service.Spec.ClusterIPs = nil
+		service.Status = corev1.ServiceStatus{}
+		if err := addEntry(entries, "services", &service); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(entries[name])), Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(entries[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if len(passphrase) > 0 {
+		var err error
+		if data, err = encrypt(data, passphrase); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Read reads a backup written by Write, decrypting it with the given passphrase if it is encrypted.
+func Read(r io.Reader, passphrase []byte) (Backup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Backup{}, err
+	}
+	if bytes.HasPrefix(data, magic) {
+		if len(passphrase) == 0 {
+			return Backup{}, ErrPassphraseRequired
+		}
+		if data, err = decrypt(data, passphrase); err != nil {
+			return Backup{}, err
+		}
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Backup{}, fmt.Errorf("invalid backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var b Backup
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return Backup{}, fmt.Errorf("invalid backup: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		manifest, err := io.ReadAll(tr)
+		if err != nil {
+			return Backup{}, err
+		}
+		switch kind, _, _ := strings.Cut(header.Name, "/"); kind {
+		case "secrets":
+			var secret corev1.Secret
+			if err := yaml.UnmarshalStrict(manifest, &secret); err != nil {
+				return Backup{}, fmt.Errorf("invalid secret %q: %w", header.Name, err)
+			}
+			b.Secrets = append(b.Secrets, secret)
+		case "services":
+			var service corev1.Service
+			if err := yaml.UnmarshalStrict(manifest, &service); err != nil {
+				return Backup{}, fmt.Errorf("invalid service %q: %w", header.Name, err)
+			}
+			b.Services = append(b.Services, service)
+		default:
+			return Backup{}, fmt.Errorf("invalid backup: unexpected entry %q", header.Name)
+		}
+	}
+	return b, nil
+}
+
+// cleanObjectMeta removes the metadata managed by the API server.
+func cleanObjectMeta(meta *metav1.ObjectMeta) {
+	*meta = metav1.ObjectMeta{
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+		Finalizers:  meta.Finalizers,
+	}
+}
+
+func addEntry(entries map[string][]byte, kind string, obj client.Object) error {
+	manifest, err := yaml.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s %s/%s: %w", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	entries[path.Join(kind, obj.GetNamespace(), obj.GetName()+".yaml")] = manifest
+	return nil
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encrypt(plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(append(append([]byte{}, magic...), salt...), nonce...)
+	return aead.Seal(out, nonce, plaintext, magic), nil
+}
+
+func decrypt(data, passphrase []byte) ([]byte, error) {
+	data = data[len(magic):]
+	if len(data) < saltSize {
+		return nil, errors.New("invalid backup: truncated header")
+	}
+	aead, err := newAEAD(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid backup: truncated header")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], magic)
+	if err != nil {
+		return nil, errors.New("failed to decrypt the backup: wrong passphrase or corrupted backup")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,79 @@
+package backup_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chezmoidotsh/argotails/internal/backup"
+)
+
+func fixture() backup.Backup {
+	return backup.Backup{
+		Secrets: []corev1.Secret{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "laptop",
+				Namespace:       "argocd",
+				Labels:          map[string]string{"apps.kubernetes.io/managed-by": "argotails"},
+				ResourceVersion: "42",
+				UID:             "8d5c3a38",
+			},
+			StringData: map[string]string{"name": "laptop"},
+			Data:       map[string][]byte{"server": []byte("https://laptop.example.ts.net")},
+		}},
+		Services: []corev1.Service{{
+			ObjectMeta: metav1.ObjectMeta{Name: "laptop", Namespace: "argocd", ResourceVersion: "43"},
+			Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "laptop.example.ts.net",
+				ClusterIP:    "10.0.0.1",
+			},
+		}},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, backup.Write(&buf, fixture(), nil))
+
+	b, err := backup.Read(&buf, nil)
+	require.NoError(t, err)
+	require.Len(t, b.Secrets, 1)
+	require.Len(t, b.Services, 1)
+
+	assert.Equal(t, "laptop", b.Secrets[0].Name)
+	assert.Equal(t, "argocd", b.Secrets[0].Namespace)
+	assert.Equal(t, "argotails", b.Secrets[0].Labels["apps.kubernetes.io/managed-by"])
+	assert.Empty(t, b.Secrets[0].ResourceVersion)
+	assert.Empty(t, b.Secrets[0].UID)
+	assert.Equal(t, []byte("https://laptop.example.ts.net"), b.Secrets[0].Data["server"])
+	assert.Equal(t, "laptop", b.Secrets[0].StringData["name"])
+
+	assert.Equal(t, "laptop.example.ts.net", b.Services[0].Spec.ExternalName)
+	assert.Empty(t, b.Services[0].ResourceVersion)
+	assert.Empty(t, b.Services[0].Spec.ClusterIP)
+	assert.Len(t, b.Objects(), 2)
+}
+
+func TestWriteRead_Encrypted(t *testing.T) {
+	backup.Iterations = 1000
+
+	var buf bytes.Buffer
+	require.NoError(t, backup.Write(&buf, fixture(), []byte("correct horse battery staple")))
+	assert.NotContains(t, buf.String(), "laptop")
+
+	_, err := backup.Read(bytes.NewReader(buf.Bytes()), nil)
+	assert.ErrorIs(t, err, backup.ErrPassphraseRequired)
+
+	_, err = backup.Read(bytes.NewReader(buf.Bytes()), []byte("wrong"))
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	b, err := backup.Read(bytes.NewReader(buf.Bytes()), []byte("correct horse battery staple"))
+	require.NoError(t, err)
+	assert.Len(t, b.Secrets, 1)
+	assert.Len(t, b.Services, 1)
+}
@@ -0,0 +1,155 @@
+// Package checkpoint splits the full synchronization of very large fleets into prioritized chunks
+// and persists the progress of the current cycle after every chunk, so that a restarted controller
+// resumes the interrupted cycle instead of starting over.
+package checkpoint
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigMapKey is the ConfigMap entry holding the JSON checkpoint.
+const ConfigMapKey = "checkpoint.json"
+
+type (
+	// Checkpoint is the progress of an interrupted synchronization cycle.
+	Checkpoint struct {
+		// Started is when the cycle started.
+		Started time.Time `json:"started"`
+		// Done are the secrets (namespace/name) already synchronized during the cycle.
+		Done []string `json:"done"`
+	}
+
+	// Item is a secret to synchronize, with the last time its Tailscale device changed.
+	Item struct {
+		Key types.NamespacedName
+		// Changed is the last time the device changed; the zero time for the secrets without
+		// device, synchronized last.
+		Changed time.Time
+	}
+
+	// Store persists the checkpoint into a ConfigMap; a Store without ConfigMap name only keeps it
+	// in memory.
+	Store struct {
+		reader    client.Reader
+		client    client.Client
+		namespace string
+		name      string
+		managedBy string
+		maxAge    time.Duration
+
+		memory Checkpoint
+	}
+)
+
+// NewStore returns a store persisting the checkpoint into the given ConfigMap, or in memory only
+// if name is empty. The ConfigMap is read with the given reader (usually the manager API reader,
+// as only this ConfigMap can be read) and written with the given client.
+func NewStore(reader client.Reader, c client.Client, namespace, name, managedBy string) *Store {
+	return &Store{reader: reader, client: c, namespace: namespace, name: name, managedBy: managedBy}
+}
+
+// SetMaxAge discards the checkpoints of the cycles started longer than the given age ago, whose
+// progress is likely outdated (0 for no limit).
+func (s *Store) SetMaxAge(maxAge time.Duration) { s.maxAge = maxAge }
+
+// Load returns the checkpoint of the interrupted cycle, if any and not older than the maximum age.
+func (s *Store) Load(ctx context.Context) (Checkpoint, error) {
+	checkpoint, err := s.read(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if s.maxAge > 0 && !checkpoint.Started.IsZero() && time.Since(checkpoint.Started) > s.maxAge {
+		return Checkpoint{}, nil
+	}
+	return checkpoint, nil
+}
+
+// read returns the stored checkpoint, whatever its age.
+func (s *Store) read(ctx context.Context) (Checkpoint, error) {
+	if s.name == "" {
+		return s.memory, nil
+	}
+
+	var cm corev1.ConfigMap
+	err := s.reader.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: s.name}, &cm)
+	if client.IgnoreNotFound(err) != nil {
+		return Checkpoint{}, fmt.Errorf("failed to read the checkpoint ConfigMap: %w", err)
+	}
+
+	var checkpoint Checkpoint
+	if raw := cm.Data[ConfigMapKey]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &checkpoint); err != nil {
+			return Checkpoint{}, fmt.Errorf("invalid checkpoint: %w", err)
+		}
+	}
+	return checkpoint, nil
+}
+
+// Save persists the given checkpoint, using server-side apply.
+func (s *Store) Save(ctx context.Context, checkpoint Checkpoint) error {
+	if s.name == "" {
+		s.memory = checkpoint
+		return nil
+	}
+
+	raw, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	cm := corev1ac.ConfigMap(s.name, s.namespace).
+		WithLabels(map[string]string{"apps.kubernetes.io/managed-by": s.managedBy}).
+		WithData(map[string]string{ConfigMapKey: string(raw)})
+	return s.client.Apply(ctx, cm, client.FieldOwner(s.managedBy), client.ForceOwnership)
+}
+
+// Clear removes the checkpoint once the cycle is complete; nothing is written if no checkpoint is
+// stored (e.g. the cycle was paused or the checkpoint already cleared).
+func (s *Store) Clear(ctx context.Context) error {
+	checkpoint, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Started.IsZero() && len(checkpoint.Done) == 0 {
+		return nil
+	}
+	return s.Save(ctx, Checkpoint{})
+}
+
+// Chunks orders the given items by priority, the most recently changed devices first and the
+// secrets without device last, skips the ones already done and splits the others into chunks of
+// the given size (a single chunk if size is not positive).
+func Chunks(items []Item, done []string, size int) [][]types.NamespacedName {
+	skipped := make(map[string]struct{}, len(done))
+	for _, key := range done {
+		skipped[key] = struct{}{}
+	}
+	items = slices.DeleteFunc(slices.Clone(items), func(item Item) bool {
+		_, exists := skipped[item.Key.String()]
+		return exists
+	})
+	slices.SortStableFunc(items, func(a, b Item) int {
+		if c := b.Changed.Compare(a.Changed); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key.String(), b.Key.String())
+	})
+
+	keys := make([]types.NamespacedName, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	if size <= 0 || len(keys) == 0 {
+		return [][]types.NamespacedName{keys}
+	}
+	return slices.Collect(slices.Chunk(keys, size))
+}
@@ -0,0 +1,107 @@
+package checkpoint_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/chezmoidotsh/argotails/internal/checkpoint"
+)
+
+func TestChunks(t *testing.T) {
+	now := time.Now()
+	key := func(name string) types.NamespacedName { return types.NamespacedName{Namespace: "argocd", Name: name} }
+	items := []checkpoint.Item{
+		{Key: key("orphan")},
+		{Key: key("old"), Changed: now.Add(-time.Hour)},
+		{Key: key("recent"), Changed: now},
+		{Key: key("b"), Changed: now.Add(-time.Minute)},
+		{Key: key("a"), Changed: now.Add(-time.Minute)},
+	}
+
+	assert.Equal(t, [][]types.NamespacedName{
+		{key("recent"), key("a")},
+		{key("b"), key("old")},
+		{key("orphan")},
+	}, checkpoint.Chunks(items, nil, 2))
+
+	// The secrets already done are skipped.
+	assert.Equal(t, [][]types.NamespacedName{
+		{key("a"), key("old")},
+	}, checkpoint.Chunks(items, []string{"argocd/recent", "argocd/b", "argocd/orphan"}, 2))
+
+	// Without chunk size, everything is synchronized at once.
+	assert.Len(t, checkpoint.Chunks(items, nil, 0), 1)
+	assert.Equal(t, [][]types.NamespacedName{{}}, checkpoint.Chunks(nil, nil, 2))
+}
+
+func TestStore(t *testing.T) {
+	ks := fake.NewClientBuilder().Build()
+	store := checkpoint.NewStore(ks, ks, "argocd", "argotails-checkpoint", "argotails")
+	progress := checkpoint.Checkpoint{Started: time.Now().UTC().Truncate(time.Second), Done: []string{"argocd/a"}}
+
+	loaded, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Done, "no checkpoint before the first save")
+
+	// Clearing a store without checkpoint writes nothing.
+	require.NoError(t, store.Clear(context.Background()))
+	var cm corev1.ConfigMap
+	err = ks.Get(context.Background(), types.NamespacedName{Name: "argotails-checkpoint", Namespace: "argocd"}, &cm)
+	assert.True(t, apierrors.IsNotFound(err), "no ConfigMap must be created by Clear, got %v", err)
+
+	require.NoError(t, store.Save(context.Background(), progress))
+	require.NoError(t, ks.Get(context.Background(), types.NamespacedName{Name: "argotails-checkpoint", Namespace: "argocd"}, &cm))
+	assert.Equal(t, "argotails", cm.Labels["apps.kubernetes.io/managed-by"])
+
+	// A restarted controller resumes from the persisted checkpoint.
+	loaded, err = checkpoint.NewStore(ks, ks, "argocd", "argotails-checkpoint", "argotails").Load(context.Background())
+	require.NoError(t, err)
+	assert.True(t, progress.Started.Equal(loaded.Started))
+	assert.Equal(t, progress.Done, loaded.Done)
+
+	require.NoError(t, store.Clear(context.Background()))
+	loaded, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Done)
+
+	// Without ConfigMap name, the checkpoint is only kept in memory.
+	memory := checkpoint.NewStore(ks, ks, "argocd", "", "argotails")
+	require.NoError(t, memory.Save(context.Background(), progress))
+	loaded, err = memory.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, progress, loaded)
+}
+
+func TestStore_MaxAge(t *testing.T) {
+	ks := fake.NewClientBuilder().Build()
+	store := checkpoint.NewStore(ks, ks, "argocd", "argotails-checkpoint", "argotails")
+	store.SetMaxAge(time.Hour)
+
+	recent := checkpoint.Checkpoint{Started: time.Now().Add(-time.Minute).UTC().Truncate(time.Second), Done: []string{"argocd/a"}}
+	require.NoError(t, store.Save(context.Background(), recent))
+	loaded, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, recent.Done, loaded.Done)
+
+	// The checkpoint of a cycle started too long ago is discarded...
+	outdated := checkpoint.Checkpoint{Started: time.Now().Add(-2 * time.Hour).UTC(), Done: []string{"argocd/a"}}
+	require.NoError(t, store.Save(context.Background(), outdated))
+	loaded, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint.Checkpoint{}, loaded)
+
+	// ... but still cleared.
+	require.NoError(t, store.Clear(context.Background()))
+	store.SetMaxAge(0)
+	loaded, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Done)
+}
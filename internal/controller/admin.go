@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"k8s.io/apimachinery/pkg/types"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/admin"
+	"github.com/chezmoidotsh/argotails/internal/api"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
+)
+
+// adminBackend performs the operations of the admin API on the controller.
+type adminBackend struct {
+	c      *RunCmd
+	filter tsutils.TagFilter
+}
+
+func (b adminBackend) ForceSync(ctx context.Context, deviceName string) (string, error) {
+	if b.c.pause.Paused() {
+		return "", &admin.Error{Code: "failed_precondition", Message: "mutations are paused"}
+	}
+	device, err := b.c.findDevice(ctx, deviceName)
+	if err != nil {
+		return "", err
+	}
+
+	req := reconcile.Request{NamespacedName: b.c.deviceSecretName(device)}
+	ctrllog.FromContext(ctx).V(1).Info("Forcing synchronization of device", "device", device.Name, "secret", req.NamespacedName)
+	if _, err := b.c.state.Load().reconciler.Reconcile(reconciler.TriggerContext(ctx, reconciler.TriggerAdmin), req); err != nil {
+		return "", err
+	}
+	return req.String(), nil
+}
+
+func (b adminBackend) EvaluateFilter(ctx context.Context, deviceName string) (admin.EvaluateFilterResponse, error) {
+	device, err := b.c.findDevice(ctx, deviceName)
+	if err != nil {
+		return admin.EvaluateFilterResponse{}, err
+	}
+
+	res := admin.EvaluateFilterResponse{Device: device.Name, Matched: b.filter.Match(device)}
+	if res.Matched {
+		res.Secret = b.c.deviceSecretName(device).String()
+	}
+	return res, nil
+}
+
+func (b adminBackend) SetPaused(paused bool) {
+	ctrllog.Log.WithName("admin").V(0).Info("Mutations pause changed", "paused", paused)
+	b.c.pause.SetBy(reconciler.PauseSourceAdmin, paused)
+}
+
+func (b adminBackend) Paused() bool               { return b.c.pause.Paused() }
+func (b adminBackend) SyncStatus() api.SyncStatus { return b.c.syncs.Status() }
+
+func (b adminBackend) SetVerbosity(verbosity string, duration time.Duration) (admin.LogLevelResponse, error) {
+	level, err := zapcoreutils.ParseVerbosity(verbosity)
+	if err != nil {
+		return admin.LogLevelResponse{}, &admin.Error{Code: "invalid_argument", Message: fmt.Sprintf("invalid verbosity: %s", err)}
+	}
+
+	// NOTE: logged before lowering the verbosity, so that the change is always visible.
+	ctrllog.Log.WithName("admin").V(0).Info("Log verbosity changed", "verbosity", int(level)*-1, "duration", duration)
+	b.c.logLevel.Set(level, duration)
+	return b.Verbosity(), nil
+}
+
+func (b adminBackend) Verbosity() admin.LogLevelResponse {
+	res := admin.LogLevelResponse{Verbosity: int(b.c.logLevel.Level()) * -1}
+	if until := b.c.logLevel.Until(); !until.IsZero() {
+		res.Until = until.Format(time.RFC3339)
+	}
+	return res
+}
+
+// findDevice returns the Tailscale device with the given name, hostname or ID.
+func (c *RunCmd) findDevice(ctx context.Context, name string) (tailscale.Device, error) {
+	state := c.state.Load()
+	devices, err := state.snapshot.List(ctx, state.devices)
+	if err != nil {
+		return tailscale.Device{}, fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	for _, device := range devices {
+		if device.Name == name || device.Hostname == name || device.NodeID == name {
+			return device, nil
+		}
+	}
+	return tailscale.Device{}, fmt.Errorf("%w: no Tailscale device named %q", admin.ErrNotFound, name)
+}
+
+// deviceSecretName returns the secret of the given device.
+func (c *RunCmd) deviceSecretName(device tailscale.Device) types.NamespacedName {
+	return c.state.Load().deviceSecretName(device, c.Namespace)
+}
+
+func (c *RunCmd) adminServer(ctx context.Context, filter tsutils.TagFilter) error {
+	log := ctrllog.FromContext(ctx).WithName("admin")
+	log.V(0).Info("Starting admin API server")
+
+	rt := chi.NewRouter()
+	rt.Use(middleware.RealIP)
+	rt.Use(middleware.Recoverer)
+	rt.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := log.WithValues("client", map[string]any{"ip": r.RemoteAddr, "user-agent": r.UserAgent()})
+			log.V(2).Info("Admin API request received", "request", map[string]any{"method": r.Method, "path": r.URL.Path})
+			next.ServeHTTP(w, r.WithContext(ctrllog.IntoContext(r.Context(), log)))
+		})
+	})
+	rt.Handle(admin.ServicePath+"*", admin.NewHandler(adminBackend{c: c, filter: filter}, c.Admin.Token))
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", c.Admin.Port),
+		Handler:           rt,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.V(0).Info("Admin API server starting", "address", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error(err, "Admin API server stopped with error")
+		return err
+	}
+
+	log.V(0).Info("Admin API server successfully stopped")
+	return nil
+}
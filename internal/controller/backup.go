@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/chezmoidotsh/argotails/internal/backup"
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+// BackupCmd dumps the secrets and services managed by Argotails into a tarball, to be recreated
+// by RestoreCmd after the ArgoCD namespace has been rebuilt.
+type BackupCmd struct {
+	Namespaces     []string `name:"namespace" help:"Namespaces where the managed secrets and services are backed up ('*' for all namespaces)." default:"argocd" env:"NAMESPACE"`
+	Output         string   `name:"output" short:"o" type:"path" placeholder:"PATH" help:"Path of the backup tarball ('-' for the standard output)." default:"-"`
+	PassphraseFile []byte   `name:"passphrase-file" type:"filecontent" placeholder:"PATH" help:"Path to the file containing the passphrase used to encrypt the backup (AES-256-GCM); the backup is not encrypted otherwise." env:"BACKUP_PASSPHRASE_FILE"`
+
+	Kubernetes struct {
+		Kubeconfig string `name:"kubeconfig" help:"Path, or ':'-separated list of paths, to the kubeconfig of the cluster where ArgoCD cluster secrets are managed (defaults to in-cluster configuration)." env:"KUBECONFIG" group:"Kubernetes flags"`
+		Context    string `name:"context" help:"Kubeconfig context to use." env:"KUBE_CONTEXT" group:"Kubernetes flags"`
+	} `embed:"" prefix:"kube."`
+}
+
+func (c *BackupCmd) Run(cli *kong.Context) error {
+	ctx := context.Background()
+
+	ks, err := newBackupClient(c.Kubernetes.Kubeconfig, c.Kubernetes.Context)
+	if err != nil {
+		return err
+	}
+
+	namespaces := c.Namespaces
+	for _, namespace := range namespaces {
+		if namespace == "*" {
+			namespaces = []string{""}
+			break
+		}
+	}
+
+	var b backup.Backup
+	selector := client.MatchingLabels{"apps.kubernetes.io/managed-by": cli.Model.Name}
+	for _, namespace := range namespaces {
+		var secrets corev1.SecretList
+		if err := ks.List(ctx, &secrets, client.InNamespace(namespace), selector); err != nil {
+			return fmt.Errorf("failed to list managed secrets: %w", err)
+		}
+		b.Secrets = append(b.Secrets, secrets.Items...)
+
+		var services corev1.ServiceList
+		if err := ks.List(ctx, &services, client.InNamespace(namespace), selector); err != nil {
+			return fmt.Errorf("failed to list managed services: %w", err)
+		}
+		b.Services = append(b.Services, services.Items...)
+	}
+
+	var w io.Writer = cli.Stdout
+	if c.Output != "-" {
+		f, err := os.OpenFile(c.Output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	if err := backup.Write(w, b, bytes.TrimSpace(c.PassphraseFile)); err != nil {
+		return fmt.Errorf("failed to write the backup: %w", err)
+	}
+	if c.Output != "-" {
+		_, _ = fmt.Fprintf(cli.Stdout, "%d secrets and %d services backed up to %q\n", len(b.Secrets), len(b.Services), c.Output)
+	}
+	return nil
+}
+
+// RestoreCmd recreates the secrets and services saved by BackupCmd.
+type RestoreCmd struct {
+	Input          string `arg:"" name:"backup" type:"path" help:"Path of the backup tarball ('-' for the standard input)."`
+	Namespace      string `name:"namespace" placeholder:"NAMESPACE" help:"Namespace where the objects are restored (defaults to their original namespace)." env:"NAMESPACE"`
+	Overwrite      bool   `name:"overwrite" help:"Replace the objects that already exist; otherwise, they are left untouched." default:"false"`
+	PassphraseFile []byte `name:"passphrase-file" type:"filecontent" placeholder:"PATH" help:"Path to the file containing the passphrase used to encrypt the backup." env:"BACKUP_PASSPHRASE_FILE"`
+
+	Kubernetes struct {
+		Kubeconfig string `name:"kubeconfig" help:"Path, or ':'-separated list of paths, to the kubeconfig of the cluster where ArgoCD cluster secrets are managed (defaults to in-cluster configuration)." env:"KUBECONFIG" group:"Kubernetes flags"`
+		Context    string `name:"context" help:"Kubeconfig context to use." env:"KUBE_CONTEXT" group:"Kubernetes flags"`
+	} `embed:"" prefix:"kube."`
+}
+
+func (c *RestoreCmd) Run(cli *kong.Context) error {
+	ctx := context.Background()
+
+	var r io.Reader = os.Stdin
+	if c.Input != "-" {
+		f, err := os.Open(c.Input)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	b, err := backup.Read(r, bytes.TrimSpace(c.PassphraseFile))
+	if err != nil {
+		return err
+	}
+
+	ks, err := newBackupClient(c.Kubernetes.Kubeconfig, c.Kubernetes.Context)
+	if err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for _, obj := range b.Objects() {
+		if c.Namespace != "" {
+			obj.SetNamespace(c.Namespace)
+		}
+		kind := "secret"
+		if _, ok := obj.(*corev1.Service); ok {
+			kind = "service"
+		}
+
+		err := ks.Create(ctx, obj)
+		switch {
+		case err == nil:
+			_, _ = fmt.Fprintf(cli.Stdout, "%s %s/%s created\n", kind, obj.GetNamespace(), obj.GetName())
+		case apierrors.IsAlreadyExists(err) && !c.Overwrite:
+			_, _ = fmt.Fprintf(cli.Stdout, "%s %s/%s already exists, skipped\n", kind, obj.GetNamespace(), obj.GetName())
+		case apierrors.IsAlreadyExists(err):
+			if err := overwrite(ctx, ks, obj); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("failed to restore %s %s/%s: %w", kind, obj.GetNamespace(), obj.GetName(), err))
+				continue
+			}
+			_, _ = fmt.Fprintf(cli.Stdout, "%s %s/%s replaced\n", kind, obj.GetNamespace(), obj.GetName())
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("failed to restore %s %s/%s: %w", kind, obj.GetNamespace(), obj.GetName(), err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// overwrite replaces an existing object by its backed up version, keeping the fields allocated
+// by the API server (resource version and cluster IPs).
+func overwrite(ctx context.Context, ks client.Client, obj client.Object) error {
+	current := obj.DeepCopyObject().(client.Object)
+	if err := ks.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	if service, ok := obj.(*corev1.Service); ok {
+		service.Spec.ClusterIP = current.(*corev1.Service).Spec.ClusterIP
+		service.Spec.ClusterIPs = current.(*corev1.Service).Spec.ClusterIPs
+	}
+	return ks.Update(ctx, obj)
+}
+
+func newBackupClient(kubeconfig, context string) (client.Client, error) {
+	kcfg, err := kubeutils.Target{Kubeconfig: kubeconfig, Context: context}.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(kcfg, client.Options{Scheme: clientgoscheme.Scheme})
+}
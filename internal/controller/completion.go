@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/prometheus/common/version"
+)
+
+type (
+	// CompletionCmd prints the shell completion script of the command line, generated from its
+	// kong model.
+	CompletionCmd struct {
+		Shell string `arg:"" help:"Shell to generate the completion script for: 'bash', 'zsh' or 'fish'." enum:"bash,zsh,fish"`
+	}
+
+	// ManCmd prints the man page of the command line, generated from its kong model.
+	ManCmd struct{}
+)
+
+func (c CompletionCmd) Run(cli *kong.Context) error {
+	switch c.Shell {
+	case "bash":
+		return writeBashCompletion(cli.Stdout, cli.Model)
+	case "zsh":
+		return writeZshCompletion(cli.Stdout, cli.Model)
+	case "fish":
+		return writeFishCompletion(cli.Stdout, cli.Model)
+	}
+	return fmt.Errorf("unsupported shell %q", c.Shell)
+}
+
+func (ManCmd) Run(cli *kong.Context) error {
+	return writeManPage(cli.Stdout, cli.Model)
+}
+
+// commands returns the visible commands of the application.
+func commands(app *kong.Application) []*kong.Node {
+	var nodes []*kong.Node
+	for _, node := range app.Children {
+		if node.Type == kong.CommandNode && !node.Hidden {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// commandFlags returns the visible flags of the given command, including the application ones.
+func commandFlags(node *kong.Node) []*kong.Flag {
+	var flags []*kong.Flag
+	for _, group := range node.AllFlags(true) {
+		flags = append(flags, group...)
+	}
+	return flags
+}
+
+// flagNames returns the long names under which the given flag can be passed.
+func flagNames(flag *kong.Flag) []string {
+	names := append([]string{flag.Name}, flag.Aliases...)
+	if flag.Tag.Negatable != "" {
+		names = append(names, "no-"+flag.Name)
+	}
+	return names
+}
+
+// isFileValue returns true if the given value is a path.
+func isFileValue(value *kong.Value) bool {
+	switch value.Tag.Type {
+	case "path", "existingfile", "existingdir", "filecontent":
+		return true
+	}
+	return false
+}
+
+// takesValue returns true if the given flag requires a value.
+func takesValue(flag *kong.Flag) bool { return !flag.IsBool() && !flag.IsCounter() }
+
+func writeBashCompletion(w io.Writer, app *kong.Application) error {
+	var names, words, values strings.Builder
+	for _, node := range commands(app) {
+		_, _ = fmt.Fprintf(&names, "%s|", node.Name)
+
+		var completions []string
+		for _, positional := range node.Positional {
+			completions = append(completions, positional.EnumSlice()...)
+		}
+		for _, flag := range commandFlags(node) {
+			for _, name := range flagNames(flag) {
+				if takesValue(flag) && !strings.HasPrefix(name, "no-") {
+					name += "="
+				}
+				completions = append(completions, "--"+name)
+			}
+
+			switch {
+			case !takesValue(flag):
+			case flag.Enum != "":
+				_, _ = fmt.Fprintf(&values, "\t\t%q) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", node.Name+" --"+flag.Name, strings.Join(flag.EnumSlice(), " "))
+			case isFileValue(flag.Value):
+				_, _ = fmt.Fprintf(&values, "\t\t%q) COMPREPLY=($(compgen -f -- \"$cur\")) ;;\n", node.Name+" --"+flag.Name)
+			}
+		}
+		_, _ = fmt.Fprintf(&words, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", node.Name, strings.Join(completions, " "))
+	}
+
+	var roots []string
+	for _, node := range commands(app) {
+		roots = append(roots, node.Name)
+	}
+	for _, flag := range app.Flags {
+		if !flag.Hidden {
+			roots = append(roots, "--"+flag.Name)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `# bash completion for %[1]s
+_%[1]s() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" flag="" word
+
+	for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+		case "$word" in
+		%[2]s) cmd="$word"; break ;;
+		esac
+	done
+
+	# COMP_WORDBREAKS splits --flag=value into "--flag", "=" and "value"
+	if [[ "$cur" == "=" ]]; then
+		flag="$prev"
+		cur=""
+	elif [[ "$prev" == "=" ]]; then
+		flag="${COMP_WORDS[COMP_CWORD-2]}"
+	fi
+	if [[ -n "$flag" ]]; then
+		case "$cmd $flag" in
+%[3]s		esac
+		return
+	fi
+
+	case "$cmd" in
+	"") COMPREPLY=($(compgen -W %[4]q -- "$cur")) ;;
+%[5]s	esac
+	[[ "${COMPREPLY[*]}" == *= ]] && compopt -o nospace
+}
+
+complete -o default -F _%[1]s %[1]s
+`, app.Name, strings.TrimSuffix(names.String(), "|"), values.String(), strings.Join(roots, " "), words.String())
+	return err
+}
+
+// zshEscaper escapes the characters interpreted by the zsh _arguments and _values specs.
+var zshEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`, `'`, `'\''`)
+
+func writeZshCompletion(w io.Writer, app *kong.Application) error {
+	var cmds, args strings.Builder
+	for _, node := range commands(app) {
+		_, _ = fmt.Fprintf(&cmds, " \\\n\t\t\t'%s[%s]'", node.Name, zshEscaper.Replace(node.Help))
+
+		_, _ = fmt.Fprintf(&args, "\t\t%s)\n\t\t\t_arguments", node.Name)
+		for _, positional := range node.Positional {
+			action := ""
+			if positional.Enum != "" {
+				action = "(" + strings.Join(positional.EnumSlice(), " ") + ")"
+			}
+			_, _ = fmt.Fprintf(&args, " \\\n\t\t\t\t'%d:%s:%s'", positional.Position+1, positional.Name, action)
+		}
+		for _, flag := range commandFlags(node) {
+			repeat := ""
+			if flag.IsCumulative() {
+				repeat = "*"
+			}
+			for _, name := range flagNames(flag) {
+				help := zshEscaper.Replace(flag.Help)
+				if !takesValue(flag) || strings.HasPrefix(name, "no-") {
+					_, _ = fmt.Fprintf(&args, " \\\n\t\t\t\t'%s--%s[%s]'", repeat, name, help)
+					continue
+				}
+
+				action := ""
+				switch {
+				case flag.Enum != "":
+					action = "(" + strings.Join(flag.EnumSlice(), " ") + ")"
+				case isFileValue(flag.Value):
+					action = "_files"
+				}
+				_, _ = fmt.Fprintf(&args, " \\\n\t\t\t\t'%s--%s=[%s]:%s:%s'", repeat, name, help, zshEscaper.Replace(flag.FormatPlaceHolder()), action)
+			}
+		}
+		args.WriteString("\n\t\t\t;;\n")
+	}
+
+	_, err := fmt.Fprintf(w, `#compdef %[1]s
+
+_%[1]s() {
+	local line state
+
+	_arguments -C \
+		'1: :->cmds' \
+		'*:: :->args'
+
+	case $state in
+	cmds)
+		_values 'command'%[2]s
+		;;
+	args)
+		case $line[1] in
+%[3]s		esac
+		;;
+	esac
+}
+
+if [ "$funcstack[1]" = "_%[1]s" ]; then
+	_%[1]s "$@"
+else
+	compdef _%[1]s %[1]s
+fi
+`, app.Name, cmds.String(), args.String())
+	return err
+}
+
+// fishEscaper escapes the characters interpreted inside fish single-quoted strings.
+var fishEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func writeFishCompletion(w io.Writer, app *kong.Application) error {
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "# fish completion for %[1]s\ncomplete -c %[1]s -f\n", app.Name)
+
+	for _, node := range commands(app) {
+		_, _ = fmt.Fprintf(&out, "complete -c %s -n __fish_use_subcommand -a %s -d '%s'\n", app.Name, node.Name, fishEscaper.Replace(node.Help))
+	}
+	for _, node := range commands(app) {
+		condition := "'__fish_seen_subcommand_from " + node.Name + "'"
+		for _, positional := range node.Positional {
+			if positional.Enum != "" {
+				_, _ = fmt.Fprintf(&out, "complete -c %s -n %s -a '%s' -d '%s'\n", app.Name, condition, strings.Join(positional.EnumSlice(), " "), fishEscaper.Replace(positional.Help))
+			}
+		}
+		for _, flag := range commandFlags(node) {
+			for _, name := range flagNames(flag) {
+				args := ""
+				if takesValue(flag) && !strings.HasPrefix(name, "no-") {
+					switch {
+					case flag.Enum != "":
+						args = " -x -a '" + strings.Join(flag.EnumSlice(), " ") + "'"
+					case isFileValue(flag.Value):
+						args = " -r -F"
+					default:
+						args = " -x"
+					}
+				}
+				_, _ = fmt.Fprintf(&out, "complete -c %s -n %s -l %s%s -d '%s'\n", app.Name, condition, name, args, fishEscaper.Replace(flag.Help))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// roffEscaper escapes the characters interpreted by roff inside a line.
+var roffEscaper = strings.NewReplacer(`\`, `\e`, `-`, `\-`)
+
+// roff escapes the given text, also protecting the lines starting with a control character.
+func roff(text string) string {
+	lines := strings.Split(roffEscaper.Replace(text), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeManPage(w io.Writer, app *kong.Application) error {
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, ".TH %s 1 \"\" \"%s %s\" \"User Commands\"\n", strings.ToUpper(app.Name), app.Name, version.Version)
+	_, _ = fmt.Fprintf(&out, ".SH NAME\n%s \\- %s\n", app.Name, roff(app.Help))
+	_, _ = fmt.Fprintf(&out, ".SH SYNOPSIS\n.B %s\n\\fICOMMAND\\fR [\\fIFLAGS\\fR]\n", app.Name)
+
+	out.WriteString(".SH COMMANDS\n")
+	for _, node := range commands(app) {
+		_, _ = fmt.Fprintf(&out, ".SS \"%s %s\"\n%s\n", app.Name, node.Name, roff(node.Help))
+		if node.Detail != "" {
+			_, _ = fmt.Fprintf(&out, ".PP\n%s\n", roff(node.Detail))
+		}
+
+		for _, positional := range node.Positional {
+			_, _ = fmt.Fprintf(&out, ".TP\n\\fI%s\\fR\n%s\n", roff(strings.ToUpper(positional.Name)), roff(positional.Help))
+		}
+		for _, flag := range node.Flags {
+			if flag.Hidden {
+				continue
+			}
+
+			_, _ = fmt.Fprintf(&out, ".TP\n\\fB\\-\\-%s\\fR", roff(flag.Name))
+			if takesValue(flag) {
+				_, _ = fmt.Fprintf(&out, "=\\fI%s\\fR", roff(flag.FormatPlaceHolder()))
+			}
+			_, _ = fmt.Fprintf(&out, "\n%s", roff(flag.Help))
+			if flag.HasDefault && flag.Default != "" {
+				_, _ = fmt.Fprintf(&out, " Defaults to \\fB%s\\fR.", roff(flag.Default))
+			}
+			if len(flag.Envs) > 0 {
+				_, _ = fmt.Fprintf(&out, " Environment: \\fB$%s\\fR.", roff(strings.Join(flag.Envs, ", $")))
+			}
+			out.WriteString("\n")
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
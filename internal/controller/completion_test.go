@@ -0,0 +1,56 @@
+package controller_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrl "github.com/chezmoidotsh/argotails/internal/controller"
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
+)
+
+func generate(t *testing.T, args ...string) string {
+	t.Helper()
+
+	var stdout bytes.Buffer
+	cmd := ctrl.Command{}
+	parser, err := kong.New(&cmd,
+		kong.Name("argotails"),
+		kong.Writers(&stdout, &bytes.Buffer{}),
+		zapcoreutils.LevelEnablerMapper,
+		zapcoreutils.EncoderMapper,
+	)
+	require.NoError(t, err)
+
+	cli, err := parser.Parse(args)
+	require.NoError(t, err)
+	require.NoError(t, cli.Run())
+	return stdout.String()
+}
+
+func TestCompletion(t *testing.T) {
+	bash := generate(t, "completion", "bash")
+	assert.Contains(t, bash, "complete -o default -F _argotails argotails")
+	assert.Contains(t, bash, `"run --output.flavor") COMPREPLY=($(compgen -W "argocd flux kubeconfig inventory" -- "$cur")) ;;`)
+	assert.Contains(t, bash, "--reconcile.interval=")
+
+	zsh := generate(t, "completion", "zsh")
+	assert.Contains(t, zsh, "#compdef argotails")
+	assert.Contains(t, zsh, `'--kube.kubeconfig=[`)
+	assert.Contains(t, zsh, `'--no-tsnet.funnel[`)
+
+	fish := generate(t, "completion", "fish")
+	assert.Contains(t, fish, "complete -c argotails -n '__fish_seen_subcommand_from render' -l encrypt -x -a 'none sealed-secrets sops'")
+	assert.Contains(t, fish, "-l ts.webhook.secret-file -r -F")
+}
+
+func TestMan(t *testing.T) {
+	man := generate(t, "man")
+	assert.Contains(t, man, ".TH ARGOTAILS 1")
+	assert.Contains(t, man, `.SS "argotails run"`)
+	assert.Contains(t, man, `\fB\-\-reconcile.interval\fR=\fI30s\fR`)
+	assert.Contains(t, man, `Environment: \fB$RECONCILE_INTERVAL\fR.`)
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/alecthomas/kong"
+	"sigs.k8s.io/yaml"
+)
+
+type (
+	// ConfigCmd groups the commands describing the configuration of the command line.
+	ConfigCmd struct {
+		PrintDefaults ConfigPrintDefaultsCmd `cmd:"" name:"print-defaults" help:"Print every flag of every command with its default value and environment variable, as YAML or JSON."`
+	}
+
+	// ConfigPrintDefaultsCmd prints the machine-readable schema of the command line, generated from
+	// its kong model, so that the Helm chart and the documentation can be generated or validated.
+	ConfigPrintDefaultsCmd struct {
+		Output string `name:"output" short:"o" help:"Output format: 'yaml' or 'json'." default:"yaml" enum:"yaml,json"`
+	}
+
+	// ConfigSchema is the machine-readable schema of the command line.
+	ConfigSchema struct {
+		Name     string          `json:"name"`
+		Commands []CommandSchema `json:"commands"`
+	}
+
+	// CommandSchema describes a command and its own flags.
+	CommandSchema struct {
+		Name  string       `json:"name"`
+		Help  string       `json:"help"`
+		Flags []FlagSchema `json:"flags,omitempty"`
+	}
+
+	// FlagSchema describes a flag: how it is passed, its default value and its accepted values.
+	FlagSchema struct {
+		Name      string   `json:"name"`
+		Aliases   []string `json:"aliases,omitempty"`
+		Env       []string `json:"env,omitempty"`
+		Default   *string  `json:"default,omitempty"`
+		Type      string   `json:"type"`
+		Enum      []string `json:"enum,omitempty"`
+		Required  bool     `json:"required,omitempty"`
+		Negatable bool     `json:"negatable,omitempty"`
+		Group     string   `json:"group,omitempty"`
+		Help      string   `json:"help"`
+	}
+)
+
+func (c ConfigPrintDefaultsCmd) Run(cli *kong.Context) error {
+	return writeConfigSchema(cli.Stdout, c.Output, NewConfigSchema(cli.Model))
+}
+
+// NewConfigSchema returns the schema of the visible commands and flags of the given application.
+func NewConfigSchema(app *kong.Application) ConfigSchema {
+	schema := ConfigSchema{Name: app.Name}
+
+	var walk func(node *kong.Node)
+	walk = func(node *kong.Node) {
+		for _, child := range node.Children {
+			if child.Type != kong.CommandNode || child.Hidden {
+				continue
+			}
+
+			command := CommandSchema{Name: child.Path(), Help: child.Help}
+			for _, flag := range child.Flags {
+				if !flag.Hidden {
+					command.Flags = append(command.Flags, newFlagSchema(flag))
+				}
+			}
+			schema.Commands = append(schema.Commands, command)
+			walk(child)
+		}
+	}
+	walk(app.Node)
+	return schema
+}
+
+// newFlagSchema returns the schema of the given flag.
+func newFlagSchema(flag *kong.Flag) FlagSchema {
+	schema := FlagSchema{
+		Name:      flag.Name,
+		Aliases:   flag.Aliases,
+		Env:       flag.Envs,
+		Type:      flag.Target.Type().String(),
+		Required:  flag.Required,
+		Negatable: flag.Tag.Negatable != "",
+		Help:      flag.Help,
+	}
+	if flag.HasDefault {
+		value := flag.Default
+		schema.Default = &value
+	}
+	if flag.Enum != "" {
+		schema.Enum = flag.EnumSlice()
+	}
+	if flag.Group != nil {
+		schema.Group = flag.Group.Title
+	}
+	return schema
+}
+
+// writeConfigSchema writes the given schema in the given output format.
+func writeConfigSchema(w io.Writer, output string, schema ConfigSchema) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(schema)
+	}
+
+	raw, err := yaml.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
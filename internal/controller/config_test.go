@@ -0,0 +1,55 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	ctrl "github.com/chezmoidotsh/argotails/internal/controller"
+)
+
+func TestConfigPrintDefaults(t *testing.T) {
+	var schema ctrl.ConfigSchema
+	require.NoError(t, json.Unmarshal([]byte(generate(t, "config", "print-defaults", "--output=json")), &schema))
+	assert.Equal(t, "argotails", schema.Name)
+
+	flags := map[string]ctrl.FlagSchema{}
+	for _, command := range schema.Commands {
+		if command.Name == "run" {
+			for _, flag := range command.Flags {
+				flags[flag.Name] = flag
+			}
+		}
+	}
+	require.Contains(t, flags, "reconcile.interval")
+	assert.Equal(t, "30s", *flags["reconcile.interval"].Default)
+	assert.Equal(t, []string{"RECONCILE_INTERVAL"}, flags["reconcile.interval"].Env)
+	assert.Equal(t, []string{"argocd", "flux", "kubeconfig", "inventory"}, flags["output.flavor"].Enum)
+	assert.True(t, flags["ts.tailnet"].Required)
+	assert.Nil(t, flags["ts.tailnet"].Default)
+
+	var fromYAML ctrl.ConfigSchema
+	require.NoError(t, yaml.Unmarshal([]byte(generate(t, "config", "print-defaults")), &fromYAML))
+	assert.Equal(t, schema, fromYAML)
+}
+
+// TestConfigReferenceConformance checks that every flag is documented in the configuration
+// reference of the README.
+func TestConfigReferenceConformance(t *testing.T) {
+	readme, err := os.ReadFile("../../README.md")
+	require.NoError(t, err)
+
+	var schema ctrl.ConfigSchema
+	require.NoError(t, json.Unmarshal([]byte(generate(t, "config", "print-defaults", "--output=json")), &schema))
+	for _, command := range schema.Commands {
+		for _, flag := range command.Flags {
+			documented := strings.Contains(string(readme), "--"+flag.Name) || strings.Contains(string(readme), "--[no-]"+flag.Name)
+			assert.True(t, documented, "flag --%s of %q is not documented in the README", flag.Name, command.Name)
+		}
+	}
+}
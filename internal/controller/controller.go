@@ -2,11 +2,18 @@ package controller
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -16,151 +23,670 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/oklog/ulid/v2"
 	"github.com/prometheus/common/version"
+	uberzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"tailscale.com/client/tailscale/v2"
 
+	"github.com/chezmoidotsh/argotails/internal/api"
+	"github.com/chezmoidotsh/argotails/internal/apis/v1alpha1"
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	"github.com/chezmoidotsh/argotails/internal/checkpoint"
+	"github.com/chezmoidotsh/argotails/internal/dns"
+	"github.com/chezmoidotsh/argotails/internal/featuregate"
+	"github.com/chezmoidotsh/argotails/internal/fips"
+	"github.com/chezmoidotsh/argotails/internal/fleet"
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+	"github.com/chezmoidotsh/argotails/internal/policy"
+	"github.com/chezmoidotsh/argotails/internal/rbac"
 	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	"github.com/chezmoidotsh/argotails/internal/report"
+	"github.com/chezmoidotsh/argotails/internal/schedule"
 	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	tsnetutils "github.com/chezmoidotsh/argotails/internal/tsnet"
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
 )
 
 type (
-	VersionCmd struct{}
-	RunCmd     struct {
-		ReconcileInterval time.Duration `name:"reconcile.interval" help:"Time between two Tailscale devices and ArgoCD cluster secrets reconciliation." default:"30s" env:"RECONCILE_INTERVAL"`
+	VersionCmd struct {
+		Output string `name:"output" short:"o" help:"Output format: 'text', 'json' or 'yaml'." enum:"text,json,yaml" default:"text"`
+	}
+	RBACCmd struct {
+		Name                string            `name:"name" help:"Name of the Role, RoleBinding and ServiceAccount." default:"argotails"`
+		Namespace           string            `name:"namespace" help:"Namespace where ArgoCD cluster secrets are managed." default:"argocd" env:"NAMESPACE"`
+		Mode                string            `name:"mode" help:"Deployment mode: 'controller', 'poll-only' or 'webhook-only', which does not require the permission to watch the managed resources." enum:"controller,webhook-only,poll-only" default:"controller" env:"MODE"`
+		CreateService       bool              `name:"service.create" help:"Grant the permissions required to create Kubernetes services for the Tailscale devices." default:"false" env:"SERVICE_CREATE_SERVICE"`
+		ClusterResource     bool              `name:"cluster.resource" help:"Grant the permissions required to maintain TailscaleCluster resources." default:"false" env:"CLUSTER_RESOURCE"`
+		Application         bool              `name:"cluster.application" help:"Grant the permissions required to create an ArgoCD Application per registered cluster." default:"false" env:"CLUSTER_APPLICATION"`
+		ReportConfigMap     string            `name:"reconcile.report-configmap" placeholder:"NAME" help:"Grant the permissions required to write the synchronization report into the given ConfigMap." env:"RECONCILE_REPORT_CONFIGMAP"`
+		CheckpointConfigMap string            `name:"reconcile.checkpoint-configmap" placeholder:"NAME" help:"Grant the permissions required to checkpoint the synchronization progress into the given ConfigMap." env:"RECONCILE_CHECKPOINT_CONFIGMAP"`
+		PauseConfigMap      string            `name:"reconcile.pause-configmap" placeholder:"NAME" help:"Grant the permissions required to watch the given pause ConfigMap." env:"RECONCILE_PAUSE_CONFIGMAP"`
+		RolloutConfigMap    string            `name:"rollout.state-configmap" placeholder:"NAME" help:"Grant the permissions required to persist the rollout state into the given ConfigMap." env:"ROLLOUT_STATE_CONFIGMAP"`
+		DNSConfigMap        string            `name:"dns.configmap" placeholder:"[NAMESPACE/]NAME" help:"Grant the permissions required to write the hosts file into the given ConfigMap (in --namespace unless specified)." env:"DNS_CONFIGMAP"`
+		Fleets              string            `name:"cluster.fleets" type:"existingfile" placeholder:"PATH" help:"Grant the permissions required to manage the resources of the fleets in their namespaces." env:"CLUSTER_FLEETS"`
+		CleanupNamespaces   []string          `name:"cluster.cleanup-namespaces" placeholder:"NAMESPACE,..." help:"Grant the permissions required to clean up the managed secrets of the given namespaces (a ClusterRole for '*')." env:"CLUSTER_CLEANUP_NAMESPACES"`
+		TenantNamespaces    map[string]string `name:"cluster.tenant-namespace" placeholder:"TAG=NAMESPACE" help:"Grant the permissions required to manage the resources of the devices in the namespace of their tenant." env:"CLUSTER_TENANT_NAMESPACES"`
+		Backends            map[string]string `name:"output.backend" placeholder:"TAG=BACKEND" help:"Grant the permissions required to register the devices as 'karmada' Cluster or 'ocm' ManagedCluster objects, through a ClusterRole." env:"OUTPUT_BACKENDS"`
+		As                  string            `name:"kube.as" placeholder:"USER" help:"Grant the permission to impersonate the given user (and --kube.as-group) for the writes; the permissions required by the writes must then be granted to this identity." env:"KUBE_AS"`
+		AsGroups            []string          `name:"kube.as-group" placeholder:"GROUP,..." help:"Grant the permission to impersonate the given groups along with --kube.as." env:"KUBE_AS_GROUPS"`
+	}
+	RunCmd struct {
+		Mode                string          `name:"mode" help:"Deployment mode: 'controller' watches the managed secrets and synchronizes every device on schedule, 'webhook-only' only translates the Tailscale webhook events into Kubernetes API writes, without watching the secrets nor synchronizing on schedule (requires --ts.webhook.enable), and 'poll-only' never opens any listening port (no webhook, API, metrics nor health probes) and skips the unchanged synchronization cycles." enum:"controller,webhook-only,poll-only" default:"controller" env:"MODE"`
+		RequireFIPS         bool            `name:"require-fips" help:"Refuse to start unless the cryptographic operations (e.g. the webhook HMAC verification) run in FIPS 140 mode: build with GOFIPS140 or a FIPS backend (boringcrypto, systemcrypto), or run with GODEBUG=fips140=on." default:"false" env:"REQUIRE_FIPS"`
+		FeatureGates        map[string]bool `name:"feature-gates" placeholder:"NAME=BOOL,..." mapsep:"," help:"Enable or disable the experimental subsystems (e.g. 'WebhookAutoProvision=true'); 'argotails version' lists the known feature gates with their default state." env:"FEATURE_GATES"`
+		ReconcileInterval   time.Duration   `name:"reconcile.interval" help:"Time between two Tailscale devices and ArgoCD cluster secrets reconciliation, aligned on the wall clock (e.g. at :00 and :30 of every minute for 30s)." default:"30s" env:"RECONCILE_INTERVAL"`
+		Schedule            string          `name:"reconcile.schedule" placeholder:"CRON" help:"Standard 5-field cron expression scheduling the Tailscale devices and ArgoCD cluster secrets reconciliations (e.g. '*/5 * * * *'), overriding --reconcile.interval." env:"RECONCILE_SCHEDULE"`
+		ReconcileTimeout    time.Duration   `name:"reconcile.timeout" help:"Maximum duration of a time-based synchronization, after which it is interrupted so that a hung Tailscale or Kubernetes API call cannot stall the next ones (0 to disable)." default:"0" env:"RECONCILE_TIMEOUT"`
+		Jitter              time.Duration   `name:"reconcile.jitter" help:"Maximum random delay added to every scheduled reconciliation, to spread the reconciliations of several instances (0 to disable)." default:"0" env:"RECONCILE_JITTER"`
+		PauseConfigMap      string          `name:"reconcile.pause-configmap" placeholder:"NAME" help:"ConfigMap watched to pause every mutation of the Kubernetes resources while its 'paused' key is 'true' (e.g. during maintenance windows); the Tailscale devices are still listed." env:"RECONCILE_PAUSE_CONFIGMAP"`
+		ReportConfigMap     string          `name:"reconcile.report-configmap" placeholder:"NAME" help:"ConfigMap where the report of every synchronization cycle (secrets without matching device, devices failing to produce their secret) is written, in addition to the report metrics." env:"RECONCILE_REPORT_CONFIGMAP"`
+		ResyncPerObject     time.Duration   `name:"reconcile.resync-per-object" help:"Time between two reconciliations of every managed secret through the controller queue, independently of --reconcile.interval, to spread the Tailscale and Kubernetes API requests over time (0 to disable)." default:"0" env:"RECONCILE_RESYNC_PER_OBJECT"`
+		SkipUnchanged       bool            `name:"reconcile.skip-unchanged" help:"Skip the synchronization cycles whose Tailscale devices are identical to the last successful cycle ones; the managed secrets are still watched for manual changes." default:"false" env:"RECONCILE_SKIP_UNCHANGED"`
+		ChunkSize           int             `name:"reconcile.chunk-size" help:"Number of secrets synchronized per chunk by the time-based synchronization, the most recently changed devices first, the progress being checkpointed after every chunk (0 to synchronize every secret at once)." default:"0" env:"RECONCILE_CHUNK_SIZE"`
+		DeletionMaxPercent  int             `name:"reconcile.deletion-max-percent" help:"Maximum percentage of the managed secrets deleted by a single time-based synchronization (e.g. after a mass rename or a misconfigured filter); beyond it, no secret is deleted and the synchronization fails (0 to disable)." default:"0" env:"RECONCILE_DELETION_MAX_PERCENT"`
+		PlanOutput          string          `name:"plan-output" enum:"none,log,json" help:"Output of the plan computed by every time-based synchronization before applying it: 'log' logs every planned change, 'json' writes the planned changes as a JSON document on the standard output (one per synchronization)." default:"none" env:"PLAN_OUTPUT"`
+		CheckpointConfigMap string          `name:"reconcile.checkpoint-configmap" placeholder:"NAME" help:"ConfigMap where the progress of the chunked synchronization is checkpointed, so that a restarted controller resumes the interrupted synchronization instead of starting over (requires --reconcile.chunk-size)." env:"RECONCILE_CHECKPOINT_CONFIGMAP"`
+		CheckpointMaxAge    time.Duration   `name:"reconcile.checkpoint-max-age" help:"Age after which the checkpoint of an interrupted synchronization is discarded, the next synchronization starting over (0 for no limit)." default:"24h" env:"RECONCILE_CHECKPOINT_MAX_AGE"`
 
 		Tailscale struct {
 			BaseURL          *url.URL `name:"base-url" help:"Tailscale API base URL." default:"https://api.tailscale.com" env:"TAILSCALE_BASE_URL" group:"Tailscale flags"`
+			ProxyURL         *url.URL `name:"proxy-url" placeholder:"URL" help:"HTTP(S) or SOCKS5 proxy used to reach the Tailscale API (defaults to HTTPS_PROXY/HTTP_PROXY environment variables)." env:"TAILSCALE_PROXY_URL" group:"Tailscale flags"`
 			Tailnet          string   `name:"tailnet" required:"" placeholder:"TAILSCALE_TAILNET" help:"Tailscale network name." env:"TAILSCALE_TAILNET" group:"Tailscale flags"`
 			AuthKey          string   `name:"authkey" required:"" placeholder:"TAILSCALE_AUTH_KEY" help:"Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY" group:"Tailscale flags" xor:"authkey"`
-			AuthKeyFile      []byte   `name:"authkey-file" type:"filecontent" placeholder:"TAILSCALE_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY_FILE" group:"Tailscale flags" xor:"authkey"`
+			AuthKeyFile      string   `name:"authkey-file" type:"existingfile" placeholder:"TAILSCALE_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale OAuth key, reloaded when the OAuth client is rotated." env:"TAILSCALE_AUTH_KEY_FILE" group:"Tailscale flags" xor:"authkey"`
+			Scopes           []string `name:"scopes" placeholder:"SCOPE" help:"OAuth scopes requested for the Tailscale API, which must cover the enabled features (defaults to the scopes required by the enabled features)." env:"TAILSCALE_SCOPES" group:"Tailscale flags"`
 			DeviceTagFilters []string `name:"device-filter" placeholder:"PATTERN" help:"List of regular expressions to filter the Tailscale devices based on their tags." group:"Tailscale flags"`
+			ServerSideFilter bool     `name:"server-side-filter" help:"Let the Tailscale API filter the devices by tag when every --ts.device-filter pattern is a plain tag, reducing the listing payloads; regular expressions are always evaluated client-side." default:"true" negatable:"" env:"TAILSCALE_SERVER_SIDE_FILTER" group:"Tailscale flags"`
+			DeviceSnapshot   string   `name:"device-snapshot" type:"path" placeholder:"PATH" help:"File where the last known Tailscale devices are persisted, used when the Tailscale API is unavailable (e.g. after a restart)." env:"TAILSCALE_DEVICE_SNAPSHOT" group:"Tailscale flags"`
 
 			Webhook struct {
-				Enable     bool   `name:"enable" help:"Enable the Tailscale webhook handler." default:"false" env:"TAILSCALE_WEBHOOK_ENABLE" group:"Tailscale flags"`
-				Port       int    `name:"port" help:"Tailscale webhook port." default:"3000" env:"TAILSCALE_WEBHOOK_PORT" group:"Tailscale flags" `
-				Secret     string `name:"secret" placeholder:"TAILSCALE_WEBHOOK_SECRET" help:"Tailscale webhook secret." env:"TAILSCALE_WEBHOOK_SECRET" group:"Tailscale flags" xor:"webhook"`
-				SecretFile []byte `name:"secret-file"  type:"filecontent" placeholder:"TAILSCALE_WEBHOOK_SECRET_FILE" help:"Path to the file containing the Tailscale webhook secret." env:"TAILSCALE_WEBHOOK_SECRET_FILE" group:"Tailscale flags" xor:"webhook"`
+				Enable        bool          `name:"enable" help:"Enable the Tailscale webhook handler." default:"false" env:"TAILSCALE_WEBHOOK_ENABLE" group:"Tailscale flags"`
+				Port          int           `name:"port" help:"Tailscale webhook port." default:"3000" env:"TAILSCALE_WEBHOOK_PORT" group:"Tailscale flags" `
+				Secret        string        `name:"secret" placeholder:"TAILSCALE_WEBHOOK_SECRET" help:"Tailscale webhook secret." env:"TAILSCALE_WEBHOOK_SECRET" group:"Tailscale flags" xor:"webhook"`
+				SecretFile    []byte        `name:"secret-file"  type:"filecontent" placeholder:"TAILSCALE_WEBHOOK_SECRET_FILE" help:"Path to the file containing the Tailscale webhook secret." env:"TAILSCALE_WEBHOOK_SECRET_FILE" group:"Tailscale flags" xor:"webhook"`
+				ClockSkew     time.Duration `name:"clock-skew" help:"Maximum difference allowed between the webhook signature timestamp and the local clock." default:"5m" env:"TAILSCALE_WEBHOOK_CLOCK_SKEW" group:"Tailscale flags"`
+				AutoProvision bool          `name:"autoprovision" help:"Register the --ts.webhook.url endpoint in the tailnet, subscribed to the device events, and keep its secret in the --ts.webhook.secret-name Kubernetes secret (requires the 'webhooks' OAuth scope)." default:"false" env:"TAILSCALE_WEBHOOK_AUTOPROVISION" group:"Tailscale flags"`
+				URL           *url.URL      `name:"url" placeholder:"URL" help:"Public URL of the webhook endpoint registered by --ts.webhook.autoprovision (e.g. https://argotails.example.com/webhook)." env:"TAILSCALE_WEBHOOK_URL" group:"Tailscale flags"`
+				SecretName    string        `name:"secret-name" help:"Kubernetes secret, in --namespace, where the secret of the webhook registered by --ts.webhook.autoprovision is kept." default:"argotails-webhook" env:"TAILSCALE_WEBHOOK_SECRET_NAME" group:"Tailscale flags"`
+				RotateSecret  bool          `name:"rotate-secret" help:"Rotate the secret of the webhook registered by --ts.webhook.autoprovision at startup." default:"false" env:"TAILSCALE_WEBHOOK_ROTATE_SECRET" group:"Tailscale flags"`
+				CheckInterval time.Duration `name:"check-interval" help:"Time between two checks that the --ts.webhook.url endpoint is registered in the tailnet and subscribed to the device events, reported by the argotails_tailscale_webhook_health metric (requires the 'webhooks:read' OAuth scope, 0 to disable)." default:"15m" env:"TAILSCALE_WEBHOOK_CHECK_INTERVAL" group:"Tailscale flags"`
+				TLSCertFile   string        `name:"tls-cert-file" type:"existingfile" placeholder:"PATH" help:"Path to the PEM certificate the webhook is served with over TLS, reloaded when the file changes (requires --ts.webhook.tls-key-file)." env:"TAILSCALE_WEBHOOK_TLS_CERT_FILE" group:"Tailscale flags" xor:"webhook-tls"`
+				TLSKeyFile    string        `name:"tls-key-file" type:"existingfile" placeholder:"PATH" help:"Path to the PEM private key of --ts.webhook.tls-cert-file." env:"TAILSCALE_WEBHOOK_TLS_KEY_FILE" group:"Tailscale flags"`
+				TLSSecret     string        `name:"tls-secret" placeholder:"NAME" help:"Kubernetes TLS secret, in --namespace, the webhook is served with over TLS (e.g. the secret of a cert-manager Certificate), reloaded when rotated." env:"TAILSCALE_WEBHOOK_TLS_SECRET" group:"Tailscale flags" xor:"webhook-tls"`
+				TLSReload     time.Duration `name:"tls-reload-interval" help:"Time between two reloads of the --ts.webhook.tls-secret secret." default:"1m" env:"TAILSCALE_WEBHOOK_TLS_RELOAD_INTERVAL" group:"Tailscale flags"`
 			} `embed:"" prefix:"webhook."`
+
+			Breaker struct {
+				Threshold   int           `name:"threshold" help:"Number of consecutive Tailscale API failures opening the circuit breaker, which then serves the last known devices instead of calling the Tailscale API (0 disables it)." default:"5" env:"TAILSCALE_BREAKER_THRESHOLD" group:"Tailscale flags"`
+				Cooldown    time.Duration `name:"cooldown" help:"Time before the open circuit breaker lets a probe call the Tailscale API again, doubled on every failed probe." default:"30s" env:"TAILSCALE_BREAKER_COOLDOWN" group:"Tailscale flags"`
+				MaxCooldown time.Duration `name:"max-cooldown" help:"Maximum time before the open circuit breaker lets a probe call the Tailscale API again." default:"10m" env:"TAILSCALE_BREAKER_MAX_COOLDOWN" group:"Tailscale flags"`
+			} `embed:"" prefix:"breaker."`
+
+			Snapshot struct {
+				MaxAge time.Duration `name:"max-age" help:"Age after which the last known Tailscale devices are no longer used when the Tailscale API is unavailable, failing the 'tailscale-snapshot' readiness check (0 for no limit)." default:"24h" env:"TAILSCALE_SNAPSHOT_MAX_AGE" group:"Tailscale flags"`
+			} `embed:"" prefix:"snapshot."`
 		} `embed:"" prefix:"ts."`
 
+		Tsnet struct {
+			Enable      bool   `name:"enable" help:"Join the tailnet and serve the webhook over Tailscale (requires a build with tsnet support)." default:"false" env:"TSNET_ENABLE" group:"Tsnet flags"`
+			Hostname    string `name:"hostname" help:"Hostname of the Argotails node on the tailnet." default:"argotails" env:"TSNET_HOSTNAME" group:"Tsnet flags"`
+			StateDir    string `name:"state-dir" type:"path" help:"Directory where the Tailscale node state is stored." default:"/var/lib/argotails/tsnet" env:"TSNET_STATE_DIR" group:"Tsnet flags"`
+			AuthKey     string `name:"authkey" placeholder:"TSNET_AUTH_KEY" help:"Tailscale auth key used to register the Argotails node." env:"TSNET_AUTH_KEY" group:"Tsnet flags" xor:"tsnet-authkey"`
+			AuthKeyFile []byte `name:"authkey-file" type:"filecontent" placeholder:"TSNET_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale auth key used to register the Argotails node." env:"TSNET_AUTH_KEY_FILE" group:"Tsnet flags" xor:"tsnet-authkey"`
+			ControlURL  string `name:"control-url" placeholder:"URL" help:"URL of the coordination server the Argotails node registers with (defaults to the Tailscale control plane)." env:"TSNET_CONTROL_URL" group:"Tsnet flags"`
+			Funnel      bool   `name:"funnel" help:"Expose the webhook publicly through Tailscale Funnel (required to receive webhooks from the Tailscale control plane)." default:"false" negatable:"" env:"TSNET_FUNNEL" group:"Tsnet flags"`
+		} `embed:"" prefix:"tsnet."`
+
 		Namespace string `name:"namespace" help:"Namespace where ArgoCD cluster secret must be created (configure it only if Argotails runs outside the cluster)." env:"NAMESPACE"` // trunk-ignore(golangci-lint/lll)
 
+		Kubernetes struct {
+			Kubeconfig   string   `name:"kubeconfig" help:"Path, or ':'-separated list of paths, to the kubeconfig of the cluster where ArgoCD cluster secrets are managed (defaults to in-cluster configuration)." env:"KUBECONFIG" group:"Kubernetes flags"`
+			Context      string   `name:"context" help:"Kubeconfig context to use." env:"KUBE_CONTEXT" group:"Kubernetes flags"`
+			ExtraTargets []string `name:"extra-target" placeholder:"KUBECONFIG[#CONTEXT]" help:"Additional clusters where ArgoCD cluster secrets must also be written." env:"KUBE_EXTRA_TARGETS" group:"Kubernetes flags"`
+
+			RequestTimeout time.Duration `name:"request-timeout" help:"Maximum duration of a single Kubernetes API request done by the reconciler (0 to disable)." default:"10s" env:"KUBE_REQUEST_TIMEOUT" group:"Kubernetes flags"`
+			RequestRetries int           `name:"request-retries" help:"Number of retries of a Kubernetes API request failing with a transient error." default:"3" env:"KUBE_REQUEST_RETRIES" group:"Kubernetes flags"`
+			As             string        `name:"as" placeholder:"USER" help:"User impersonated by the controller to write the Kubernetes resources (e.g. 'system:serviceaccount:argocd:argotails-writer'), so that its writes are audited under a dedicated identity; reads keep the kubeconfig identity." env:"KUBE_AS" group:"Kubernetes flags"`
+			AsGroups       []string      `name:"as-group" placeholder:"GROUP,..." help:"Groups impersonated along with --kube.as." env:"KUBE_AS_GROUPS" group:"Kubernetes flags"`
+			QPS            float32       `name:"qps" help:"Maximum number of requests per second sent by the controller to the Kubernetes API, for reads and writes separately (0 for the controller-runtime default, 20)." default:"0" env:"KUBE_QPS" group:"Kubernetes flags"`
+			Burst          int           `name:"burst" help:"Maximum burst of requests sent by the controller to the Kubernetes API above --kube.qps (0 for the controller-runtime default, 30)." default:"0" env:"KUBE_BURST" group:"Kubernetes flags"`
+			ReadMode       string        `name:"read-mode" help:"How the reconciler reads the secrets it manages: 'cache' (label-selected informer cache, fast but eventually consistent) or 'direct' (Kubernetes API, strongly consistent)." enum:"cache,direct" default:"cache" env:"KUBE_READ_MODE" group:"Kubernetes flags"`
+
+			GracefulShutdownTimeout time.Duration `name:"graceful-shutdown-timeout" help:"Time given to the running reconciliations and loops to complete on shutdown (e.g. during a rollout) before Argotails is stopped." default:"30s" env:"KUBE_GRACEFUL_SHUTDOWN_TIMEOUT" group:"Kubernetes flags"`
+			PprofBindAddress        string        `name:"pprof-bind-address" placeholder:"ADDRESS" help:"Address (e.g. ':8083') on which the controller manager serves the pprof profiles (disabled by default)." env:"KUBE_PPROF_BIND_ADDRESS" group:"Kubernetes flags"`
+		} `embed:"" prefix:"kube."`
+
+		// Secrets are the flags shaping the generated resources, shared with the render and import
+		// commands.
+		Secrets SecretFlags `embed:""`
+
+		Device struct {
+			ExcludeSelf   *bool                    `name:"exclude-self" help:"Never register the Tailscale devices of the cluster where Argotails runs (--device.self and the --tsnet.enable node), which ArgoCD already manages as its local cluster. Enabled by default as soon as one of them is known." negatable:"" env:"EXCLUDE_SELF" group:"Device flags"`
+			Self          []string                 `name:"self" placeholder:"NODE_ID,..." help:"Node IDs of the Tailscale devices of the cluster where Argotails runs, excluded by --device.exclude-self." env:"SELF" group:"Device flags"`
+			SyncIntervals map[string]time.Duration `name:"sync-interval" placeholder:"TAG=DURATION" help:"Reconciliation interval of the devices having the given tag (e.g. 'tag:argotails-fast-sync=1m'), overriding --reconcile.interval." env:"SYNC_INTERVALS" group:"Device flags"`
+		} `embed:"" prefix:"device." envprefix:"DEVICE_"`
+
+		Cluster struct {
+			Resource          bool              `name:"resource" help:"Maintain a TailscaleCluster resource per device, reporting its state through status conditions (requires the TailscaleCluster CRD)." default:"false" env:"RESOURCE" group:"Cluster flags"`
+			CleanupNamespaces []string          `name:"cleanup-namespaces" placeholder:"NAMESPACE,..." help:"Additional namespaces (or '*' for all namespaces) where the secrets managed by Argotails are listed and deleted once they no longer match a Tailscale device, e.g. the namespaces of ArgoCD 'application.namespaces'." env:"CLEANUP_NAMESPACES" group:"Cluster flags"`
+			Application       string            `name:"application-template" type:"existingfile" placeholder:"PATH" help:"Path to a Go template of an ArgoCD Application created for each registered cluster (e.g. an app-of-apps bootstrapping it)." env:"APPLICATION_TEMPLATE" group:"Cluster flags"`
+			InCluster         bool              `name:"in-cluster" help:"Also manage the ArgoCD 'in-cluster' secret declaring the cluster where ArgoCD runs, labeled as the Tailscale devices' secrets so that ApplicationSet generators select the hub like the spokes; an existing unmanaged 'in-cluster' secret is left untouched." default:"false" env:"IN_CLUSTER" group:"Cluster flags"`
+			TTL               time.Duration     `name:"ttl" help:"Time after which every secret expires, stamped in its 'argotails.chezmoi.sh/expires-at' annotation: expired secrets are refreshed while their device is present and deleted otherwise, even if their fleet retains them (0 to disable)." default:"0" env:"TTL" group:"Cluster flags"`
+			InClusterLabels   map[string]string `name:"in-cluster-label" placeholder:"KEY=VALUE" help:"Additional label of the ArgoCD 'in-cluster' secret (requires --cluster.in-cluster)." env:"IN_CLUSTER_LABELS" group:"Cluster flags"`
+		} `embed:"" prefix:"cluster." envprefix:"CLUSTER_"`
+
+		Output struct {
+			Backends           map[string]string `name:"backend" placeholder:"TAG=BACKEND" help:"Register the devices having the given tag as 'karmada' Cluster or 'ocm' ManagedCluster objects instead of generating a secret (e.g. 'tag:karmada=karmada')." env:"BACKENDS" group:"Output flags"`
+			KarmadaCredentials string            `name:"karmada-credentials" placeholder:"NAMESPACE/NAME" help:"Secret holding the credentials ('caBundle' and 'token' entries) Karmada uses to reach the member clusters in Push mode, referenced by every Karmada Cluster object (required by the 'karmada' backend)." env:"KARMADA_CREDENTIALS" group:"Output flags"`
+		} `embed:"" prefix:"output." envprefix:"OUTPUT_"`
+
+		Rollout struct {
+			Canaries       []string      `name:"canary" placeholder:"DEVICE,..." help:"Names, hostnames or IDs of the Tailscale devices whose secrets are updated first when the configuration (templates, filters, ...) changes." env:"CANARIES" group:"Rollout flags"`
+			Percentage     int           `name:"percentage" help:"Percentage of the Tailscale devices whose secrets are updated first when the configuration changes (0 to only use --rollout.canary)." default:"0" env:"PERCENTAGE" group:"Rollout flags"`
+			Soak           time.Duration `name:"soak" help:"Time the canary devices run a new configuration without failure before it is rolled out to every device." default:"10m" env:"SOAK" group:"Rollout flags"`
+			StateConfigMap string        `name:"state-configmap" placeholder:"NAME" help:"ConfigMap where the rollout state is persisted, so that a restarted controller neither restarts the soak nor forgets a canary failure." env:"STATE_CONFIGMAP" group:"Rollout flags"`
+		} `embed:"" prefix:"rollout." envprefix:"ROLLOUT_"`
+
 		Service struct {
-			CreateService bool   `name:"create" help:"Create Kubernetes services with Tailscale annotations for multi-cluster ArgoCD support." default:"false" env:"CREATE_SERVICE" group:"Service flags"`
-			ProxyClass    string `name:"proxy-class" help:"ProxyClass to use for Tailscale services (optional)." env:"SERVICE_PROXY_CLASS" group:"Service flags"`
+			CreateService bool     `name:"create" help:"Create Kubernetes services with Tailscale annotations for multi-cluster ArgoCD support." default:"false" env:"CREATE_SERVICE" group:"Service flags"`
+			ProxyClass    string   `name:"proxy-class" help:"ProxyClass to use for Tailscale services (optional)." env:"SERVICE_PROXY_CLASS" group:"Service flags"`
+			OSes          []string `name:"os" placeholder:"OS" help:"Create services only for the Tailscale devices running one of these operating systems ('--service.os=' for all devices)." default:"linux" env:"OS" group:"Service flags"`
+			SkipTag       string   `name:"skip-tag" placeholder:"TAG" help:"Tag of the Tailscale devices for which no service is created (e.g. devices already exposed by an operator-managed egress service); secrets can also be annotated with 'argotails.chezmoi.sh/skip-service=true' ('--service.skip-tag=' to disable the tag)." default:"tag:argotails-no-service" env:"SKIP_TAG" group:"Service flags"`
+			NameTemplate  string   `name:"name-template" placeholder:"TEMPLATE" help:"Go template of the service names, rendered over the secret '.Name' with the 'dns1035', 'trunc' and 'hash' sanitizers (e.g. 'team-a-{{ .Name | dns1035 | trunc 40 }}-{{ .Name | hash 8 }}'); defaults to the DNS-1035 form of the secret name." env:"NAME_TEMPLATE" group:"Service flags"`
 		} `embed:"" prefix:"service." envprefix:"SERVICE_"`
 
+		DNS struct {
+			ConfigMap string `name:"configmap" placeholder:"[NAMESPACE/]NAME" help:"ConfigMap where the addresses of the registered Tailscale devices are written as a hosts file for the CoreDNS hosts plugin, as an alternative to --service.create for clusters that cannot run the Tailscale operator (in the namespace of the secrets unless specified)." env:"CONFIGMAP" group:"DNS flags"`
+			Key       string `name:"key" help:"ConfigMap entry holding the hosts file." default:"tailscale.hosts" env:"KEY" group:"DNS flags"`
+		} `embed:"" prefix:"dns." envprefix:"DNS_"`
+
+		ArgoCD struct {
+			Server    *url.URL `name:"server" placeholder:"URL" help:"ArgoCD API server URL; when set, the ArgoCD connection state of every cluster is reported back to its Tailscale device as a posture attribute." env:"SERVER" group:"ArgoCD flags"`
+			Token     string   `name:"token" placeholder:"ARGOCD_TOKEN" help:"ArgoCD API token (requires the 'clusters, get' permission)." env:"TOKEN" group:"ArgoCD flags" xor:"argocd-token"`
+			TokenFile []byte   `name:"token-file" type:"filecontent" placeholder:"ARGOCD_TOKEN_FILE" help:"Path to the file containing the ArgoCD API token." env:"TOKEN_FILE" group:"ArgoCD flags" xor:"argocd-token"`
+			Insecure  bool     `name:"insecure" help:"Skip the TLS verification of the ArgoCD API server." default:"false" env:"INSECURE" group:"ArgoCD flags"`
+
+			PostureAttribute string `name:"posture-attribute" help:"Tailscale device posture attribute receiving the ArgoCD connection state (requires the 'devices:posture_attributes' OAuth scope; empty to disable)." default:"custom:argocdConnection" env:"POSTURE_ATTRIBUTE" group:"ArgoCD flags"`
+			RefreshFailed    bool   `name:"refresh-failed" help:"Reconcile again the devices as soon as ArgoCD reports their cluster connection as failed (e.g. to pick up a new device address)." default:"false" env:"REFRESH_FAILED" group:"ArgoCD flags"`
+		} `embed:"" prefix:"argocd." envprefix:"ARGOCD_"`
+
+		API struct {
+			Enable bool `name:"enable" help:"Enable the read-only HTTP API, served by the metrics server, exposing the managed clusters on /clusters and the synchronization status on /status/sync." default:"false" env:"ENABLE" group:"API flags"`
+
+			PluginToken     string `name:"plugin-token" placeholder:"API_PLUGIN_TOKEN" help:"Token of the ArgoCD ApplicationSet plugin generator; when set, the live Tailscale devices are served on /api/v1/getparams.execute." env:"PLUGIN_TOKEN" group:"API flags" xor:"plugin-token"`
+			PluginTokenFile []byte `name:"plugin-token-file" type:"filecontent" placeholder:"API_PLUGIN_TOKEN_FILE" help:"Path to the file containing the token of the ArgoCD ApplicationSet plugin generator." env:"PLUGIN_TOKEN_FILE" group:"API flags" xor:"plugin-token"`
+		} `embed:"" prefix:"api." envprefix:"API_"`
+
+		Admin struct {
+			Enable    bool   `name:"enable" help:"Enable the authenticated admin API (Connect protocol, JSON) offering the ForceSync, Pause, Resume, GetStatus and EvaluateFilter procedures." default:"false" env:"ENABLE" group:"Admin flags"`
+			Port      int    `name:"port" help:"Admin API port." default:"8083" env:"PORT" group:"Admin flags"`
+			Token     string `name:"token" placeholder:"ADMIN_TOKEN" help:"Bearer token authenticating the admin API requests." env:"TOKEN" group:"Admin flags" xor:"admin-token"`
+			TokenFile []byte `name:"token-file" type:"filecontent" placeholder:"ADMIN_TOKEN_FILE" help:"Path to the file containing the bearer token authenticating the admin API requests." env:"TOKEN_FILE" group:"Admin flags" xor:"admin-token"`
+		} `embed:"" prefix:"admin." envprefix:"ADMIN_"`
+
 		Log struct {
 			Development bool                 `name:"devel" help:"Enable development logging." env:"DEVEL" group:"Log flags"`
-			Verbosity   zapcore.LevelEnabler `name:"v" help:"Log verbosity level." default:"2" env:"VERBOSITY" group:"Log flags"`
+			Verbosity   zapcore.LevelEnabler `name:"v" help:"Log verbosity level: from 0 to 128 or one of 'error', 'warn', 'info', 'debug' (2) or 'trace' (3)." default:"2" env:"VERBOSITY" group:"Log flags"`
 			Format      zapcore.Encoder      `name:"format" help:"Log encoding format, either 'json' or 'console'." default:"json" env:"FORMAT" group:"Log flags"`
+
+			SampleTick       time.Duration `name:"sample-tick" help:"Period over which the verbose log entries (above V(0)) are sampled." default:"1s" env:"SAMPLE_TICK" group:"Log flags"`
+			SampleInitial    int           `name:"sample-initial" help:"Number of verbose log entries sharing the same message logged every sample tick before sampling them (0 disables the sampling)." default:"0" env:"SAMPLE_INITIAL" group:"Log flags"`
+			SampleThereafter int           `name:"sample-thereafter" help:"Once --log.sample-initial is reached, only log every N-th verbose entry sharing the same message during the sample tick (0 drops them)." default:"0" env:"SAMPLE_THEREAFTER" group:"Log flags"`
 		} `embed:"" prefix:"log." envprefix:"LOG_"`
 
-		ts         *tailscale.Client
-		mgr        manager.Manager
-		ctrlName   string
-		reconciler reconcile.TypedReconciler[reconcile.Request]
+		logLevel *zapcoreutils.RuntimeLevel
+		// stdout receives the plans written with --plan-output=json.
+		stdout io.Writer
+		mgr    manager.Manager
+		// recorder records the Kubernetes events, impersonating --kube.as like the other writes.
+		recorder events.EventRecorder
+		statuses *reconciler.StatusRecorder
+		syncs    *api.SyncTracker
+		// synced is the last successful time-based synchronization, used to skip the synchronizations
+		// of unchanged devices.
+		synced atomic.Pointer[syncedCycle]
+		// deviceSecrets are the secrets of the devices registered by the last time-based
+		// synchronization, used to adopt the secrets recreated under their names (see
+		// adoptableSecret).
+		deviceSecrets atomic.Pointer[map[types.NamespacedName]struct{}]
+		// api is the router of the read-only API, mounted on the metrics server (see apiHandlers).
+		api      http.Handler
+		schedule schedule.Schedule
+		pause    *reconciler.PauseSwitch
+		ctrlName string
+		// rollout rolls the configuration changes out progressively, if enabled (see --rollout.canary).
+		rollout *reconciler.Rollout
+		// checkpoints holds the progress of the chunked synchronization (see --reconcile.chunk-size).
+		checkpoints *checkpoint.Store
+		// self excludes the devices of the cluster where Argotails runs (see --device.exclude-self).
+		self *tsutils.SelfFilter
+		// hosts holds the devices written in the --dns.configmap hosts file, if any.
+		hosts *dns.Registry
+		// tailnetRename keeps the secrets in place when the MagicDNS domain of the tailnet changes.
+		tailnetRename *reconciler.TailnetRename
+		// state is shared by the reconciliation loops and the APIs (see sharedState).
+		state stateHolder
 	}
 
 	Command struct {
-		Run     RunCmd     `cmd:"" help:"Run the ArgoCD Tailscale integration controller."`
-		Version VersionCmd `cmd:"" name:"version" help:"Show version information and exit."`
+		Run        RunCmd        `cmd:"" help:"Run the ArgoCD Tailscale integration controller."`
+		RBAC       RBACCmd       `cmd:"" name:"rbac" help:"Print the minimal RBAC resources required by the given configuration."`
+		Import     ImportCmd     `cmd:"" name:"import" help:"Match existing ArgoCD cluster secrets to Tailscale devices and hand them over to Argotails."`
+		Doctor     DoctorCmd     `cmd:"" name:"doctor" help:"Check, read-only, the Tailscale credentials, device filter, webhook and RBAC of an installation."`
+		Render     RenderCmd     `cmd:"" name:"render" help:"Print the secrets generated for the current Tailscale devices, optionally sealed, to be committed to Git."`
+		Backup     BackupCmd     `cmd:"" name:"backup" help:"Dump the secrets and services managed by Argotails to a tarball, optionally encrypted."`
+		Restore    RestoreCmd    `cmd:"" name:"restore" help:"Recreate the secrets and services saved by the backup command."`
+		Webhook    WebhookCmd    `cmd:"" name:"webhook" help:"Developer tools for the Tailscale webhook handler."`
+		Completion CompletionCmd `cmd:"" name:"completion" help:"Print the shell completion script (bash, zsh or fish)."`
+		Man        ManCmd        `cmd:"" name:"man" help:"Print the man page."`
+		Config     ConfigCmd     `cmd:"" name:"config" help:"Describe the configuration of the command line."`
+		Version    VersionCmd    `cmd:"" name:"version" help:"Show version information and exit."`
 	}
 )
 
-func (VersionCmd) Run(cli *kong.Context) error {
-	fmt.Println(version.Print(cli.Model.Name))
-	return nil
+func (c RBACCmd) Run(cli *kong.Context) error {
+	namespaces := slices.Sorted(maps.Values(c.TenantNamespaces))
+	if c.Fleets != "" {
+		raw, err := os.ReadFile(c.Fleets)
+		if err != nil {
+			return fmt.Errorf("failed to read --cluster.fleets: %w", err)
+		}
+		fleets, err := fleet.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("invalid --cluster.fleets: %w", err)
+		}
+		namespaces = append(namespaces, fleet.Namespaces(fleets, c.Namespace)...)
+	}
+
+	for tag, backend := range c.Backends {
+		if _, exists := reconciler.RegistrationBackends[backend]; !exists {
+			return fmt.Errorf("--output.backend: unknown backend '%s' for tag '%s'", backend, tag)
+		}
+	}
+
+	raw, err := rbac.Manifests(rbac.Options{
+		Name:                 c.Name,
+		Namespace:            c.Namespace,
+		WebhookOnly:          c.Mode == modeWebhookOnly,
+		CreateService:        c.CreateService,
+		ClusterResource:      c.ClusterResource,
+		Application:          c.Application,
+		ReportConfigMap:      c.ReportConfigMap,
+		CheckpointConfigMap:  c.CheckpointConfigMap,
+		RolloutConfigMap:     c.RolloutConfigMap,
+		PauseConfigMap:       c.PauseConfigMap,
+		DNSConfigMap:         c.DNSConfigMap,
+		Namespaces:           append(namespaces, c.CleanupNamespaces...),
+		RegistrationBackends: slices.Collect(maps.Values(c.Backends)),
+		ImpersonateUser:      c.As,
+		ImpersonateGroups:    c.AsGroups,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cli.Stdout.Write(raw)
+	return err
 }
 
 func (c *RunCmd) AfterApply() error {
-	if c.Tailscale.AuthKeyFile != nil {
-		c.Tailscale.AuthKey = string(c.Tailscale.AuthKeyFile)
+	if c.Tailscale.AuthKeyFile != "" {
+		raw, err := os.ReadFile(c.Tailscale.AuthKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --ts.authkey-file: %w", err)
+		}
+		c.Tailscale.AuthKey = strings.TrimSpace(string(raw))
 	}
 	if c.Tailscale.Webhook.SecretFile != nil {
 		c.Tailscale.Webhook.Secret = string(c.Tailscale.Webhook.SecretFile)
 	}
+	if c.Tsnet.AuthKeyFile != nil {
+		c.Tsnet.AuthKey = string(c.Tsnet.AuthKeyFile)
+	}
+	if c.ArgoCD.TokenFile != nil {
+		c.ArgoCD.Token = strings.TrimSpace(string(c.ArgoCD.TokenFile))
+	}
+	if c.API.PluginTokenFile != nil {
+		c.API.PluginToken = strings.TrimSpace(string(c.API.PluginTokenFile))
+	}
+	if c.Admin.TokenFile != nil {
+		c.Admin.Token = strings.TrimSpace(string(c.Admin.TokenFile))
+	}
+	if err := featuregate.Default.Set(c.FeatureGates); err != nil {
+		return fmt.Errorf("--feature-gates: %w", err)
+	}
+	for _, gate := range []struct {
+		flag    string
+		used    bool
+		feature featuregate.Feature
+	}{
+		{"--tsnet.enable", c.Tsnet.Enable, featuregate.TsnetListener},
+		{"--cluster.require-approval", c.Secrets.Cluster.RequireApproval, featuregate.ApprovalWorkflow},
+		{"--argocd.server", c.ArgoCD.Server != nil, featuregate.PostureSync},
+		{"--ts.webhook.autoprovision", c.Tailscale.Webhook.AutoProvision, featuregate.WebhookAutoProvision},
+	} {
+		if gate.used && !featuregate.Default.Enabled(gate.feature) {
+			return fmt.Errorf("%s requires the %s feature gate (--feature-gates=%s=true)", gate.flag, gate.feature, gate.feature)
+		}
+	}
+	if c.RequireFIPS && !fips.Enabled() {
+		return fmt.Errorf("--require-fips requires a FIPS 140 cryptographic backend, got %s", fips.String())
+	}
+	for _, required := range c.requiredScopes() {
+		if len(c.Tailscale.Scopes) > 0 && !tsutils.HasScope(c.Tailscale.Scopes, required.scope) {
+			return fmt.Errorf("%s requires the %q OAuth scope, missing from --ts.scopes", required.feature, required.scope)
+		}
+	}
+	if c.Rollout.Percentage < 0 || c.Rollout.Percentage > 100 {
+		return fmt.Errorf("--rollout.percentage must be between 0 and 100, got %d", c.Rollout.Percentage)
+	}
+	if c.Admin.Enable && c.Admin.Token == "" {
+		return errors.New("--admin.enable requires --admin.token or --admin.token-file")
+	}
+	if c.Tailscale.Webhook.AutoProvision {
+		switch {
+		case !c.Tailscale.Webhook.Enable:
+			return errors.New("--ts.webhook.autoprovision requires --ts.webhook.enable")
+		case c.Tailscale.Webhook.URL == nil:
+			return errors.New("--ts.webhook.autoprovision requires --ts.webhook.url")
+		case c.Tailscale.Webhook.Secret != "":
+			return errors.New("--ts.webhook.autoprovision cannot be used with --ts.webhook.secret or --ts.webhook.secret-file")
+		}
+	}
+	if len(c.Kubernetes.AsGroups) > 0 && c.Kubernetes.As == "" {
+		return errors.New("--kube.as-group requires --kube.as")
+	}
+	if c.webhookOnly() {
+		switch {
+		case !c.Tailscale.Webhook.Enable:
+			return errors.New("--mode=webhook-only requires --ts.webhook.enable")
+		case c.ResyncPerObject > 0:
+			return errors.New("--reconcile.resync-per-object requires --mode=controller")
+		case c.ChunkSize > 0:
+			return errors.New("--reconcile.chunk-size requires --mode=controller")
+		case c.DeletionMaxPercent > 0:
+			return errors.New("--reconcile.deletion-max-percent requires --mode=controller")
+		case c.ReconcileTimeout > 0:
+			return errors.New("--reconcile.timeout requires --mode=controller")
+		case c.PlanOutput != "none":
+			return errors.New("--plan-output requires --mode=controller")
+		case c.ReportConfigMap != "":
+			return errors.New("--reconcile.report-configmap requires --mode=controller")
+		case c.DNS.ConfigMap != "":
+			return errors.New("--dns.configmap requires --mode=controller")
+		}
+	}
+	if c.pollOnly() {
+		switch {
+		case c.Tailscale.Webhook.Enable:
+			return errors.New("--ts.webhook.enable cannot be used with --mode=poll-only")
+		case c.API.Enable:
+			return errors.New("--api.enable cannot be used with --mode=poll-only")
+		case c.Admin.Enable:
+			return errors.New("--admin.enable cannot be used with --mode=poll-only")
+		case c.Kubernetes.PprofBindAddress != "":
+			return errors.New("--kube.pprof-bind-address cannot be used with --mode=poll-only")
+		}
+	}
+	if c.Tsnet.Enable && !c.Tailscale.Webhook.Enable {
+		return errors.New("--tsnet.enable requires --ts.webhook.enable")
+	}
+	if (c.Tailscale.Webhook.TLSCertFile == "") != (c.Tailscale.Webhook.TLSKeyFile == "") {
+		return errors.New("--ts.webhook.tls-cert-file and --ts.webhook.tls-key-file must be set together")
+	}
+	if c.Tsnet.Enable && (c.Tailscale.Webhook.TLSCertFile != "" || c.Tailscale.Webhook.TLSSecret != "") {
+		return errors.New("--tsnet.enable serves the webhook with the tailnet certificate and cannot be used with --ts.webhook.tls-cert-file or --ts.webhook.tls-secret")
+	}
+	if c.Tailscale.Webhook.TLSReload <= 0 {
+		return errors.New("--ts.webhook.tls-reload-interval must be positive")
+	}
+	if c.Tailscale.Webhook.ClockSkew <= 0 {
+		return errors.New("--ts.webhook.clock-skew must be positive")
+	}
+	if err := c.Secrets.validate(); err != nil {
+		return err
+	}
+	if len(c.Cluster.InClusterLabels) > 0 && !c.Cluster.InCluster {
+		return errors.New("--cluster.in-cluster-label requires --cluster.in-cluster")
+	}
+	if c.Cluster.InCluster && c.Secrets.Output.Flavor != reconciler.FlavorArgoCD {
+		return errors.New("--cluster.in-cluster requires --output.flavor=argocd")
+	}
+	for key, value := range c.Cluster.InClusterLabels {
+		if errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...); len(errs) > 0 {
+			return fmt.Errorf("--cluster.in-cluster-label: invalid label %s=%s: %s", key, value, strings.Join(errs, ", "))
+		}
+	}
+	if c.Jitter < 0 {
+		return errors.New("--reconcile.jitter must not be negative")
+	}
+	if c.ResyncPerObject < 0 {
+		return errors.New("--reconcile.resync-per-object must not be negative")
+	}
+	if c.Cluster.TTL < 0 {
+		return errors.New("--cluster.ttl must not be negative")
+	}
+	if c.ChunkSize < 0 {
+		return errors.New("--reconcile.chunk-size must not be negative")
+	}
+	if c.ReconcileTimeout < 0 {
+		return errors.New("--reconcile.timeout must not be negative")
+	}
+	if c.DeletionMaxPercent < 0 || c.DeletionMaxPercent > 100 {
+		return errors.New("--reconcile.deletion-max-percent must be between 0 and 100")
+	}
+	if c.CheckpointMaxAge < 0 {
+		return errors.New("--reconcile.checkpoint-max-age must not be negative")
+	}
+	if c.CheckpointConfigMap != "" && c.ChunkSize == 0 {
+		return errors.New("--reconcile.checkpoint-configmap requires --reconcile.chunk-size")
+	}
+	if c.Schedule == "" && c.Jitter > 0 && c.Jitter >= c.ReconcileInterval {
+		return errors.New("--reconcile.jitter must be shorter than --reconcile.interval")
+	}
+	sched, err := schedule.Parse(c.Schedule, c.ReconcileInterval, c.Jitter)
+	if err != nil {
+		return fmt.Errorf("--reconcile.schedule: %w", err)
+	}
+	c.schedule = sched
 	if c.Namespace == "" {
-		ns, _ := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
-		if len(ns) == 0 {
-			return errors.New("--namespace is required when running outside a cluster or service account not mounted")
+		ns, err := kubeutils.InClusterNamespace(kubeutils.ServiceAccountDir)
+		if err != nil {
+			return errors.New("--namespace is required when running outside a cluster (or when neither the service account namespace, POD_NAMESPACE nor the service account token are available)")
 		}
-		c.Namespace = string(ns)
+		c.Namespace = ns
 	}
 	return nil
 }
 
+// requiredScope is an OAuth scope required by an enabled feature.
+type requiredScope struct {
+	feature string
+	scope   string
+}
+
+// requiredScopes returns the OAuth scopes required by the enabled features.
+func (c *RunCmd) requiredScopes() []requiredScope {
+	scopes := []requiredScope{{"listing the Tailscale devices", tsutils.ScopeDevicesCoreRead}}
+	if c.ArgoCD.Server != nil && c.ArgoCD.PostureAttribute != "" {
+		scopes = append(scopes, requiredScope{"--argocd.posture-attribute", tsutils.ScopeDevicesPostureAttributes})
+	}
+	if c.Tailscale.Webhook.AutoProvision {
+		scopes = append(scopes, requiredScope{"--ts.webhook.autoprovision", tsutils.ScopeWebhooks})
+	}
+	if c.checksWebhook() {
+		scopes = append(scopes, requiredScope{"--ts.webhook.check-interval", tsutils.ScopeWebhooksRead})
+	}
+	return scopes
+}
+
+// tailscaleScopes returns the OAuth scopes to request: the configured ones, or the ones required
+// by the enabled features.
+func (c *RunCmd) tailscaleScopes() []string {
+	if len(c.Tailscale.Scopes) > 0 {
+		return c.Tailscale.Scopes
+	}
+
+	var scopes []string
+	for _, required := range c.requiredScopes() {
+		scopes = append(scopes, required.scope)
+	}
+	return scopes
+}
+
 func (c *RunCmd) Run(cli *kong.Context) error {
 	c.ctrlName = cli.Model.Name
+	c.stdout = cli.Stdout
 
-	// Initialize the logger and context
+	// Initialize the logger and context; the verbosity can be changed at runtime through the
+	// admin API
+	c.logLevel = zapcoreutils.NewRuntimeLevel(zapcore.LevelOf(c.Log.Verbosity))
 	zopts := []zap.Opts{
 		zap.UseFlagOptions(&zap.Options{
 			TimeEncoder: zapcore.ISO8601TimeEncoder,
 		}),
-		zap.Level(c.Log.Verbosity),
+		zap.Level(c.logLevel),
 		zap.Encoder(c.Log.Format),
 	}
 	if c.Log.Development {
-		zopts = []zap.Opts{zap.UseDevMode(true), zap.Level(zapcore.Level(-127))}
+		c.logLevel = zapcoreutils.NewRuntimeLevel(zapcore.Level(-127))
+		zopts = []zap.Opts{zap.UseDevMode(true), zap.Level(c.logLevel)}
+	}
+	if c.Log.SampleInitial > 0 {
+		// Large tailnets produce thousands of verbose entries per cycle, mostly the same messages
+		// logged for every device: they are rate limited per message
+		zopts = append(zopts, zap.RawZapOpts(uberzap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcoreutils.NewVerboseSampler(core, c.Log.SampleTick, c.Log.SampleInitial, c.Log.SampleThereafter)
+		})))
 	}
 	log := zap.New(zopts...)
 	ctrllog.SetLogger(log)
 	ctx := ctrllog.IntoContext(signals.SetupSignalHandler(), log)
 
 	// Log startup configuration
-	log.V(0).Info("Starting ArgoCD Tailscale integration controller", "version", version.Version)
+	log.V(0).Info("Starting ArgoCD Tailscale integration controller", "version", version.Version, "crypto", fips.String(), "featureGates", featuregate.Default.String())
+	reportDeprecatedUsages(log, DeprecatedUsages(cli.Model, cli.Args, os.LookupEnv))
 
 	// Configure the Tailscale client.
 	log.V(1).Info("Initializing Tailscale client",
 		"tailscale", map[string]any{
 			"baseURL": c.Tailscale.BaseURL.String(),
 			"tailnet": c.Tailscale.Tailnet,
+			"proxied": c.Tailscale.ProxyURL != nil,
 		},
 	)
-	var err error
-	c.ts, err = tsutils.NewTailscaleClient(c.Tailscale.BaseURL, c.Tailscale.Tailnet, c.Tailscale.AuthKey)
+	tsopts := []tsutils.ClientOption{tsutils.WithProxyURL(c.Tailscale.ProxyURL)}
+	if c.Tailscale.AuthKeyFile != "" {
+		tsopts = append(tsopts, tsutils.WithAuthKeyFile(c.Tailscale.AuthKeyFile))
+	}
+	tsopts = append(tsopts, tsutils.WithScopes(c.tailscaleScopes()...))
+
+	ts, err := tsutils.NewTailscaleClient(c.Tailscale.BaseURL, c.Tailscale.Tailnet, c.Tailscale.AuthKey, tsopts...)
 	if err != nil {
 		log.Error(err, "Unable to create Tailscale client. Please check the configuration and try again.")
 		return err
 	}
 	log.V(1).Info("Tailscale client initialized successfully")
+	deviceAPI := tsutils.NewDeviceAPI(ts)
+
+	listopts := c.Secrets.listOptions()
+	if tags, literal := tsutils.LiteralTags(c.Tailscale.DeviceTagFilters...); c.Tailscale.ServerSideFilter && literal {
+		// NOTE: the devices are still filtered client-side, which also covers the Tailscale API
+		//       implementations ignoring the filter (e.g. older Headscale releases).
+		log.V(1).Info("Filtering the Tailscale devices by tag on the Tailscale API side", "tags", tags)
+		listopts = append(listopts, tailscale.WithFilter("tags", tags))
+	}
+	snapshot, err := tsutils.NewDeviceSnapshot(c.Tailscale.DeviceSnapshot, listopts...)
+	if err != nil {
+		log.Error(err, "Unable to load the Tailscale devices snapshot.", "path", c.Tailscale.DeviceSnapshot)
+		return err
+	}
+	if taken := snapshot.Time(); !taken.IsZero() {
+		log.V(1).Info("Tailscale devices snapshot loaded", "snapshot", map[string]any{"path": c.Tailscale.DeviceSnapshot, "time": taken})
+	}
+	snapshot.SetMaxAge(c.Tailscale.Snapshot.MaxAge)
+	var breaker *tsutils.CircuitBreaker
+	if c.Tailscale.Breaker.Threshold > 0 {
+		breaker = tsutils.NewCircuitBreaker(c.Tailscale.Breaker.Threshold, c.Tailscale.Breaker.Cooldown, c.Tailscale.Breaker.MaxCooldown)
+		snapshot.SetCircuitBreaker(breaker)
+	}
 
 	// Configure the controller manager.
 	log.V(1).Info("Initializing controller manager")
-	c.mgr, err = manager.New(config.GetConfigOrDie(), manager.Options{
-		Cache: cache.Options{
-			DefaultNamespaces: map[string]cache.Config{
-				// Argotails controller must only watch secrets managed by itself inside the configured namespace
-				// (or the namespace where it runs if it's running inside a Kubernetes cluster). This ensures that
-				// the controller will not interfere with other controllers or resources and will not read secrets
-				// from other namespaces.
-				c.Namespace: {LabelSelector: labels.SelectorFromSet(labels.Set{"apps.kubernetes.io/managed-by": c.ctrlName})},
-			},
-		},
-		HealthProbeBindAddress: ":8081", // Expose health endpoints
-		BaseContext:            func() context.Context { return ctx },
-		Logger:                 log,
-	})
+	kcfg, err := kubeutils.Target{Kubeconfig: c.Kubernetes.Kubeconfig, Context: c.Kubernetes.Context}.RESTConfig()
+	if err != nil {
+		log.Error(err, "Unable to load Kubernetes configuration. Please check the configuration and try again.")
+		return err
+	}
+	if c.Kubernetes.QPS > 0 {
+		kcfg.QPS = c.Kubernetes.QPS
+	}
+	if c.Kubernetes.Burst > 0 {
+		kcfg.Burst = c.Kubernetes.Burst
+	}
+	devOpts, err := c.Secrets.deviceOptions(log, c.Namespace, c.Tailscale.DeviceTagFilters, tsutils.DeviceFieldsOf(ts))
+	if err != nil {
+		return err
+	}
+	fleets := devOpts.fleets
+	watched := append(fleet.Namespaces(fleets, c.Namespace), c.Cluster.CleanupNamespaces...)
+	if slices.Contains(c.Cluster.CleanupNamespaces, "*") {
+		watched = []string{cache.AllNamespaces}
+	}
+	namespaces := map[string]cache.Config{}
+	for _, namespace := range watched {
+		namespaces[namespace] = cache.Config{LabelSelector: labels.SelectorFromSet(labels.Set{"apps.kubernetes.io/managed-by": c.ctrlName})}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	c.mgr, err = manager.New(kcfg, c.managerOptions(ctx, log, scheme, namespaces))
+
 	if err != nil {
 		log.Error(err, "Unable to set up the overall controller manager. Please check the configuration and try again.")
 		return err
 	}
 
+	c.recorder = c.mgr.GetEventRecorder(c.ctrlName)
+	if c.Kubernetes.As != "" {
+		c.recorder, err = kubeutils.NewEventRecorder(ctx, kubeutils.Impersonate(kcfg, c.Kubernetes.As, c.Kubernetes.AsGroups), c.mgr.GetScheme(), c.ctrlName)
+		if err != nil {
+			log.Error(err, "Unable to set up the Kubernetes events recorder")
+			return err
+		}
+	}
+
 	// Add health check endpoints
 	if err := c.mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "Unable to set up health check", "error", err)
@@ -170,44 +696,355 @@ func (c *RunCmd) Run(cli *kong.Context) error {
 		log.Error(err, "Unable to set up ready check", "error", err)
 		return err
 	}
+	// The last known devices are only served for a limited time
+	if err := c.mgr.AddReadyzCheck("tailscale-snapshot", snapshot.Check); err != nil {
+		log.Error(err, "Unable to set up ready check", "error", err)
+		return err
+	}
+	if breaker != nil {
+		// While the circuit breaker is open, Argotails only stays ready if it can serve the last known devices
+		err := c.mgr.AddReadyzCheck("tailscale-api", func(*http.Request) error {
+			if breaker.State() == tsutils.BreakerOpen && c.state.Load().snapshot.Time().IsZero() {
+				return tsutils.ErrBreakerOpen
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error(err, "Unable to set up ready check", "error", err)
+			return err
+		}
+	}
 
 	log.V(1).Info("Controller manager initialized successfully")
 
 	// Configure the Kubernetes reconciler.
-	log.V(1).Info("Initializing tag filter", "filter.patterns", c.Tailscale.DeviceTagFilters)
-	filter, err := tsutils.NewRegexpTagFilter(c.Tailscale.DeviceTagFilters...)
+	filter := devOpts.filter
+	if excludesSelf(c.Device.ExcludeSelf, len(c.Device.Self) > 0 || c.Tsnet.Enable) {
+		// NOTE: the node ID of the Argotails node is only known once it has joined the tailnet.
+		log.V(1).Info("Devices of the cluster where Argotails runs are never registered", "self", c.Device.Self, "tsnet", c.Tsnet.Enable)
+		c.self = tsutils.NewSelfFilter(c.Device.Self...)
+		filter = tsutils.AllTagFilters(filter, c.self)
+	}
+
+	c.tailnetRename = reconciler.NewTailnetRename(devOpts.secretName)
+	secretName := c.tailnetRename.Namer()
+
+	serviceName, err := reconciler.NewServiceNamer(c.Service.NameTemplate)
 	if err != nil {
-		log.Error(err, "Invalid Tailscale devices' tag filters.", "filter.patterns", c.Tailscale.DeviceTagFilters)
+		log.Error(err, "Invalid service name template.")
 		return err
 	}
-	log.V(1).Info("Tag filter initialized successfully")
+
+	opts := append(devOpts.opts,
+		reconciler.WithSecretNamer(secretName),
+		reconciler.WithServiceNamer(serviceName),
+		reconciler.WithClusterResource(c.Cluster.Resource),
+		reconciler.WithDeviceSnapshot(snapshot),
+		reconciler.WithTTL(c.Cluster.TTL),
+	)
+	if len(c.Rollout.Canaries) > 0 || c.Rollout.Percentage > 0 {
+		hash, err := c.configHash()
+		if err != nil {
+			return err
+		}
+		log.V(1).Info("Configuration changes rolled out progressively", "rollout", map[string]any{"hash": hash, "canaries": c.Rollout.Canaries, "percentage": c.Rollout.Percentage, "soak": c.Rollout.Soak})
+		c.rollout = reconciler.NewRollout(hash, c.Rollout.Canaries, c.Rollout.Percentage, c.Rollout.Soak)
+		if c.Rollout.StateConfigMap != "" {
+			if err := c.persistRollout(ctx, c.rollout); err != nil {
+				log.Error(err, "Unable to persist the rollout state")
+				return err
+			}
+		}
+		opts = append(opts, reconciler.WithRollout(c.rollout))
+	}
+	for tag, interval := range c.Device.SyncIntervals {
+		opts = append(opts, reconciler.WithSyncInterval(tag, interval))
+	}
+	if c.ResyncPerObject > 0 {
+		opts = append(opts, reconciler.WithResyncPerObject(c.ResyncPerObject))
+	}
+	if c.Cluster.Application != "" {
+		raw, err := os.ReadFile(c.Cluster.Application)
+		if err != nil {
+			return fmt.Errorf("failed to read --cluster.application-template: %w", err)
+		}
+		tmpl, err := policy.Parse("application", string(raw), policy.WithFields(tsutils.DeviceFieldsOf(ts)))
+		if err != nil {
+			log.Error(err, "Invalid ArgoCD application template.")
+			return err
+		}
+		opts = append(opts, reconciler.WithApplicationTemplate(tmpl))
+	}
+	for tag, backend := range c.Output.Backends {
+		if _, exists := reconciler.RegistrationBackends[backend]; !exists {
+			return fmt.Errorf("--output.backend: unknown backend '%s' for tag '%s'", backend, tag)
+		}
+		opts = append(opts, reconciler.WithRegistrationBackend(tag, backend))
+	}
+	if slices.Contains(slices.Collect(maps.Values(c.Output.Backends)), reconciler.BackendKarmada) {
+		namespace, name, found := strings.Cut(c.Output.KarmadaCredentials, "/")
+		if !found || namespace == "" || name == "" {
+			return fmt.Errorf("--output.karmada-credentials: the 'karmada' backend requires the NAMESPACE/NAME of the Karmada credentials secret, got '%s'", c.Output.KarmadaCredentials)
+		}
+		opts = append(opts, reconciler.WithKarmadaCredentials(types.NamespacedName{Namespace: namespace, Name: name}))
+	}
+
+	if c.PauseConfigMap != "" || c.Admin.Enable {
+		c.pause = &reconciler.PauseSwitch{}
+		opts = append(opts, reconciler.WithPauseSwitch(c.pause))
+	}
+	if c.PauseConfigMap != "" {
+		if err := c.watchPauseConfigMap(ctx, c.pause); err != nil {
+			log.Error(err, "Unable to watch the pause ConfigMap")
+			return err
+		}
+	}
 
 	log.V(1).Info("Initializing reconciler")
-	c.reconciler, err = reconciler.NewReconciler(c.mgr.GetClient(), c.ts, filter, c.ctrlName, reconciler.ServiceConfig{
+	serviceConfig := reconciler.ServiceConfig{
 		CreateService: c.Service.CreateService,
 		ProxyClass:    c.Service.ProxyClass,
 		Namespace:     c.Namespace,
-	})
+		OSes:          c.Service.OSes,
+		SkipTag:       c.Service.SkipTag,
+	}
+	reconcilers := make([]reconcile.TypedReconciler[reconcile.Request], 0, 1+len(c.Kubernetes.ExtraTargets))
+	mainClient := c.mgr.GetClient()
+	// NOTE: only the statuses of the main target are recorded, as only its secrets are served by /clusters
+	c.statuses = reconciler.NewStatusRecorder()
+	mainOpts := append(slices.Clip(opts), reconciler.WithAPIReader(c.mgr.GetAPIReader()), reconciler.WithEventRecorder(c.recorder), reconciler.WithStatusRecorder(c.statuses))
+	if c.Kubernetes.ReadMode == "direct" || c.webhookOnly() {
+		// The managed secrets are read from the Kubernetes API, still hiding the ones the cache would not contain
+		mainClient = kubeutils.NewDirectSecretClient(mainClient, c.mgr.GetAPIReader(), labels.SelectorFromSet(labels.Set{"apps.kubernetes.io/managed-by": c.ctrlName}))
+	} else {
+		// The secrets of a device are looked up by device ID in the cache
+		if err := c.mgr.GetFieldIndexer().IndexField(ctx, &corev1.Secret{}, reconciler.IndexDeviceID, reconciler.IndexSecretDeviceID); err != nil {
+			log.Error(err, "Unable to index the secrets by Tailscale device ID")
+			return err
+		}
+		mainOpts = append(mainOpts, reconciler.WithDeviceIDIndex())
+	}
+	if c.DNS.ConfigMap != "" {
+		// NOTE: only the devices registered on the main target are written in the hosts file
+		c.hosts = dns.NewRegistry()
+		mainOpts = append(mainOpts, reconciler.WithHooks(hostsHooks{hosts: c.hosts}))
+	}
+	main, err := reconciler.NewReconciler(c.kubeClient(mainClient), deviceAPI, filter, c.ctrlName, serviceConfig, mainOpts...)
 	if err != nil {
 		log.Error(err, "Unable to create Tailscale reconciler. Please check the configuration and try again.")
 		return err
 	}
+	reconcilers = append(reconcilers, main)
+
+	for _, raw := range c.Kubernetes.ExtraTargets {
+		extra, err := c.extraTargetReconciler(raw, deviceAPI, filter, serviceConfig, opts...)
+		if err != nil {
+			log.Error(err, "Unable to create Tailscale reconciler for an extra target. Please check the configuration and try again.", "target", raw)
+			return err
+		}
+		log.V(1).Info("Extra Kubernetes target configured", "target", raw)
+		reconcilers = append(reconcilers, extra)
+	}
+	targets := reconciler.NewMultiReconciler(reconcilers...)
+	planner, ok := targets.(reconciler.Planner)
+	if !ok {
+		return errors.New("the reconciler cannot plan the synchronizations")
+	}
+	secrets, ok := targets.(reconciler.SecretLister)
+	if !ok {
+		return errors.New("the reconciler cannot list its secrets")
+	}
+	// NOTE: the synchronizations are late once the longest interval of the schedule is exceeded,
+	//       including the jitter
+	interval, err := schedule.MaxInterval(c.schedule, time.Now(), 64)
+	if err != nil {
+		log.Error(err, "Unable to compute the reconciliation schedule. Please check the configuration and try again.")
+		return err
+	}
+	c.syncs = api.NewSyncTracker(interval + c.Jitter)
+	c.checkpoints = checkpoint.NewStore(c.mgr.GetAPIReader(), c.mgr.GetClient(), c.Namespace, c.CheckpointConfigMap, c.ctrlName)
+	c.checkpoints.SetMaxAge(c.CheckpointMaxAge)
+	c.state.Update(func(state *sharedState) {
+		state.ts = ts
+		state.devices = deviceAPI
+		state.snapshot = snapshot
+		state.fleets = fleets
+		state.secretName = secretName
+		state.reconciler = c.hostsReconciler(c.inClusterReconciler(targets))
+		state.planner = planner
+		state.secrets = secrets
+	})
 	log.V(1).Info("Reconciler initialized successfully")
 
+	if c.Tailscale.Webhook.AutoProvision {
+		if err := c.provisionWebhook(ctx); err != nil {
+			log.Error(err, "Unable to provision the Tailscale webhook", "url", c.Tailscale.Webhook.URL.String())
+			return err
+		}
+	}
+
+	// NOTE: a tailnet renamed while Argotails was down must be detected before the loops reconcile
+	//       the existing secrets, which would otherwise be deleted as their devices are not found.
+	if err := c.detectInitialTailnetRename(ctx, snapshot, deviceAPI, filter); err != nil {
+		log.Error(err, "Unable to detect a tailnet rename, the renamed devices' secrets will be replaced")
+	}
+
 	// Configure all reconciliation loops
 	log.V(1).Info("Setting up reconciliation loops")
 	errg, ctx := errgroup.WithContext(ctx)
 
 	loopCtx := ctrllog.IntoContext(ctx, log.WithName("main"))
-	errg.Go(func() error { return c.kubernetesReconcilationLoop(loopCtx) })
-	errg.Go(func() error { return c.timeBasedReconciliationLoop(loopCtx, filter) })
+	if c.API.Enable {
+		c.api = c.apiRouter(log.WithName("api"), filter)
+	}
+	if c.webhookOnly() {
+		log.V(0).Info("Webhook-only mode, the managed secrets are neither watched nor synchronized on schedule")
+		// NOTE: without controller, the manager only serves the metrics (with the read-only API) and
+		//       the health probes.
+		errg.Go(func() error { return c.mgr.Start(loopCtx) })
+	} else {
+		errg.Go(func() error { return c.kubernetesReconcilationLoop(loopCtx) })
+		errg.Go(func() error { return c.timeBasedReconciliationLoop(loopCtx, filter) })
+	}
 	if c.Tailscale.Webhook.Enable {
 		errg.Go(func() error { return c.webhookReconciliationLoop(loopCtx) })
 	}
+	if c.checksWebhook() {
+		errg.Go(func() error { return c.webhookHealthLoop(loopCtx) })
+	}
+	if c.Admin.Enable {
+		errg.Go(func() error { return c.adminServer(loopCtx, filter) })
+	}
+	if c.ArgoCD.Server != nil {
+		errg.Go(func() error { return c.postureSyncLoop(loopCtx) })
+	}
 
 	// Start the controller
 	log.V(0).Info("Controller initialization completed")
-	return errg.Wait()
+	return c.waitLoops(ctx, errg)
+}
+
+// waitLoops waits for the reconciliation loops of the given group to return. Once the given
+// context is done (on shutdown or when a loop failed), the loops are given the graceful shutdown
+// timeout to return; Argotails then stops without waiting for the remaining ones.
+func (c *RunCmd) waitLoops(ctx context.Context, errg *errgroup.Group) error {
+	done := make(chan error, 1)
+	go func() { done <- errg.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(c.Kubernetes.GracefulShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("reconciliation loops still running after the %s graceful shutdown timeout", c.Kubernetes.GracefulShutdownTimeout)
+	}
+}
+
+// managerOptions returns the options of the controller manager, caching the managed resources of
+// the given namespaces. In poll-only mode, none of its servers is started.
+func (c *RunCmd) managerOptions(ctx context.Context, log logr.Logger, scheme *runtime.Scheme, namespaces map[string]cache.Config) manager.Options {
+	return manager.Options{
+		Scheme: scheme,
+		Cache: cache.Options{
+			// Argotails controller must only watch secrets managed by itself inside the configured namespace
+			// (or the namespace where it runs if it's running inside a Kubernetes cluster), the fleet
+			// namespaces and the cleanup namespaces. This ensures that the controller will not interfere with other controllers or
+			// resources and will not read secrets from other namespaces.
+			DefaultNamespaces: namespaces,
+		},
+		// NOTE: in webhook-only mode, nothing is cached so that the secrets (and the other managed
+		//       resources) are never watched; they are read from the Kubernetes API instead.
+		Client:                  c.clientOptions(),
+		HealthProbeBindAddress:  c.bindAddress(":8081"), // Expose health endpoints
+		Metrics:                 metricsserver.Options{BindAddress: c.bindAddress(metricsserver.DefaultBindAddress), ExtraHandlers: c.apiHandlers()},
+		PprofBindAddress:        c.Kubernetes.PprofBindAddress,
+		GracefulShutdownTimeout: &c.Kubernetes.GracefulShutdownTimeout,
+		BaseContext:             func() context.Context { return ctx },
+		Logger:                  log,
+		// The manager client reads through the cache, filled with the kubeconfig identity, but
+		// sends its other requests (writes) through its own REST client, impersonating --kube.as
+		NewClient: func(config *rest.Config, options client.Options) (client.Client, error) {
+			return client.New(kubeutils.Impersonate(config, c.Kubernetes.As, c.Kubernetes.AsGroups), options)
+		},
+	}
+}
+
+// Deployment modes of Argotails (--mode).
+const (
+	modeWebhookOnly = "webhook-only"
+	modePollOnly    = "poll-only"
+)
+
+// bindAddress returns the given bind address of the manager servers, or "0" (disabled) in
+// poll-only mode.
+func (c *RunCmd) bindAddress(addr string) string {
+	if c.pollOnly() {
+		return "0"
+	}
+	return addr
+}
+
+// webhookOnly returns true if Argotails only translates the webhook events into Kubernetes API
+// writes (--mode=webhook-only).
+func (c *RunCmd) webhookOnly() bool {
+	return c.Mode == modeWebhookOnly
+}
+
+// pollOnly returns true if Argotails never opens any listening port (--mode=poll-only).
+func (c *RunCmd) pollOnly() bool {
+	return c.Mode == modePollOnly
+}
+
+// skipsUnchanged returns true if the synchronization cycles of unchanged devices are skipped
+// (--reconcile.skip-unchanged, always in poll-only mode where the device listings are already
+// conditional requests). The cycles still run once a secret expires, while paused or while the
+// rollout holds secrets back, as the previous cycle is then not recorded as synced.
+func (c *RunCmd) skipsUnchanged() bool {
+	return c.SkipUnchanged || c.pollOnly()
+}
+
+// clientOptions returns the options of the manager client: in webhook-only mode, the managed
+// resources are read from the Kubernetes API, so that no informer (and no watch permission) is
+// required.
+func (c *RunCmd) clientOptions() client.Options {
+	if !c.webhookOnly() {
+		return client.Options{}
+	}
+	return client.Options{Cache: &client.CacheOptions{
+		DisableFor: []client.Object{&corev1.Secret{}, &corev1.Service{}, &v1alpha1.TailscaleCluster{}},
+	}}
+}
+
+// kubeClient wraps the given Kubernetes client to bound and retry the requests done by the reconciler.
+func (c *RunCmd) kubeClient(ks client.Client) client.Client {
+	return kubeutils.NewRetryingClient(ks, c.Kubernetes.RequestTimeout, c.Kubernetes.RequestRetries)
+}
+
+// extraTargetReconciler creates a reconciler writing the Tailscale devices' secrets into the cluster
+// referenced by the given target. The extra targets are not watched; they are only kept in sync by
+// the time-based and webhook reconciliation loops.
+func (c *RunCmd) extraTargetReconciler(raw string, deviceAPI tsutils.DeviceAPI, filter tsutils.TagFilter, serviceConfig reconciler.ServiceConfig, opts ...reconciler.Option) (reconcile.TypedReconciler[reconcile.Request], error) {
+	target, err := kubeutils.ParseTarget(raw)
+	if err != nil {
+		return nil, err
+	}
+	kcfg, err := target.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: the extra targets are not cached, so their reads are impersonated along with the writes.
+	ks, err := client.New(kubeutils.Impersonate(kcfg, c.Kubernetes.As, c.Kubernetes.AsGroups), client.Options{Scheme: c.mgr.GetScheme()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for target %q: %w", target, err)
+	}
+	return reconciler.NewReconciler(c.kubeClient(ks), deviceAPI, filter, c.ctrlName, serviceConfig, append(opts, reconciler.WithTarget(target.String()))...)
 }
 
 func (c *RunCmd) kubernetesReconcilationLoop(ctx context.Context) error {
@@ -232,7 +1069,25 @@ func (c *RunCmd) kubernetesReconcilationLoop(ctx context.Context) error {
 		controllerBuilder = controllerBuilder.Owns(&corev1.Service{})
 	}
 
-	err := controllerBuilder.Complete(c.reconciler)
+	// Also watch secrets not labeled as managed by this controller but written for a Tailscale device.
+	// Such secrets are invisible to the main cache (e.g. a managed secret deleted and recreated by
+	// another tool without our labels) and must be adopted as soon as possible.
+	unmanaged, unmanagedCache, err := c.unmanagedSecretsSource()
+	if err != nil {
+		log.Error(err, "Unable to watch unmanaged secrets")
+		return err
+	}
+	controllerBuilder = controllerBuilder.WatchesRawSource(unmanaged)
+	if err := c.watchHiddenSecrets(ctx, unmanagedCache); err != nil {
+		log.Error(err, "Unable to watch hidden secrets")
+		return err
+	}
+
+	// NOTE: the reconciler is resolved on every request, so that the controller always uses the
+	//       current shared state.
+	err = controllerBuilder.Complete(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		return c.state.Load().reconciler.Reconcile(reconciler.TriggerContext(ctx, reconciler.TriggerKubernetes), req)
+	}))
 
 	if err != nil {
 		log.Error(err, "Unable to create controller")
@@ -249,120 +1104,153 @@ func (c *RunCmd) kubernetesReconcilationLoop(ctx context.Context) error {
 	return nil
 }
 
-func (c *RunCmd) timeBasedReconciliationLoop(ctx context.Context, filter tsutils.TagFilter) error {
-	log := ctrllog.FromContext(ctx).WithName("time_based")
-	log.V(1).Info("Starting time-based reconciliation loop")
-
-	ticker := time.NewTicker(c.ReconcileInterval)
-	defer ticker.Stop()
+// unmanagedSecretsSource returns a metadata-only source of the secrets, inside the controller and
+// fleet namespaces, that are not labeled as managed by any controller but are either annotated
+// with a Tailscale device ID or named after the secret of a registered device (see adoptableSecret).
+func (c *RunCmd) unmanagedSecretsSource() (source.Source, cache.Cache, error) {
+	notManaged, err := labels.NewRequirement("apps.kubernetes.io/managed-by", selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// NOTE: in order to have a clean way to handle error on the reconciliation loop
-	//		 we will use an anonymous function
-	syncAllDevices := func(ctx context.Context) error {
-		log.V(1).Info("Starting device synchronization")
+	namespaces := map[string]cache.Config{}
+	for _, namespace := range c.hiddenSecretsNamespaces() {
+		namespaces[namespace] = cache.Config{LabelSelector: labels.NewSelector().Add(*notManaged)}
+	}
+	unmanaged, err := cache.New(c.mgr.GetConfig(), cache.Options{
+		Scheme:            c.mgr.GetScheme(),
+		Mapper:            c.mgr.GetRESTMapper(),
+		DefaultNamespaces: namespaces,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.mgr.Add(unmanaged); err != nil {
+		return nil, nil, err
+	}
 
-		// All devices to reconcile will be stored in deviceToSync
-		deviceToSync := map[reconcile.Request]any{}
+	secret := &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}}
+	return source.Kind(unmanaged, secret,
+		&handler.TypedEnqueueRequestForObject[*metav1.PartialObjectMetadata]{},
+		predicate.NewTypedPredicateFuncs(func(obj *metav1.PartialObjectMetadata) bool {
+			return c.adoptableSecret(obj)
+		}),
+	), unmanaged, nil
+}
 
-		// Get all Tailscale devices
-		log.V(2).Info("Listing all Tailscale devices")
-		devices, err := c.ts.Devices().List(ctx)
+// watchPauseConfigMap makes the given pause switch follow the 'paused' key of the pause ConfigMap,
+// inside the controller namespace. Mutations are paused until the ConfigMap has been read.
+func (c *RunCmd) watchPauseConfigMap(ctx context.Context, pause *reconciler.PauseSwitch) error {
+	log := ctrllog.FromContext(ctx).WithName("pause")
+	pause.SetBy(reconciler.PauseSourceConfigMap, true)
+
+	configMaps, err := cache.New(c.mgr.GetConfig(), cache.Options{
+		Scheme: c.mgr.GetScheme(),
+		Mapper: c.mgr.GetRESTMapper(),
+		DefaultNamespaces: map[string]cache.Config{
+			c.Namespace: {FieldSelector: fields.OneTermEqualSelector("metadata.name", c.PauseConfigMap)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.mgr.Add(configMaps); err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Error(err, "Failed to list Tailscale devices")
-			return fmt.Errorf("failed to list Tailscale devices: %w", err)
-		}
-		log.V(3).Info("Retrieved Tailscale devices", "devices", map[string]any{"count": len(devices)})
-
-		// Apply filter to devices
-		for _, device := range devices {
-			if filter.Match(device) {
-				deviceToSync[reconcile.Request{
-					NamespacedName: types.NamespacedName{
-						Name:      device.Name,
-						Namespace: c.Namespace,
-					},
-				}] = struct{}{}
-			} else {
-				log.V(4).Info("Device ignored by filter",
-					"device", map[string]any{
-						"name": device.Name,
-						"id":   device.NodeID,
-						"tags": device.Tags,
-					},
-				)
-			}
+	update := func(obj any) {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		paused := ok && configMap.Data["paused"] == "true"
+		if paused != pause.PausedBy(reconciler.PauseSourceConfigMap) {
+			log.V(0).Info("Mutations pause changed", "paused", paused, "configmap", c.PauseConfigMap)
 		}
+		// NOTE: the pause requested through the admin API is kept, the mutations staying paused
+		//       until both have been resumed.
+		pause.SetBy(reconciler.PauseSourceConfigMap, paused)
+	}
+	informer, err := configMaps.GetInformer(ctx, &corev1.ConfigMap{})
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+		DeleteFunc: func(any) { update(nil) },
+	})
+	if err != nil {
+		return err
+	}
 
-		// Get all existing secrets managed by this controller
-		log.V(2).Info("Listing existing Tailscale device secrets")
-		existingSecrets := corev1.SecretList{}
-		err = c.mgr.GetClient().List(
-			ctx,
-			&existingSecrets,
-			client.InNamespace(c.Namespace),
-			client.MatchingLabels{"apps.kubernetes.io/managed-by": c.ctrlName},
-		)
-		if err != nil {
-			log.Error(err, "Failed to list existing Tailscale devices' secrets")
-			return fmt.Errorf("failed to list existing Tailscale devices' secrets: %w", err)
+	// The informer only notifies existing ConfigMaps; mutations are resumed once it is known that
+	// the ConfigMap does not exist.
+	err = c.mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !configMaps.WaitForCacheSync(ctx) {
+			return ctx.Err()
 		}
-
-		// Add all existing secrets to reconciliation list
-		for _, secret := range existingSecrets.Items {
-			req := reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Name:      secret.Name,
-					Namespace: secret.Namespace,
-				},
-			}
-			if _, exists := deviceToSync[req]; !exists {
-				log.V(3).Info("Adding existing secret to sync list",
-					"secret", map[string]any{
-						"name":      secret.Name,
-						"namespace": secret.Namespace,
-					},
-				)
-				deviceToSync[req] = struct{}{}
-			}
+		var configMap corev1.ConfigMap
+		err := configMaps.Get(ctx, types.NamespacedName{Namespace: c.Namespace, Name: c.PauseConfigMap}, &configMap)
+		if apierrors.IsNotFound(err) {
+			update(nil)
+			return nil
 		}
+		return err
+	}))
+	return err
+}
 
-		// Reconcile all devices
-		log.V(1).Info("Starting reconciliation of all devices", "devices", map[string]any{"count": len(deviceToSync)})
+// syncedCycle is a successful synchronization cycle.
+type syncedCycle struct {
+	// hash is the hash of the synchronized Tailscale devices.
+	hash string
+	// until is when the first synchronized secret expires (zero without TTL).
+	until time.Time
+}
 
-		var errs *multierror.Error
-		for req := range deviceToSync {
-			log.V(3).Info("Reconciling device", "device", req)
-			_, err := c.reconciler.Reconcile(ctrllog.IntoContext(ctx, log), req)
+// unchanged returns true if the synchronization of the Tailscale devices with the given hash can
+// be skipped at the given time: they are unchanged and no secret has expired since this cycle.
+func (s *syncedCycle) unchanged(hash string, now time.Time) bool {
+	return s != nil && s.hash == hash && (s.until.IsZero() || now.Before(s.until))
+}
 
-			if err != nil {
-				log.Error(err, "Failed to reconcile device")
-				errs = multierror.Append(errs, err)
-			} else {
-				log.V(3).Info("Successfully reconciled device")
-			}
-		}
+func (c *RunCmd) timeBasedReconciliationLoop(ctx context.Context, filter tsutils.TagFilter) error {
+	log := ctrllog.FromContext(ctx).WithName("time_based")
+	log.V(1).Info("Starting time-based reconciliation loop")
 
-		if err := errs.ErrorOrNil(); err != nil {
-			log.Error(err, "Device synchronization completed with error")
-		}
-		log.V(0).Info("Device synchronization successfully completed")
-		return nil
+	// NOTE: the next synchronization is computed from the schedule on every run, instead of a
+	//       ticker, so that the synchronizations stay aligned on the wall clock whatever their
+	//       duration.
+	next, err := c.schedule.Next(time.Now())
+	if err != nil {
+		log.Error(err, "Unable to compute the next reconciliation")
+		return err
+	}
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	c.syncs.Schedule(next)
+
+	syncAllDevices := func(ctx context.Context) error {
+		return c.syncAllDevices(ctrllog.IntoContext(ctx, log), filter)
 	}
 
 	// Run a first reconciliation when the manager starts
 	_ = c.mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
 		log.V(1).Info("Running initial Tailscale devices reconciliation")
-		return syncAllDevices(ctx)
+		return c.syncWithTimeout(ctx, syncAllDevices)
 	}))
 
 	remainingRetries := 5
 	for {
 		select {
-		case <-ticker.C:
-			log.V(1).Info("Reconciliation interval reached")
+		case <-timer.C:
+			log.V(1).Info("Reconciliation schedule reached")
+			if next, err = c.schedule.Next(time.Now()); err != nil {
+				log.Error(err, "Unable to compute the next reconciliation")
+				return err
+			}
+			timer.Reset(time.Until(next))
+			c.syncs.Schedule(next)
 
-			if err := syncAllDevices(ctx); err != nil {
+			if err := c.syncWithTimeout(ctx, syncAllDevices); err != nil {
 				remainingRetries--
 				log.Error(err, "Failed to reconcile devices", "retries", map[string]any{"remaining": remainingRetries})
 
@@ -383,6 +1271,314 @@ func (c *RunCmd) timeBasedReconciliationLoop(ctx context.Context, filter tsutils
 	}
 }
 
+// syncAllDevices synchronizes the secrets of all the Tailscale devices matching the given filter,
+// and deletes the secrets left without device.
+func (c *RunCmd) syncAllDevices(ctx context.Context, filter tsutils.TagFilter) (err error) {
+	log := ctrllog.FromContext(ctx)
+	log.V(1).Info("Starting device synchronization")
+
+	// Report the outcome of the synchronization, including device reconciliation failures
+	var errs *multierror.Error
+	cycle := syncedCycle{}
+	defer func(start time.Time) {
+		outcome := err
+		if outcome == nil {
+			outcome = errs.ErrorOrNil()
+		}
+		c.syncs.Record(start, outcome)
+
+		// NOTE: nothing has been written while paused, so the devices must be synchronized
+		//       again once resumed; neither have the secrets held back by the rollout until
+		//       it is promoted.
+		if outcome == nil && !c.pause.Paused() && (c.rollout == nil || c.rollout.Remaining() == 0) {
+			c.synced.Store(&cycle)
+		} else {
+			c.synced.Store(nil)
+		}
+	}(time.Now())
+
+	// All devices to reconcile will be stored in deviceToSync with the last time their device
+	// changed (used to prioritize the chunks), the requests matching a device being also stored
+	// in matched with the device name
+	deviceToSync := map[reconcile.Request]time.Time{}
+	matched := map[reconcile.Request]string{}
+	registered := map[types.NamespacedName]tailscale.Device{}
+
+	// NOTE: the same state is used during the whole synchronization, even if it is replaced
+	//       in the meantime.
+	state := c.state.Load()
+
+	// Get all Tailscale devices
+	log.V(2).Info("Listing all Tailscale devices")
+	devices, err := state.snapshot.List(ctx, state.devices)
+
+	if err != nil {
+		log.Error(err, "Failed to list Tailscale devices")
+		return fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	log.V(3).Info("Retrieved Tailscale devices", "devices", map[string]any{"count": len(devices)})
+
+	if c.Secrets.Device.Connectivity {
+		tsutils.RecordConnectivity(slices.DeleteFunc(slices.Clone(devices), func(device tailscale.Device) bool { return !filter.Match(device) }))
+	}
+
+	cycle.hash = tsutils.DevicesHash(devices, tsutils.DeviceHashFields{
+		Routes:       c.Secrets.Device.Routes || c.Secrets.Cluster.ServerAddress == reconciler.ServerAddressSubnet,
+		Connectivity: c.Secrets.Device.Connectivity,
+	})
+	if last := c.synced.Load(); c.skipsUnchanged() && last.unchanged(cycle.hash, time.Now()) {
+		log.V(1).Info("Tailscale devices unchanged since the last synchronization, skipping it", "devices", map[string]any{"count": len(devices), "hash": cycle.hash})
+		return nil
+	}
+
+	// Get all existing secrets managed by this controller, on every target so that the
+	// secrets left without device are deleted from the extra targets too
+	log.V(2).Info("Listing existing Tailscale device secrets")
+	existingSecrets, err := state.secrets.ListSecrets(ctx)
+	if err != nil {
+		log.Error(err, "Failed to list existing Tailscale devices' secrets")
+		return fmt.Errorf("failed to list existing Tailscale devices' secrets: %w", err)
+	}
+	if c.Cluster.TTL > 0 {
+		// The unchanged cycles are no longer skipped once the first secret expires, so that it
+		// is refreshed or deleted
+		now := time.Now()
+		cycle.until = now.Add(c.Cluster.TTL)
+		for _, secret := range existingSecrets {
+			if expiry := reconciler.ExpiresAt(secret); expiry.Before(cycle.until) {
+				cycle.until = expiry
+			}
+		}
+		// NOTE: the secrets expired or without expiry date yet are stamped by this cycle, so
+		//       the next one is not skipped and sees their new expiry date.
+		if cycle.until.Before(now) {
+			cycle.until = now
+		}
+	}
+
+	// Keep the secrets in place if the whole tailnet has been renamed
+	c.detectTailnetRename(ctx, existingSecrets, devices, filter)
+
+	// Apply filter to devices
+	for _, device := range devices {
+		if filter.Match(device) {
+			req := reconcile.Request{NamespacedName: state.deviceSecretName(device, c.Namespace)}
+			deviceToSync[req] = deviceChanged(device)
+			matched[req] = device.Name
+			registered[req.NamespacedName] = device
+		} else {
+			log.V(4).Info("Device ignored by filter",
+				"device", map[string]any{
+					"name": device.Name,
+					"id":   device.NodeID,
+					"tags": device.Tags,
+				},
+			)
+		}
+	}
+
+	names := make(map[types.NamespacedName]struct{}, len(registered))
+	for name := range registered {
+		names[name] = struct{}{}
+	}
+	c.deviceSecrets.Store(&names)
+
+	if c.Cluster.InCluster {
+		req := reconcile.Request{NamespacedName: c.inClusterSecret()}
+		deviceToSync[req] = time.Time{}
+		matched[req] = reconciler.InClusterName
+	}
+
+	// Add all existing secrets to reconciliation list
+	existing := map[reconcile.Request]struct{}{}
+	for _, secret := range existingSecrets {
+		req := reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      secret.Name,
+				Namespace: secret.Namespace,
+			},
+		}
+		existing[req] = struct{}{}
+		if _, exists := deviceToSync[req]; !exists {
+			log.V(3).Info("Adding existing secret to sync list",
+				"secret", map[string]any{
+					"name":      secret.Name,
+					"namespace": secret.Namespace,
+				},
+			)
+			deviceToSync[req] = time.Time{}
+		}
+	}
+
+	// Add the devices registered with a backend, whose secret has been deleted
+	if len(c.Output.Backends) > 0 {
+		registrations, err := reconciler.ListRegistrations(ctx, c.mgr.GetAPIReader(), c.ctrlName, slices.Compact(slices.Sorted(maps.Values(c.Output.Backends)))...)
+		if err != nil {
+			log.Error(err, "Failed to list existing Tailscale devices' registrations")
+			return fmt.Errorf("failed to list existing Tailscale devices' registrations: %w", err)
+		}
+		for _, key := range registrations {
+			req := reconcile.Request{NamespacedName: key}
+			existing[req] = struct{}{}
+			if _, exists := deviceToSync[req]; !exists {
+				deviceToSync[req] = time.Time{}
+			}
+		}
+	}
+
+	// Plan the changes of the synchronization before applying any of them; the reconciliations
+	// then apply the planned changes instead of planning them again
+	plan := c.planSync(ctrllog.IntoContext(ctx, log), state, deviceToSync, devices)
+	ctx = reconciler.WithPlan(ctx, plan)
+	log.V(1).Info("Synchronization planned", "plan", map[string]any{
+		"create": plan.Count(reconciler.PlanCreate),
+		"update": plan.Count(reconciler.PlanUpdate),
+		"delete": plan.Count(reconciler.PlanDelete),
+	})
+	c.emitPlan(ctx, plan)
+
+	// Refuse to delete too many secrets at once, e.g. after an undetected mass rename
+	if deletions := plan.Requests(reconciler.PlanDelete); c.DeletionMaxPercent > 0 && len(deletions)*100 > c.DeletionMaxPercent*len(existing) {
+		err := fmt.Errorf("%d of the %d managed secrets would be deleted, more than --reconcile.deletion-max-percent=%d", len(deletions), len(existing), c.DeletionMaxPercent)
+		log.Error(err, "Too many secrets would be deleted, deletions skipped")
+		errs = multierror.Append(errs, err)
+		for _, req := range deletions {
+			delete(deviceToSync, req)
+		}
+	}
+
+	// NOTE: the devices without secret yet are the most recent changes, synchronized first.
+	items := make([]checkpoint.Item, 0, len(deviceToSync))
+	for req, changed := range deviceToSync {
+		if _, exists := existing[req]; !exists && !changed.IsZero() {
+			changed = time.Now()
+		}
+		items = append(items, checkpoint.Item{Key: req.NamespacedName, Changed: changed})
+	}
+
+	// Resume the interrupted synchronization, if any
+	progress, err := c.checkpoints.Load(ctx)
+	if err != nil {
+		log.Error(err, "Failed to load the synchronization checkpoint, starting over")
+		progress = checkpoint.Checkpoint{}
+	}
+	if len(progress.Done) > 0 {
+		log.V(0).Info("Resuming the interrupted synchronization", "checkpoint", map[string]any{"started": progress.Started, "done": len(progress.Done)})
+	} else {
+		progress.Started = time.Now().UTC()
+	}
+	chunks := checkpoint.Chunks(items, progress.Done, c.ChunkSize)
+
+	// Reconcile all devices
+	log.V(1).Info("Starting reconciliation of all devices", "devices", map[string]any{"count": len(deviceToSync)}, "chunks", len(chunks))
+
+	failures := map[reconcile.Request]error{}
+	for i, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		log.V(2).Info("Reconciling chunk", "chunk", map[string]any{"index": i, "count": len(chunk)})
+		for _, key := range chunk {
+			req := reconcile.Request{NamespacedName: key}
+			log.V(3).Info("Reconciling device", "device", req)
+			_, err := state.reconciler.Reconcile(reconciler.TriggerContext(ctrllog.IntoContext(ctx, log), reconciler.TriggerTimer), req)
+
+			if err != nil {
+				log.Error(err, "Failed to reconcile device")
+				errs = multierror.Append(errs, err)
+				failures[req] = err
+			} else {
+				log.V(3).Info("Successfully reconciled device")
+			}
+		}
+
+		// NOTE: nothing has been written while paused, so the chunk is not checkpointed; neither
+		//       is the chunk interrupted by the shutdown, which would have failed. The failed
+		//       secrets are not checkpointed either, to be retried by the resumed cycle.
+		if c.ChunkSize > 0 && ctx.Err() == nil && !c.pause.Paused() {
+			for _, key := range chunk {
+				if _, failed := failures[reconcile.Request{NamespacedName: key}]; !failed {
+					progress.Done = append(progress.Done, key.String())
+				}
+			}
+			if err := c.checkpoints.Save(ctx, progress); err != nil {
+				log.Error(err, "Failed to checkpoint the synchronization", "chunk", i)
+			}
+		}
+	}
+	if c.ChunkSize > 0 {
+		if err := c.checkpoints.Clear(ctx); err != nil {
+			log.Error(err, "Failed to clear the synchronization checkpoint")
+		}
+	}
+	if !c.pause.Paused() {
+		if err := c.publishReport(ctx, matched, failures); err != nil {
+			log.Error(err, "Failed to publish the synchronization report")
+		}
+	}
+	if c.hosts != nil && !c.pause.Paused() {
+		c.hosts.Replace(registered)
+		if err := c.publishHosts(ctx); err != nil {
+			log.Error(err, "Failed to publish the Tailscale devices hosts file", "configmap", c.DNS.ConfigMap)
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		log.Error(err, "Device synchronization completed with error")
+	}
+	log.V(0).Info("Device synchronization successfully completed")
+	return nil
+}
+
+// detectTailnetRename keeps the secrets of the given devices matching the filter in place if the
+// MagicDNS domain of the tailnet has changed (see reconciler.TailnetRename).
+func (c *RunCmd) detectTailnetRename(ctx context.Context, secrets []corev1.Secret, devices []tailscale.Device, filter tsutils.TagFilter) {
+	devices = slices.DeleteFunc(slices.Clone(devices), func(device tailscale.Device) bool { return !filter.Match(device) })
+	if from, to, renamed := c.tailnetRename.Detect(secrets, devices); renamed > 0 {
+		ctrllog.FromContext(ctx).V(0).Info("Tailnet renamed, the secrets of the renamed devices are updated in place", "tailnet", map[string]any{"from": from, "to": to, "devices": renamed})
+	}
+}
+
+// detectInitialTailnetRename detects a tailnet rename from the current devices and secrets, read
+// from the Tailscale and Kubernetes APIs as the caches are not started yet.
+func (c *RunCmd) detectInitialTailnetRename(ctx context.Context, snapshot *tsutils.DeviceSnapshot, devices tsutils.DeviceAPI, filter tsutils.TagFilter) error {
+	listed, err := snapshot.List(ctx, devices)
+	if err != nil {
+		return fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	var secrets corev1.SecretList
+	if err := c.mgr.GetAPIReader().List(ctx, &secrets, client.MatchingLabels{"apps.kubernetes.io/managed-by": c.ctrlName}); err != nil {
+		return fmt.Errorf("failed to list existing Tailscale devices' secrets: %w", err)
+	}
+	c.detectTailnetRename(ctx, secrets.Items, listed, filter)
+	return nil
+}
+
+// validateAnnotations checks that the given annotations, set by the given flag, are valid and not
+// reserved to Argotails.
+func validateAnnotations(flag string, annotations map[string]string) error {
+	for key := range annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid annotation %s: %s", flag, key, strings.Join(errs, ", "))
+		}
+		if reconciler.IsReservedAnnotation(key) {
+			return fmt.Errorf("%s: annotation %s is reserved to Argotails", flag, key)
+		}
+	}
+	return nil
+}
+
+// deviceChanged returns the last time the given device changed, as known from the Tailscale API:
+// when it went offline, or when it was added to the tailnet.
+func deviceChanged(device tailscale.Device) time.Time {
+	if device.LastSeen != nil && device.LastSeen.After(device.Created.Time) {
+		return device.LastSeen.Time
+	}
+	return device.Created.Time
+}
+
 func (c *RunCmd) webhookReconciliationLoop(ctx context.Context) error {
 	log := ctrllog.FromContext(ctx).WithName("webhook")
 	log.V(0).Info("Starting Tailscale webhook server")
@@ -408,7 +1604,7 @@ func (c *RunCmd) webhookReconciliationLoop(ctx context.Context) error {
 		log.V(1).Info("Processing Tailscale webhook request")
 
 		var events []tsutils.WebhookEvent
-		err := tsutils.VerifyWebhookSignature(ctx, r, c.Tailscale.Webhook.Secret, &events)
+		err := tsutils.VerifyWebhookSignature(ctx, r, c.Tailscale.Webhook.Secret, c.Tailscale.Webhook.ClockSkew, &events)
 		if err != nil {
 			log.Error(err, "Failed to verify webhook signature", "response.status", "UNAUTHORIZED")
 			http.Error(w, "401 Invalid request signature", http.StatusUnauthorized)
@@ -430,12 +1626,14 @@ func (c *RunCmd) webhookReconciliationLoop(ctx context.Context) error {
 			}
 
 			log.V(1).Info("Processing device event")
-			_, err = c.reconciler.Reconcile(ctrllog.IntoContext(ctx, log), reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Name:      event.Data.DeviceName,
-					Namespace: c.Namespace,
-				},
-			})
+			reconcileCtx := reconciler.TriggerContext(ctrllog.IntoContext(ctx, log), reconciler.TriggerWebhook)
+			if event.Type == string(tailscale.WebhookNodeDeleted) {
+				reconcileCtx = reconciler.DeviceDeletedContext(reconcileCtx)
+			}
+			nn, err := c.webhookSecretName(reconcileCtx, event.Data.DeviceName)
+			if err == nil {
+				_, err = c.state.Load().reconciler.Reconcile(reconcileCtx, reconcile.Request{NamespacedName: nn})
+			}
 
 			if err != nil {
 				log.Error(err, "Failed to reconcile device from webhook event")
@@ -471,8 +1669,59 @@ func (c *RunCmd) webhookReconciliationLoop(ctx context.Context) error {
 		Handler: rt,
 	}
 
+	var ln net.Listener
+	if c.Tsnet.Enable {
+		log.V(1).Info("Joining the tailnet to serve the webhook over Tailscale", "tsnet", map[string]any{
+			"hostname": c.Tsnet.Hostname,
+			"funnel":   c.Tsnet.Funnel,
+		})
+
+		var node *tsnetutils.Node
+		var err error
+		ln, node, err = tsnetutils.Listen(ctx, tsnetutils.Config{
+			Hostname:   c.Tsnet.Hostname,
+			StateDir:   c.Tsnet.StateDir,
+			AuthKey:    c.Tsnet.AuthKey,
+			ControlURL: c.Tsnet.ControlURL,
+			Funnel:     c.Tsnet.Funnel,
+		})
+		if err != nil {
+			log.Error(err, "Unable to serve the webhook over Tailscale")
+			return err
+		}
+		defer node.Close()
+		server.Addr = ln.Addr().String()
+		if c.self != nil && node.ID != "" {
+			log.V(1).Info("Argotails node joined the tailnet, it is never registered", "node", map[string]any{"id": node.ID})
+			c.self.Add(node.ID)
+		}
+	} else {
+		var err error
+		ln, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			log.Error(err, "Unable to listen for webhook requests")
+			return err
+		}
+
+		getCertificate, err := c.webhookCertificate(ctx)
+		if err != nil {
+			_ = ln.Close()
+			log.Error(err, "Unable to load the webhook TLS certificate")
+			return err
+		}
+		if getCertificate != nil {
+			log.V(1).Info("Serving the webhook over TLS")
+			ln = tls.NewListener(ln, &tls.Config{GetCertificate: getCertificate, MinVersion: tls.VersionTLS12})
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
 	log.V(0).Info("Webhook server starting", "address", server.Addr)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Error(err, "Webhook server stopped with error")
 		return err
 	}
@@ -480,3 +1729,398 @@ func (c *RunCmd) webhookReconciliationLoop(ctx context.Context) error {
 	log.V(0).Info("Webhook server successfully stopped")
 	return nil
 }
+
+// webhookCertificate returns the function serving the webhook TLS certificate, loaded from
+// --ts.webhook.tls-secret or --ts.webhook.tls-cert-file and reloaded on rotation until the given
+// context is done, or nil when the webhook is served without TLS.
+func (c *RunCmd) webhookCertificate(ctx context.Context) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	log := ctrllog.FromContext(ctx)
+
+	switch {
+	case c.Tailscale.Webhook.TLSSecret != "":
+		cert := kubeutils.NewSecretCertificate(c.mgr.GetAPIReader(), types.NamespacedName{Name: c.Tailscale.Webhook.TLSSecret, Namespace: c.Namespace})
+		if _, err := cert.Load(ctx); err != nil {
+			return nil, err
+		}
+		go cert.Watch(ctx, c.Tailscale.Webhook.TLSReload)
+		return cert.GetCertificate, nil
+	case c.Tailscale.Webhook.TLSCertFile != "":
+		watcher, err := certwatcher.New(c.Tailscale.Webhook.TLSCertFile, c.Tailscale.Webhook.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			if err := watcher.Start(ctx); err != nil {
+				log.Error(err, "Unable to watch the webhook TLS certificate files")
+			}
+		}()
+		return watcher.GetCertificate, nil
+	}
+	return nil, nil
+}
+
+// inClusterSecret returns the ArgoCD in-cluster secret managed with --cluster.in-cluster.
+func (c *RunCmd) inClusterSecret() types.NamespacedName {
+	return types.NamespacedName{Name: reconciler.InClusterName, Namespace: c.Namespace}
+}
+
+// inClusterReconciler wraps the given reconciler so that it also maintains the ArgoCD in-cluster
+// secret when --cluster.in-cluster is set.
+func (c *RunCmd) inClusterReconciler(next reconcile.TypedReconciler[reconcile.Request]) reconcile.TypedReconciler[reconcile.Request] {
+	if !c.Cluster.InCluster {
+		return next
+	}
+	return reconciler.NewInClusterReconciler(next, c.kubeClient(c.mgr.GetClient()), c.mgr.GetAPIReader(), c.inClusterSecret(), reconciler.BuildConfig{
+		ManagedBy:    c.ctrlName,
+		Labels:       c.Cluster.InClusterLabels,
+		Annotations:  c.Secrets.Cluster.Annotations,
+		DataMetadata: reconciler.DataMetadata{Labels: c.Secrets.Cluster.DataLabels, Annotations: c.Secrets.Cluster.DataAnnotations},
+	}, c.pause)
+}
+
+// publishReport publishes the report of a synchronization cycle: the managed secrets remaining
+// without matching device and the devices whose reconciliation failed.
+func (c *RunCmd) publishReport(ctx context.Context, matched map[reconcile.Request]string, failures map[reconcile.Request]error) error {
+	var cycle report.Report
+	for req, err := range failures {
+		if device, exists := matched[req]; exists {
+			cycle.AddFailure(req.Namespace, req.Name, device, err)
+		}
+	}
+
+	orphans, err := orphanSecrets(ctx, c.mgr.GetClient(), c.mgr.GetAPIReader(), c.ctrlName, matched)
+	if err != nil {
+		return err
+	}
+	for _, req := range orphans {
+		reason := "no matching Tailscale device"
+		if err, failed := failures[req]; failed {
+			reason = err.Error()
+		}
+		cycle.AddOrphan(req.Namespace, req.Name, reason)
+	}
+
+	return cycle.Publish(ctx, c.mgr.GetClient(), c.Namespace, c.ReportConfigMap, c.ctrlName)
+}
+
+// orphanSecrets returns the managed secrets matching none of the given devices, listed from the
+// cache and confirmed with the API reader: the cache may still hold the secrets deleted by the
+// cycle, which are no longer orphans.
+func orphanSecrets(ctx context.Context, cache, api client.Reader, managedBy string, matched map[reconcile.Request]string) ([]reconcile.Request, error) {
+	var secrets corev1.SecretList
+	err := cache.List(ctx, &secrets, client.MatchingLabels{"apps.kubernetes.io/managed-by": managedBy})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing Tailscale devices' secrets: %w", err)
+	}
+
+	var orphans []reconcile.Request
+	for _, secret := range secrets.Items {
+		req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&secret)}
+		if _, exists := matched[req]; exists {
+			continue
+		}
+
+		var current corev1.Secret
+		err := api.Get(ctx, req.NamespacedName, &current)
+		switch {
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to get the Tailscale device's secret %s: %w", req, err)
+		case current.DeletionTimestamp != nil:
+			continue
+		}
+		orphans = append(orphans, req)
+	}
+	return orphans, nil
+}
+
+// publishHosts writes the addresses of the registered devices into the --dns.configmap ConfigMap,
+// if they changed since they were last written.
+func (c *RunCmd) publishHosts(ctx context.Context) error {
+	devices, changed := c.hosts.Pending()
+	if !changed {
+		return nil
+	}
+	namespace, name, found := strings.Cut(c.DNS.ConfigMap, "/")
+	if !found {
+		namespace, name = c.Namespace, c.DNS.ConfigMap
+	}
+	if err := dns.Publish(ctx, c.mgr.GetClient(), namespace, name, c.DNS.Key, c.ctrlName, devices); err != nil {
+		c.hosts.Retry()
+		return err
+	}
+	return nil
+}
+
+// hostsReconciler wraps the given reconciler so that the reconciliations outside the time-based
+// synchronization (e.g. of the webhook events or of the Kubernetes events) write the hosts file as
+// soon as they change the registered devices, when --dns.configmap is set; the time-based
+// synchronization writes it once done.
+func (c *RunCmd) hostsReconciler(next reconcile.TypedReconciler[reconcile.Request]) reconcile.TypedReconciler[reconcile.Request] {
+	if c.hosts == nil {
+		return next
+	}
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		res, err := next.Reconcile(ctx, req)
+		if reconciler.TriggerFrom(ctx) != reconciler.TriggerTimer && !c.pause.Paused() {
+			if err := c.publishHosts(ctx); err != nil {
+				ctrllog.FromContext(ctx).Error(err, "Failed to publish the Tailscale devices hosts file", "configmap", c.DNS.ConfigMap)
+			}
+		}
+		return res, err
+	})
+}
+
+// hostsHooks records the devices whose secret is written or deleted in the hosts registry.
+type hostsHooks struct {
+	reconciler.NopHooks
+	hosts *dns.Registry
+}
+
+func (h hostsHooks) OnAfterCreate(_ context.Context, device tailscale.Device, secret *corev1.Secret) error {
+	h.hosts.Set(client.ObjectKeyFromObject(secret), device)
+	return nil
+}
+
+func (h hostsHooks) OnAfterUpdate(_ context.Context, device tailscale.Device, secret *corev1.Secret) error {
+	h.hosts.Set(client.ObjectKeyFromObject(secret), device)
+	return nil
+}
+
+func (h hostsHooks) OnAfterDelete(_ context.Context, secret *corev1.Secret) error {
+	h.hosts.Delete(client.ObjectKeyFromObject(secret))
+	return nil
+}
+
+// provisionWebhook registers the Argotails webhook endpoint in the tailnet and loads its secret,
+// kept in the --ts.webhook.secret-name Kubernetes secret as the Tailscale API only returns it when
+// the endpoint is created or its secret rotated.
+func (c *RunCmd) provisionWebhook(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("webhook")
+	nn := types.NamespacedName{Name: c.Tailscale.Webhook.SecretName, Namespace: c.Namespace}
+
+	// NOTE: the secret is not managed by Argotails (otherwise, it would be deleted as it matches
+	//       no device), so it is not visible through the cache.
+	var stored corev1.Secret
+	err := c.mgr.GetAPIReader().Get(ctx, nn, &stored)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to get the webhook secret %s: %w", nn, err)
+	}
+	exists := err == nil
+	known := len(stored.Data["secret"]) > 0
+
+	webhook, err := tsutils.NewWebhookClient(c.state.Load().ts, c.Tailscale.Webhook.URL.String()).Provision(ctx, c.Tailscale.Webhook.RotateSecret || !known)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == nil {
+		log.V(1).Info("Tailscale webhook already provisioned", "webhook", map[string]any{"id": webhook.EndpointID, "url": webhook.EndpointURL})
+		c.state.Update(func(state *sharedState) { state.webhookID = webhook.EndpointID })
+		c.Tailscale.Webhook.Secret = string(stored.Data["secret"])
+		return nil
+	}
+
+	stored.Name, stored.Namespace = nn.Name, nn.Namespace
+	stored.Data = map[string][]byte{"secret": []byte(*webhook.Secret)}
+	if stored.Annotations == nil {
+		stored.Annotations = map[string]string{}
+	}
+	stored.Annotations["argotails.chezmoi.sh/webhook-id"] = webhook.EndpointID
+	if exists {
+		err = c.mgr.GetClient().Update(ctx, &stored)
+	} else {
+		err = c.mgr.GetClient().Create(ctx, &stored)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store the webhook secret into %s: %w", nn, err)
+	}
+	c.state.Update(func(state *sharedState) { state.webhookID = webhook.EndpointID })
+	log.V(0).Info("Tailscale webhook provisioned with a new secret", "webhook", map[string]any{"id": webhook.EndpointID, "url": webhook.EndpointURL}, "secret", nn.String())
+	c.Tailscale.Webhook.Secret = *webhook.Secret
+	return nil
+}
+
+// checksWebhook returns true if the health of the webhook endpoint is periodically checked.
+func (c *RunCmd) checksWebhook() bool {
+	return c.Tailscale.Webhook.Enable && c.Tailscale.Webhook.URL != nil && c.Tailscale.Webhook.CheckInterval > 0
+}
+
+// webhookHealthLoop periodically checks that the webhook endpoint is still registered in the
+// tailnet with the expected URL and subscriptions; otherwise, the device events are silently only
+// caught by the time-based reconciliation loop.
+func (c *RunCmd) webhookHealthLoop(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("webhook_health")
+	ticker := time.NewTicker(c.Tailscale.Webhook.CheckInterval)
+	defer ticker.Stop()
+	for {
+		state := c.state.Load()
+		health, err := tsutils.NewWebhookClient(state.ts, c.Tailscale.Webhook.URL.String()).Check(ctx, state.webhookID)
+		switch {
+		case err != nil:
+			log.Error(err, "Failed to check the Tailscale webhook health")
+		case health != tsutils.WebhookHealthy:
+			log.Error(nil, "Tailscale webhook is unhealthy, device events are only caught by the time-based reconciliation", "health", health, "url", c.Tailscale.Webhook.URL.String())
+		default:
+			log.V(2).Info("Tailscale webhook is healthy")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// webhookSecretName returns the name of the secret of the device with the given name, as webhook
+// events only contain the device name. When secrets are not named after the device name or may
+// live in a fleet namespace, the secret is looked up from the managed secrets first, then from the
+// Tailscale devices.
+func (c *RunCmd) webhookSecretName(ctx context.Context, deviceName string) (types.NamespacedName, error) {
+	state := c.state.Load()
+	if c.Secrets.Cluster.SecretName == reconciler.SecretNamingDeviceName && len(state.fleets) == 0 {
+		return types.NamespacedName{Name: deviceName, Namespace: c.Namespace}, nil
+	}
+
+	var secrets corev1.SecretList
+	err := c.mgr.GetClient().List(ctx, &secrets,
+		client.MatchingLabels{"apps.kubernetes.io/managed-by": c.ctrlName},
+	)
+	if err != nil {
+		return types.NamespacedName{}, fmt.Errorf("failed to list existing Tailscale devices' secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		if string(secret.Data[argocd.KeyName]) == deviceName {
+			return types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, nil
+		}
+	}
+
+	devices, err := state.snapshot.List(ctx, state.devices)
+	if err != nil {
+		return types.NamespacedName{}, fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	for _, device := range devices {
+		if device.Name == deviceName {
+			return state.deviceSecretName(device, c.Namespace), nil
+		}
+	}
+	return types.NamespacedName{}, fmt.Errorf("no Tailscale device nor secret found for device %q", deviceName)
+}
+
+// postureSyncLoop periodically reports the ArgoCD connection state of every managed cluster back
+// into Tailscale as a device posture attribute. Failures are only logged; they must never stop the
+// controller.
+func (c *RunCmd) postureSyncLoop(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("posture")
+	log.V(1).Info("Starting ArgoCD posture synchronization loop", "argocd", map[string]any{"server": c.ArgoCD.Server.String(), "attribute": c.ArgoCD.PostureAttribute})
+
+	argo, err := argocd.NewClient(c.ArgoCD.Server, c.ArgoCD.Token, c.ArgoCD.Insecure)
+	if err != nil {
+		log.Error(err, "Unable to create ArgoCD client. Please check the configuration and try again.")
+		return err
+	}
+	syncer := &argocd.PostureSyncer{
+		ArgoCD:             argo,
+		Reader:             c.mgr.GetClient(),
+		Namespace:          c.Namespace,
+		ManagedBy:          c.ctrlName,
+		Attribute:          c.ArgoCD.PostureAttribute,
+		DeviceIDAnnotation: reconciler.AnnotationDeviceID,
+	}
+	failed := map[types.NamespacedName]bool{}
+	syncer.OnState = func(ctx context.Context, secret corev1.Secret, state string) error {
+		nn := client.ObjectKeyFromObject(&secret)
+		if c.rollout != nil {
+			c.rollout.ReportConnection(ctx, secret, state)
+		}
+		if c.Cluster.Resource {
+			if err := reconciler.ReportAPIServerReachability(ctx, c.mgr.GetClient(), nn, state); err != nil {
+				return err
+			}
+		}
+
+		// Only the transitions to the failed state trigger a reconciliation, the timer loop taking
+		// care of the clusters remaining unreachable.
+		wasFailed := failed[nn]
+		failed[nn] = state == argocd.ConnectionStatusFailed
+		if !c.ArgoCD.RefreshFailed || !failed[nn] || wasFailed {
+			return nil
+		}
+		log.V(1).Info("ArgoCD cluster connection failed, Tailscale device will be reconciled", "secret", nn)
+		_, err := c.state.Load().reconciler.Reconcile(reconciler.TriggerContext(ctrllog.IntoContext(ctx, log), reconciler.TriggerArgoCD), reconcile.Request{NamespacedName: nn})
+		return err
+	}
+
+	ticker := time.NewTicker(c.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.V(1).Info("Stopping ArgoCD posture synchronization loop")
+			return nil
+		case <-ticker.C:
+			// NOTE: the connection states are reported into the TailscaleCluster resources and
+			//       the rollout state, which must not be written while paused.
+			if c.pause.Paused() {
+				log.V(2).Info("Mutations are paused, ArgoCD connection state not reported")
+				continue
+			}
+			syncer.Tailscale = c.state.Load().devices
+			if err := syncer.Sync(ctx); err != nil {
+				log.Error(err, "Failed to report ArgoCD connection state to Tailscale")
+			}
+		}
+	}
+}
+
+// apiPaths are the paths of the read-only API, served by the metrics server.
+var apiPaths = []string{"/clusters", "/status/sync", api.PluginPath}
+
+// apiHandlers returns the handlers of the read-only API to mount on the metrics server, if enabled.
+// As the manager is created before the device filter the API depends on, they serve the router
+// built once the reconciler is initialized (see apiRouter).
+func (c *RunCmd) apiHandlers() map[string]http.Handler {
+	if !c.API.Enable {
+		return nil
+	}
+	handlers := make(map[string]http.Handler, len(apiPaths))
+	for _, path := range apiPaths {
+		handlers[path] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.api == nil {
+				http.Error(w, "503 API not initialized", http.StatusServiceUnavailable)
+				return
+			}
+			c.api.ServeHTTP(w, r)
+		})
+	}
+	return handlers
+}
+
+// apiRouter returns the router of the read-only API.
+func (c *RunCmd) apiRouter(log logr.Logger, filter tsutils.TagFilter) http.Handler {
+	rt := chi.NewRouter()
+	rt.Use(middleware.RealIP)
+	rt.Use(middleware.Recoverer)
+	rt.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := log.WithValues("client", map[string]any{"ip": r.RemoteAddr, "user-agent": r.UserAgent()})
+			log.V(2).Info("API request received", "request", map[string]any{"method": r.Method, "path": r.URL.Path})
+			next.ServeHTTP(w, r.WithContext(ctrllog.IntoContext(r.Context(), log)))
+		})
+	})
+	rt.Method(http.MethodGet, "/clusters", api.NewClustersHandler(c.mgr.GetClient(), c.Namespace, c.ctrlName, c.statuses))
+	if !c.webhookOnly() {
+		rt.Method(http.MethodGet, "/status/sync", api.NewSyncStatusHandler(c.syncs))
+	}
+	if c.API.PluginToken != "" {
+		list := func(ctx context.Context) ([]tailscale.Device, error) {
+			state := c.state.Load()
+			return state.snapshot.List(ctx, state.devices)
+		}
+		secretName := func(device tailscale.Device) string { return c.state.Load().secretName(device) }
+		rt.Method(http.MethodPost, api.PluginPath, api.NewPluginHandler(list, filter.Match, secretName, c.API.PluginToken))
+	}
+	return rt
+}
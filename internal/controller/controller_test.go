@@ -0,0 +1,172 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal synchronization helpers */
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
+)
+
+// parseRun parses the run command with the given arguments, in addition to the required ones.
+func parseRun(t *testing.T, args ...string) (*RunCmd, error) {
+	t.Helper()
+
+	var cmd Command
+	parser, err := kong.New(&cmd, kong.Name("argotails"), zapcoreutils.LevelEnablerMapper, zapcoreutils.EncoderMapper)
+	require.NoError(t, err)
+	_, err = parser.Parse(append([]string{"run", "--ts.tailnet=fake.ts.net", "--namespace=argocd"}, args...))
+	return &cmd.Run, err
+}
+
+func TestSyncedCycle_Unchanged(t *testing.T) {
+	now := time.Now()
+
+	// Nothing is skipped before the first successful synchronization.
+	assert.False(t, (*syncedCycle)(nil).unchanged("sha256:a", now))
+
+	cycle := &syncedCycle{hash: "sha256:a"}
+	assert.True(t, cycle.unchanged("sha256:a", now))
+	assert.False(t, cycle.unchanged("sha256:b", now))
+
+	// With TTL, the unchanged devices are synchronized again once the first secret expires.
+	cycle.until = now.Add(time.Minute)
+	assert.True(t, cycle.unchanged("sha256:a", now))
+	assert.False(t, cycle.unchanged("sha256:a", now.Add(time.Minute)))
+}
+
+func TestOrphanSecrets(t *testing.T) {
+	secret := func(name string) client.Object {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd", Labels: map[string]string{"apps.kubernetes.io/managed-by": "argotails"}}}
+	}
+	req := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "argocd"}}
+	}
+
+	// The cache still holds the secret deleted by the cycle.
+	cache := fake.NewClientBuilder().WithObjects(secret("A.fake.ts.net"), secret("B.fake.ts.net"), secret("deleted")).Build()
+	api := fake.NewClientBuilder().WithObjects(secret("A.fake.ts.net"), secret("B.fake.ts.net")).Build()
+
+	orphans, err := orphanSecrets(context.Background(), cache, api, "argotails", map[reconcile.Request]string{req("A.fake.ts.net"): "A.fake.ts.net"})
+	require.NoError(t, err)
+	assert.Equal(t, []reconcile.Request{req("B.fake.ts.net")}, orphans)
+}
+
+func TestRunCmd_PollOnly(t *testing.T) {
+	// In controller mode, the manager servers are started and the read-only API is mounted on the
+	// metrics server.
+	cmd, err := parseRun(t, "--api.enable")
+	require.NoError(t, err)
+	assert.False(t, cmd.skipsUnchanged())
+	opts := cmd.managerOptions(context.Background(), logr.Discard(), runtime.NewScheme(), nil)
+	assert.Equal(t, ":8081", opts.HealthProbeBindAddress)
+	assert.Equal(t, ":8080", opts.Metrics.BindAddress)
+	assert.Contains(t, opts.Metrics.ExtraHandlers, "/clusters")
+
+	// In poll-only mode, none of them is started and the unchanged cycles are skipped.
+	cmd, err = parseRun(t, "--mode=poll-only")
+	require.NoError(t, err)
+	assert.True(t, cmd.skipsUnchanged())
+	opts = cmd.managerOptions(context.Background(), logr.Discard(), runtime.NewScheme(), nil)
+	assert.Equal(t, "0", opts.HealthProbeBindAddress)
+	assert.Equal(t, "0", opts.Metrics.BindAddress)
+	assert.Empty(t, opts.Metrics.ExtraHandlers)
+	assert.Empty(t, opts.PprofBindAddress)
+
+	for _, flags := range [][]string{{"--ts.webhook.enable"}, {"--api.enable"}, {"--admin.enable", "--admin.token=token"}, {"--kube.pprof-bind-address=:6060"}} {
+		_, err := parseRun(t, append([]string{"--mode=poll-only"}, flags...)...)
+		assert.ErrorContains(t, err, "cannot be used with --mode=poll-only", flags)
+	}
+}
+
+func TestRunCmd_WaitLoops(t *testing.T) {
+	c, err := parseRun(t, "--kube.graceful-shutdown-timeout=50ms")
+	require.NoError(t, err)
+
+	// The loops returning on shutdown are waited on.
+	ctx, cancel := context.WithCancel(context.Background())
+	errg, ctx := errgroup.WithContext(ctx)
+	errg.Go(func() error { <-ctx.Done(); return nil })
+	cancel()
+	assert.NoError(t, c.waitLoops(ctx, errg))
+
+	// The ones still running after the graceful shutdown timeout are not.
+	ctx, cancel = context.WithCancel(context.Background())
+	errg, ctx = errgroup.WithContext(ctx)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	errg.Go(func() error { <-stuck; return nil })
+	cancel()
+	assert.ErrorContains(t, c.waitLoops(ctx, errg), "graceful shutdown timeout")
+}
+
+func TestRunCmd_WebhookClockSkew(t *testing.T) {
+	_, err := parseRun(t, "--ts.webhook.clock-skew=1m")
+	require.NoError(t, err)
+
+	// A skew of zero or less would reject (almost) every webhook delivery.
+	for _, skew := range []string{"0s", "-1m"} {
+		_, err := parseRun(t, "--ts.webhook.clock-skew="+skew)
+		assert.EqualError(t, err, "--ts.webhook.clock-skew must be positive", skew)
+	}
+}
+
+func TestRunCmd_ExcludeSelf(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected bool
+	}{
+		// Off when no device of the cluster where Argotails runs is known.
+		{args: nil, expected: false},
+		{args: []string{"--device.exclude-self"}, expected: false},
+		// On by default as soon as one of them is known.
+		{args: []string{"--device.self=nHub"}, expected: true},
+		{args: []string{"--device.self=nHub", "--no-device.exclude-self"}, expected: false},
+	} {
+		cmd, err := parseRun(t, tc.args...)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, excludesSelf(cmd.Device.ExcludeSelf, len(cmd.Device.Self) > 0 || cmd.Tsnet.Enable), "%v", tc.args)
+	}
+}
+
+func TestImportCmd_UserOwned(t *testing.T) {
+	tagged := tailscale.Device{Name: "a.fake.ts.net", Tags: []string{"tag:k8s"}}
+	owned := tailscale.Device{Name: "laptop.fake.ts.net"}
+
+	for _, tc := range []struct {
+		args     []string
+		expected bool
+	}{
+		// The devices owned by a user are never matched to a secret, as by the controller...
+		{args: []string{"--adopt"}, expected: false},
+		// ... unless allowed.
+		{args: []string{"--adopt", "--device.allow-user-owned"}, expected: true},
+	} {
+		var cmd Command
+		parser, err := kong.New(&cmd, kong.Name("argotails"), zapcoreutils.LevelEnablerMapper, zapcoreutils.EncoderMapper)
+		require.NoError(t, err)
+		_, err = parser.Parse(append([]string{"import", "--ts.tailnet=fake.ts.net", "--ts.authkey=key"}, tc.args...))
+		require.NoError(t, err)
+
+		devOpts, err := cmd.Import.Secrets.deviceOptions(logr.Discard(), cmd.Import.Namespace, nil, tsutils.DeviceFields)
+		require.NoError(t, err)
+		assert.True(t, devOpts.filter.Match(tagged), "%v", tc.args)
+		assert.Equal(t, tc.expected, devOpts.filter.Match(owned), "%v", tc.args)
+	}
+}
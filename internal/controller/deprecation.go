@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var deprecatedFlagsUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "argotails_deprecated_flag_used",
+	Help: "Deprecated flag names or environment variables used to configure Argotails (1 when used).",
+}, []string{"flag", "name"})
+
+func init() {
+	metrics.Registry.MustRegister(deprecatedFlagsUsed)
+}
+
+// DeprecatedUsage is the use of a deprecated name of a flag.
+//
+// A renamed flag keeps its previous names as kong aliases (`aliases:"old-name"`) and its previous
+// environment variables after the current one (`env:"NEW_NAME,OLD_NAME"`); both keep working but
+// are reported as deprecated until they are removed.
+type DeprecatedUsage struct {
+	// Flag is the current name of the flag.
+	Flag string
+	// Name is the deprecated name used, as "--old-name" or "$OLD_NAME".
+	Name string
+}
+
+// DeprecatedUsages returns the deprecated flag names used by the given command line arguments and
+// the deprecated environment variables set in the given environment.
+func DeprecatedUsages(app *kong.Application, args []string, lookupEnv func(string) (string, bool)) []DeprecatedUsage {
+	if i := slices.Index(args, "--"); i >= 0 {
+		args = args[:i]
+	}
+
+	var usages []DeprecatedUsage
+	var walk func(node *kong.Node)
+	walk = func(node *kong.Node) {
+		for _, flag := range node.Flags {
+			for _, alias := range flag.Aliases {
+				name := "--" + alias
+				if slices.ContainsFunc(args, func(arg string) bool { return arg == name || strings.HasPrefix(arg, name+"=") }) {
+					usages = append(usages, DeprecatedUsage{Flag: flag.Name, Name: name})
+				}
+			}
+			for _, env := range flag.Envs[min(1, len(flag.Envs)):] {
+				if _, exists := lookupEnv(env); exists {
+					usages = append(usages, DeprecatedUsage{Flag: flag.Name, Name: "$" + env})
+				}
+			}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(app.Node)
+	return usages
+}
+
+// reportDeprecatedUsages warns about the deprecated flag names and environment variables used to
+// configure Argotails, so that large deployments can migrate gradually.
+func reportDeprecatedUsages(log logr.Logger, usages []DeprecatedUsage) {
+	for _, usage := range usages {
+		log.V(0).Info("Deprecated flag used, please migrate to its current name", "deprecated", usage.Name, "flag", "--"+usage.Flag)
+		deprecatedFlagsUsed.WithLabelValues(usage.Flag, usage.Name).Set(1)
+	}
+}
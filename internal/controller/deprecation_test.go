@@ -0,0 +1,36 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrl "github.com/chezmoidotsh/argotails/internal/controller"
+)
+
+func TestDeprecatedUsages(t *testing.T) {
+	var cli struct {
+		Run struct {
+			Label   string `name:"label" aliases:"managed-by" env:"LABEL,MANAGED_BY"`
+			Timeout string `name:"timeout" env:"TIMEOUT"`
+		} `cmd:""`
+	}
+	parser, err := kong.New(&cli)
+	require.NoError(t, err)
+
+	env := map[string]string{"MANAGED_BY": "argotails", "TIMEOUT": "1s"}
+	lookupEnv := func(key string) (string, bool) {
+		value, exists := env[key]
+		return value, exists
+	}
+
+	usages := ctrl.DeprecatedUsages(parser.Model, []string{"run", "--managed-by=argotails", "--", "--managed-by"}, lookupEnv)
+	assert.Equal(t, []ctrl.DeprecatedUsage{
+		{Flag: "label", Name: "--managed-by"},
+		{Flag: "label", Name: "$MANAGED_BY"},
+	}, usages)
+
+	assert.Empty(t, ctrl.DeprecatedUsages(parser.Model, []string{"run", "--label", "argotails"}, func(string) (string, bool) { return "", false }))
+}
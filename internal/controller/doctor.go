@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/chezmoidotsh/argotails/internal/doctor"
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// DoctorCmd runs a read-only battery of checks against the live Tailscale API and Kubernetes
+// cluster and prints a pass/fail report, in order to verify an installation.
+type DoctorCmd struct {
+	Namespace      string        `name:"namespace" help:"Namespace where ArgoCD cluster secrets are managed." default:"argocd" env:"NAMESPACE"`
+	ServiceAccount string        `name:"service-account" placeholder:"[NAMESPACE/]NAME" help:"Service account of the controller whose permissions are checked (in --namespace unless specified)." default:"argotails" env:"SERVICE_ACCOUNT"`
+	CreateService  bool          `name:"service.create" help:"Also check that the Kubernetes services of the Tailscale devices can be managed." default:"false" env:"SERVICE_CREATE_SERVICE"`
+	Timeout        time.Duration `name:"timeout" help:"Timeout of the whole battery of checks." default:"1m"`
+
+	// Secrets are the flags selecting the registered devices, as in the run command.
+	Secrets SecretFlags `embed:""`
+
+	Device struct {
+		ExcludeSelf *bool    `name:"exclude-self" help:"Never count the Tailscale devices of the cluster where Argotails runs (--device.self), as the run command does. Enabled by default as soon as one of them is known." negatable:"" env:"EXCLUDE_SELF" group:"Device flags"`
+		Self        []string `name:"self" placeholder:"NODE_ID,..." help:"Node IDs of the Tailscale devices of the cluster where Argotails runs, excluded by --device.exclude-self." env:"SELF" group:"Device flags"`
+	} `embed:"" prefix:"device." envprefix:"DEVICE_"`
+
+	Tailscale struct {
+		BaseURL          *url.URL `name:"base-url" help:"Tailscale API base URL." default:"https://api.tailscale.com" env:"TAILSCALE_BASE_URL" group:"Tailscale flags"`
+		ProxyURL         *url.URL `name:"proxy-url" placeholder:"URL" help:"HTTP(S) or SOCKS5 proxy used to reach the Tailscale API (defaults to HTTPS_PROXY/HTTP_PROXY environment variables)." env:"TAILSCALE_PROXY_URL" group:"Tailscale flags"`
+		Tailnet          string   `name:"tailnet" required:"" placeholder:"TAILSCALE_TAILNET" help:"Tailscale network name." env:"TAILSCALE_TAILNET" group:"Tailscale flags"`
+		AuthKey          string   `name:"authkey" required:"" placeholder:"TAILSCALE_AUTH_KEY" help:"Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY" group:"Tailscale flags" xor:"authkey"`
+		AuthKeyFile      []byte   `name:"authkey-file" type:"filecontent" placeholder:"TAILSCALE_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY_FILE" group:"Tailscale flags" xor:"authkey"`
+		DeviceTagFilters []string `name:"device-filter" placeholder:"PATTERN" help:"List of regular expressions to filter the Tailscale devices based on their tags." group:"Tailscale flags"`
+		WebhookURL       *url.URL `name:"webhook.url" placeholder:"URL" help:"Public URL of the webhook handler, checked to be registered in the tailnet (requires the 'webhooks:read' OAuth scope) and reachable; the webhook is not checked unless set." env:"TAILSCALE_WEBHOOK_URL" group:"Tailscale flags"`
+	} `embed:"" prefix:"ts."`
+
+	Kubernetes struct {
+		Kubeconfig string `name:"kubeconfig" help:"Path, or ':'-separated list of paths, to the kubeconfig of the cluster where ArgoCD cluster secrets are managed (defaults to in-cluster configuration)." env:"KUBECONFIG" group:"Kubernetes flags"`
+		Context    string `name:"context" help:"Kubeconfig context to use." env:"KUBE_CONTEXT" group:"Kubernetes flags"`
+	} `embed:"" prefix:"kube."`
+}
+
+func (c *DoctorCmd) AfterApply() error {
+	if c.Tailscale.AuthKeyFile != nil {
+		c.Tailscale.AuthKey = strings.TrimSpace(string(c.Tailscale.AuthKeyFile))
+	}
+	return c.Secrets.validate()
+}
+
+func (c *DoctorCmd) Run(cli *kong.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	ts, err := tsutils.NewTailscaleClient(c.Tailscale.BaseURL, c.Tailscale.Tailnet, c.Tailscale.AuthKey,
+		tsutils.WithProxyURL(c.Tailscale.ProxyURL),
+	)
+	if err != nil {
+		return err
+	}
+	log := funcr.New(func(_, args string) { _, _ = fmt.Fprintln(cli.Stderr, args) }, funcr.Options{})
+	devOpts, err := c.Secrets.deviceOptions(log, c.Namespace, c.Tailscale.DeviceTagFilters, tsutils.DeviceFieldsOf(ts))
+	if err != nil {
+		return err
+	}
+	filter := devOpts.filter
+	if excludesSelf(c.Device.ExcludeSelf, len(c.Device.Self) > 0) {
+		filter = tsutils.AllTagFilters(filter, tsutils.NewSelfFilter(c.Device.Self...))
+	}
+
+	report := doctor.Tailscale(ctx, tsutils.NewDeviceAPI(ts), filter)
+	if c.Tailscale.WebhookURL != nil {
+		endpoint := c.Tailscale.WebhookURL.String()
+		report = append(report, doctor.Webhook(ctx, tsutils.NewWebhookClient(ts, endpoint), http.DefaultClient, endpoint)...)
+	}
+
+	ks, err := c.kubeClient()
+	if err != nil {
+		report = append(report, doctor.Result{Name: "kubernetes", Status: doctor.StatusFail, Detail: err.Error()})
+	} else {
+		report = append(report, doctor.RBAC(ctx, ks, c.Namespace, c.serviceAccount(), c.CreateService)...)
+	}
+
+	if err := report.Print(cli.Stdout); err != nil {
+		return err
+	}
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(report))
+	}
+	return nil
+}
+
+// serviceAccount returns the service account of the controller (see --service-account).
+func (c *DoctorCmd) serviceAccount() types.NamespacedName {
+	namespace, name, found := strings.Cut(c.ServiceAccount, "/")
+	if !found {
+		namespace, name = c.Namespace, c.ServiceAccount
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}
+}
+
+// kubeClient returns the client of the cluster where ArgoCD cluster secrets are managed.
+func (c *DoctorCmd) kubeClient() (client.Client, error) {
+	kcfg, err := kubeutils.Target{Kubeconfig: c.Kubernetes.Kubeconfig, Context: c.Kubernetes.Context}.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(kcfg, client.Options{Scheme: clientgoscheme.Scheme})
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-logr/logr/funcr"
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+	"github.com/chezmoidotsh/argotails/internal/migrate"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// ImportCmd matches the existing ArgoCD cluster secrets (created manually or by other tools) to
+// the Tailscale devices and, if requested, hands them over to Argotails.
+type ImportCmd struct {
+	Namespace string `name:"namespace" help:"Namespace where ArgoCD cluster secrets are managed." default:"argocd" env:"NAMESPACE"`
+	Adopt     bool   `name:"adopt" help:"Hand the matched secrets over to Argotails; otherwise, only report what would be done." default:"false"`
+	Prune     bool   `name:"prune" help:"Delete the secrets replaced by a new Argotails secret (requires --adopt)." default:"false"`
+
+	// Secrets are the flags shaping the imported secrets, as in the run command.
+	Secrets SecretFlags `embed:""`
+
+	Tailscale struct {
+		BaseURL          *url.URL `name:"base-url" help:"Tailscale API base URL." default:"https://api.tailscale.com" env:"TAILSCALE_BASE_URL" group:"Tailscale flags"`
+		ProxyURL         *url.URL `name:"proxy-url" placeholder:"URL" help:"HTTP(S) or SOCKS5 proxy used to reach the Tailscale API (defaults to HTTPS_PROXY/HTTP_PROXY environment variables)." env:"TAILSCALE_PROXY_URL" group:"Tailscale flags"`
+		Tailnet          string   `name:"tailnet" required:"" placeholder:"TAILSCALE_TAILNET" help:"Tailscale network name." env:"TAILSCALE_TAILNET" group:"Tailscale flags"`
+		AuthKey          string   `name:"authkey" required:"" placeholder:"TAILSCALE_AUTH_KEY" help:"Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY" group:"Tailscale flags" xor:"authkey"`
+		AuthKeyFile      []byte   `name:"authkey-file" type:"filecontent" placeholder:"TAILSCALE_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY_FILE" group:"Tailscale flags" xor:"authkey"`
+		DeviceTagFilters []string `name:"device-filter" placeholder:"PATTERN" help:"List of regular expressions to filter the Tailscale devices based on their tags." group:"Tailscale flags"`
+	} `embed:"" prefix:"ts."`
+
+	Kubernetes struct {
+		Kubeconfig string `name:"kubeconfig" help:"Path, or ':'-separated list of paths, to the kubeconfig of the cluster where ArgoCD cluster secrets are managed (defaults to in-cluster configuration)." env:"KUBECONFIG" group:"Kubernetes flags"`
+		Context    string `name:"context" help:"Kubeconfig context to use." env:"KUBE_CONTEXT" group:"Kubernetes flags"`
+	} `embed:"" prefix:"kube."`
+}
+
+func (c *ImportCmd) AfterApply() error {
+	if c.Tailscale.AuthKeyFile != nil {
+		c.Tailscale.AuthKey = strings.TrimSpace(string(c.Tailscale.AuthKeyFile))
+	}
+	if c.Prune && !c.Adopt {
+		return errors.New("--prune requires --adopt")
+	}
+	return c.Secrets.validate()
+}
+
+func (c *ImportCmd) Run(cli *kong.Context) error {
+	ctx := context.Background()
+	managedBy := cli.Model.Name
+	log := funcr.New(func(_, args string) { _, _ = fmt.Fprintln(cli.Stderr, args) }, funcr.Options{})
+
+	ts, err := tsutils.NewTailscaleClient(c.Tailscale.BaseURL, c.Tailscale.Tailnet, c.Tailscale.AuthKey,
+		tsutils.WithProxyURL(c.Tailscale.ProxyURL),
+	)
+	if err != nil {
+		return err
+	}
+	deviceAPI := tsutils.NewDeviceAPI(ts)
+	devOpts, err := c.Secrets.deviceOptions(log, c.Namespace, c.Tailscale.DeviceTagFilters, tsutils.DeviceFieldsOf(ts))
+	if err != nil {
+		return err
+	}
+
+	kcfg, err := kubeutils.Target{Kubeconfig: c.Kubernetes.Kubeconfig, Context: c.Kubernetes.Context}.RESTConfig()
+	if err != nil {
+		return err
+	}
+	ks, err := client.New(kcfg, client.Options{Scheme: clientgoscheme.Scheme})
+	if err != nil {
+		return err
+	}
+
+	// Only the ArgoCD cluster secrets not already managed by Argotails are considered
+	var list corev1.SecretList
+	err = ks.List(ctx, &list, client.InNamespace(c.Namespace), client.MatchingLabels{argocd.LabelSecretType: argocd.SecretTypeCluster})
+	if err != nil {
+		return fmt.Errorf("failed to list ArgoCD cluster secrets: %w", err)
+	}
+	secrets := make([]corev1.Secret, 0, len(list.Items))
+	for _, secret := range list.Items {
+		if secret.Labels["apps.kubernetes.io/managed-by"] != managedBy {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	all, err := deviceAPI.List(ctx, c.Secrets.listOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	devices := make([]tailscale.Device, 0, len(all))
+	for _, device := range all {
+		if devOpts.filter.Match(device) {
+			devices = append(devices, device)
+		}
+	}
+
+	secretName := devOpts.secretName
+	candidates := migrate.Match(secrets, devices, secretName)
+
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SECRET\tSERVER\tDEVICE\tACTION\tREASON")
+	for _, candidate := range candidates {
+		device := "-"
+		if candidate.Device != nil {
+			device = candidate.Device.Name
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", candidate.Secret.Name, candidate.Server, device, candidate.Action, candidate.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !c.Adopt {
+		return nil
+	}
+
+	// The secrets are adopted as the controller would write them, with the same options
+	r, err := reconciler.NewReconciler(ks, deviceAPI, devOpts.filter, managedBy, reconciler.ServiceConfig{Namespace: c.Namespace}, devOpts.opts...)
+	if err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for _, candidate := range candidates {
+		if candidate.Action == migrate.ActionIgnore {
+			continue
+		}
+
+		nn := types.NamespacedName{Name: secretName(*candidate.Device), Namespace: reconciler.FleetNamespace(devOpts.fleets, *candidate.Device, c.Namespace)}
+		if _, err := r.Reconcile(reconciler.TriggerContext(ctx, reconciler.TriggerImport), reconcile.Request{NamespacedName: nn}); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to import secret %q: %w", candidate.Secret.Name, err))
+			continue
+		}
+		_, _ = fmt.Fprintf(cli.Stdout, "secret %q imported as %q\n", candidate.Secret.Name, nn.Name)
+
+		if c.Prune && candidate.Action == migrate.ActionReplace {
+			if err := ks.Delete(ctx, &candidate.Secret); client.IgnoreNotFound(err) != nil {
+				errs = multierror.Append(errs, fmt.Errorf("failed to delete replaced secret %q: %w", candidate.Secret.Name, err))
+				continue
+			}
+			_, _ = fmt.Fprintf(cli.Stdout, "secret %q deleted\n", candidate.Secret.Name)
+		}
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,210 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal synchronization loop */
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/admin"
+	"github.com/chezmoidotsh/argotails/internal/api"
+	"github.com/chezmoidotsh/argotails/internal/checkpoint"
+	"github.com/chezmoidotsh/argotails/internal/dns"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	"github.com/chezmoidotsh/argotails/internal/tailscaletest"
+)
+
+// newIntegrationRun returns the run command parsed from the given arguments, with a controller
+// manager writing into a fake Kubernetes client and the reconcilers of the devices of the given
+// in-memory device API, configured the way Run does.
+func newIntegrationRun(t *testing.T, devices *tailscaletest.DeviceAPI, args ...string) (*RunCmd, tsutils.TagFilter, client.Client) {
+	t.Helper()
+
+	c, err := parseRun(t, append([]string{"--mode=poll-only"}, args...)...)
+	require.NoError(t, err)
+	c.ctrlName = "argotails"
+	c.recorder = events.NewFakeRecorder(100)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	ks := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	// NOTE: the manager never reaches the Kubernetes API: it reads and writes through the fake
+	//       client, its cache being always synced.
+	mgrOpts := c.managerOptions(context.Background(), logr.Discard(), scheme, nil)
+	mgrOpts.NewClient = func(*rest.Config, client.Options) (client.Client, error) { return ks, nil }
+	mgrOpts.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+		return &informertest.FakeInformers{Scheme: scheme}, nil
+	}
+	c.mgr, err = manager.New(&rest.Config{Host: "https://127.0.0.1:0"}, mgrOpts)
+	require.NoError(t, err)
+
+	devOpts, err := c.Secrets.deviceOptions(logr.Discard(), c.Namespace, c.Tailscale.DeviceTagFilters, tsutils.DeviceFields)
+	require.NoError(t, err)
+	c.tailnetRename = reconciler.NewTailnetRename(devOpts.secretName)
+	snapshot, err := tsutils.NewDeviceSnapshot("")
+	require.NoError(t, err)
+	opts := append(devOpts.opts, reconciler.WithSecretNamer(c.tailnetRename.Namer()), reconciler.WithDeviceSnapshot(snapshot))
+	if c.DNS.ConfigMap != "" {
+		c.hosts = dns.NewRegistry()
+		opts = append(opts, reconciler.WithHooks(hostsHooks{hosts: c.hosts}))
+	}
+	main, err := reconciler.NewReconciler(c.mgr.GetClient(), devices, devOpts.filter, c.ctrlName, reconciler.ServiceConfig{Namespace: c.Namespace}, opts...)
+	require.NoError(t, err)
+
+	targets := reconciler.NewMultiReconciler(main)
+	c.syncs = api.NewSyncTracker(time.Hour)
+	c.checkpoints = checkpoint.NewStore(c.mgr.GetClient(), c.mgr.GetClient(), c.Namespace, "", c.ctrlName)
+	c.state.Update(func(state *sharedState) {
+		state.devices = devices
+		state.snapshot = snapshot
+		state.secretName = c.tailnetRename.Namer()
+		state.reconciler = c.hostsReconciler(c.inClusterReconciler(targets))
+		state.planner = targets.(reconciler.Planner)
+		state.secrets = targets.(reconciler.SecretLister)
+	})
+	return c, devOpts.filter, ks
+}
+
+// managedSecretNames returns the names of the secrets managed by Argotails.
+func managedSecretNames(t *testing.T, ks client.Client) []string {
+	t.Helper()
+
+	var secrets corev1.SecretList
+	require.NoError(t, ks.List(context.Background(), &secrets, client.MatchingLabels{"apps.kubernetes.io/managed-by": "argotails"}))
+	names := make([]string, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestIntegration_TimeBased follows a device timeline through the time-based loop: the initial
+// synchronization is run by the controller manager, the following ones as scheduled.
+func TestIntegration_TimeBased(t *testing.T) {
+	a := tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Hostname: "a", Tags: []string{"tag:prod"}}
+	b := tailscale.Device{NodeID: "n2", Name: "b.example.ts.net", Hostname: "b", Tags: []string{"tag:prod"}}
+	devices := tailscaletest.NewDeviceAPI(a)
+	c, filter, ks := newIntegrationRun(t, devices, "--ts.device-filter=prod", "--reconcile.interval=1h")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	loop := make(chan error, 1)
+	go func() { loop <- c.timeBasedReconciliationLoop(ctx, filter) }()
+	go func() { _ = c.mgr.Start(ctx) }()
+
+	require.Eventually(t, func() bool { return c.syncs.Status().LastSuccess != nil }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a.example.ts.net"}, managedSecretNames(t, ks))
+
+	renamed := a
+	renamed.Name = "c.example.ts.net"
+	retagged := b
+	retagged.Tags = []string{"tag:dev"}
+	timeline := []struct {
+		devices  []tailscale.Device
+		expected []string
+	}{
+		{devices: []tailscale.Device{a, b}, expected: []string{"a.example.ts.net", "b.example.ts.net"}},
+		{devices: []tailscale.Device{renamed, b}, expected: []string{"b.example.ts.net", "c.example.ts.net"}},
+		{devices: []tailscale.Device{renamed, retagged}, expected: []string{"c.example.ts.net"}},
+		{devices: []tailscale.Device{retagged}, expected: []string{}},
+	}
+	for i, step := range timeline {
+		devices.SetDevices(step.devices...)
+		require.NoError(t, c.syncWithTimeout(ctx, func(ctx context.Context) error { return c.syncAllDevices(ctx, filter) }))
+		assert.Equal(t, step.expected, managedSecretNames(t, ks), "step %d", i)
+	}
+
+	// The last known devices are synchronized while the Tailscale API is unavailable.
+	devices.SetDevices(a)
+	require.NoError(t, c.syncAllDevices(ctx, filter))
+	devices.SetError(assert.AnError)
+	assert.NoError(t, c.syncAllDevices(ctx, filter))
+	assert.Equal(t, []string{"a.example.ts.net"}, managedSecretNames(t, ks))
+
+	cancel()
+	assert.NoError(t, <-loop)
+}
+
+// TestIntegration_Hosts follows the hosts file of the registered devices, written by the
+// time-based synchronization and as soon as a webhook event changes the registered devices.
+func TestIntegration_Hosts(t *testing.T) {
+	a := tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Hostname: "a", Addresses: []string{"100.64.0.1"}, Tags: []string{"tag:prod"}}
+	b := tailscale.Device{NodeID: "n2", Name: "b.example.ts.net", Hostname: "b", Addresses: []string{"100.64.0.2"}, Tags: []string{"tag:prod"}}
+	devices := tailscaletest.NewDeviceAPI(a)
+	c, filter, ks := newIntegrationRun(t, devices, "--ts.device-filter=prod", "--dns.configmap=kube-system/tailscale-hosts")
+	hosts := func() string {
+		var cm corev1.ConfigMap
+		if err := ks.Get(context.Background(), types.NamespacedName{Namespace: "kube-system", Name: "tailscale-hosts"}, &cm); err != nil {
+			return err.Error()
+		}
+		return cm.Data[dns.DefaultKey]
+	}
+	webhook := func(device tailscale.Device) {
+		ctx := reconciler.TriggerContext(context.Background(), reconciler.TriggerWebhook)
+		_, err := c.state.Load().reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: device.Name}})
+		require.NoError(t, err)
+	}
+
+	// Nothing is written before the first synchronization, which would only hold part of the devices.
+	devices.SetDevices(a, b)
+	webhook(b)
+	assert.Contains(t, hosts(), "not found")
+
+	devices.SetDevices(a)
+	require.NoError(t, c.syncAllDevices(context.Background(), filter))
+	assert.Equal(t, "100.64.0.1 a.example.ts.net\n", hosts())
+
+	devices.SetDevices(a, b)
+	webhook(b)
+	assert.Equal(t, "100.64.0.1 a.example.ts.net\n100.64.0.2 b.example.ts.net\n", hosts())
+
+	devices.SetDevices(b)
+	webhook(a)
+	assert.Equal(t, "100.64.0.2 b.example.ts.net\n", hosts())
+}
+
+// TestIntegration_AdminPause follows the admin API pause, which is neither overridden by the pause
+// ConfigMap nor lets a device be synchronized while paused.
+func TestIntegration_AdminPause(t *testing.T) {
+	a := tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Hostname: "a", Tags: []string{"tag:prod"}}
+	c, filter, ks := newIntegrationRun(t, tailscaletest.NewDeviceAPI(a), "--ts.device-filter=prod")
+	c.pause = &reconciler.PauseSwitch{}
+	backend := adminBackend{c: c, filter: filter}
+
+	backend.SetPaused(true)
+	c.pause.SetBy(reconciler.PauseSourceConfigMap, true)
+	c.pause.SetBy(reconciler.PauseSourceConfigMap, false)
+	assert.True(t, backend.Paused())
+
+	_, err := backend.ForceSync(context.Background(), "a")
+	var cerr *admin.Error
+	require.ErrorAs(t, err, &cerr)
+	assert.Equal(t, "failed_precondition", cerr.Code)
+	assert.Empty(t, managedSecretNames(t, ks))
+
+	backend.SetPaused(false)
+	secret, err := backend.ForceSync(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "argocd/a.example.ts.net", secret)
+	assert.Equal(t, []string{"a.example.ts.net"}, managedSecretNames(t, ks))
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/common/version"
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	"github.com/chezmoidotsh/argotails/internal/fleet"
+	"github.com/chezmoidotsh/argotails/internal/policy"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// SecretFlags are the flags shaping the secrets generated for the Tailscale devices, shared by the
+// commands generating them as the controller does (run, render and import).
+type SecretFlags struct {
+	Device struct {
+		Policy           string            `name:"policy" placeholder:"EXPRESSION" help:"CEL expression evaluated against each Tailscale device, which must be true for the device to be registered (e.g. 'hasTag(device, \"k8s\") && device.os == \"linux\"')." env:"POLICY" group:"Device flags"`
+		PolicyLabels     map[string]string `name:"policy-label" placeholder:"KEY=EXPRESSION" help:"Additional label computed for each Tailscale device from a CEL expression evaluating to a string." env:"POLICY_LABELS" group:"Device flags"`
+		OSFilters        []string          `name:"os-filter" placeholder:"OS" help:"Register only the Tailscale devices running one of these operating systems (e.g. 'linux'), as reported by Tailscale." env:"OS_FILTERS" group:"Device flags"`
+		AllowUserOwned   bool              `name:"allow-user-owned" help:"Also register the Tailscale devices owned by a user (i.e. without tags); by default, only the tagged devices, owned by machines, are registered." default:"false" env:"ALLOW_USER_OWNED" group:"Device flags"`
+		MinClientVersion string            `name:"min-client-version" placeholder:"VERSION" help:"Minimum Tailscale client version (e.g. 1.58.0) required for a device to be registered." env:"MIN_CLIENT_VERSION" group:"Device flags"`
+		OutdatedAction   string            `name:"outdated-action" help:"Action applied on devices older than --device.min-client-version: 'skip' them or 'label' them with 'device.tailscale.com/outdated=true'." enum:"skip,label" default:"skip" env:"OUTDATED_ACTION" group:"Device flags"`
+		TagLabels        string            `name:"tag-labels" help:"Handling of the device tags that are not valid label keys: 'lenient' replaces their invalid characters, 'strict' drops them." enum:"lenient,strict" default:"lenient" env:"TAG_LABELS" group:"Device flags"`
+		Routes           bool              `name:"routes" help:"Fetch the routes enabled for the Tailscale devices and label them with 'device.tailscale.com/subnet-router' and 'device.tailscale.com/exit-node'." default:"false" env:"ROUTES" group:"Device flags"`
+		Connectivity     bool              `name:"connectivity" help:"Fetch the connectivity of the Tailscale devices, annotate their secrets with their preferred DERP relay region ('device.tailscale.com/derp-region') and report their latency to it through the 'argotails_device_derp_latency_seconds' metric, for diagnostics." default:"false" env:"CONNECTIVITY" group:"Device flags"`
+	} `embed:"" prefix:"device." envprefix:"DEVICE_"`
+
+	Cluster struct {
+		ExtraData        map[string]string `name:"extra-data" placeholder:"KEY=EXPRESSION" help:"Additional entry added to every ArgoCD cluster secret, rendered from a Go template expression over the Tailscale device." env:"EXTRA_DATA" group:"Cluster flags"`
+		DataTemplates    map[string]string `name:"data-template" placeholder:"KEY=TEMPLATE" help:"Additional entry added to every ArgoCD cluster secret as a JSON document, rendered from a Go template over '.Device' with sprig-like functions (e.g. 'metadata={{ toJson .Device.Tags }}'), for ApplicationSet generators needing structured values." env:"DATA_TEMPLATES" group:"Cluster flags"`
+		DataLabels       []string          `name:"data-labels" placeholder:"KEY,..." help:"Labels of the ArgoCD cluster secrets also written into their 'labels' data entry as a JSON object, for ApplicationSet templates reading the cluster metadata from the secret data (a key ending with '*' selects every key with this prefix, e.g. 'tag.device.tailscale.com/*')." env:"DATA_LABELS" group:"Cluster flags"`
+		DataAnnotations  []string          `name:"data-annotations" placeholder:"KEY,..." help:"Annotations of the ArgoCD cluster secrets also written into their 'annotations' data entry as a JSON object (a key ending with '*' selects every key with this prefix)." env:"DATA_ANNOTATIONS" group:"Cluster flags"`
+		RequireApproval  bool              `name:"require-approval" help:"Create new ArgoCD cluster secrets pending approval; they are registered only once annotated with 'argotails.chezmoi.sh/approved=true'." default:"false" env:"REQUIRE_APPROVAL" group:"Cluster flags"`
+		SecretName       string            `name:"secret-name" help:"Naming strategy of the ArgoCD cluster secrets: 'device-name', 'device-name-node-id' (avoids collisions between devices sharing the same name) or 'node-id' (device renames update the secret in place)." enum:"device-name,device-name-node-id,node-id" default:"device-name" env:"SECRET_NAME" group:"Cluster flags"`
+		ServerAddress    string            `name:"server-address" help:"How the Kubernetes API server of each device is reached: through its 'magicdns' name or through the first single host route enabled for it ('subnet'), for clusters behind a subnet router." enum:"magicdns,subnet" default:"magicdns" env:"SERVER_ADDRESS" group:"Cluster flags"`
+		ServerNames      map[string]string `name:"server-name" placeholder:"TAG=TEMPLATE" help:"TLS server name (SNI) of the Kubernetes API server of the devices having the given tag ('*' for every device), rendered from a Go template expression over the Tailscale device (e.g. 'tag:lb={{ .Hostname }}.k8s.example.com'), for devices fronted by a shared load balancer routing on SNI; with several matching tags, the first in lexical order is used." env:"SERVER_NAMES" group:"Cluster flags"`
+		Fleets           string            `name:"fleets" type:"existingfile" placeholder:"PATH" help:"Path to a YAML file grouping the Tailscale devices into fleets, each with its own namespace, secret and service settings and deletion policy." env:"FLEETS" group:"Cluster flags"`
+		TenantNamespaces map[string]string `name:"tenant-namespace" placeholder:"TAG=NAMESPACE" help:"Namespace where the resources of the devices having the given tag are created (e.g. 'tag:team-a=argocd-team-a'), for multi-tenant ArgoCD deployments; --cluster.fleets take precedence." env:"TENANT_NAMESPACES" group:"Cluster flags"`
+		MaxSecretSize    int               `name:"max-secret-size" placeholder:"BYTES" help:"Maximum size, in bytes, of the data of every secret (e.g. with embedded CA bundles or exec configurations); larger secrets are not written and reported as an error (defaults to the 1MiB Kubernetes limit)." default:"1048576" env:"MAX_SECRET_SIZE" group:"Cluster flags"`
+		Annotations      map[string]string `name:"annotation" placeholder:"KEY=VALUE" help:"Additional annotation of every generated secret, e.g. 'argocd.argoproj.io/sync-wave=-1' so that the GitOps-rendered secrets are applied first by app-of-apps bootstrap pipelines (the 'argotails.chezmoi.sh/' and 'device.tailscale.com/' prefixes are reserved)." env:"ANNOTATIONS" group:"Cluster flags"`
+	} `embed:"" prefix:"cluster." envprefix:"CLUSTER_"`
+
+	Output struct {
+		Flavor string `name:"flavor" help:"Kind of secret generated for each device: 'argocd' cluster secrets, 'flux' kubeconfig secrets (kubeconfig in the 'value' entry), plain 'kubeconfig' secrets (kubeconfig in the 'kubeconfig' entry) consumable by Cluster API or Karmada, or 'inventory' secrets (ArgoCD cluster data and device metadata, ignored by ArgoCD)." enum:"argocd,flux,kubeconfig,inventory" default:"argocd" env:"FLAVOR" group:"Output flags"`
+	} `embed:"" prefix:"output." envprefix:"OUTPUT_"`
+}
+
+// reservedDataKeys are the secret data entries written by the output flavors, which cannot be
+// overridden by the extra data.
+var reservedDataKeys = []string{argocd.KeyName, argocd.KeyServer, argocd.KeyConfig, "value", "kubeconfig"}
+
+// deviceOptions are the settings built from the SecretFlags: the filter of the registered
+// devices, the naming of their secrets, their fleets and the options of their reconciler.
+type deviceOptions struct {
+	filter     tsutils.TagFilter
+	secretName reconciler.SecretNamer
+	fleets     []reconciler.Fleet
+	opts       []reconciler.Option
+}
+
+// validate checks the flags which can be checked without being parsed.
+func (f *SecretFlags) validate() error {
+	if f.Cluster.MaxSecretSize <= 0 || f.Cluster.MaxSecretSize > corev1.MaxSecretSize {
+		return fmt.Errorf("--cluster.max-secret-size must be between 1 and %d bytes", corev1.MaxSecretSize)
+	}
+	return validateAnnotations("--cluster.annotation", f.Cluster.Annotations)
+}
+
+// listOptions returns the options the Tailscale devices must be listed with.
+func (f *SecretFlags) listOptions() []tailscale.ListDevicesOptions {
+	if f.Device.Routes || f.Device.Connectivity || f.Cluster.ServerAddress == reconciler.ServerAddressSubnet {
+		// Routes and connectivity are only returned when listing the devices with all their fields
+		return []tailscale.ListDevicesOptions{tailscale.WithFields(tailscale.IncludeFieldsAll)}
+	}
+	return nil
+}
+
+// deviceOptions builds the settings of the devices, filtered by the given tag patterns, whose
+// resources are created in the given namespace (or in the namespace of their fleet). The device
+// expressions are evaluated against the device fields returned by the given function.
+func (f *SecretFlags) deviceOptions(log logr.Logger, namespace string, tagFilters []string, fields func(tailscale.Device) map[string]any) (deviceOptions, error) {
+	policyOpts := []policy.Option{policy.WithFields(fields)}
+	var fleets []reconciler.Fleet
+	if f.Cluster.Fleets != "" {
+		raw, err := os.ReadFile(f.Cluster.Fleets)
+		if err != nil {
+			return deviceOptions{}, fmt.Errorf("failed to read --cluster.fleets: %w", err)
+		}
+		fleets, err = fleet.Parse(raw, policyOpts...)
+		if err != nil {
+			log.Error(err, "Invalid fleets configuration.")
+			return deviceOptions{}, err
+		}
+	}
+	if len(f.Cluster.TenantNamespaces) > 0 {
+		tenants, err := fleet.Tenants(f.Cluster.TenantNamespaces)
+		if err != nil {
+			log.Error(err, "Invalid tenant namespaces.")
+			return deviceOptions{}, err
+		}
+		for _, tenant := range tenants {
+			if slices.ContainsFunc(fleets, func(f reconciler.Fleet) bool { return f.Name == tenant.Name }) {
+				return deviceOptions{}, fmt.Errorf("--cluster.tenant-namespace: tenant %q conflicts with the fleet of the same name", tenant.Name)
+			}
+		}
+		fleets = append(fleets, tenants...)
+	}
+	if len(fleets) > 0 {
+		log.V(1).Info("Fleets configured", "fleets", len(fleets), "namespaces", fleet.Namespaces(fleets, namespace))
+	}
+
+	log.V(1).Info("Initializing tag filter", "filter.patterns", tagFilters)
+	filter, err := tsutils.NewRegexpTagFilter(tagFilters...)
+	if err != nil {
+		log.Error(err, "Invalid Tailscale devices' tag filters.", "filter.patterns", tagFilters)
+		return deviceOptions{}, err
+	}
+	log.V(1).Info("Tag filter initialized successfully")
+
+	log.V(1).Info("Initializing device policy", "policy", map[string]any{"admit": f.Device.Policy, "labels": f.Device.PolicyLabels})
+	devicePolicy, err := policy.NewPolicy(f.Device.Policy, f.Device.PolicyLabels, policyOpts...)
+	if err != nil {
+		log.Error(err, "Invalid device policy.")
+		return deviceOptions{}, err
+	}
+	devicePolicy.OnError = func(device tailscale.Device, err error) {
+		log.Error(err, "Failed to evaluate device policy, device's resources left untouched", "device", map[string]any{"name": device.Name, "id": device.NodeID})
+	}
+	filter = tsutils.AllTagFilters(filter, devicePolicy)
+	log.V(1).Info("Device policy initialized successfully")
+
+	if !f.Device.AllowUserOwned {
+		log.V(1).Info("Only tagged devices are registered, devices owned by a user are ignored")
+	}
+	filter = tsutils.AllTagFilters(filter, tsutils.NewOwnershipFilter(f.Device.AllowUserOwned))
+
+	if len(f.Device.OSFilters) > 0 {
+		log.V(1).Info("Operating system filter configured", "filter.os", f.Device.OSFilters)
+		filter = tsutils.AllTagFilters(filter, tsutils.NewOSFilter(f.Device.OSFilters...))
+	}
+
+	extraData, err := policy.NewTemplates(f.Cluster.ExtraData, policyOpts...)
+	if err != nil {
+		log.Error(err, "Invalid ArgoCD cluster secret extra data.")
+		return deviceOptions{}, err
+	}
+	for _, key := range reservedDataKeys {
+		if _, exists := extraData[key]; exists {
+			return deviceOptions{}, fmt.Errorf("--cluster.extra-data cannot override the '%s' entry", key)
+		}
+	}
+
+	if _, exists := extraData["labels"]; exists && len(f.Cluster.DataLabels) > 0 {
+		return deviceOptions{}, fmt.Errorf("--cluster.extra-data cannot override the 'labels' entry written by --cluster.data-labels")
+	}
+	if _, exists := extraData["annotations"]; exists && len(f.Cluster.DataAnnotations) > 0 {
+		return deviceOptions{}, fmt.Errorf("--cluster.extra-data cannot override the 'annotations' entry written by --cluster.data-annotations")
+	}
+
+	dataTemplates, err := policy.NewDocuments(f.Cluster.DataTemplates, policyOpts...)
+	if err != nil {
+		log.Error(err, "Invalid ArgoCD cluster secret data templates.")
+		return deviceOptions{}, err
+	}
+	for key := range dataTemplates {
+		_, extra := extraData[key]
+		switch {
+		case slices.Contains(reservedDataKeys, key):
+			return deviceOptions{}, fmt.Errorf("--cluster.data-template cannot override the '%s' entry", key)
+		case extra:
+			return deviceOptions{}, fmt.Errorf("--cluster.data-template cannot override the '%s' entry written by --cluster.extra-data", key)
+		case key == "labels" && len(f.Cluster.DataLabels) > 0:
+			return deviceOptions{}, fmt.Errorf("--cluster.data-template cannot override the 'labels' entry written by --cluster.data-labels")
+		case key == "annotations" && len(f.Cluster.DataAnnotations) > 0:
+			return deviceOptions{}, fmt.Errorf("--cluster.data-template cannot override the 'annotations' entry written by --cluster.data-annotations")
+		}
+	}
+
+	secretName, err := reconciler.NewSecretNamer(f.Cluster.SecretName)
+	if err != nil {
+		log.Error(err, "Invalid ArgoCD cluster secret naming strategy.")
+		return deviceOptions{}, err
+	}
+
+	opts := []reconciler.Option{
+		reconciler.WithSecretNamer(secretName),
+		reconciler.WithApproval(f.Cluster.RequireApproval),
+		reconciler.WithLabeler(devicePolicy),
+		reconciler.WithExtraData(extraData),
+		reconciler.WithExtraData(dataTemplates),
+		reconciler.WithFlavor(f.Output.Flavor),
+		reconciler.WithTagLabelMode(f.Device.TagLabels),
+		reconciler.WithDataMetadata(reconciler.DataMetadata{Labels: f.Cluster.DataLabels, Annotations: f.Cluster.DataAnnotations}),
+		reconciler.WithAnnotations(f.Cluster.Annotations),
+		reconciler.WithServerAddress(f.Cluster.ServerAddress),
+		reconciler.WithConnectivity(f.Device.Connectivity),
+		reconciler.WithFleets(fleets...),
+		reconciler.WithMaxSecretSize(f.Cluster.MaxSecretSize),
+		reconciler.WithControllerVersion(version.Version),
+	}
+	if f.Device.Routes {
+		opts = append(opts, reconciler.WithLabeler(reconciler.RouteLabels))
+	}
+	for tag, expr := range f.Cluster.ServerNames {
+		tmpl, err := policy.Parse("server-name "+tag, expr, policyOpts...)
+		if err != nil {
+			log.Error(err, "Invalid TLS server name template.")
+			return deviceOptions{}, err
+		}
+		opts = append(opts, reconciler.WithServerName(tag, tmpl))
+	}
+
+	if f.Device.MinClientVersion != "" {
+		minVersion, err := tsutils.ParseClientVersion(f.Device.MinClientVersion)
+		if err != nil {
+			log.Error(err, "Invalid minimum Tailscale client version.")
+			return deviceOptions{}, err
+		}
+		log.V(1).Info("Minimum Tailscale client version configured", "version", map[string]any{"min": minVersion.String(), "action": f.Device.OutdatedAction})
+
+		switch f.Device.OutdatedAction {
+		case "label":
+			opts = append(opts, reconciler.WithLabeler(reconciler.LabelerFunc(func(device tailscale.Device) (map[string]string, error) {
+				return map[string]string{reconciler.LabelDeviceOutdated: strconv.FormatBool(tsutils.IsOutdated(device, minVersion))}, nil
+			})))
+		default:
+			filter = tsutils.AllTagFilters(filter, tsutils.NewMinClientVersionFilter(minVersion))
+		}
+	}
+
+	return deviceOptions{filter: filter, secretName: secretName, fleets: fleets, opts: opts}, nil
+}
+
+// excludesSelf returns true if the devices of the cluster where Argotails runs must be excluded
+// (see --device.exclude-self): by default, as soon as one of them is known.
+func excludesSelf(exclude *bool, known bool) bool {
+	return known && (exclude == nil || *exclude)
+}
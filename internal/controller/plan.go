@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+// planSync plans the changes of the synchronization of the given secrets, on every target, the
+// Tailscale devices being the given ones. The synchronization then applies these changes (see
+// reconciler.WithPlan). The secrets failing to be planned are left out of the plan but still
+// synchronized: their reconciliation plans them again and reports the failure.
+func (c *RunCmd) planSync(ctx context.Context, state *sharedState, reqs map[reconcile.Request]time.Time, devices []tailscale.Device) reconciler.Plan {
+	log := ctrllog.FromContext(ctx)
+
+	changes := make([]reconciler.Change, 0, len(reqs))
+	for req := range reqs {
+		planned, err := state.planner.Plan(ctx, req, devices)
+		if err != nil {
+			log.Error(err, "Failed to plan the synchronization of the secret", "secret", req.NamespacedName)
+			continue
+		}
+		changes = append(changes, planned...)
+	}
+	return reconciler.NewPlan(changes)
+}
+
+// emitPlan writes the changes of the given plan to the --plan-output, before they are applied.
+func (c *RunCmd) emitPlan(ctx context.Context, plan reconciler.Plan) {
+	log := ctrllog.FromContext(ctx)
+	switch c.PlanOutput {
+	case "log":
+		for _, change := range plan.Changed().Changes {
+			log.V(0).Info("Planned change",
+				"action", change.Action,
+				"secret", map[string]any{"name": change.Name, "namespace": change.Namespace},
+				"target", change.Target,
+				"device", change.Device,
+				"reason", change.Reason,
+				"deletions", change.Deletions,
+			)
+		}
+	case "json":
+		if err := json.NewEncoder(c.stdout).Encode(plan.Changed()); err != nil {
+			log.Error(err, "Failed to write the synchronization plan")
+		}
+	}
+}
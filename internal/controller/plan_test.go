@@ -0,0 +1,34 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal synchronization helpers */
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+func TestEmitPlan(t *testing.T) {
+	var out bytes.Buffer
+
+	plan := reconciler.NewPlan([]reconciler.Change{
+		{Action: reconciler.PlanKeep, Namespace: "argocd", Name: "A.fake.ts.net", Reason: "up to date"},
+		{Action: reconciler.PlanDelete, Namespace: "argocd", Name: "B.fake.ts.net", Reason: "Tailscale device not found or filtered"},
+	})
+
+	// Nothing is written without --plan-output=json.
+	(&RunCmd{PlanOutput: "none", stdout: &out}).emitPlan(context.Background(), plan)
+	assert.Zero(t, out.Len())
+
+	// Only the changes are written, as a single JSON document.
+	(&RunCmd{PlanOutput: "json", stdout: &out}).emitPlan(context.Background(), plan)
+	var emitted reconciler.Plan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &emitted))
+	assert.Equal(t, []reconciler.Change{plan.Changes[1]}, emitted.Changes)
+	assert.True(t, plan.Time.Equal(emitted.Time))
+}
@@ -0,0 +1,59 @@
+package controller_test
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// roles returns the Roles and ClusterRoles of the given multi-document YAML, keyed by
+// "namespace/name" (or "name" for the ClusterRoles).
+func roles(t *testing.T, manifests string) map[string]rbacv1.ClusterRole {
+	t.Helper()
+
+	roles := map[string]rbacv1.ClusterRole{}
+	for _, doc := range strings.Split(manifests, "---\n") {
+		var role rbacv1.ClusterRole
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &role))
+		switch role.Kind {
+		case "Role":
+			roles[role.Namespace+"/"+role.Name] = role
+		case "ClusterRole":
+			roles[role.Name] = role
+		}
+	}
+	return roles
+}
+
+func TestRBAC(t *testing.T) {
+	fleets := filepath.Join(t.TempDir(), "fleets.yaml")
+	require.NoError(t, os.WriteFile(fleets, []byte(`fleets:
+  - name: edge
+    tags: ["edge"]
+    namespace: argocd-edge
+  - name: lab
+    tags: ["lab"]
+`), 0o600))
+
+	manifests := roles(t, generate(t, "rbac",
+		"--namespace=argocd",
+		"--cluster.fleets="+fleets,
+		"--cluster.tenant-namespace=tag:team-a=argocd-team-a",
+		"--dns.configmap=kube-system/tailscale-hosts",
+	))
+	assert.ElementsMatch(t, []string{"argocd/argotails", "argocd-edge/argotails", "argocd-team-a/argotails", "kube-system/argotails"}, slices.Collect(maps.Keys(manifests)))
+	assert.Equal(t, []string{"secrets"}, manifests["argocd-edge/argotails"].Rules[0].Resources)
+	assert.Equal(t, []string{"secrets"}, manifests["argocd-team-a/argotails"].Rules[0].Resources)
+	assert.Equal(t, []string{"tailscale-hosts"}, manifests["kube-system/argotails"].Rules[1].ResourceNames)
+
+	manifests = roles(t, generate(t, "rbac", "--namespace=argocd", "--cluster.cleanup-namespaces=*"))
+	assert.ElementsMatch(t, []string{"argocd/argotails", "argotails"}, slices.Collect(maps.Keys(manifests)))
+}
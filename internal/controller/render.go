@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	"github.com/chezmoidotsh/argotails/internal/sealedsecrets"
+	"github.com/chezmoidotsh/argotails/internal/sops"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// RenderCmd prints the secrets Argotails would generate for the current Tailscale devices, in
+// order to be committed to Git and applied by a GitOps tool instead of by the controller.
+type RenderCmd struct {
+	Namespace string `name:"namespace" help:"Namespace of the rendered secrets." default:"argocd" env:"NAMESPACE"`
+
+	// Secrets are the flags shaping the rendered secrets, as in the run command.
+	Secrets SecretFlags `embed:""`
+
+	Encrypt           string   `name:"encrypt" help:"Encryption of the rendered secrets: 'none', 'sealed-secrets' (requires --sealed-secrets.cert) or 'sops' (requires --sops.age-recipient)." enum:"none,sealed-secrets,sops" default:"none" env:"ENCRYPT"`
+	SealedSecretsCert []byte   `name:"sealed-secrets.cert" type:"filecontent" placeholder:"PATH" help:"Path to the sealed-secrets controller certificate (e.g. from 'kubeseal --fetch-cert')." env:"SEALED_SECRETS_CERT"`
+	SOPSAgeRecipients []string `name:"sops.age-recipient" placeholder:"RECIPIENT,..." help:"age recipients (e.g. from 'age-keygen -y') the data of the rendered secrets is encrypted for with SOPS." env:"SOPS_AGE_RECIPIENTS"`
+
+	Tailscale struct {
+		BaseURL          *url.URL `name:"base-url" help:"Tailscale API base URL." default:"https://api.tailscale.com" env:"TAILSCALE_BASE_URL" group:"Tailscale flags"`
+		ProxyURL         *url.URL `name:"proxy-url" placeholder:"URL" help:"HTTP(S) or SOCKS5 proxy used to reach the Tailscale API (defaults to HTTPS_PROXY/HTTP_PROXY environment variables)." env:"TAILSCALE_PROXY_URL" group:"Tailscale flags"`
+		Tailnet          string   `name:"tailnet" required:"" placeholder:"TAILSCALE_TAILNET" help:"Tailscale network name." env:"TAILSCALE_TAILNET" group:"Tailscale flags"`
+		AuthKey          string   `name:"authkey" required:"" placeholder:"TAILSCALE_AUTH_KEY" help:"Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY" group:"Tailscale flags" xor:"authkey"`
+		AuthKeyFile      []byte   `name:"authkey-file" type:"filecontent" placeholder:"TAILSCALE_AUTH_KEY_FILE" help:"Path to the file containing the Tailscale OAuth key." env:"TAILSCALE_AUTH_KEY_FILE" group:"Tailscale flags" xor:"authkey"`
+		DeviceTagFilters []string `name:"device-filter" placeholder:"PATTERN" help:"List of regular expressions to filter the Tailscale devices based on their tags." group:"Tailscale flags"`
+	} `embed:"" prefix:"ts."`
+}
+
+func (c *RenderCmd) AfterApply() error {
+	if c.Tailscale.AuthKeyFile != nil {
+		c.Tailscale.AuthKey = strings.TrimSpace(string(c.Tailscale.AuthKeyFile))
+	}
+	if err := c.Secrets.validate(); err != nil {
+		return err
+	}
+	if c.Encrypt == "sealed-secrets" && c.SealedSecretsCert == nil {
+		return errors.New("--encrypt=sealed-secrets requires --sealed-secrets.cert")
+	}
+	if c.Encrypt == "sops" && len(c.SOPSAgeRecipients) == 0 {
+		return errors.New("--encrypt=sops requires --sops.age-recipient")
+	}
+	return nil
+}
+
+func (c *RenderCmd) Run(cli *kong.Context) error {
+	ctx := context.Background()
+	log := funcr.New(func(_, args string) { _, _ = fmt.Fprintln(cli.Stderr, args) }, funcr.Options{})
+
+	ts, err := tsutils.NewTailscaleClient(c.Tailscale.BaseURL, c.Tailscale.Tailnet, c.Tailscale.AuthKey,
+		tsutils.WithProxyURL(c.Tailscale.ProxyURL),
+	)
+	if err != nil {
+		return err
+	}
+	deviceAPI := tsutils.NewDeviceAPI(ts)
+	devOpts, err := c.Secrets.deviceOptions(log, c.Namespace, c.Tailscale.DeviceTagFilters, tsutils.DeviceFieldsOf(ts))
+	if err != nil {
+		return err
+	}
+	renderer := reconciler.NewSecretRenderer(cli.Model.Name, c.Namespace, devOpts.opts...)
+
+	seal := func(secret corev1.Secret) (any, error) { return secret, nil }
+	if c.Encrypt == "sealed-secrets" {
+		key, err := sealedsecrets.ParseCertificate(c.SealedSecretsCert)
+		if err != nil {
+			return fmt.Errorf("invalid --sealed-secrets.cert: %w", err)
+		}
+		seal = func(secret corev1.Secret) (any, error) {
+			sealed, err := sealedsecrets.Seal(secret, key)
+			// NOTE: GitOps tools order the resources they apply, here the SealedSecret, by their own
+			//       annotations (e.g. the ArgoCD sync waves).
+			if len(c.Secrets.Cluster.Annotations) > 0 {
+				sealed.Annotations = maps.Clone(c.Secrets.Cluster.Annotations)
+			}
+			return sealed, err
+		}
+	}
+	var recipients []*age.X25519Recipient
+	if c.Encrypt == "sops" {
+		recipients, err = sops.ParseRecipients(c.SOPSAgeRecipients...)
+		if err != nil {
+			return fmt.Errorf("invalid --sops.age-recipient: %w", err)
+		}
+	}
+
+	devices, err := deviceAPI.List(ctx, c.Secrets.listOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devOpts.secretName(devices[i]) < devOpts.secretName(devices[j]) })
+
+	objects := make([]any, 0, len(devices))
+	for _, device := range devices {
+		if !devOpts.filter.Match(device) {
+			continue
+		}
+
+		secret, warnings, err := renderer.Render(device)
+		if err != nil {
+			return fmt.Errorf("failed to render secret %q: %w", devOpts.secretName(device), err)
+		}
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		for _, warning := range warnings {
+			_, _ = fmt.Fprintf(cli.Stderr, "secret %q: %s\n", secret.Name, warning)
+		}
+
+		obj, err := seal(secret)
+		if err != nil {
+			return fmt.Errorf("failed to seal secret %q: %w", secret.Name, err)
+		}
+		objects = append(objects, obj)
+	}
+
+	if c.Encrypt == "sops" {
+		// NOTE: the documents of a SOPS file share their data key and MAC, so they are encrypted
+		//       all at once.
+		raw, err := sops.Encrypt(objects, recipients)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secrets with SOPS: %w", err)
+		}
+		_, _ = cli.Stdout.Write(raw)
+		return nil
+	}
+	for _, obj := range objects {
+		raw, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to marshal secret: %w", err)
+		}
+		_, _ = fmt.Fprintf(cli.Stdout, "---\n%s", raw)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/chezmoidotsh/argotails/internal/fleet"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+var hiddenSecrets = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "argotails_hidden_secrets",
+	Help: "Number of secrets carrying the Tailscale device ID annotation but no managed-by label, hidden from the cache of the managed secrets.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(hiddenSecrets)
+}
+
+// isHiddenSecret returns true if the given secret has been written for a Tailscale device but its
+// managed-by label has since been removed. The secrets labeled as managed by another controller
+// (e.g. another Argotails instance) belong to it and are never adopted.
+func isHiddenSecret(obj metav1.Object) bool {
+	_, labeled := obj.GetLabels()["apps.kubernetes.io/managed-by"]
+	return reconciler.IsDeviceSecret(obj) && !labeled
+}
+
+// adoptableSecret returns true if the given secret, not labeled as managed by any controller, must
+// be reconciled to be adopted: a hidden secret (see isHiddenSecret) or a secret recreated by
+// another tool under the name of the secret of a device registered by the last synchronization,
+// which carries none of the Argotails metadata.
+func (c *RunCmd) adoptableSecret(obj metav1.Object) bool {
+	if _, labeled := obj.GetLabels()["apps.kubernetes.io/managed-by"]; labeled {
+		return false
+	}
+	if isHiddenSecret(obj) {
+		return true
+	}
+	names := c.deviceSecrets.Load()
+	if names == nil {
+		return false
+	}
+	_, registered := (*names)[types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}]
+	return registered
+}
+
+// hiddenSecretsNamespaces returns the namespaces where the hidden secrets are looked for: the
+// controller namespace and the namespaces of the fleets, including the tenant ones.
+func (c *RunCmd) hiddenSecretsNamespaces() []string {
+	return fleet.Namespaces(c.state.Load().fleets, c.Namespace)
+}
+
+// watchHiddenSecrets compares, on every change of the unmanaged secrets, the secrets carrying the
+// Tailscale device ID annotation with the managed ones. Such secrets have been written by
+// Argotails, but their managed-by label has since been removed (e.g. label tampering), hiding them
+// from the cache label selector. When their number grows, the hidden secrets are listed directly
+// from the Kubernetes API and reconciled, which adopts them back, and a warning is emitted.
+func (c *RunCmd) watchHiddenSecrets(ctx context.Context, unmanaged cache.Cache) error {
+	log := ctrllog.FromContext(ctx).WithName("hidden_secrets")
+
+	partial := &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}}
+	informer, err := unmanaged.GetInformer(ctx, partial)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the resynchronization calls the Kubernetes API, so it runs outside of the informer
+	//       handlers; pending triggers are coalesced.
+	trigger := make(chan struct{}, 1)
+	notify := func(any) {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj any) { notify(obj) },
+		DeleteFunc: notify,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !unmanaged.WaitForCacheSync(ctx) {
+			return ctx.Err()
+		}
+
+		previous := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-trigger:
+			}
+
+			hidden, err := listHiddenSecrets(ctx, unmanaged, c.hiddenSecretsNamespaces())
+			if err != nil {
+				log.Error(err, "Failed to count the hidden secrets")
+				continue
+			}
+			hiddenSecrets.Set(float64(len(hidden)))
+			if len(hidden) > previous {
+				c.resyncHiddenSecrets(ctx, c.mgr.GetAPIReader())
+			}
+			previous = len(hidden)
+		}
+	}))
+}
+
+// listHiddenSecrets lists the hidden secrets of the given namespaces (see isHiddenSecret).
+func listHiddenSecrets(ctx context.Context, reader client.Reader, namespaces []string) ([]metav1.PartialObjectMetadata, error) {
+	var hidden []metav1.PartialObjectMetadata
+	for _, namespace := range namespaces {
+		var secrets metav1.PartialObjectMetadataList
+		secrets.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("SecretList"))
+		if err := reader.List(ctx, &secrets, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets.Items {
+			if isHiddenSecret(&secret) {
+				hidden = append(hidden, secret)
+			}
+		}
+	}
+	return hidden, nil
+}
+
+// resyncHiddenSecrets lists the hidden secrets directly from the Kubernetes API, bypassing every
+// cache, and reconciles them.
+func (c *RunCmd) resyncHiddenSecrets(ctx context.Context, api client.Reader) {
+	log := ctrllog.FromContext(ctx).WithName("hidden_secrets")
+
+	hidden, err := listHiddenSecrets(ctx, api, c.hiddenSecretsNamespaces())
+	if err != nil {
+		log.Error(err, "Failed to list the secrets from the Kubernetes API")
+		return
+	}
+	if len(hidden) == 0 {
+		return
+	}
+	log.Error(nil, "Secrets of Tailscale devices hidden from the cache, their managed-by label has been removed; reconciling them", "secrets", map[string]any{"hidden": len(hidden)})
+
+	for _, secret := range hidden {
+		nn := types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}
+		c.recorder.Eventf(&secret, nil, corev1.EventTypeWarning, "SecretHidden", "Resync",
+			"Secret of Tailscale device %s hidden from the cache by the removal of its managed-by label, reconciled from the Kubernetes API",
+			secret.Annotations[reconciler.AnnotationDeviceID])
+		if _, err := c.state.Load().reconciler.Reconcile(reconciler.TriggerContext(ctx, reconciler.TriggerKubernetes), reconcile.Request{NamespacedName: nn}); err != nil {
+			log.Error(err, "Failed to reconcile hidden secret", "secret", nn)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal resynchronization helpers */
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+func TestResyncHiddenSecrets(t *testing.T) {
+	secret := func(namespace, name string, labels map[string]string) client.Object {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{reconciler.AnnotationDeviceID: name},
+		}}
+	}
+	api := fake.NewClientBuilder().WithObjects(
+		secret("argocd", "managed", map[string]string{"apps.kubernetes.io/managed-by": "argotails"}),
+		secret("argocd", "hidden", nil),
+		secret("argocd", "other", map[string]string{"apps.kubernetes.io/managed-by": "other-controller"}),
+		secret("argocd-team-a", "tenant", nil),
+		secret("kube-system", "unwatched", nil),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "argocd"}},
+	).Build()
+
+	var reconciled []types.NamespacedName
+	c := &RunCmd{recorder: events.NewFakeRecorder(10)}
+	c.Namespace = "argocd"
+	c.state.Update(func(state *sharedState) {
+		state.fleets = []reconciler.Fleet{{Name: "team-a", Namespace: "argocd-team-a"}}
+		state.reconciler = reconcile.Func(func(_ context.Context, req reconcile.Request) (reconcile.Result, error) {
+			reconciled = append(reconciled, req.NamespacedName)
+			return reconcile.Result{}, nil
+		})
+	})
+
+	// Only the device secrets without managed-by label are adopted back, in the controller and
+	// fleet namespaces; the ones managed by another controller are left to it.
+	c.resyncHiddenSecrets(context.Background(), api)
+	assert.Equal(t, []types.NamespacedName{
+		{Namespace: "argocd", Name: "hidden"},
+		{Namespace: "argocd-team-a", Name: "tenant"},
+	}, reconciled)
+	assert.Len(t, c.recorder.(*events.FakeRecorder).Events, 2)
+}
+
+func TestAdoptableSecret(t *testing.T) {
+	secret := func(name string, labels, annotations map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd", Labels: labels, Annotations: annotations}}
+	}
+	c := &RunCmd{}
+
+	// The hidden secrets are adopted, before any synchronization...
+	assert.True(t, c.adoptableSecret(secret("hidden", nil, map[string]string{reconciler.AnnotationDeviceID: "n1"})))
+	assert.False(t, c.adoptableSecret(secret("a.fake.ts.net", nil, nil)))
+
+	// ... as the secrets recreated by another tool, without label nor annotation, under the name
+	// of the secret of a registered device.
+	c.deviceSecrets.Store(&map[types.NamespacedName]struct{}{{Namespace: "argocd", Name: "a.fake.ts.net"}: {}})
+	assert.True(t, c.adoptableSecret(secret("a.fake.ts.net", nil, nil)))
+	assert.False(t, c.adoptableSecret(secret("unrelated", nil, nil)))
+	assert.False(t, c.adoptableSecret(secret("a.fake.ts.net", map[string]string{"apps.kubernetes.io/managed-by": "other-controller"}, nil)))
+}
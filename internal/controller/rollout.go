@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+// rolloutConfigMapKey is the ConfigMap entry holding the JSON rollout state.
+const rolloutConfigMapKey = "rollout.json"
+
+// configHash returns the hash of the settings shaping the desired state of the device resources,
+// including the content of the fleets and application template files, so that any change of them
+// is rolled out progressively.
+func (c *RunCmd) configHash() (string, error) {
+	files := map[string]string{}
+	for _, path := range []string{c.Secrets.Cluster.Fleets, c.Cluster.Application} {
+		if path == "" {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files[path] = string(raw)
+	}
+
+	raw, err := json.Marshal(map[string]any{
+		"filters": c.Tailscale.DeviceTagFilters,
+		"secrets": c.Secrets,
+		"device":  c.Device,
+		"cluster": c.Cluster,
+		"output":  c.Output,
+		"service": c.Service,
+		"files":   files,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// loadRolloutState returns the rollout state persisted into the given ConfigMap, if any.
+func loadRolloutState(ctx context.Context, reader client.Reader, key types.NamespacedName) (reconciler.RolloutState, error) {
+	var cm corev1.ConfigMap
+	err := reader.Get(ctx, key, &cm)
+	if client.IgnoreNotFound(err) != nil {
+		return reconciler.RolloutState{}, fmt.Errorf("failed to read the rollout ConfigMap: %w", err)
+	}
+
+	var state reconciler.RolloutState
+	if raw := cm.Data[rolloutConfigMapKey]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &state); err != nil {
+			return reconciler.RolloutState{}, fmt.Errorf("invalid rollout state: %w", err)
+		}
+	}
+	return state, nil
+}
+
+// saveRolloutState persists the given rollout state into the given ConfigMap, using server-side
+// apply.
+func saveRolloutState(ctx context.Context, c client.Client, key types.NamespacedName, managedBy string, state reconciler.RolloutState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	cm := corev1ac.ConfigMap(key.Name, key.Namespace).
+		WithLabels(map[string]string{"apps.kubernetes.io/managed-by": managedBy}).
+		WithData(map[string]string{rolloutConfigMapKey: string(raw)})
+	return c.Apply(ctx, cm, client.FieldOwner(managedBy), client.ForceOwnership)
+}
+
+// persistRollout resumes the given rollout from the state persisted into --rollout.state-configmap,
+// or persists it when it is the rollout of a new configuration, and persists its failures.
+func (c *RunCmd) persistRollout(ctx context.Context, rollout *reconciler.Rollout) error {
+	log := ctrllog.FromContext(ctx).WithName("rollout")
+	key := types.NamespacedName{Namespace: c.Namespace, Name: c.Rollout.StateConfigMap}
+
+	state, err := loadRolloutState(ctx, c.mgr.GetAPIReader(), key)
+	if err != nil {
+		return err
+	}
+	if rollout.Restore(state) {
+		log.V(1).Info("Rollout resumed from its persisted state", "rollout", map[string]any{"hash": state.ConfigHash, "started": state.Started, "failure": state.Failure})
+	} else if err := saveRolloutState(ctx, c.mgr.GetClient(), key, c.ctrlName, rollout.State()); err != nil {
+		return fmt.Errorf("failed to persist the rollout state: %w", err)
+	}
+
+	rollout.OnFail = func(ctx context.Context, state reconciler.RolloutState) {
+		if err := saveRolloutState(ctx, c.mgr.GetClient(), key, c.ctrlName, state); err != nil {
+			log.Error(err, "Failed to persist the halted rollout state", "configmap", key)
+		}
+	}
+	return nil
+}
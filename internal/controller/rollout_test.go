@@ -0,0 +1,47 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal rollout helpers */
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+func TestRolloutState(t *testing.T) {
+	ks := fake.NewClientBuilder().Build()
+	key := types.NamespacedName{Namespace: "argocd", Name: "argotails-rollout"}
+
+	state, err := loadRolloutState(context.Background(), ks, key)
+	require.NoError(t, err)
+	assert.Zero(t, state, "no state before the first save")
+
+	rollout := reconciler.NewRollout("sha256:new", []string{"A.fake.ts.net"}, 0, time.Hour)
+	rollout.Restore(reconciler.RolloutState{ConfigHash: "sha256:new", Started: time.Now().Add(-time.Minute).UTC().Truncate(time.Second), Failure: "canary failure"})
+	require.NoError(t, saveRolloutState(context.Background(), ks, key, "argotails", rollout.State()))
+
+	var cm corev1.ConfigMap
+	require.NoError(t, ks.Get(context.Background(), key, &cm))
+	assert.Equal(t, "argotails", cm.Labels["apps.kubernetes.io/managed-by"])
+
+	// A restarted controller resumes the halted rollout of the same configuration...
+	state, err = loadRolloutState(context.Background(), ks, key)
+	require.NoError(t, err)
+	restarted := reconciler.NewRollout("sha256:new", []string{"A.fake.ts.net"}, 0, time.Hour)
+	assert.True(t, restarted.Restore(state))
+	assert.EqualError(t, restarted.Failure(), "canary failure")
+	assert.Equal(t, rollout.State(), restarted.State())
+
+	// ... but starts the rollout of another configuration over.
+	fixed := reconciler.NewRollout("sha256:fixed", []string{"A.fake.ts.net"}, 0, time.Hour)
+	assert.False(t, fixed.Restore(state))
+	assert.NoError(t, fixed.Failure())
+	assert.Greater(t, fixed.Remaining(), 59*time.Minute)
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+type (
+	// sharedState is the state shared by the time-based loop, the webhook handler, the Kubernetes
+	// controller and the APIs, which all run concurrently. A state is never modified once
+	// published: updates publish a modified copy, so that a reader always sees a consistent state
+	// (e.g. a reconciler and the Tailscale client it has been built with) and the state can be
+	// replaced at runtime.
+	sharedState struct {
+		// ts is the Tailscale client.
+		ts *tailscale.Client
+		// devices is the Tailscale device API of the Tailscale client.
+		devices tsutils.DeviceAPI
+		// snapshot keeps the last known Tailscale devices.
+		snapshot *tsutils.DeviceSnapshot
+		// fleets are the groups of devices sharing the same settings.
+		fleets []reconciler.Fleet
+		// secretName returns the name of the secret of a device.
+		secretName reconciler.SecretNamer
+		// reconciler reconciles the resources of a device.
+		reconciler reconcile.TypedReconciler[reconcile.Request]
+		// planner plans the changes of the reconciler on the managed secrets, before applying them.
+		planner reconciler.Planner
+		// secrets lists the secrets managed by the reconciler, on every target.
+		secrets reconciler.SecretLister
+		// webhookID is the ID of the webhook endpoint registered by --ts.webhook.autoprovision.
+		webhookID string
+	}
+
+	// stateHolder holds the current shared state. Reads are lock-free; updates are serialized.
+	stateHolder struct {
+		mu      sync.Mutex
+		current atomic.Pointer[sharedState]
+	}
+)
+
+// Load returns the current shared state, which must not be modified.
+func (h *stateHolder) Load() *sharedState {
+	if state := h.current.Load(); state != nil {
+		return state
+	}
+	return &sharedState{}
+}
+
+// Update publishes a copy of the current shared state modified by the given function.
+func (h *stateHolder) Update(update func(state *sharedState)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	next := *h.Load()
+	update(&next)
+	h.current.Store(&next)
+}
+
+// deviceSecretName returns the secret of the given device, namespace being the namespace of the
+// devices outside of any fleet.
+func (s *sharedState) deviceSecretName(device tailscale.Device, namespace string) types.NamespacedName {
+	return types.NamespacedName{Name: s.secretName(device), Namespace: reconciler.FleetNamespace(s.fleets, device, namespace)}
+}
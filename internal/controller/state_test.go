@@ -0,0 +1,34 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal shared state */
+package controller
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/v2"
+)
+
+func TestStateHolder(t *testing.T) {
+	var h stateHolder
+	assert.Nil(t, h.Load().ts)
+
+	ts := &tailscale.Client{Tailnet: "fake.ts.net"}
+	h.Update(func(state *sharedState) { state.ts = ts })
+	published := h.Load()
+
+	// Concurrent updates never lose each other's changes, and a published state is never modified.
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Go(func() {
+			h.Update(func(state *sharedState) { state.webhookID += strconv.Itoa(i % 10) })
+			_ = h.Load().webhookID
+		})
+	}
+	wg.Wait()
+
+	assert.Len(t, h.Load().webhookID, 100)
+	assert.Same(t, ts, h.Load().ts)
+	assert.Empty(t, published.webhookID)
+}
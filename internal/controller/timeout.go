@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var syncTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "argotails_sync_timeouts_total",
+	Help: "Number of time-based synchronizations interrupted because they exceeded --reconcile.timeout.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(syncTimeouts)
+}
+
+// syncWithTimeout runs the given synchronization with the --reconcile.timeout deadline, so that a
+// hung Tailscale or Kubernetes API call cannot stall the time-based loop. A timed out
+// synchronization is reported but does not fail: the loop continues with the next one.
+func (c *RunCmd) syncWithTimeout(ctx context.Context, sync func(ctx context.Context) error) error {
+	if c.ReconcileTimeout <= 0 {
+		return sync(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, c.ReconcileTimeout)
+	defer cancel()
+	err := sync(timeoutCtx)
+	if ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		syncTimeouts.Inc()
+		ctrllog.FromContext(ctx).Error(err, "Device synchronization timed out, continuing with the next one", "timeout", c.ReconcileTimeout.String())
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal synchronization helpers */
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWithTimeout(t *testing.T) {
+	hung := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c := &RunCmd{ReconcileTimeout: 10 * time.Millisecond}
+
+	// A hung synchronization is interrupted, reported and does not fail the loop.
+	before := testutil.ToFloat64(syncTimeouts)
+	assert.NoError(t, c.syncWithTimeout(context.Background(), hung))
+	assert.Equal(t, before+1, testutil.ToFloat64(syncTimeouts))
+
+	// The other errors are returned as is.
+	failure := errors.New("failure")
+	assert.Equal(t, failure, c.syncWithTimeout(context.Background(), func(context.Context) error { return failure }))
+
+	// The shutdown is not a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, c.syncWithTimeout(ctx, hung), context.Canceled)
+	assert.Equal(t, before+1, testutil.ToFloat64(syncTimeouts))
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/prometheus/common/version"
+	"sigs.k8s.io/yaml"
+
+	"github.com/chezmoidotsh/argotails/internal/featuregate"
+	"github.com/chezmoidotsh/argotails/internal/fips"
+)
+
+// tailscaleClientModule is the module of the Tailscale API client library.
+const tailscaleClientModule = "tailscale.com/client/tailscale/v2"
+
+// BuildInfo is the build metadata reported by the version command, e.g. for an automated
+// inventory of the deployed controllers.
+type BuildInfo struct {
+	Version         string   `json:"version"`
+	Revision        string   `json:"revision"`
+	Branch          string   `json:"branch"`
+	BuildUser       string   `json:"buildUser"`
+	BuildDate       string   `json:"buildDate"`
+	GoVersion       string   `json:"goVersion"`
+	Platform        string   `json:"platform"`
+	Tags            string   `json:"tags"`
+	CryptoBackend   string   `json:"cryptoBackend"`
+	FIPS            bool     `json:"fips"`
+	Features        []string `json:"features"`
+	TailscaleClient string   `json:"tailscaleClient"`
+	// FeatureGates are the feature gates known by the binary, with their stage and default state.
+	FeatureGates map[string]string `json:"featureGates"`
+}
+
+// NewBuildInfo returns the build metadata of the running binary.
+func NewBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:         version.Version,
+		Revision:        version.GetRevision(),
+		Branch:          version.Branch,
+		BuildUser:       version.BuildUser,
+		BuildDate:       version.BuildDate,
+		GoVersion:       version.GoVersion,
+		Platform:        version.GoOS + "/" + version.GoArch,
+		Tags:            version.GetTags(),
+		CryptoBackend:   fips.Backend(),
+		FIPS:            fips.Enabled(),
+		Features:        []string{},
+		TailscaleClient: "unknown",
+		FeatureGates:    map[string]string{},
+	}
+	for feature, spec := range featuregate.Default.Known() {
+		info.FeatureGates[string(feature)] = fmt.Sprintf("%s (default %t)", spec.Stage, spec.Default)
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range build.Deps {
+			if dep.Path == tailscaleClientModule {
+				info.TailscaleClient = dep.Version
+			}
+		}
+		for _, setting := range build.Settings {
+			if setting.Key == "GOEXPERIMENT" && setting.Value != "" {
+				info.Features = append(info.Features, strings.Split(setting.Value, ",")...)
+			}
+		}
+	}
+	if info.FIPS {
+		info.Features = append(info.Features, "fips140")
+	}
+	return info
+}
+
+func (c VersionCmd) Run(cli *kong.Context) error {
+	return writeVersion(cli.Stdout, cli.Model.Name, c.Output, NewBuildInfo())
+}
+
+// writeVersion writes the given build metadata in the given output format.
+func writeVersion(w io.Writer, name, output string, info BuildInfo) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	case "yaml":
+		raw, err := yaml.Marshal(info)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(raw)
+		return err
+	}
+
+	features := strings.Join(info.Features, ",")
+	if features == "" {
+		features = "none"
+	}
+	gates := make([]string, 0, len(info.FeatureGates))
+	for _, feature := range slices.Sorted(maps.Keys(info.FeatureGates)) {
+		gates = append(gates, feature+"="+info.FeatureGates[feature])
+	}
+	_, err := fmt.Fprintf(w, "%s\n  crypto backend:   %s\n  features:         %s\n  tailscale client: %s\n  feature gates:    %s\n",
+		version.Print(name), fips.String(), features, info.TailscaleClient, strings.Join(gates, ", "))
+	return err
+}
@@ -0,0 +1,28 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	ctrl "github.com/chezmoidotsh/argotails/internal/controller"
+)
+
+func TestVersion(t *testing.T) {
+	assert.Contains(t, generate(t, "version"), "crypto backend:")
+
+	var info ctrl.BuildInfo
+	require.NoError(t, json.Unmarshal([]byte(generate(t, "version", "--output=json")), &info))
+	assert.Equal(t, runtime.GOOS+"/"+runtime.GOARCH, info.Platform)
+	assert.NotEmpty(t, info.CryptoBackend)
+	assert.NotNil(t, info.Features)
+	assert.Equal(t, "Alpha (default false)", info.FeatureGates["WebhookAutoProvision"])
+
+	var fromYAML ctrl.BuildInfo
+	require.NoError(t, yaml.Unmarshal([]byte(generate(t, "version", "-o", "yaml")), &fromYAML))
+	assert.Equal(t, info, fromYAML)
+}
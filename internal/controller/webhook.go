@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+type (
+	// WebhookCmd groups the developer commands of the Tailscale webhook handler.
+	WebhookCmd struct {
+		Replay WebhookReplayCmd `cmd:"" name:"replay" help:"Sign a stored Tailscale webhook payload with the webhook secret and post it to a running controller."`
+	}
+
+	// WebhookReplayCmd signs a stored Tailscale webhook events payload, as Tailscale does, and posts
+	// it to the webhook handler of a running controller, to reproduce webhook issues without
+	// crafting the signature by hand.
+	WebhookReplayCmd struct {
+		Payload    string        `arg:"" name:"file" type:"path" help:"Path of the JSON events payload, posted as is ('-' for the standard input)."`
+		URL        *url.URL      `name:"url" help:"URL of the webhook handler of the controller." default:"http://localhost:3000/webhook" env:"WEBHOOK_REPLAY_URL"`
+		Secret     string        `name:"secret" placeholder:"TAILSCALE_WEBHOOK_SECRET" help:"Tailscale webhook secret the payload is signed with." env:"TAILSCALE_WEBHOOK_SECRET" xor:"secret" required:""`
+		SecretFile []byte        `name:"secret-file" type:"filecontent" placeholder:"PATH" help:"Path to the file containing the Tailscale webhook secret." env:"TAILSCALE_WEBHOOK_SECRET_FILE" xor:"secret" required:""`
+		Age        time.Duration `name:"age" help:"Age of the signature, e.g. to check the clock skew tolerance of the controller." default:"0"`
+		Timeout    time.Duration `name:"timeout" help:"Timeout of the request sent to the controller." default:"30s"`
+	}
+)
+
+func (c *WebhookReplayCmd) Run(cli *kong.Context) error {
+	var r io.Reader = os.Stdin
+	if c.Payload != "-" {
+		f, err := os.Open(c.Payload)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read the payload: %w", err)
+	}
+
+	secret := c.Secret
+	if c.SecretFile != nil {
+		secret = string(c.SecretFile)
+	}
+	if secret == "" {
+		return errors.New("the webhook secret must not be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tsutils.WebhookSignatureHeader, tsutils.SignWebhook(secret, time.Now().Add(-c.Age), body))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post the payload: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	answer, _ := io.ReadAll(res.Body)
+
+	_, _ = fmt.Fprintf(cli.Stdout, "%s %s\n", res.Proto, res.Status)
+	if text := strings.TrimSpace(string(answer)); text != "" {
+		_, _ = fmt.Fprintln(cli.Stdout, text)
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook handler answered %s", res.Status)
+	}
+	return nil
+}
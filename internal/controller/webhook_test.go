@@ -0,0 +1,34 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestWebhookReplay(t *testing.T) {
+	var events []tsutils.WebhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := tsutils.VerifyWebhookSignature(context.TODO(), r, "secret", tsutils.DefaultWebhookClockSkew, &events); err != nil {
+			http.Error(w, "401 Invalid request signature", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	payload := filepath.Join(t.TempDir(), "events.json")
+	require.NoError(t, os.WriteFile(payload, []byte(`[{"type":"nodeCreated","data":{"deviceName":"A.fake.ts.net"}}]`), 0o600))
+
+	out := generate(t, "webhook", "replay", "--url="+srv.URL+"/webhook", "--secret=secret", payload)
+	assert.Contains(t, out, "200 OK")
+	assert.Equal(t, []tsutils.WebhookEvent{{Type: "nodeCreated", Data: &tsutils.WebhookEventData{DeviceName: "A.fake.ts.net"}}}, events)
+}
@@ -0,0 +1,112 @@
+// Package dns publishes the addresses of the registered Tailscale devices as a hosts file in a
+// ConfigMap, consumable by the CoreDNS hosts plugin; it is an alternative to the services created
+// for the devices when the Tailscale operator cannot run in the cluster.
+package dns
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// DefaultKey is the default ConfigMap entry holding the hosts file.
+const DefaultKey = "tailscale.hosts"
+
+// Hosts returns the hosts file mapping every address (IPv4 and IPv6) of the given devices to their
+// MagicDNS name, sorted by name so that the file only changes with the devices.
+func Hosts(devices []tailscale.Device) string {
+	devices = slices.Clone(devices)
+	slices.SortFunc(devices, func(a, b tailscale.Device) int { return strings.Compare(a.Name, b.Name) })
+
+	var hosts strings.Builder
+	for _, device := range devices {
+		for _, address := range device.Addresses {
+			hosts.WriteString(address + " " + device.Name + "\n")
+		}
+	}
+	return hosts.String()
+}
+
+// Publish writes the hosts file of the given devices into the given entry of the given ConfigMap,
+// using server-side apply.
+func Publish(ctx context.Context, c client.Client, namespace, name, key, managedBy string, devices []tailscale.Device) error {
+	cm := corev1ac.ConfigMap(name, namespace).
+		WithLabels(map[string]string{"apps.kubernetes.io/managed-by": managedBy}).
+		WithData(map[string]string{key: Hosts(devices)})
+	return c.Apply(ctx, cm, client.FieldOwner(managedBy), client.ForceOwnership)
+}
+
+// Registry holds the registered devices whose addresses are published, by secret, so that the
+// hosts file can be published again as soon as a single secret changes. It is safe for concurrent
+// use.
+type Registry struct {
+	mu      sync.Mutex
+	devices map[types.NamespacedName]tailscale.Device
+	// changed is true when the devices changed since they were last returned by Pending.
+	changed bool
+}
+
+// NewRegistry creates a new registry, without any device until the first Replace.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Replace replaces all the devices of the registry, e.g. once all of them have been synchronized.
+func (r *Registry) Replace(devices map[types.NamespacedName]tailscale.Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices = maps.Clone(devices)
+	if r.devices == nil {
+		r.devices = map[types.NamespacedName]tailscale.Device{}
+	}
+	r.changed = true
+}
+
+// Set records the device of the given secret, written with its current addresses.
+func (r *Registry) Set(secret types.NamespacedName, device tailscale.Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devices == nil {
+		return
+	}
+	if current, exists := r.devices[secret]; !exists || current.Name != device.Name || !slices.Equal(current.Addresses, device.Addresses) {
+		r.devices[secret] = device
+		r.changed = true
+	}
+}
+
+// Delete forgets the device of the given deleted secret.
+func (r *Registry) Delete(secret types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.devices[secret]; exists {
+		delete(r.devices, secret)
+		r.changed = true
+	}
+}
+
+// Pending returns the devices of the registry if they changed since the last call, false
+// otherwise or until the first Replace (a partial hosts file must never be published).
+func (r *Registry) Pending() ([]tailscale.Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.changed {
+		return nil, false
+	}
+	r.changed = false
+	return slices.Collect(maps.Values(r.devices)), true
+}
+
+// Retry makes the devices pending again, e.g. when they could not be published.
+func (r *Registry) Retry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed = r.devices != nil
+}
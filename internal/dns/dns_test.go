@@ -0,0 +1,69 @@
+package dns_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/dns"
+)
+
+func TestPublish(t *testing.T) {
+	ks := fake.NewClientBuilder().Build()
+	devices := []tailscale.Device{
+		{Name: "b.fake.ts.net", Addresses: []string{"100.64.0.2"}},
+		{Name: "a.fake.ts.net", Addresses: []string{"100.64.0.1", "fd7a:115c:a1e0::1"}},
+		{Name: "c.fake.ts.net"},
+	}
+	require.NoError(t, dns.Publish(context.Background(), ks, "kube-system", "tailscale-hosts", dns.DefaultKey, "argotails", devices))
+
+	var cm corev1.ConfigMap
+	require.NoError(t, ks.Get(context.Background(), types.NamespacedName{Name: "tailscale-hosts", Namespace: "kube-system"}, &cm))
+	assert.Equal(t, "argotails", cm.Labels["apps.kubernetes.io/managed-by"])
+	assert.Equal(t, "100.64.0.1 a.fake.ts.net\nfd7a:115c:a1e0::1 a.fake.ts.net\n100.64.0.2 b.fake.ts.net\n", cm.Data[dns.DefaultKey])
+
+	// Removed devices are removed from the hosts file
+	require.NoError(t, dns.Publish(context.Background(), ks, "kube-system", "tailscale-hosts", dns.DefaultKey, "argotails", devices[:1]))
+	require.NoError(t, ks.Get(context.Background(), types.NamespacedName{Name: "tailscale-hosts", Namespace: "kube-system"}, &cm))
+	assert.Equal(t, "100.64.0.2 b.fake.ts.net\n", cm.Data[dns.DefaultKey])
+}
+
+func TestRegistry(t *testing.T) {
+	a := types.NamespacedName{Namespace: "argocd", Name: "a.fake.ts.net"}
+	b := types.NamespacedName{Namespace: "argocd", Name: "b.fake.ts.net"}
+	registry := dns.NewRegistry()
+
+	// Nothing is pending until all the devices are known.
+	registry.Set(b, tailscale.Device{Name: "b.fake.ts.net"})
+	_, changed := registry.Pending()
+	assert.False(t, changed)
+
+	registry.Replace(map[types.NamespacedName]tailscale.Device{a: {Name: "a.fake.ts.net", Addresses: []string{"100.64.0.1"}}})
+	devices, changed := registry.Pending()
+	assert.True(t, changed)
+	assert.Equal(t, []tailscale.Device{{Name: "a.fake.ts.net", Addresses: []string{"100.64.0.1"}}}, devices)
+	_, changed = registry.Pending()
+	assert.False(t, changed)
+
+	// Unchanged devices are not pending again.
+	registry.Set(a, tailscale.Device{Name: "a.fake.ts.net", Addresses: []string{"100.64.0.1"}})
+	_, changed = registry.Pending()
+	assert.False(t, changed)
+
+	registry.Set(b, tailscale.Device{Name: "b.fake.ts.net", Addresses: []string{"100.64.0.2"}})
+	registry.Delete(a)
+	devices, changed = registry.Pending()
+	assert.True(t, changed)
+	assert.Equal(t, []tailscale.Device{{Name: "b.fake.ts.net", Addresses: []string{"100.64.0.2"}}}, devices)
+
+	// The devices which could not be published are pending again.
+	registry.Retry()
+	_, changed = registry.Pending()
+	assert.True(t, changed)
+}
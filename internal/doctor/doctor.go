@@ -0,0 +1,192 @@
+// Package doctor implements the read-only checks of an Argotails installation against the live
+// Tailscale API and Kubernetes cluster, run by 'argotails doctor' to verify an installation.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+const (
+	// StatusPass is the status of a successful check.
+	StatusPass Status = "PASS"
+	// StatusFail is the status of a failed check.
+	StatusFail Status = "FAIL"
+	// StatusSkip is the status of a check that could not run, e.g. as a check it depends on failed.
+	StatusSkip Status = "SKIP"
+)
+
+type (
+	// Status is the outcome of a check.
+	Status string
+
+	// Result is the result of a check.
+	Result struct {
+		Name   string
+		Status Status
+		Detail string
+	}
+
+	// Report is the results of all the checks, in the order they ran.
+	Report []Result
+)
+
+// pass, fail and skip return the result of the given check.
+func pass(name, format string, args ...any) Result {
+	return Result{Name: name, Status: StatusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func fail(name string, err error) Result {
+	return Result{Name: name, Status: StatusFail, Detail: err.Error()}
+}
+
+func skip(name, reason string) Result {
+	return Result{Name: name, Status: StatusSkip, Detail: reason}
+}
+
+// Failed returns the number of failed checks.
+func (r Report) Failed() int {
+	failed := 0
+	for _, result := range r {
+		if result.Status == StatusFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print writes the report as a table.
+func (r Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+	for _, result := range r {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Name, result.Status, result.Detail)
+	}
+	return tw.Flush()
+}
+
+// Tailscale checks that the Tailscale API accepts the credentials, by listing the devices, and
+// reports how many devices the filter matches.
+func Tailscale(ctx context.Context, api tsutils.DeviceAPI, filter tsutils.TagFilter) Report {
+	devices, err := api.List(ctx)
+	if err != nil {
+		return Report{
+			fail("tailscale-auth", err),
+			skip("tailscale-devices", "Tailscale API not reachable"),
+			skip("device-filter", "Tailscale API not reachable"),
+		}
+	}
+
+	matched := 0
+	for _, device := range devices {
+		if filter.Match(device) {
+			matched++
+		}
+	}
+	report := Report{
+		pass("tailscale-auth", "devices listed"),
+		pass("tailscale-devices", "%d devices in the tailnet", len(devices)),
+	}
+	if matched == 0 {
+		return append(report, fail("device-filter", fmt.Errorf("no device matches the filter out of %d", len(devices))))
+	}
+	return append(report, pass("device-filter", "%d devices match the filter", matched))
+}
+
+// Webhook checks that the webhook endpoint with the given URL is registered in the tailnet and
+// subscribed to the device events, and that the URL is served by Argotails: as the request is not
+// signed, the handler must reject it with a 401 status, any other answer (e.g. from a proxy or
+// another service) failing the check.
+func Webhook(ctx context.Context, webhooks *tsutils.WebhookClient, httpClient *http.Client, endpointURL string) Report {
+	var report Report
+	health, err := webhooks.Check(ctx, "")
+	switch {
+	case err != nil:
+		report = append(report, fail("webhook-registration", err))
+	case health != tsutils.WebhookHealthy:
+		report = append(report, fail("webhook-registration", fmt.Errorf("webhook endpoint %s", strings.ReplaceAll(string(health), "_", " "))))
+	default:
+		report = append(report, pass("webhook-registration", "registered and subscribed to the device events"))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, http.NoBody)
+	if err != nil {
+		return append(report, fail("webhook-reachability", err))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return append(report, fail("webhook-reachability", err))
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return append(report, fail("webhook-reachability", fmt.Errorf("%s answered %s to an unsigned request instead of rejecting its signature", endpointURL, resp.Status)))
+	}
+	return append(report, pass("webhook-reachability", "%s rejected the unsigned request (%s)", endpointURL, resp.Status))
+}
+
+// rbacChecks are the permissions required by the controller on the resources of its namespace.
+var rbacChecks = []struct {
+	name     string
+	resource string
+	verbs    []string
+	service  bool
+}{
+	{name: "rbac-read-secrets", resource: "secrets", verbs: []string{"get", "list", "watch"}},
+	{name: "rbac-write-secrets", resource: "secrets", verbs: []string{"create", "update", "patch", "delete"}},
+	{name: "rbac-write-services", resource: "services", verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"}, service: true},
+}
+
+// RBAC checks that the given service account, the identity of the controller, is granted the
+// permissions on the secrets of the given namespace (and on its services too, if requested). The
+// permissions are checked through SubjectAccessReviews, so nothing is written and the permissions
+// of the user running the checks do not matter.
+func RBAC(ctx context.Context, ks client.Client, namespace string, serviceAccount types.NamespacedName, services bool) Report {
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", serviceAccount.Namespace, serviceAccount.Name)
+	groups := []string{"system:serviceaccounts", "system:serviceaccounts:" + serviceAccount.Namespace, "system:authenticated"}
+
+	var report Report
+	for _, check := range rbacChecks {
+		if check.service && !services {
+			continue
+		}
+
+		var denied []string
+		var err error
+		for _, verb := range check.verbs {
+			review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user,
+				Groups: groups,
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Resource:  check.resource,
+				},
+			}}
+			if err = ks.Create(ctx, review); err != nil {
+				break
+			}
+			if !review.Status.Allowed {
+				denied = append(denied, verb)
+			}
+		}
+		switch {
+		case err != nil:
+			report = append(report, fail(check.name, err))
+		case len(denied) > 0:
+			report = append(report, fail(check.name, fmt.Errorf("%s cannot %s %s in %s", user, strings.Join(denied, ", "), check.resource, namespace)))
+		default:
+			report = append(report, pass(check.name, "%s can %s %s in %s", user, strings.Join(check.verbs, ", "), check.resource, namespace))
+		}
+	}
+	return report
+}
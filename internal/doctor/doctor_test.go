@@ -0,0 +1,145 @@
+package doctor_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/doctor"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	"github.com/chezmoidotsh/argotails/internal/tailscaletest"
+)
+
+// statuses returns the status of every check, by name.
+func statuses(report doctor.Report) map[string]doctor.Status {
+	statuses := map[string]doctor.Status{}
+	for _, result := range report {
+		statuses[result.Name] = result.Status
+	}
+	return statuses
+}
+
+func TestTailscale(t *testing.T) {
+	filter, err := tsutils.NewRegexpTagFilter("k8s")
+	require.NoError(t, err)
+	api := tailscaletest.NewDeviceAPI(
+		tailscale.Device{Name: "A.fake.ts.net", Tags: []string{"tag:k8s"}},
+		tailscale.Device{Name: "B.fake.ts.net", Tags: []string{"tag:other"}},
+	)
+
+	report := doctor.Tailscale(context.Background(), api, filter)
+	assert.Equal(t, map[string]doctor.Status{"tailscale-auth": doctor.StatusPass, "tailscale-devices": doctor.StatusPass, "device-filter": doctor.StatusPass}, statuses(report))
+	assert.Equal(t, "2 devices in the tailnet", report[1].Detail)
+	assert.Equal(t, "1 devices match the filter", report[2].Detail)
+
+	// A filter matching no device is reported, as nothing would be registered.
+	api.SetDevices(tailscale.Device{Name: "B.fake.ts.net", Tags: []string{"tag:other"}})
+	report = doctor.Tailscale(context.Background(), api, filter)
+	assert.Equal(t, doctor.StatusFail, statuses(report)["device-filter"])
+
+	// The checks depending on the API are skipped when the credentials are rejected.
+	api.SetError(errors.New("401 Unauthorized"))
+	report = doctor.Tailscale(context.Background(), api, filter)
+	assert.Equal(t, map[string]doctor.Status{"tailscale-auth": doctor.StatusFail, "tailscale-devices": doctor.StatusSkip, "device-filter": doctor.StatusSkip}, statuses(report))
+	assert.Equal(t, 1, report.Failed())
+}
+
+func TestWebhook(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "401 Invalid request signature", http.StatusUnauthorized)
+	}))
+	defer endpoint.Close()
+
+	webhooks := []tailscale.Webhook{{EndpointID: "id", EndpointURL: endpoint.URL, Subscriptions: tsutils.WebhookSubscriptions}}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"webhooks": webhooks})
+	}))
+	defer api.Close()
+	apiURL, err := url.Parse(api.URL)
+	require.NoError(t, err)
+	ts := &tailscale.Client{Tailnet: "fake.ts.net", HTTP: api.Client(), BaseURL: apiURL}
+
+	report := doctor.Webhook(context.Background(), tsutils.NewWebhookClient(ts, endpoint.URL), endpoint.Client(), endpoint.URL)
+	assert.Equal(t, map[string]doctor.Status{"webhook-registration": doctor.StatusPass, "webhook-reachability": doctor.StatusPass}, statuses(report))
+	assert.Contains(t, report[1].Detail, "401 Unauthorized")
+
+	// An endpoint not rejecting the unsigned request is not served by Argotails.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer other.Close()
+	report = doctor.Webhook(context.Background(), tsutils.NewWebhookClient(ts, endpoint.URL), other.Client(), other.URL)
+	assert.Equal(t, doctor.StatusFail, statuses(report)["webhook-reachability"])
+	assert.Contains(t, report[1].Detail, "200 OK")
+
+	webhooks = nil
+	endpoint.Close()
+	report = doctor.Webhook(context.Background(), tsutils.NewWebhookClient(ts, endpoint.URL), endpoint.Client(), endpoint.URL)
+	assert.Equal(t, map[string]doctor.Status{"webhook-registration": doctor.StatusFail, "webhook-reachability": doctor.StatusFail}, statuses(report))
+	assert.Equal(t, "webhook endpoint missing", report[0].Detail)
+}
+
+// reviewer returns a client answering the SubjectAccessReviews of the given user with the given
+// permissions, by namespace, resource and verb.
+func reviewer(user string, allowed ...string) client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+			review, ok := obj.(*authorizationv1.SubjectAccessReview)
+			if !ok {
+				return errors.New("unexpected write")
+			}
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = review.Spec.User == user && slices.Contains(allowed, attrs.Namespace+"/"+attrs.Resource+"/"+attrs.Verb)
+			return nil
+		},
+	}).Build()
+}
+
+func TestRBAC(t *testing.T) {
+	sa := types.NamespacedName{Namespace: "argocd", Name: "argotails"}
+	var allowed []string
+	for _, resource := range []string{"secrets", "services"} {
+		for _, verb := range []string{"get", "list", "watch", "create", "update", "patch", "delete"} {
+			allowed = append(allowed, "argocd/"+resource+"/"+verb)
+		}
+	}
+
+	report := doctor.RBAC(context.Background(), reviewer("system:serviceaccount:argocd:argotails", allowed...), "argocd", sa, true)
+	assert.Equal(t, map[string]doctor.Status{"rbac-read-secrets": doctor.StatusPass, "rbac-write-secrets": doctor.StatusPass, "rbac-write-services": doctor.StatusPass}, statuses(report))
+
+	// The permissions of the service account are checked, not the ones of the user running the checks.
+	report = doctor.RBAC(context.Background(), reviewer("system:serviceaccount:argocd:argotails", allowed...), "argocd", types.NamespacedName{Namespace: "argocd", Name: "other"}, false)
+	assert.Equal(t, map[string]doctor.Status{"rbac-read-secrets": doctor.StatusFail, "rbac-write-secrets": doctor.StatusFail}, statuses(report))
+
+	// Every denied verb is reported.
+	readOnly := reviewer("system:serviceaccount:argocd:argotails", "argocd/secrets/get", "argocd/secrets/list", "argocd/secrets/watch", "argocd/secrets/create")
+	report = doctor.RBAC(context.Background(), readOnly, "argocd", sa, false)
+	assert.Equal(t, map[string]doctor.Status{"rbac-read-secrets": doctor.StatusPass, "rbac-write-secrets": doctor.StatusFail}, statuses(report))
+	assert.Equal(t, "system:serviceaccount:argocd:argotails cannot update, patch, delete secrets in argocd", report[1].Detail)
+
+	forbidden := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+			return apierrors.NewForbidden(authorizationv1.Resource("subjectaccessreviews"), obj.GetName(), errors.New("RBAC denied"))
+		},
+	}).Build()
+	report = doctor.RBAC(context.Background(), forbidden, "argocd", sa, false)
+	assert.Equal(t, 2, report.Failed())
+
+	var out bytes.Buffer
+	require.NoError(t, report.Print(&out))
+	assert.Contains(t, out.String(), "rbac-read-secrets   FAIL")
+}
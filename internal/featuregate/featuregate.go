@@ -0,0 +1,126 @@
+// Package featuregate enables or disables the experimental subsystems of Argotails, following the
+// Kubernetes feature gates conventions: every feature has a maturity stage and a default state,
+// overridden per environment with `--feature-gates=Name=true,...`.
+package featuregate
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Alpha features are experimental and disabled by default.
+	Alpha Stage = "Alpha"
+	// Beta features are well tested and usually enabled by default.
+	Beta Stage = "Beta"
+	// GA features are stable and cannot be disabled anymore.
+	GA Stage = "GA"
+)
+
+const (
+	// TsnetListener serves the webhook over Tailscale (--tsnet.enable).
+	TsnetListener Feature = "TsnetListener"
+	// ApprovalWorkflow creates the new ArgoCD cluster secrets pending approval (--cluster.require-approval).
+	ApprovalWorkflow Feature = "ApprovalWorkflow"
+	// PostureSync reports the ArgoCD cluster health as Tailscale device posture attributes (--argocd.server).
+	PostureSync Feature = "PostureSync"
+	// WebhookAutoProvision registers the webhook endpoint in the tailnet (--ts.webhook.autoprovision).
+	WebhookAutoProvision Feature = "WebhookAutoProvision"
+)
+
+type (
+	// Feature is the name of a feature gate.
+	Feature string
+
+	// Stage is the maturity of a feature.
+	Stage string
+
+	// Spec describes a feature gate.
+	Spec struct {
+		// Default is the state of the feature when not overridden.
+		Default bool
+		// Stage is the maturity of the feature.
+		Stage Stage
+	}
+
+	// Gates are the known feature gates and their state. It is safe for concurrent use.
+	Gates struct {
+		known map[Feature]Spec
+
+		mu      sync.RWMutex
+		enabled map[Feature]bool
+	}
+)
+
+// Default are the feature gates of Argotails.
+var Default = New(map[Feature]Spec{
+	TsnetListener:        {Default: false, Stage: Alpha},
+	ApprovalWorkflow:     {Default: false, Stage: Alpha},
+	PostureSync:          {Default: true, Stage: Beta},
+	WebhookAutoProvision: {Default: false, Stage: Alpha},
+})
+
+// New returns the given feature gates, in their default state.
+func New(known map[Feature]Spec) *Gates {
+	enabled := make(map[Feature]bool, len(known))
+	for feature, spec := range known {
+		enabled[feature] = spec.Default
+	}
+	return &Gates{known: known, enabled: enabled}
+}
+
+// Set overrides the state of the given features. Unknown features and attempts to disable GA
+// features are rejected, leaving the gates unchanged.
+func (g *Gates) Set(values map[string]bool) error {
+	for name, enabled := range values {
+		spec, exists := g.known[Feature(name)]
+		switch {
+		case !exists:
+			return fmt.Errorf("unknown feature gate %q (known feature gates: %s)", name, strings.Join(g.names(), ", "))
+		case spec.Stage == GA && !enabled:
+			return fmt.Errorf("feature gate %q is GA and cannot be disabled", name)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, enabled := range values {
+		g.enabled[Feature(name)] = enabled
+	}
+	return nil
+}
+
+// Enabled returns true if the given feature is enabled; unknown features are disabled.
+func (g *Gates) Enabled(feature Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.enabled[feature]
+}
+
+// Known returns the specification of every known feature gate.
+func (g *Gates) Known() map[Feature]Spec { return maps.Clone(g.known) }
+
+// String returns the state of every feature gate, as `Name=true,...` sorted by name.
+func (g *Gates) String() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	states := make([]string, 0, len(g.enabled))
+	for _, name := range g.names() {
+		states = append(states, name+"="+strconv.FormatBool(g.enabled[Feature(name)]))
+	}
+	return strings.Join(states, ",")
+}
+
+// names returns the sorted names of the known feature gates.
+func (g *Gates) names() []string {
+	names := make([]string, 0, len(g.known))
+	for feature := range g.known {
+		names = append(names, string(feature))
+	}
+	return slices.Sorted(slices.Values(names))
+}
@@ -0,0 +1,51 @@
+package featuregate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/featuregate"
+)
+
+func gates() *featuregate.Gates {
+	return featuregate.New(map[featuregate.Feature]featuregate.Spec{
+		"Experimental": {Default: false, Stage: featuregate.Alpha},
+		"Tested":       {Default: true, Stage: featuregate.Beta},
+		"Stable":       {Default: true, Stage: featuregate.GA},
+	})
+}
+
+func TestGates_Defaults(t *testing.T) {
+	g := gates()
+	assert.False(t, g.Enabled("Experimental"))
+	assert.True(t, g.Enabled("Tested"))
+	assert.True(t, g.Enabled("Stable"))
+	assert.False(t, g.Enabled("Unknown"))
+	assert.Equal(t, "Experimental=false,Stable=true,Tested=true", g.String())
+}
+
+func TestGates_Set(t *testing.T) {
+	g := gates()
+	require.NoError(t, g.Set(map[string]bool{"Experimental": true, "Tested": false, "Stable": true}))
+	assert.True(t, g.Enabled("Experimental"))
+	assert.False(t, g.Enabled("Tested"))
+	assert.Equal(t, "Experimental=true,Stable=true,Tested=false", g.String())
+}
+
+func TestGates_Set_Error(t *testing.T) {
+	g := gates()
+	assert.ErrorContains(t, g.Set(map[string]bool{"Experimental": true, "Unknown": true}), `unknown feature gate "Unknown"`)
+	assert.ErrorContains(t, g.Set(map[string]bool{"Stable": false}), "cannot be disabled")
+
+	// Invalid values leave the gates unchanged.
+	assert.False(t, g.Enabled("Experimental"))
+	assert.True(t, g.Enabled("Stable"))
+}
+
+func TestDefault(t *testing.T) {
+	for feature, spec := range featuregate.Default.Known() {
+		assert.Equal(t, spec.Stage == featuregate.Alpha, !spec.Default, feature)
+	}
+}
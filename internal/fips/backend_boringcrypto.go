@@ -0,0 +1,5 @@
+//go:build goexperiment.boringcrypto
+
+package fips
+
+const backend = "boringcrypto"
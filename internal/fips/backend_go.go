@@ -0,0 +1,5 @@
+//go:build !goexperiment.boringcrypto && !goexperiment.systemcrypto
+
+package fips
+
+const backend = "go"
@@ -0,0 +1,5 @@
+//go:build goexperiment.systemcrypto && !goexperiment.boringcrypto
+
+package fips
+
+const backend = "systemcrypto"
@@ -0,0 +1,31 @@
+// Package fips reports the cryptographic backend Argotails has been built with, and whether it
+// runs in FIPS 140 mode (e.g. for the webhook HMAC verification).
+//
+// FIPS 140 mode is enabled either with the native Go Cryptographic Module (built with
+// GOFIPS140=v1.0.0 or run with GODEBUG=fips140=on), or by building with a FIPS-validated backend
+// (GOEXPERIMENT=boringcrypto, or GOEXPERIMENT=systemcrypto with the Microsoft Go toolchain).
+package fips
+
+import (
+	"crypto/fips140"
+	"fmt"
+)
+
+// Backend returns the name of the cryptographic backend: "boringcrypto", "systemcrypto" or "go".
+func Backend() string { return backend }
+
+// Enabled returns true if the cryptographic operations use a FIPS 140 validated module.
+func Enabled() bool { return backend != "go" || fips140.Enabled() }
+
+// String describes the cryptographic backend and its FIPS 140 mode.
+func String() string {
+	switch {
+	case backend != "go":
+		return fmt.Sprintf("%s (FIPS 140 mode)", backend)
+	case fips140.Enforced():
+		return fmt.Sprintf("go (FIPS 140-3 mode enforced, module %s)", fips140.Version())
+	case fips140.Enabled():
+		return fmt.Sprintf("go (FIPS 140-3 mode, module %s)", fips140.Version())
+	}
+	return "go"
+}
@@ -0,0 +1,19 @@
+package fips_test
+
+import (
+	"crypto/fips140"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chezmoidotsh/argotails/internal/fips"
+)
+
+func TestString(t *testing.T) {
+	assert.True(t, strings.HasPrefix(fips.String(), fips.Backend()))
+	if fips.Backend() == "go" {
+		assert.Equal(t, fips140.Enabled(), fips.Enabled())
+		assert.Equal(t, fips140.Enabled(), strings.Contains(fips.String(), "FIPS 140-3 mode"))
+	}
+}
@@ -0,0 +1,178 @@
+// Package fleet loads the fleets configuration, grouping the Tailscale devices into named fleets
+// (e.g. edge, core or lab clusters) with their own namespace, secret and service settings.
+//
+// The configuration is a YAML document like:
+//
+//	fleets:
+//	  - name: edge
+//	    tags: ["edge", "edge-.*"]
+//	    namespace: argocd-edge
+//	    deletionPolicy: Retain
+//	    secret:
+//	      labels:
+//	        region: '{{ trimPrefix "tag:region-" (index .Tags 0) }}'
+//	      data:
+//	        config: '{"tlsClientConfig":{"insecure":false}}'
+//	    service:
+//	      proxyClass: edge
+//
+// Tags are regular expressions matched against the device tags (as --ts.device-filter), labels and
+// data are Go template expressions rendered against the device (as --cluster.extra-data).
+//
+// Tenants builds the fleets of a simple tag to namespace mapping, e.g. one ArgoCD namespace per
+// team in multi-tenant deployments.
+package fleet
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	"github.com/chezmoidotsh/argotails/internal/policy"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+type (
+	// Config is the fleets configuration.
+	Config struct {
+		Fleets []Spec `json:"fleets"`
+	}
+
+	// Spec is the configuration of a fleet.
+	Spec struct {
+		Name           string   `json:"name"`
+		Tags           []string `json:"tags"`
+		Namespace      string   `json:"namespace,omitempty"`
+		DeletionPolicy string   `json:"deletionPolicy,omitempty"`
+
+		Secret struct {
+			Labels map[string]string `json:"labels,omitempty"`
+			Data   map[string]string `json:"data,omitempty"`
+		} `json:"secret,omitempty"`
+
+		Service struct {
+			ProxyClass  string            `json:"proxyClass,omitempty"`
+			Annotations map[string]string `json:"annotations,omitempty"`
+		} `json:"service,omitempty"`
+	}
+)
+
+// Parse parses and validates the given fleets configuration, its templates being parsed with the
+// given options.
+func Parse(raw []byte, opts ...policy.Option) ([]reconciler.Fleet, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid fleets configuration: %w", err)
+	}
+
+	fleets := make([]reconciler.Fleet, 0, len(config.Fleets))
+	names := map[string]bool{}
+	for i, spec := range config.Fleets {
+		fleet, err := spec.build(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fleet #%d (%s): %w", i, spec.Name, err)
+		}
+		if names[fleet.Name] {
+			return nil, fmt.Errorf("invalid fleet #%d (%s): duplicated name", i, spec.Name)
+		}
+		names[fleet.Name] = true
+		fleets = append(fleets, fleet)
+	}
+	return fleets, nil
+}
+
+// build validates the fleet configuration and compiles its expressions.
+func (s Spec) build(opts []policy.Option) (reconciler.Fleet, error) {
+	if errs := validation.IsValidLabelValue(s.Name); s.Name == "" || len(errs) > 0 {
+		return reconciler.Fleet{}, fmt.Errorf("name must be a non-empty label value: %v", errs)
+	}
+	if len(s.Tags) == 0 {
+		return reconciler.Fleet{}, fmt.Errorf("at least one tag pattern is required")
+	}
+	if errs := validation.IsDNS1123Label(s.Namespace); s.Namespace != "" && len(errs) > 0 {
+		return reconciler.Fleet{}, fmt.Errorf("invalid namespace %q: %v", s.Namespace, errs)
+	}
+	switch s.DeletionPolicy {
+	case "":
+		s.DeletionPolicy = reconciler.DeletionPolicyDelete
+	case reconciler.DeletionPolicyDelete, reconciler.DeletionPolicyRetain:
+	default:
+		return reconciler.Fleet{}, fmt.Errorf("deletionPolicy must be '%s' or '%s'", reconciler.DeletionPolicyDelete, reconciler.DeletionPolicyRetain)
+	}
+	for _, key := range []string{argocd.KeyName, argocd.KeyServer, "value", "kubeconfig"} {
+		if _, exists := s.Secret.Data[key]; exists {
+			return reconciler.Fleet{}, fmt.Errorf("secret data cannot override the '%s' entry", key)
+		}
+	}
+
+	match, err := tsutils.NewRegexpTagFilter(s.Tags...)
+	if err != nil {
+		return reconciler.Fleet{}, err
+	}
+	labels, err := policy.NewTemplates(s.Secret.Labels, opts...)
+	if err != nil {
+		return reconciler.Fleet{}, err
+	}
+	data, err := policy.NewTemplates(s.Secret.Data, opts...)
+	if err != nil {
+		return reconciler.Fleet{}, err
+	}
+
+	return reconciler.Fleet{
+		Name:               s.Name,
+		Match:              match,
+		Namespace:          s.Namespace,
+		DeletionPolicy:     s.DeletionPolicy,
+		Labels:             labels,
+		ExtraData:          data,
+		ProxyClass:         s.Service.ProxyClass,
+		ServiceAnnotations: s.Service.Annotations,
+	}, nil
+}
+
+// Tenants returns one fleet per tag of the given tag to namespace mapping (e.g. "tag:team-a" to
+// "argocd-team-a"), named after the tag, so that the resources of the devices having the tag are
+// created in the namespace of their tenant. Fleets are ordered by name.
+func Tenants(namespaces map[string]string) ([]reconciler.Fleet, error) {
+	byName := make(map[string]string, len(namespaces))
+	for tag, namespace := range namespaces {
+		name := strings.TrimPrefix(tag, "tag:")
+		if _, exists := byName[name]; exists {
+			return nil, fmt.Errorf("invalid tenant %q: duplicated tag", tag)
+		}
+		if namespace == "" {
+			return nil, fmt.Errorf("invalid tenant %q: namespace is required", tag)
+		}
+		byName[name] = namespace
+	}
+
+	fleets := make([]reconciler.Fleet, 0, len(byName))
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		fleet, err := Spec{Name: name, Tags: []string{regexp.QuoteMeta(name)}, Namespace: byName[name]}.build(nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant %q: %w", "tag:"+name, err)
+		}
+		fleets = append(fleets, fleet)
+	}
+	return fleets, nil
+}
+
+// Namespaces returns the namespaces of the given fleets, in addition to the default one.
+func Namespaces(fleets []reconciler.Fleet, defaultNamespace string) []string {
+	namespaces := []string{defaultNamespace}
+	seen := map[string]bool{defaultNamespace: true}
+	for _, fleet := range fleets {
+		if fleet.Namespace != "" && !seen[fleet.Namespace] {
+			seen[fleet.Namespace] = true
+			namespaces = append(namespaces, fleet.Namespace)
+		}
+	}
+	return namespaces
+}
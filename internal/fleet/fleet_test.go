@@ -0,0 +1,91 @@
+package fleet_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/fleet"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+func TestParse(t *testing.T) {
+	fleets, err := fleet.Parse([]byte(`
+fleets:
+  - name: edge
+    tags: ["edge-.*"]
+    namespace: argocd-edge
+    deletionPolicy: Retain
+    secret:
+      labels:
+        os: '{{ .OS }}'
+      data:
+        config: '{"tlsClientConfig":{"insecure":true}}'
+    service:
+      proxyClass: edge
+  - name: core
+    tags: ["k8s"]
+`))
+	require.NoError(t, err)
+	require.Len(t, fleets, 2)
+
+	edge := tailscale.Device{Name: "A.fake.ts.net", OS: "linux", Tags: []string{"tag:k8s", "tag:edge-eu"}}
+	assert.Equal(t, "edge", reconciler.FleetOf(fleets, edge).Name)
+	assert.Equal(t, "argocd-edge", reconciler.FleetNamespace(fleets, edge, "argocd"))
+	assert.Equal(t, reconciler.DeletionPolicyRetain, fleets[0].DeletionPolicy)
+	labels, err := fleets[0].Labels.Render(edge)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"os": "linux"}, labels)
+
+	core := tailscale.Device{Name: "B.fake.ts.net", Tags: []string{"tag:k8s"}}
+	assert.Equal(t, "core", reconciler.FleetOf(fleets, core).Name)
+	assert.Equal(t, "argocd", reconciler.FleetNamespace(fleets, core, "argocd"))
+	assert.Equal(t, reconciler.DeletionPolicyDelete, fleets[1].DeletionPolicy)
+
+	assert.Nil(t, reconciler.FleetOf(fleets, tailscale.Device{Tags: []string{"tag:lab"}}))
+	assert.Equal(t, []string{"argocd", "argocd-edge"}, fleet.Namespaces(fleets, "argocd"))
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, raw := range map[string]string{
+		"unknown field":   `fleets: [{name: edge, tags: [edge], unknown: true}]`,
+		"missing name":    `fleets: [{tags: [edge]}]`,
+		"missing tags":    `fleets: [{name: edge}]`,
+		"duplicated name": `fleets: [{name: edge, tags: [edge]}, {name: edge, tags: [lab]}]`,
+		"deletion policy": `fleets: [{name: edge, tags: [edge], deletionPolicy: Orphan}]`,
+		"namespace":       `fleets: [{name: edge, tags: [edge], namespace: Invalid_NS}]`,
+		"server override": `fleets: [{name: edge, tags: [edge], secret: {data: {server: "https://x"}}}]`,
+		"invalid pattern": `fleets: [{name: edge, tags: ["("]}]`,
+	} {
+		_, err := fleet.Parse([]byte(raw))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	fleets, err := fleet.Tenants(map[string]string{"tag:team-b": "argocd-team-b", "team-a": "argocd-team-a"})
+	require.NoError(t, err)
+	require.Len(t, fleets, 2)
+	assert.Equal(t, "team-a", fleets[0].Name)
+
+	device := tailscale.Device{Name: "A.fake.ts.net", Tags: []string{"tag:k8s", "tag:team-b"}}
+	assert.Equal(t, "team-b", reconciler.FleetOf(fleets, device).Name)
+	assert.Equal(t, "argocd-team-b", reconciler.FleetNamespace(fleets, device, "argocd"))
+	assert.Equal(t, reconciler.DeletionPolicyDelete, fleets[1].DeletionPolicy)
+
+	// Tags are matched literally.
+	assert.Nil(t, reconciler.FleetOf(fleets, tailscale.Device{Tags: []string{"tag:team-a2"}}))
+	assert.Equal(t, []string{"argocd", "argocd-team-a", "argocd-team-b"}, fleet.Namespaces(fleets, "argocd"))
+
+	for name, namespaces := range map[string]map[string]string{
+		"missing namespace": {"tag:team-a": ""},
+		"namespace":         {"tag:team-a": "Invalid_NS"},
+		"name":              {"tag:": "argocd-team-a"},
+		"duplicated tag":    {"tag:team-a": "argocd-team-a", "team-a": "argocd-a"},
+	} {
+		_, err := fleet.Tenants(namespaces)
+		assert.Error(t, err, name)
+	}
+}
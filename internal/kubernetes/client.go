@@ -0,0 +1,82 @@
+package kubeutils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// retryingClient bounds the duration of every Kubernetes API call and retries the ones failing
+// with a transient error.
+type retryingClient struct {
+	client.Client
+
+	timeout time.Duration
+	backoff wait.Backoff
+}
+
+// NewRetryingClient wraps the given client so that Get, List, Create, Update, Patch and Delete calls
+// are bounded by the given timeout (0 to disable it) and retried up to the given number of times
+// when they fail with a transient error (timeout, throttling or server unavailability).
+func NewRetryingClient(c client.Client, timeout time.Duration, retries int) client.Client {
+	return &retryingClient{
+		Client:  c,
+		timeout: timeout,
+		backoff: wait.Backoff{Steps: retries + 1, Duration: 100 * time.Millisecond, Factor: 2, Jitter: 0.1},
+	}
+}
+
+// IsTransientError returns true if the error is a transient Kubernetes API error worth retrying.
+func IsTransientError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err)
+}
+
+// do calls fn with a bounded context, retrying it on transient errors as long as the parent
+// context is not done.
+func (c *retryingClient) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return retry.OnError(c.backoff,
+		func(err error) bool { return ctx.Err() == nil && IsTransientError(err) },
+		func() error {
+			if c.timeout <= 0 {
+				return fn(ctx)
+			}
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			return fn(ctx)
+		},
+	)
+}
+
+func (c *retryingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.Get(ctx, key, obj, opts...) })
+}
+
+func (c *retryingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.List(ctx, list, opts...) })
+}
+
+func (c *retryingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.Create(ctx, obj, opts...) })
+}
+
+func (c *retryingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.Update(ctx, obj, opts...) })
+}
+
+func (c *retryingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.Patch(ctx, obj, patch, opts...) })
+}
+
+func (c *retryingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.do(ctx, func(ctx context.Context) error { return c.Client.Delete(ctx, obj, opts...) })
+}
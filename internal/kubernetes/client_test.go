@@ -0,0 +1,80 @@
+package kubeutils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+func TestRetryingClient_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	ks := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			calls++
+			if calls < 3 {
+				return apierrors.NewServiceUnavailable("unavailable")
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+
+	c := kubeutils.NewRetryingClient(ks, time.Second, 3)
+	err := c.Create(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "argocd"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryingClient_BoundedRetries(t *testing.T) {
+	calls := 0
+	ks := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+			calls++
+			return apierrors.NewTooManyRequests("throttled", 0)
+		},
+	}).Build()
+
+	c := kubeutils.NewRetryingClient(ks, time.Second, 2)
+	err := c.Get(context.Background(), client.ObjectKey{Name: "secret", Namespace: "argocd"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsTooManyRequests(err))
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryingClient_NoRetryOnPermanentErrors(t *testing.T) {
+	calls := 0
+	ks := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+			calls++
+			return apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "secret")
+		},
+	}).Build()
+
+	c := kubeutils.NewRetryingClient(ks, time.Second, 3)
+	err := c.Get(context.Background(), client.ObjectKey{Name: "secret", Namespace: "argocd"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryingClient_Timeout(t *testing.T) {
+	ks := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, _ client.WithWatch, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}).Build()
+
+	c := kubeutils.NewRetryingClient(ks, 10*time.Millisecond, 0)
+	err := c.Get(context.Background(), client.ObjectKey{Name: "secret", Namespace: "argocd"}, &corev1.Secret{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,94 @@
+package kubeutils
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	eventsv1client "k8s.io/client-go/kubernetes/typed/events/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// Target references a Kubernetes cluster through a kubeconfig file and an optional context.
+type Target struct {
+	// Kubeconfig is the path to the kubeconfig file, or a list of paths separated like $KUBECONFIG
+	// (empty to use the default loading rules).
+	Kubeconfig string
+	// Context is the kubeconfig context to use (empty to use the current context).
+	Context string
+}
+
+// ParseTarget parses a target formatted as `KUBECONFIG[#CONTEXT]`.
+func ParseTarget(raw string) (Target, error) {
+	kubeconfig, context, _ := strings.Cut(raw, "#")
+	if kubeconfig == "" && context == "" {
+		return Target{}, fmt.Errorf("invalid target %q: expected KUBECONFIG[#CONTEXT]", raw)
+	}
+	return Target{Kubeconfig: kubeconfig, Context: context}, nil
+}
+
+// String returns the target formatted as `KUBECONFIG[#CONTEXT]`.
+func (t Target) String() string {
+	if t.Context == "" {
+		return t.Kubeconfig
+	}
+	return t.Kubeconfig + "#" + t.Context
+}
+
+// RESTConfig loads the REST configuration of the target. When neither the kubeconfig nor the context
+// are configured, the controller-runtime default behavior is used (in-cluster configuration,
+// $KUBECONFIG or ~/.kube/config).
+func (t Target) RESTConfig() (*rest.Config, error) {
+	if t.Kubeconfig == "" && t.Context == "" {
+		return config.GetConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if paths := filepath.SplitList(t.Kubeconfig); len(paths) > 1 {
+		// NOTE: a list of kubeconfig files is merged like $KUBECONFIG, the explicit path being a
+		//       single file.
+		rules.Precedence = paths
+	} else if t.Kubeconfig != "" {
+		rules.ExplicitPath = t.Kubeconfig
+	}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: t.Context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig for target %q: %w", t, err)
+	}
+	return cfg, nil
+}
+
+// Impersonate returns a copy of the REST configuration impersonating the given user and groups, or
+// the configuration itself when no user is given.
+func Impersonate(cfg *rest.Config, user string, groups []string) *rest.Config {
+	if user == "" {
+		return cfg
+	}
+	impersonated := rest.CopyConfig(cfg)
+	impersonated.Impersonate = rest.ImpersonationConfig{UserName: user, Groups: groups}
+	return impersonated
+}
+
+// NewEventRecorder returns a recorder of the Kubernetes events reported by the given controller,
+// sent with the given REST configuration (e.g. impersonating the identity of the other writes)
+// until the context is done.
+func NewEventRecorder(ctx context.Context, cfg *rest.Config, scheme *runtime.Scheme, name string) (events.EventRecorder, error) {
+	cl, err := eventsv1client.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes events client: %w", err)
+	}
+	broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: cl})
+	if err := broadcaster.StartRecordingToSinkWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start recording Kubernetes events: %w", err)
+	}
+	context.AfterFunc(ctx, broadcaster.Shutdown)
+	return broadcaster.NewRecorder(scheme, name), nil
+}
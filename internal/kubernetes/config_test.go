@@ -0,0 +1,86 @@
+package kubeutils_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected kubeutils.Target
+	}{
+		{name: "KubeconfigOnly", raw: "/etc/kubeconfig", expected: kubeutils.Target{Kubeconfig: "/etc/kubeconfig"}},
+		{name: "KubeconfigAndContext", raw: "/etc/kubeconfig#mgmt", expected: kubeutils.Target{Kubeconfig: "/etc/kubeconfig", Context: "mgmt"}},
+		{name: "ContextOnly", raw: "#mgmt", expected: kubeutils.Target{Context: "mgmt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := kubeutils.ParseTarget(tt.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, target)
+			assert.Equal(t, tt.raw, target.String())
+		})
+	}
+}
+
+func TestParseTarget_Error(t *testing.T) {
+	for _, raw := range []string{"", "#"} {
+		_, err := kubeutils.ParseTarget(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestTarget_RESTConfig_Error(t *testing.T) {
+	_, err := kubeutils.Target{Kubeconfig: "/non/existing/kubeconfig"}.RESTConfig()
+	assert.Error(t, err)
+}
+
+func TestTarget_RESTConfig_KubeconfigList(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name)
+		raw := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters: [{name: %[1]s, cluster: {server: "https://%[1]s.example.com"}}]
+contexts: [{name: %[1]s, context: {cluster: %[1]s, user: %[1]s}}]
+users: [{name: %[1]s, user: {token: %[1]s}}]
+current-context: %[1]s
+`, name)
+		require.NoError(t, os.WriteFile(path, []byte(raw), 0o600))
+		paths = append(paths, path)
+	}
+
+	// The files of a $KUBECONFIG-like list are merged, the first one setting the current context.
+	cfg, err := kubeutils.Target{Kubeconfig: strings.Join(paths, string(filepath.ListSeparator))}.RESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example.com", cfg.Host)
+
+	cfg, err = kubeutils.Target{Kubeconfig: strings.Join(paths, string(filepath.ListSeparator)), Context: "b"}.RESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example.com", cfg.Host)
+}
+
+func TestImpersonate(t *testing.T) {
+	cfg := &rest.Config{Host: "https://kubernetes.default.svc", QPS: 50}
+
+	assert.Same(t, cfg, kubeutils.Impersonate(cfg, "", nil))
+
+	impersonated := kubeutils.Impersonate(cfg, "system:serviceaccount:argocd:argotails-writer", []string{"argotails"})
+	assert.Equal(t, rest.ImpersonationConfig{UserName: "system:serviceaccount:argocd:argotails-writer", Groups: []string{"argotails"}}, impersonated.Impersonate)
+	assert.Equal(t, cfg.Host, impersonated.Host)
+	assert.Equal(t, cfg.QPS, impersonated.QPS)
+	assert.Empty(t, cfg.Impersonate.UserName)
+}
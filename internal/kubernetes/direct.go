@@ -0,0 +1,64 @@
+package kubeutils
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// directSecretClient reads the secrets from the Kubernetes API instead of the cache backing the
+// wrapped client, while only exposing the secrets matching the selector of this cache.
+type directSecretClient struct {
+	client.Client
+
+	reader   client.Reader
+	selector labels.Selector
+}
+
+// NewDirectSecretClient wraps the given cache-backed client so that the secrets are read with the
+// given reader (usually the manager API reader), making the reads strongly consistent. Like a
+// label-selected cache, the secrets not matching the given selector are reported as not found on
+// Get and omitted on List. Every other call is done by the wrapped client.
+func NewDirectSecretClient(c client.Client, reader client.Reader, selector labels.Selector) client.Client {
+	return &directSecretClient{Client: c, reader: reader, selector: selector}
+}
+
+func (c *directSecretClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	secret, isSecret := obj.(*corev1.Secret)
+	if !isSecret {
+		return c.Client.Get(ctx, key, obj, opts...)
+	}
+
+	if err := c.reader.Get(ctx, key, secret, opts...); err != nil {
+		return err
+	}
+	if !c.selector.Matches(labels.Set(secret.Labels)) {
+		*secret = corev1.Secret{}
+		return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	return nil
+}
+
+func (c *directSecretClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	secrets, isSecretList := list.(*corev1.SecretList)
+	if !isSecretList {
+		return c.Client.List(ctx, list, opts...)
+	}
+
+	// NOTE: the selector is applied after listing, as a label selector given as option would
+	// replace the one requested by the caller.
+	if err := c.reader.List(ctx, secrets, opts...); err != nil {
+		return err
+	}
+	items := secrets.Items[:0]
+	for _, secret := range secrets.Items {
+		if c.selector.Matches(labels.Set(secret.Labels)) {
+			items = append(items, secret)
+		}
+	}
+	secrets.Items = items
+	return nil
+}
@@ -0,0 +1,54 @@
+package kubeutils_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+func TestDirectSecretClient(t *testing.T) {
+	managed := map[string]string{"apps.kubernetes.io/managed-by": "argotails"}
+	api := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "managed", Namespace: "argocd", Labels: managed}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "argocd"}},
+	).Build()
+	// The cache has not observed any secret yet
+	cache := fake.NewClientBuilder().Build()
+
+	c := kubeutils.NewDirectSecretClient(cache, api, labels.SelectorFromSet(managed))
+
+	t.Run("Get", func(t *testing.T) {
+		var secret corev1.Secret
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: "managed", Namespace: "argocd"}, &secret))
+		assert.Equal(t, "managed", secret.Name)
+
+		err := c.Get(context.Background(), client.ObjectKey{Name: "foreign", Namespace: "argocd"}, &secret)
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var secrets corev1.SecretList
+		require.NoError(t, c.List(context.Background(), &secrets, client.InNamespace("argocd")))
+		require.Len(t, secrets.Items, 1)
+		assert.Equal(t, "managed", secrets.Items[0].Name)
+	})
+
+	t.Run("OtherResources", func(t *testing.T) {
+		err := c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "argocd"}})
+		require.NoError(t, err)
+
+		var configMap corev1.ConfigMap
+		assert.NoError(t, cache.Get(context.Background(), client.ObjectKey{Name: "config", Namespace: "argocd"}, &configMap))
+		assert.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: "config", Namespace: "argocd"}, &configMap))
+	})
+}
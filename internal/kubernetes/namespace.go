@@ -0,0 +1,66 @@
+package kubeutils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServiceAccountDir is the directory where the credentials of the pod service account are mounted.
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNamespaceNotFound is returned when the namespace of the pod cannot be detected.
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+// InClusterNamespace returns the namespace of the pod, detected from (in order) the namespace
+// file of the service account mounted in the given directory, the POD_NAMESPACE environment
+// variable (set through the downward API) or the namespace claim of the service account token,
+// which is the only source available when the pod only mounts a projected token.
+func InClusterNamespace(dir string) (string, error) {
+	if raw, err := os.ReadFile(filepath.Join(dir, "namespace")); err == nil {
+		if namespace := strings.TrimSpace(string(raw)); namespace != "" {
+			return namespace, nil
+		}
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+	if raw, err := os.ReadFile(filepath.Join(dir, "token")); err == nil {
+		if namespace := tokenNamespace(strings.TrimSpace(string(raw))); namespace != "" {
+			return namespace, nil
+		}
+	}
+	return "", ErrNamespaceNotFound
+}
+
+// tokenNamespace returns the namespace claim of the given service account token, either bound
+// ("kubernetes.io".namespace) or legacy ("kubernetes.io/serviceaccount/namespace").
+// NOTE: the token signature is not verified; the claim is only used to default the namespace, the
+// token itself being verified by the Kubernetes API server on every request.
+func tokenNamespace(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Kubernetes struct {
+			Namespace string `json:"namespace"`
+		} `json:"kubernetes.io"`
+		LegacyNamespace string `json:"kubernetes.io/serviceaccount/namespace"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	if claims.Kubernetes.Namespace != "" {
+		return claims.Kubernetes.Namespace
+	}
+	return claims.LegacyNamespace
+}
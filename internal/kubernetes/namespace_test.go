@@ -0,0 +1,47 @@
+package kubeutils_test
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+func TestInClusterNamespace(t *testing.T) {
+	token := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2lnbmF0dXJl"
+	}
+	tests := []struct {
+		name      string
+		files     map[string]string
+		env       string
+		namespace string
+		err       error
+	}{
+		{name: "NamespaceFile", files: map[string]string{"namespace": "argocd\n", "token": token(`{"kubernetes.io":{"namespace":"other"}}`)}, env: "other", namespace: "argocd"},
+		{name: "DownwardAPI", files: map[string]string{"token": token(`{"kubernetes.io":{"namespace":"other"}}`)}, env: "argocd", namespace: "argocd"},
+		{name: "BoundToken", files: map[string]string{"token": token(`{"kubernetes.io":{"namespace":"argocd"}}`)}, namespace: "argocd"},
+		{name: "LegacyToken", files: map[string]string{"token": token(`{"kubernetes.io/serviceaccount/namespace":"argocd"}`)}, namespace: "argocd"},
+		{name: "InvalidToken", files: map[string]string{"token": "not-a-jwt"}, err: kubeutils.ErrNamespaceNotFound},
+		{name: "NothingMounted", err: kubeutils.ErrNamespaceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+			}
+			t.Setenv("POD_NAMESPACE", tt.env)
+
+			namespace, err := kubeutils.InClusterNamespace(dir)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.namespace, namespace)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package kubeutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// SecretCertificate serves the TLS certificate of a Kubernetes TLS secret (e.g. issued by
+// cert-manager), reloaded when the secret is rotated.
+type SecretCertificate struct {
+	reader client.Reader
+	secret types.NamespacedName
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	raw  []byte
+}
+
+// NewSecretCertificate returns the certificate of the given TLS secret, read with the given reader
+// (usually the manager API reader, as the secret is not managed by the controller). The
+// certificate must be loaded with Load before being served.
+func NewSecretCertificate(reader client.Reader, secret types.NamespacedName) *SecretCertificate {
+	return &SecretCertificate{reader: reader, secret: secret}
+}
+
+// Load reads the certificate and the private key of the secret, and returns true if they changed
+// since the last load. The current certificate is kept when the secret is invalid.
+func (c *SecretCertificate) Load(ctx context.Context) (bool, error) {
+	var secret corev1.Secret
+	if err := c.reader.Get(ctx, c.secret, &secret); err != nil {
+		return false, fmt.Errorf("failed to read TLS secret %q: %w", c.secret, err)
+	}
+	certPEM, keyPEM := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	raw := append(append([]byte{}, certPEM...), keyPEM...)
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && bytes.Equal(c.raw, raw)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("invalid TLS secret %q: %w", c.secret, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert, c.raw = &cert, raw
+	return true, nil
+}
+
+// Watch reloads the certificate every interval until the context is done. Reload failures are
+// logged, the last valid certificate being served in the meantime.
+func (c *SecretCertificate) Watch(ctx context.Context, interval time.Duration) {
+	log := ctrllog.FromContext(ctx).WithValues("secret", c.secret)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.Load(ctx)
+			if err != nil {
+				log.Error(err, "Failed to reload the TLS certificate, the current one is still served")
+			} else if reloaded {
+				log.V(0).Info("TLS certificate rotated, new certificate loaded")
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate; it is meant to be used as
+// tls.Config.GetCertificate.
+func (c *SecretCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cert == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return c.cert, nil
+}
@@ -0,0 +1,89 @@
+package kubeutils_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+)
+
+// selfSigned returns a PEM self-signed certificate of the given common name and its private key.
+func selfSigned(t *testing.T, cn string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+}
+
+func TestSecretCertificate(t *testing.T) {
+	nn := types.NamespacedName{Name: "argotails-webhook-tls", Namespace: "argocd"}
+	certPEM, keyPEM := selfSigned(t, "first")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: nn.Name, Namespace: nn.Namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+	}
+	ks := fake.NewClientBuilder().WithObjects(secret).Build()
+	cert := kubeutils.NewSecretCertificate(ks, nn)
+	commonName := func() string {
+		t.Helper()
+		served, err := cert.GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(served.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+
+	_, err := cert.GetCertificate(nil)
+	require.Error(t, err, "no certificate before the first load")
+
+	reloaded, err := cert.Load(context.Background())
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "first", commonName())
+
+	reloaded, err = cert.Load(context.Background())
+	require.NoError(t, err)
+	assert.False(t, reloaded, "unchanged secret")
+
+	// Rotated certificates are served once reloaded.
+	secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey] = selfSigned(t, "second")
+	require.NoError(t, ks.Update(context.Background(), secret))
+	reloaded, err = cert.Load(context.Background())
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "second", commonName())
+
+	// Invalid secrets keep the current certificate.
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	require.NoError(t, ks.Update(context.Background(), secret))
+	_, err = cert.Load(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "second", commonName())
+}
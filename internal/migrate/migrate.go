@@ -0,0 +1,121 @@
+// Package migrate matches existing ArgoCD cluster secrets (created manually or by other tools) to
+// Tailscale devices, in order to safely migrate an existing fleet to Argotails.
+package migrate
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+// Action is the action to apply to an existing ArgoCD cluster secret.
+type Action string
+
+const (
+	// ActionAdopt is applied to secrets already named as Argotails would; they are taken over as is.
+	ActionAdopt Action = "adopt"
+	// ActionReplace is applied to secrets named differently from their device; a new secret, managed
+	// by Argotails, is created and the existing one becomes redundant.
+	ActionReplace Action = "replace"
+	// ActionIgnore is applied to secrets without matching device (or matching several devices).
+	ActionIgnore Action = "ignore"
+)
+
+// Candidate is an existing ArgoCD cluster secret with the Tailscale device it has been matched to.
+type Candidate struct {
+	// Secret is the existing ArgoCD cluster secret.
+	Secret corev1.Secret
+	// Server is the ArgoCD cluster server URL.
+	Server string
+	// Device is the matching Tailscale device, if any.
+	Device *tailscale.Device
+	// Action is the action to apply to the secret.
+	Action Action
+	// Reason explains how the secret has been matched (or why it has not).
+	Reason string
+}
+
+// Match matches every ArgoCD cluster secret to a Tailscale device based on the host of its server
+// URL, which can be the device MagicDNS name, one of its Tailscale addresses or its hostname. The
+// secretName function returns the name Argotails gives to the secret of a device. Results are sorted
+// by secret name.
+func Match(secrets []corev1.Secret, devices []tailscale.Device, secretName func(tailscale.Device) string) []Candidate {
+	candidates := make([]Candidate, 0, len(secrets))
+	for _, secret := range secrets {
+		candidate := Candidate{Secret: secret, Server: server(secret), Action: ActionIgnore}
+
+		u, err := url.Parse(candidate.Server)
+		if err != nil || u.Hostname() == "" {
+			candidate.Reason = "invalid server URL"
+			candidates = append(candidates, candidate)
+			continue
+		}
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+
+		matches, reason := matchHost(host, devices)
+		switch len(matches) {
+		case 0:
+			candidate.Reason = "no matching device"
+		case 1:
+			candidate.Device, candidate.Reason = &matches[0], reason
+			candidate.Action = ActionReplace
+			if secret.Name == secretName(*candidate.Device) {
+				candidate.Action = ActionAdopt
+			}
+		default:
+			candidate.Reason = "ambiguous " + reason
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Secret.Name < candidates[j].Secret.Name })
+	return candidates
+}
+
+// matchHost returns the devices matching the given host, trying the most specific criteria first.
+func matchHost(host string, devices []tailscale.Device) ([]tailscale.Device, string) {
+	criteria := []struct {
+		reason string
+		match  func(tailscale.Device) bool
+	}{
+		{"MagicDNS name", func(d tailscale.Device) bool { return strings.EqualFold(d.Name, host) }},
+		{"Tailscale address", func(d tailscale.Device) bool {
+			for _, address := range d.Addresses {
+				if address == host {
+					return true
+				}
+			}
+			return false
+		}},
+		{"hostname", func(d tailscale.Device) bool {
+			label, _, _ := strings.Cut(host, ".")
+			return strings.EqualFold(d.Hostname, label)
+		}},
+	}
+
+	for _, criterion := range criteria {
+		var matches []tailscale.Device
+		for _, device := range devices {
+			if criterion.match(device) {
+				matches = append(matches, device)
+			}
+		}
+		if len(matches) > 0 {
+			return matches, criterion.reason
+		}
+	}
+	return nil, ""
+}
+
+// server returns the ArgoCD cluster server URL stored in the given secret.
+func server(secret corev1.Secret) string {
+	if raw, exists := secret.Data[argocd.KeyServer]; exists {
+		return string(raw)
+	}
+	return secret.StringData[argocd.KeyServer]
+}
@@ -0,0 +1,59 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/migrate"
+)
+
+func TestMatch(t *testing.T) {
+	secret := func(name, server string) corev1.Secret {
+		return corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Data:       map[string][]byte{"server": []byte(server)},
+		}
+	}
+	devices := []tailscale.Device{
+		{Name: "a.fake.ts.net", Hostname: "a", Addresses: []string{"100.64.0.1"}},
+		{Name: "b.fake.ts.net", Hostname: "b", Addresses: []string{"100.64.0.2"}},
+		{Name: "b-1.fake.ts.net", Hostname: "b"},
+	}
+
+	candidates := migrate.Match([]corev1.Secret{
+		secret("a.fake.ts.net", "https://a.fake.ts.net"),
+		secret("by-address", "https://100.64.0.2:6443"),
+		secret("by-hostname", "https://A.corp.example"),
+		secret("c-ambiguous", "https://b.corp.example"),
+		secret("d-unknown", "https://unknown.example"),
+		secret("e-invalid", "::"),
+	}, devices, func(d tailscale.Device) string { return d.Name })
+
+	require.Len(t, candidates, 6)
+	expected := []struct {
+		action migrate.Action
+		device string
+		reason string
+	}{
+		{migrate.ActionAdopt, "a.fake.ts.net", "MagicDNS name"},
+		{migrate.ActionReplace, "b.fake.ts.net", "Tailscale address"},
+		{migrate.ActionReplace, "a.fake.ts.net", "hostname"},
+		{migrate.ActionIgnore, "", "ambiguous hostname"},
+		{migrate.ActionIgnore, "", "no matching device"},
+		{migrate.ActionIgnore, "", "invalid server URL"},
+	}
+	for i, candidate := range candidates {
+		assert.Equal(t, expected[i].action, candidate.Action, candidate.Secret.Name)
+		assert.Equal(t, expected[i].reason, candidate.Reason, candidate.Secret.Name)
+		if expected[i].device == "" {
+			assert.Nil(t, candidate.Device, candidate.Secret.Name)
+		} else if assert.NotNil(t, candidate.Device, candidate.Secret.Name) {
+			assert.Equal(t, expected[i].device, candidate.Device.Name, candidate.Secret.Name)
+		}
+	}
+}
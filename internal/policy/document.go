@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+type (
+	// Documents are named templates rendering a JSON document from a device, e.g. to expose
+	// structured values to ApplicationSet generators through the secret data.
+	Documents map[string]*template.Template
+
+	// DocumentData is the data of the document templates.
+	DocumentData struct {
+		// Device is the Tailscale device.
+		Device tailscale.Device
+	}
+)
+
+// DocumentFuncs are the sprig-like functions available inside document templates, in addition to
+// Funcs and the Go template builtin functions.
+var DocumentFuncs = template.FuncMap{
+	"toJson": func(v any) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+	"toPrettyJson": func(v any) (string, error) {
+		raw, err := json.MarshalIndent(v, "", "  ")
+		return string(raw), err
+	},
+	"dict": func(pairs ...any) (map[string]any, error) {
+		if len(pairs)%2 != 0 {
+			return nil, fmt.Errorf("dict requires an even number of arguments, got %d", len(pairs))
+		}
+		dict := make(map[string]any, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			key, ok := pairs[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+			}
+			dict[key] = pairs[i+1]
+		}
+		return dict, nil
+	},
+	"list": func(items ...any) []any { return items },
+	"join": func(sep string, items []string) string { return strings.Join(items, sep) },
+	"split": func(sep, s string) []string {
+		if s == "" {
+			return []string{}
+		}
+		return strings.Split(s, sep)
+	},
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"quote":     func(s string) string { return fmt.Sprintf("%q", s) },
+	"sortAlpha": func(items []string) []string { return slices.Sorted(slices.Values(items)) },
+	"trimTags": func(tags []string) []string {
+		trimmed := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			trimmed = append(trimmed, strings.TrimPrefix(tag, "tag:"))
+		}
+		return trimmed
+	},
+}
+
+// NewDocuments parses the given named document templates.
+func NewDocuments(exprs map[string]string, opts ...Option) (Documents, error) {
+	funcs := newOptions(opts).funcs()
+	documents := make(Documents, len(exprs))
+	for key, expr := range exprs {
+		tmpl, err := template.New(key).Option("missingkey=error").Funcs(Funcs).Funcs(funcs).Funcs(DocumentFuncs).Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document template %q: %w", key, err)
+		}
+		documents[key] = tmpl
+	}
+	return documents, nil
+}
+
+// Render renders all documents against the given device, as compact JSON. A document that is not
+// valid JSON is reported as an error.
+func (d Documents) Render(device tailscale.Device) (map[string]string, error) {
+	values := make(map[string]string, len(d))
+	for _, key := range slices.Sorted(maps.Keys(d)) {
+		var buf bytes.Buffer
+		if err := d[key].Execute(&buf, DocumentData{Device: device}); err != nil {
+			return nil, fmt.Errorf("failed to render document %q: %w", key, err)
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("document %q is not valid JSON: %w", key, err)
+		}
+		values[key] = compact.String()
+	}
+	return values, nil
+}
@@ -0,0 +1,51 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/policy"
+)
+
+func TestDocuments_Render(t *testing.T) {
+	device := tailscale.Device{Name: "prod-1.example.ts.net", Hostname: "prod-1", OS: "linux", Tags: []string{"tag:k8s", "tag:region-eu"}}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		err      bool
+	}{
+		{name: "Tags", expr: `{{ toJson .Device.Tags }}`, expected: `["tag:k8s","tag:region-eu"]`},
+		{name: "TrimmedTags", expr: `{{ .Device.Tags | trimTags | sortAlpha | toJson }}`, expected: `["k8s","region-eu"]`},
+		{name: "Dict", expr: `{{ dict "hostname" .Device.Hostname "os" (upper .Device.OS) "prod" (hasTag .Device "prod") | toJson }}`, expected: `{"hostname":"prod-1","os":"LINUX","prod":false}`},
+		{name: "Pretty", expr: `{{ toPrettyJson (list .Device.OS) }}`, expected: `["linux"]`},
+		{name: "Literal", expr: `{"name": {{ quote .Device.Hostname }}, "zone": {{ default "none" "" | quote }}}`, expected: `{"name":"prod-1","zone":"none"}`},
+		{name: "InvalidJSON", expr: `{{ .Device.Hostname }}`, err: true},
+		{name: "UnknownField", expr: `{{ toJson .Device.Unknown }}`, err: true},
+		{name: "InvalidDict", expr: `{{ toJson (dict "key") }}`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents, err := policy.NewDocuments(map[string]string{"metadata": tt.expr})
+			require.NoError(t, err)
+
+			values, err := documents.Render(device)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"metadata": tt.expected}, values)
+		})
+	}
+}
+
+func TestNewDocuments_Error(t *testing.T) {
+	_, err := policy.NewDocuments(map[string]string{"metadata": "{{ toJson .Device.Tags "})
+	assert.Error(t, err)
+}
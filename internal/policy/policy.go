@@ -0,0 +1,206 @@
+// Package policy evaluates operator-supplied expressions against Tailscale devices.
+//
+// The device policy, deciding which devices must be registered as ArgoCD clusters and computing
+// additional labels, is made of CEL expressions (https://cel.dev) evaluated with the device fields
+// as `device` and the evaluation time as `now`, e.g.
+// `hasTag(device, "k8s") && device.os == "linux"`.
+//
+// The other expressions (secret data, fleet settings, server names, ...) are Go templates
+// (https://pkg.go.dev/text/template) evaluated with the Tailscale device as data.
+package policy
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/ext"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"tailscale.com/client/tailscale/v2"
+)
+
+type (
+	// Policy decides whether a device must be registered and computes its additional labels.
+	Policy struct {
+		admit  cel.Program
+		labels map[string]cel.Program
+		fields func(tailscale.Device) map[string]any
+
+		// OnError is called when the policy cannot be evaluated against a device (optional).
+		OnError func(device tailscale.Device, err error)
+	}
+)
+
+// rxLabelValue is the syntax of the label values; their length is not checked as the over-long
+// values are truncated when written.
+var rxLabelValue = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`)
+
+// deviceType is the CEL type of the `device` variable: the device fields keyed by their Tailscale
+// API name (e.g. `clientVersion`).
+var deviceType = cel.MapType(cel.StringType, cel.DynType)
+
+// env is the CEL environment of the policy expressions.
+var env = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("device", deviceType),
+		cel.Variable("now", cel.TimestampType),
+		ext.Strings(),
+		cel.Function("hasTag",
+			cel.Overload("hasTag_device_string", []*cel.Type{deviceType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(hasTag),
+			),
+		),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("invalid CEL environment: %v", err))
+	}
+	return env
+}()
+
+// hasTag returns true if the device has the given tag, with or without its "tag:" prefix.
+func hasTag(device, tag ref.Val) ref.Val {
+	fields, ok := device.(traits.Mapper)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(device)
+	}
+	tags, found := fields.Find(types.String("tags"))
+	if !found {
+		return types.False
+	}
+	list, ok := tags.(traits.Lister)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(tags)
+	}
+	want := fmt.Sprint(tag.Value())
+	for it := list.Iterator(); it.HasNext() == types.True; {
+		if got := fmt.Sprint(it.Next().Value()); got == want || got == "tag:"+want {
+			return types.True
+		}
+	}
+	return types.False
+}
+
+// compile compiles a CEL expression, which must evaluate to the given type.
+func compile(name, expr string, output *cel.Type) (cel.Program, error) {
+	ast, issues := env.Compile(expr)
+	if err := issues.Err(); err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", name, err)
+	}
+	if !ast.OutputType().IsExactType(output) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("invalid expression %q: must evaluate to %s, got %s", name, output, ast.OutputType())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", name, err)
+	}
+	return prg, nil
+}
+
+// eval evaluates a compiled CEL expression against the given device.
+func (p *Policy) eval(prg cel.Program, device tailscale.Device) (any, error) {
+	out, _, err := prg.Eval(map[string]any{
+		"device": p.fields(device),
+		"now":    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Value(), nil
+}
+
+// NewPolicy creates a new policy based on the given admission expression (which must evaluate to
+// a boolean; empty to admit all devices) and label expressions (which must evaluate to strings).
+func NewPolicy(admit string, labels map[string]string, opts ...Option) (*Policy, error) {
+	p := &Policy{labels: make(map[string]cel.Program, len(labels)), fields: newOptions(opts).fields}
+
+	if admit != "" {
+		prg, err := compile("admit", admit, cel.BoolType)
+		if err != nil {
+			return nil, err
+		}
+		p.admit = prg
+	}
+
+	for key, expr := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, ", "))
+		}
+		prg, err := compile(key, expr, cel.StringType)
+		if err != nil {
+			return nil, err
+		}
+		p.labels[key] = prg
+	}
+	return p, nil
+}
+
+// Admit returns true if the device must be registered as ArgoCD cluster, or an error if the policy
+// cannot be evaluated against the device (e.g. a field it does not have).
+func (p *Policy) Admit(device tailscale.Device) (bool, error) {
+	if p == nil || p.admit == nil {
+		return true, nil
+	}
+
+	out, err := p.eval(p.admit, device)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate admission policy: %w", err)
+	}
+	admitted, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("admission policy must evaluate to a boolean, got %T", out)
+	}
+	return admitted, nil
+}
+
+// Evaluate returns true if the device is admitted by the policy, reporting the devices for which
+// the policy cannot be evaluated. It allows the policy to be used as a tsutils.FallibleTagFilter:
+// such devices are neither registered nor deleted.
+func (p *Policy) Evaluate(device tailscale.Device) (bool, error) {
+	admitted, err := p.Admit(device)
+	if err != nil && p.OnError != nil {
+		p.OnError(device, err)
+	}
+	return admitted, err
+}
+
+// Match returns true if the device is admitted by the policy; devices for which the policy cannot
+// be evaluated are not admitted (see Evaluate to tell them apart).
+func (p *Policy) Match(device tailscale.Device) bool {
+	admitted, _ := p.Evaluate(device)
+	return admitted
+}
+
+// Labels returns the labels computed by the policy for the given device. Labels evaluated to an
+// empty string are omitted; the others must have the syntax of label values.
+func (p *Policy) Labels(device tailscale.Device) (map[string]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(p.labels))
+	for _, key := range slices.Sorted(maps.Keys(p.labels)) {
+		out, err := p.eval(p.labels[key], device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute label %q: %w", key, err)
+		}
+		value, ok := out.(string)
+		if !ok {
+			return nil, fmt.Errorf("label %q must evaluate to a string, got %T", key, out)
+		}
+		if value == "" {
+			continue
+		}
+		if !rxLabelValue.MatchString(value) {
+			return nil, fmt.Errorf("invalid value %q of label %q: must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character", value, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
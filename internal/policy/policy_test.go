@@ -0,0 +1,142 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/policy"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestNewPolicy_Error(t *testing.T) {
+	_, err := policy.NewPolicy(`device.os ==`, nil)
+	assert.Error(t, err)
+
+	_, err = policy.NewPolicy(`size(device.tags)`, nil)
+	assert.Error(t, err, "the admission expression must evaluate to a boolean")
+
+	_, err = policy.NewPolicy("", map[string]string{"region": `device.os ==`})
+	assert.Error(t, err)
+
+	_, err = policy.NewPolicy("", map[string]string{"region": `size(device.tags)`})
+	assert.Error(t, err, "the label expressions must evaluate to strings")
+
+	_, err = policy.NewPolicy("", map[string]string{"invalid key!": `device.os`})
+	assert.Error(t, err)
+}
+
+func TestPolicy_Admit(t *testing.T) {
+	device := tailscale.Device{Hostname: "prod-1", OS: "linux", Tags: []string{"tag:k8s", "tag:prod"}}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected bool
+		err      bool
+	}{
+		{name: "EmptyPolicy", expr: "", expected: true},
+		{name: "HasTag", expr: `hasTag(device, "tag:k8s")`, expected: true},
+		{name: "HasTagWithoutPrefix", expr: `hasTag(device, "prod")`, expected: true},
+		{name: "MissingTag", expr: `hasTag(device, "tag:dev")`, expected: false},
+		{name: "Combined", expr: `hasTag(device, "k8s") && device.os == "linux" && device.hostname.startsWith("prod-")`, expected: true},
+		{name: "Regexp", expr: `device.hostname.matches("^dev-")`, expected: false},
+		{name: "Dyn", expr: `device.os`, err: true},
+		{name: "UnknownField", expr: `device.unknown == "value"`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := policy.NewPolicy(tt.expr, nil)
+			require.NoError(t, err)
+
+			admitted, err := p.Admit(device)
+			if tt.err {
+				assert.Error(t, err)
+				assert.False(t, p.Match(device))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, admitted)
+			assert.Equal(t, tt.expected, p.Match(device))
+		})
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	p, err := policy.NewPolicy(`device.unknown == "value"`, nil)
+	require.NoError(t, err)
+
+	var reported []string
+	p.OnError = func(device tailscale.Device, _ error) { reported = append(reported, device.Name) }
+
+	// Devices the policy cannot be evaluated against are reported, and not only rejected
+	admitted, err := tsutils.EvaluateFilter(p, tailscale.Device{Name: "device1"})
+	assert.Error(t, err)
+	assert.False(t, admitted)
+	assert.Equal(t, []string{"device1"}, reported)
+}
+
+func TestPolicy_Labels(t *testing.T) {
+	p, err := policy.NewPolicy("", map[string]string{
+		"region": `device.tags.exists(t, t.startsWith("tag:region-")) ? device.tags.filter(t, t.startsWith("tag:region-"))[0].substring(11) : ""`,
+		"os":     `device.os`,
+		"empty":  `""`,
+	})
+	require.NoError(t, err)
+
+	labels, err := p.Labels(tailscale.Device{OS: "linux", Tags: []string{"tag:k8s", "tag:region-eu"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "os": "linux"}, labels)
+}
+
+func TestPolicy_Labels_InvalidValue(t *testing.T) {
+	p, err := policy.NewPolicy("", map[string]string{"owner": `device.user`})
+	require.NoError(t, err)
+
+	// The computed values must be valid label values
+	_, err = p.Labels(tailscale.Device{User: "john@example.com"})
+	assert.Error(t, err)
+
+	labels, err := p.Labels(tailscale.Device{User: "john"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "john"}, labels)
+}
+
+func TestTemplates_Render(t *testing.T) {
+	templates, err := policy.NewTemplates(map[string]string{
+		"region": `{{ lower .Hostname }}`,
+		"fleet":  `{{ if hasTag . "edge" }}edge{{ end }}`,
+	})
+	require.NoError(t, err)
+
+	values, err := templates.Render(tailscale.Device{Hostname: "EU-1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"region": "eu-1"}, values)
+
+	_, err = policy.NewTemplates(map[string]string{"region": "{{ .OS "})
+	assert.Error(t, err)
+}
+
+func TestWithFields(t *testing.T) {
+	fields := policy.WithFields(func(device tailscale.Device) map[string]any {
+		return map[string]any{"os": device.OS, "newField": "value"}
+	})
+
+	p, err := policy.NewPolicy(`device.newField == "value"`, nil, fields)
+	require.NoError(t, err)
+	assert.True(t, p.Match(tailscale.Device{OS: "linux"}))
+
+	templates, err := policy.NewTemplates(map[string]string{"new": `{{ index (fields .) "newField" }}`}, fields)
+	require.NoError(t, err)
+	values, err := templates.Render(tailscale.Device{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"new": "value"}, values)
+
+	// Without option, only the known fields are exposed
+	p, err = policy.NewPolicy(`device.newField == "value"`, nil)
+	require.NoError(t, err)
+	assert.False(t, p.Match(tailscale.Device{OS: "linux"}))
+}
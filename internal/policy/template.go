@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+type (
+	// Templates are named Go template expressions rendered against a device.
+	Templates map[string]*template.Template
+
+	// Option configures the evaluation of the expressions.
+	Option func(*options)
+
+	options struct {
+		// fields returns the fields of a device, as exposed by the `fields` template function and
+		// to the policy expressions.
+		fields func(tailscale.Device) map[string]any
+	}
+)
+
+// WithFields exposes the fields of the devices returned by the given function (e.g. the fields
+// returned by the Tailscale API, see tsutils.DeviceFieldsOf) instead of only the fields known to
+// the Tailscale client library.
+func WithFields(fields func(tailscale.Device) map[string]any) Option {
+	return func(o *options) { o.fields = fields }
+}
+
+// newOptions returns the given options applied to the defaults.
+func newOptions(opts []Option) options {
+	o := options{fields: tsutils.DeviceFields}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// funcs returns the functions of the template expressions depending on the options.
+func (o options) funcs() template.FuncMap {
+	return template.FuncMap{"fields": o.fields}
+}
+
+// Funcs are the functions available inside template expressions, in addition to the Go template
+// builtin functions.
+var Funcs = template.FuncMap{
+	"hasTag": func(device tailscale.Device, tag string) bool {
+		return slices.Contains(device.Tags, tag) || slices.Contains(device.Tags, "tag:"+tag)
+	},
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"matches": func(pattern, s string) (bool, error) {
+		return regexp.MatchString(pattern, s)
+	},
+	"since": func(t *tailscale.Time) time.Duration {
+		if t == nil || t.IsZero() {
+			return 0
+		}
+		return time.Since(t.Time)
+	},
+	"duration": time.ParseDuration,
+	// fields exposes every field returned by the Tailscale API for the device, including the ones
+	// not yet supported by the Tailscale client library (see WithFields), e.g.
+	// `{{ index (fields .) "newField" }}`.
+	"fields": tsutils.DeviceFields,
+}
+
+// Parse parses a template expression with the functions available to templates.
+func Parse(name, expr string, opts ...Option) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(Funcs).Funcs(newOptions(opts).funcs()).Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Execute evaluates the template expression against the given device and returns its trimmed output.
+func Execute(tmpl *template.Template, device tailscale.Device) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, device); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// NewTemplates parses the given named template expressions.
+func NewTemplates(exprs map[string]string, opts ...Option) (Templates, error) {
+	templates := make(Templates, len(exprs))
+	for key, expr := range exprs {
+		tmpl, err := Parse(key, expr, opts...)
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = tmpl
+	}
+	return templates, nil
+}
+
+// Render renders all expressions against the given device. Expressions rendered to an empty string
+// are omitted.
+func (t Templates) Render(device tailscale.Device) (map[string]string, error) {
+	values := make(map[string]string, len(t))
+	for _, key := range slices.Sorted(maps.Keys(t)) {
+		value, err := Execute(t[key], device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate %q: %w", key, err)
+		}
+		if value != "" {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,277 @@
+// Package rbac generates the minimal Kubernetes RBAC resources required by Argotails for a given
+// configuration.
+package rbac
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/chezmoidotsh/argotails/internal/apis/v1alpha1"
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+)
+
+// Options describes the features that require additional permissions.
+type Options struct {
+	// Name is the name of the Role, RoleBinding and ServiceAccount.
+	Name string
+	// Namespace is the namespace where ArgoCD cluster secrets are managed.
+	Namespace string
+	// WebhookOnly is true when Argotails runs with --mode=webhook-only, without watching the
+	// resources it manages.
+	WebhookOnly bool
+	// CreateService is true when Kubernetes services are created for the Tailscale devices.
+	CreateService bool
+	// ClusterResource is true when TailscaleCluster resources are maintained for the Tailscale devices.
+	ClusterResource bool
+	// Application is true when an ArgoCD Application is created for each registered cluster.
+	Application bool
+	// ReportConfigMap is the name of the ConfigMap receiving the synchronization report, if any.
+	ReportConfigMap string
+	// CheckpointConfigMap is the name of the ConfigMap receiving the synchronization checkpoints, if
+	// any.
+	CheckpointConfigMap string
+	// RolloutConfigMap is the name of the ConfigMap receiving the rollout state, if any.
+	RolloutConfigMap string
+	// PauseConfigMap is the name of the ConfigMap watched to pause the mutations, if any.
+	PauseConfigMap string
+	// DNSConfigMap is the name, as "[namespace/]name", of the ConfigMap receiving the hosts file of
+	// the devices, if any.
+	DNSConfigMap string
+	// Namespaces are the additional namespaces where ArgoCD cluster secrets are managed (e.g. the
+	// fleet, tenant and cleanup namespaces); "*" stands for every namespace, which requires a
+	// ClusterRole.
+	Namespaces []string
+	// RegistrationBackends are the registration backends (see reconciler.RegistrationBackends) the
+	// devices are registered with, whose objects are cluster-scoped.
+	RegistrationBackends []string
+	// ImpersonateUser is the user impersonated by Argotails for its writes (--kube.as), if any; the
+	// permissions required by the writes must then be granted to this user.
+	ImpersonateUser string
+	// ImpersonateGroups are the groups impersonated along with ImpersonateUser.
+	ImpersonateGroups []string
+}
+
+// serviceAccountPrefix is the prefix of the user names of the Kubernetes service accounts.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// AllNamespaces stands for every namespace of the cluster in Options.Namespaces.
+const AllNamespaces = "*"
+
+// Rules returns the minimal policy rules required by Argotails in the namespace where ArgoCD
+// cluster secrets are managed.
+func Rules(opts Options) []rbacv1.PolicyRule {
+	written := []string{opts.ReportConfigMap, opts.CheckpointConfigMap, opts.RolloutConfigMap}
+	if namespace, name := dnsConfigMap(opts); namespace == opts.Namespace {
+		written = append(written, name)
+	}
+	rules := append(resourceRules(opts), configMapRules(slices.DeleteFunc(written, func(name string) bool { return name == "" }))...)
+	if opts.PauseConfigMap != "" {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{opts.PauseConfigMap},
+			Verbs:         []string{"get", "list", "watch"},
+		})
+	}
+	return rules
+}
+
+// dnsConfigMap returns the namespace and name of the ConfigMap receiving the hosts file of the
+// devices, in the namespace where ArgoCD cluster secrets are managed unless specified.
+func dnsConfigMap(opts Options) (string, string) {
+	if namespace, name, found := strings.Cut(opts.DNSConfigMap, "/"); found {
+		return namespace, name
+	}
+	return opts.Namespace, opts.DNSConfigMap
+}
+
+// configMapRules returns the policy rules required to write the given ConfigMaps.
+func configMapRules(names []string) []rbacv1.PolicyRule {
+	if len(names) == 0 {
+		return nil
+	}
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"create"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: names,
+			Verbs:         []string{"get", "patch"},
+		},
+	}
+}
+
+// resourceRules returns the policy rules required by Argotails in every namespace where ArgoCD
+// cluster secrets are managed.
+func resourceRules(opts Options) []rbacv1.PolicyRule {
+	resources := []string{"secrets"}
+	if opts.CreateService {
+		resources = append(resources, "services")
+	}
+
+	verbs := []string{"get", "list", "watch", "create", "update", "delete"}
+	if opts.WebhookOnly {
+		verbs = slices.DeleteFunc(verbs, func(verb string) bool { return verb == "watch" })
+	}
+
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: resources,
+			Verbs:     verbs,
+		},
+		{
+			APIGroups: []string{"events.k8s.io"},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "patch"},
+		},
+	}
+	if opts.ClusterResource {
+		rules = append(rules,
+			rbacv1.PolicyRule{
+				APIGroups: []string{v1alpha1.GroupVersion.Group},
+				Resources: []string{"tailscaleclusters"},
+				Verbs:     verbs,
+			},
+			rbacv1.PolicyRule{
+				APIGroups: []string{v1alpha1.GroupVersion.Group},
+				Resources: []string{"tailscaleclusters/status"},
+				Verbs:     []string{"update"},
+			},
+		)
+	}
+	if opts.Application {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"argoproj.io"},
+			Resources: []string{"applications"},
+			Verbs:     []string{"get", "list", "create", "update", "delete"},
+		})
+	}
+	return rules
+}
+
+// ClusterRules returns the policy rules Argotails requires cluster-wide, granted through a
+// ClusterRole: the rules on the registration objects, the impersonation of users and groups, and
+// the rules of every namespace when Options.Namespaces contains AllNamespaces.
+func ClusterRules(opts Options) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	if slices.Contains(opts.Namespaces, AllNamespaces) {
+		rules = append(rules, resourceRules(opts)...)
+	}
+	for _, backend := range slices.Compact(slices.Sorted(slices.Values(opts.RegistrationBackends))) {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{reconciler.RegistrationBackends[backend].Group},
+			Resources: []string{reconciler.RegistrationResources[backend]},
+			Verbs:     []string{"get", "list", "create", "update", "delete"},
+		})
+	}
+	if opts.ImpersonateUser != "" && !strings.HasPrefix(opts.ImpersonateUser, serviceAccountPrefix) {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"users"},
+			ResourceNames: []string{opts.ImpersonateUser},
+			Verbs:         []string{"impersonate"},
+		})
+	}
+	if opts.ImpersonateUser != "" && len(opts.ImpersonateGroups) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"groups"},
+			ResourceNames: opts.ImpersonateGroups,
+			Verbs:         []string{"impersonate"},
+		})
+	}
+	return rules
+}
+
+// NamespaceRules returns the policy rules Argotails requires in each namespace, keyed by namespace:
+// all the rules in the namespace where ArgoCD cluster secrets are managed, the rules on the
+// managed resources in the additional namespaces not covered by ClusterRules, the rules on the
+// hosts file ConfigMap and the impersonation of a service account in their namespace.
+func NamespaceRules(opts Options) map[string][]rbacv1.PolicyRule {
+	rules := map[string][]rbacv1.PolicyRule{opts.Namespace: Rules(opts)}
+	if !slices.Contains(opts.Namespaces, AllNamespaces) {
+		for _, namespace := range opts.Namespaces {
+			if _, exists := rules[namespace]; !exists {
+				rules[namespace] = resourceRules(opts)
+			}
+		}
+	}
+	if namespace, name := dnsConfigMap(opts); name != "" && namespace != opts.Namespace {
+		rules[namespace] = append(rules[namespace], configMapRules([]string{name})...)
+	}
+	// NOTE: impersonating a service account is authorized on the service account object, in its namespace.
+	if namespace, name, found := strings.Cut(strings.TrimPrefix(opts.ImpersonateUser, serviceAccountPrefix), ":"); found && strings.HasPrefix(opts.ImpersonateUser, serviceAccountPrefix) {
+		rules[namespace] = append(rules[namespace], rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"serviceaccounts"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"impersonate"},
+		})
+	}
+	return rules
+}
+
+// Manifests returns the Roles and RoleBindings (and the ClusterRole and ClusterRoleBinding, if
+// required) granting the minimal permissions to the Argotails service account, as a
+// multi-document YAML.
+func Manifests(opts Options) ([]byte, error) {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: opts.Name, Namespace: opts.Namespace}}
+
+	var objs []any
+	namespaceRules := NamespaceRules(opts)
+	for _, namespace := range slices.Sorted(maps.Keys(namespaceRules)) {
+		meta := metav1.ObjectMeta{Name: opts.Name, Namespace: namespace}
+		objs = append(objs,
+			rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: meta,
+				Rules:      namespaceRules[namespace],
+			},
+			rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: meta,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: opts.Name},
+				Subjects:   subjects,
+			},
+		)
+	}
+	if clusterRules := ClusterRules(opts); len(clusterRules) > 0 {
+		meta := metav1.ObjectMeta{Name: opts.Name}
+		objs = append(objs,
+			rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+				ObjectMeta: meta,
+				Rules:      clusterRules,
+			},
+			rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: opts.Name},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	var buf bytes.Buffer
+	for _, obj := range objs {
+		raw, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal RBAC manifest: %w", err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(raw)
+	}
+	return buf.Bytes(), nil
+}
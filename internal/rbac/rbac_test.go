@@ -0,0 +1,190 @@
+package rbac_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/rbac"
+)
+
+func TestRules(t *testing.T) {
+	rules := rbac.Rules(rbac.Options{})
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"secrets"}, rules[0].Resources)
+	assert.NotContains(t, rules[0].Verbs, "patch")
+
+	rules = rbac.Rules(rbac.Options{WebhookOnly: true, ClusterResource: true})
+	require.Len(t, rules, 4)
+	assert.NotContains(t, rules[0].Verbs, "watch")
+	assert.NotContains(t, rules[2].Verbs, "watch")
+	assert.Contains(t, rules[0].Verbs, "list")
+
+	rules = rbac.Rules(rbac.Options{CreateService: true})
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"secrets", "services"}, rules[0].Resources)
+
+	rules = rbac.Rules(rbac.Options{ClusterResource: true})
+	require.Len(t, rules, 4)
+	assert.Equal(t, []string{"tailscaleclusters"}, rules[2].Resources)
+	assert.Equal(t, []string{"tailscaleclusters/status"}, rules[3].Resources)
+
+	rules = rbac.Rules(rbac.Options{Application: true})
+	require.Len(t, rules, 3)
+	assert.Equal(t, []string{"argoproj.io"}, rules[2].APIGroups)
+	assert.Equal(t, []string{"applications"}, rules[2].Resources)
+
+	rules = rbac.Rules(rbac.Options{ReportConfigMap: "argotails-report"})
+	require.Len(t, rules, 4)
+	assert.Equal(t, []string{"configmaps"}, rules[2].Resources)
+	assert.Equal(t, []string{"argotails-report"}, rules[3].ResourceNames)
+
+	rules = rbac.Rules(rbac.Options{ReportConfigMap: "argotails-report", DNSConfigMap: "tailscale-hosts"})
+	require.Len(t, rules, 4)
+	assert.Equal(t, []string{"argotails-report", "tailscale-hosts"}, rules[3].ResourceNames)
+
+	rules = rbac.Rules(rbac.Options{ReportConfigMap: "argotails-report", CheckpointConfigMap: "argotails-checkpoint"})
+	require.Len(t, rules, 4)
+	assert.Equal(t, []string{"argotails-report", "argotails-checkpoint"}, rules[3].ResourceNames)
+
+	rules = rbac.Rules(rbac.Options{CheckpointConfigMap: "argotails-checkpoint", RolloutConfigMap: "argotails-rollout"})
+	require.Len(t, rules, 4)
+	assert.Equal(t, []string{"argotails-checkpoint", "argotails-rollout"}, rules[3].ResourceNames)
+
+	rules = rbac.Rules(rbac.Options{PauseConfigMap: "argotails-pause"})
+	require.Len(t, rules, 3)
+	assert.Equal(t, []string{"argotails-pause"}, rules[2].ResourceNames)
+	assert.Equal(t, []string{"get", "list", "watch"}, rules[2].Verbs)
+}
+
+func TestManifests(t *testing.T) {
+	raw, err := rbac.Manifests(rbac.Options{Name: "argotails", Namespace: "argocd"})
+	require.NoError(t, err)
+
+	assert.Equal(t, `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: argotails
+  namespace: argocd
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - secrets
+  verbs:
+  - get
+  - list
+  - watch
+  - create
+  - update
+  - delete
+- apiGroups:
+  - events.k8s.io
+  resources:
+  - events
+  verbs:
+  - create
+  - patch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: argotails
+  namespace: argocd
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: argotails
+subjects:
+- kind: ServiceAccount
+  name: argotails
+  namespace: argocd
+`, string(raw))
+}
+
+func TestNamespaceRules(t *testing.T) {
+	opts := rbac.Options{Namespace: "argocd", ReportConfigMap: "argotails-report", Namespaces: []string{"argocd-apps", "argocd"}}
+	rules := rbac.NamespaceRules(opts)
+	require.Len(t, rules, 2)
+	assert.Len(t, rules["argocd"], 4)
+	// The ConfigMaps are only written in the namespace of Argotails
+	require.Len(t, rules["argocd-apps"], 2)
+	assert.Equal(t, []string{"secrets"}, rules["argocd-apps"][0].Resources)
+	assert.Empty(t, rbac.ClusterRules(opts))
+
+	// Every namespace requires a ClusterRole
+	opts.Namespaces = []string{rbac.AllNamespaces}
+	rules = rbac.NamespaceRules(opts)
+	require.Len(t, rules, 1)
+	assert.Len(t, rules["argocd"], 4)
+	clusterRules := rbac.ClusterRules(opts)
+	require.Len(t, clusterRules, 2)
+	assert.Equal(t, []string{"secrets"}, clusterRules[0].Resources)
+}
+
+func TestManifests_AllNamespaces(t *testing.T) {
+	raw, err := rbac.Manifests(rbac.Options{Name: "argotails", Namespace: "argocd", Namespaces: []string{rbac.AllNamespaces}})
+	require.NoError(t, err)
+
+	assert.Contains(t, string(raw), `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: argotails
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: argotails
+subjects:
+- kind: ServiceAccount
+  name: argotails
+  namespace: argocd
+`)
+	assert.Contains(t, string(raw), "kind: ClusterRole\n")
+}
+
+func TestNamespaceRules_DNSConfigMap(t *testing.T) {
+	rules := rbac.NamespaceRules(rbac.Options{Namespace: "argocd", DNSConfigMap: "tailscale-hosts"})
+	require.Len(t, rules, 1)
+	require.Len(t, rules["argocd"], 4)
+	assert.Equal(t, []string{"tailscale-hosts"}, rules["argocd"][3].ResourceNames)
+
+	// A ConfigMap of another namespace is written with a Role of this namespace
+	rules = rbac.NamespaceRules(rbac.Options{Namespace: "argocd", DNSConfigMap: "kube-system/tailscale-hosts"})
+	require.Len(t, rules, 2)
+	assert.Len(t, rules["argocd"], 2)
+	require.Len(t, rules["kube-system"], 2)
+	assert.Equal(t, []string{"tailscale-hosts"}, rules["kube-system"][1].ResourceNames)
+}
+
+func TestClusterRules_RegistrationBackends(t *testing.T) {
+	rules := rbac.ClusterRules(rbac.Options{RegistrationBackends: []string{"ocm", "karmada", "ocm"}})
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"cluster.karmada.io"}, rules[0].APIGroups)
+	assert.Equal(t, []string{"clusters"}, rules[0].Resources)
+	assert.Equal(t, []string{"cluster.open-cluster-management.io"}, rules[1].APIGroups)
+	assert.Equal(t, []string{"managedclusters"}, rules[1].Resources)
+	assert.Contains(t, rules[1].Verbs, "list")
+}
+
+func TestRules_Impersonate(t *testing.T) {
+	opts := rbac.Options{Namespace: "argocd", ImpersonateUser: "argotails-writer", ImpersonateGroups: []string{"writers"}}
+	clusterRules := rbac.ClusterRules(opts)
+	require.Len(t, clusterRules, 2)
+	assert.Equal(t, []string{"users"}, clusterRules[0].Resources)
+	assert.Equal(t, []string{"argotails-writer"}, clusterRules[0].ResourceNames)
+	assert.Equal(t, []string{"impersonate"}, clusterRules[0].Verbs)
+	assert.Equal(t, []string{"groups"}, clusterRules[1].Resources)
+	assert.Equal(t, []string{"writers"}, clusterRules[1].ResourceNames)
+
+	// Service accounts are impersonated through a rule of their namespace
+	opts = rbac.Options{Namespace: "argocd", ImpersonateUser: "system:serviceaccount:argocd-writers:argotails-writer"}
+	assert.Empty(t, rbac.ClusterRules(opts))
+	rules := rbac.NamespaceRules(opts)
+	require.Len(t, rules["argocd-writers"], 1)
+	assert.Equal(t, []string{"serviceaccounts"}, rules["argocd-writers"][0].Resources)
+	assert.Equal(t, []string{"argotails-writer"}, rules["argocd-writers"][0].ResourceNames)
+	assert.Equal(t, []string{"impersonate"}, rules["argocd-writers"][0].Verbs)
+}
@@ -0,0 +1,154 @@
+package reconciler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// ApplicationGVK is the kind of the ArgoCD Application generated for each registered cluster.
+var ApplicationGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Application"}
+
+// ApplicationData is the data available to the ArgoCD Application template.
+type ApplicationData struct {
+	// Device is the Tailscale device.
+	Device tailscale.Device
+	// Name is the ArgoCD cluster name.
+	Name string
+	// Server is the ArgoCD cluster server URL.
+	Server string
+	// SecretName is the name of the ArgoCD cluster secret.
+	SecretName string
+	// Namespace is the namespace of the ArgoCD cluster secret.
+	Namespace string
+}
+
+// WithApplicationTemplate creates an ArgoCD Application per registered cluster, rendered from the
+// given template (e.g. an app-of-apps bootstrapping the new cluster). The Application is created
+// in the namespace of the secret and named after it unless the template says otherwise.
+func WithApplicationTemplate(tmpl *template.Template) Option {
+	return func(r *reconciler) { r.application = tmpl }
+}
+
+// buildDesiredApplication renders the ArgoCD Application expected for the given device.
+func buildDesiredApplication(tmpl *template.Template, namespacedName types.NamespacedName, device tailscale.Device, server, managedBy string) (*unstructured.Unstructured, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, ApplicationData{
+		Device:     device,
+		Name:       device.Name,
+		Server:     server,
+		SecretName: namespacedName.Name,
+		Namespace:  namespacedName.Namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render ArgoCD application: %w", err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(buf.Bytes(), &obj.Object); err != nil {
+		return nil, fmt.Errorf("invalid ArgoCD application: %w", err)
+	}
+	if obj.Object == nil {
+		obj.Object = map[string]any{}
+	}
+	if gvk := obj.GroupVersionKind(); !gvk.Empty() && gvk != ApplicationGVK {
+		return nil, fmt.Errorf("invalid ArgoCD application: unexpected kind %s", gvk)
+	}
+	obj.SetGroupVersionKind(ApplicationGVK)
+	if obj.GetName() == "" {
+		obj.SetName(toDNS1035Name(namespacedName.Name))
+	}
+	if ns := obj.GetNamespace(); ns != "" && ns != namespacedName.Namespace {
+		return nil, fmt.Errorf("invalid ArgoCD application: namespace must be %q", namespacedName.Namespace)
+	}
+	obj.SetNamespace(namespacedName.Namespace)
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels["apps.kubernetes.io/managed-by"] = managedBy
+	obj.SetLabels(labels)
+
+	annotations := map[string]string{}
+	maps.Copy(annotations, obj.GetAnnotations())
+	annotations[AnnotationDeviceID] = device.NodeID
+	annotations[AnnotationSecret] = namespacedName.String()
+	obj.SetAnnotations(annotations)
+	return obj, nil
+}
+
+// SyncDeviceApplication creates or updates the ArgoCD Application of the given device.
+func (r reconciler) SyncDeviceApplication(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) error {
+	log := ctrllog.FromContext(ctx).WithName("sync_application")
+
+	desired, err := buildDesiredApplication(r.application, namespacedName, device, ServerURL(device, r.serverAddress), r.managedBy)
+	if err != nil {
+		return err
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(ApplicationGVK)
+	err = r.ks.Get(ctx, client.ObjectKeyFromObject(desired), current)
+	switch {
+	case errors.IsNotFound(err):
+		log.V(3).Info("Create Tailscale device ArgoCD application")
+		return r.ks.Create(ctx, desired)
+	case err != nil:
+		return err
+	case current.GetLabels()["apps.kubernetes.io/managed-by"] != r.managedBy:
+		return fmt.Errorf("ArgoCD application %q is not managed by %s", desired.GetName(), r.managedBy)
+	}
+
+	// Only the fields rendered by the template are enforced; the status and the fields set by
+	// ArgoCD (e.g. operation) are kept.
+	for key, value := range desired.Object {
+		if key != "metadata" && key != "status" {
+			current.Object[key] = value
+		}
+	}
+	current.SetLabels(desired.GetLabels())
+	current.SetAnnotations(desired.GetAnnotations())
+
+	log.V(3).Info("Update Tailscale device ArgoCD application")
+	return r.ks.Update(ctx, current)
+}
+
+// DeleteDeviceApplication deletes the ArgoCD Applications generated for the given device.
+func (r reconciler) DeleteDeviceApplication(ctx context.Context, namespacedName types.NamespacedName) error {
+	log := ctrllog.FromContext(ctx).WithName("delete_application")
+
+	// The Application name may be templated, so it is found back through the secret it has been
+	// generated for.
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(ApplicationGVK.GroupVersion().WithKind(ApplicationGVK.Kind + "List"))
+	err := r.ks.List(ctx, list, client.InNamespace(namespacedName.Namespace), client.MatchingLabels{"apps.kubernetes.io/managed-by": r.managedBy})
+	if meta.IsNoMatchError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, app := range list.Items {
+		if app.GetAnnotations()[AnnotationSecret] != namespacedName.String() {
+			continue
+		}
+		log.V(3).Info("Delete Tailscale device ArgoCD application", "application", client.ObjectKeyFromObject(&app))
+		if err := r.ks.Delete(ctx, &app); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/apis/v1alpha1"
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+// syncOutcome contains the result of the synchronization of a device's resources, used to compute
+// the conditions of its TailscaleCluster resource.
+type syncOutcome struct {
+	// secret is the error returned while synchronizing the secret.
+	secret error
+	// service is the error returned while synchronizing the service.
+	service error
+	// serviceSynced is true once the service synchronization has been attempted.
+	serviceSynced bool
+}
+
+// WithClusterResource maintains a TailscaleCluster resource per device, reporting the state of the
+// device and of its resources through status conditions.
+func WithClusterResource(enabled bool) Option {
+	return func(r *reconciler) { r.clusterResource = enabled }
+}
+
+// buildDesiredConditions returns the conditions expected on the TailscaleCluster resource of the
+// given device, based on the outcome of its synchronization. The APIServerReachable condition is
+// not computed here; it is reported by the ArgoCD connection state synchronization.
+func buildDesiredConditions(device tailscale.Device, outcome syncOutcome, createService bool) []metav1.Condition {
+	online := metav1.Condition{Type: v1alpha1.ConditionDeviceOnline, Status: metav1.ConditionTrue, Reason: "Connected", Message: "Device is connected to the Tailscale control plane"}
+	if !device.ConnectedToControl {
+		online.Status, online.Reason, online.Message = metav1.ConditionFalse, "Disconnected", "Device is not connected to the Tailscale control plane"
+		if device.LastSeen != nil {
+			online.Message = fmt.Sprintf("Device last seen at %s", device.LastSeen.Format("2006-01-02T15:04:05Z07:00"))
+		}
+	}
+
+	secret := metav1.Condition{Type: v1alpha1.ConditionSecretSynced, Status: metav1.ConditionTrue, Reason: "Synced", Message: "ArgoCD cluster secret is up to date"}
+	if outcome.secret != nil {
+		secret.Status, secret.Reason, secret.Message = metav1.ConditionFalse, "SyncFailed", outcome.secret.Error()
+	}
+
+	service := metav1.Condition{Type: v1alpha1.ConditionServiceSynced, Status: metav1.ConditionTrue, Reason: "Synced", Message: "Kubernetes service is up to date"}
+	switch {
+	case !createService:
+		service.Status, service.Reason, service.Message = metav1.ConditionUnknown, "Disabled", "Service creation is disabled"
+	case !outcome.serviceSynced:
+		service.Status, service.Reason, service.Message = metav1.ConditionUnknown, "NotSynced", "Service has not been synchronized"
+	case outcome.service != nil:
+		service.Status, service.Reason, service.Message = metav1.ConditionFalse, "SyncFailed", outcome.service.Error()
+	}
+
+	return []metav1.Condition{online, secret, service}
+}
+
+// syncDeviceCluster creates or updates the TailscaleCluster resource of the given device, unless
+// the mutations are paused. Its status is only updated when one of its conditions changed.
+func (r reconciler) syncDeviceCluster(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device, outcome syncOutcome) error {
+	// NOTE: the mutations may have been paused during the reconciliation.
+	if r.pause.Paused() {
+		return nil
+	}
+	log := ctrllog.FromContext(ctx).WithName("sync_cluster")
+
+	spec := v1alpha1.TailscaleClusterSpec{DeviceID: device.NodeID, DeviceName: device.Name, SecretName: namespacedName.Name}
+	if r.createsService(device) {
+		if service, err := r.serviceNamespacedName(namespacedName); err == nil {
+			spec.ServiceName = service.Name
+		}
+	}
+
+	var cluster v1alpha1.TailscaleCluster
+	err := r.ks.Get(ctx, namespacedName, &cluster)
+	switch {
+	case errors.IsNotFound(err):
+		cluster = v1alpha1.TailscaleCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      namespacedName.Name,
+				Namespace: namespacedName.Namespace,
+				Labels:    map[string]string{"apps.kubernetes.io/managed-by": r.managedBy},
+			},
+			Spec: spec,
+		}
+		log.V(3).Info("Create Tailscale device cluster resource")
+		if err := r.ks.Create(ctx, &cluster); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case cluster.Spec != spec:
+		cluster.Spec = spec
+		log.V(3).Info("Update Tailscale device cluster resource")
+		if err := r.ks.Update(ctx, &cluster); err != nil {
+			return err
+		}
+	}
+
+	changed := false
+	for _, condition := range buildDesiredConditions(device, outcome, r.createsService(device)) {
+		condition.ObservedGeneration = cluster.Generation
+		changed = meta.SetStatusCondition(&cluster.Status.Conditions, condition) || changed
+	}
+	if meta.FindStatusCondition(cluster.Status.Conditions, v1alpha1.ConditionAPIServerReachable) == nil {
+		changed = meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionAPIServerReachable,
+			Status:             metav1.ConditionUnknown,
+			Reason:             "NotProbed",
+			Message:            "ArgoCD connection state is not monitored",
+			ObservedGeneration: cluster.Generation,
+		}) || changed
+	}
+	if !changed {
+		return nil
+	}
+
+	log.V(3).Info("Update Tailscale device cluster resource status")
+	return r.ks.Status().Update(ctx, &cluster)
+}
+
+// DeleteDeviceCluster deletes the TailscaleCluster resource of the given device.
+func (r reconciler) DeleteDeviceCluster(ctx context.Context, namespacedName types.NamespacedName) error {
+	log := ctrllog.FromContext(ctx).WithName("delete_cluster")
+
+	log.V(3).Info("Delete Tailscale device cluster resource")
+	err := r.ks.Delete(ctx, &v1alpha1.TailscaleCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespacedName.Name,
+			Namespace: namespacedName.Namespace,
+		},
+	})
+	return client.IgnoreNotFound(err)
+}
+
+// ReportAPIServerReachability updates the APIServerReachable condition of the TailscaleCluster
+// resource with the given name, based on the ArgoCD connection state of the cluster.
+func ReportAPIServerReachability(ctx context.Context, ks client.Client, namespacedName types.NamespacedName, state string) error {
+	var cluster v1alpha1.TailscaleCluster
+	if err := ks.Get(ctx, namespacedName, &cluster); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionAPIServerReachable,
+		Status:             metav1.ConditionUnknown,
+		Reason:             state,
+		Message:            "ArgoCD connection state is " + state,
+		ObservedGeneration: cluster.Generation,
+	}
+	switch state {
+	case argocd.ConnectionStatusSuccessful:
+		condition.Status = metav1.ConditionTrue
+	case argocd.ConnectionStatusFailed:
+		condition.Status = metav1.ConditionFalse
+	}
+	if !meta.SetStatusCondition(&cluster.Status.Conditions, condition) {
+		return nil
+	}
+	return ks.Status().Update(ctx, &cluster)
+}
@@ -0,0 +1,12 @@
+package reconciler
+
+// AnnotationDeviceDERPRegion is the annotation key for the preferred DERP relay region of the
+// device (e.g. "Frankfurt"), written with WithConnectivity.
+const AnnotationDeviceDERPRegion = "device.tailscale.com/derp-region"
+
+// WithConnectivity annotates the secrets with the preferred DERP relay region of their device, so
+// that slow ArgoCD synchronizations can be correlated with the Tailscale path quality. It requires
+// the devices to be listed with their connectivity.
+func WithConnectivity(enabled bool) Option {
+	return func(r *reconciler) { r.connectivity = enabled }
+}
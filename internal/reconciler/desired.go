@@ -0,0 +1,326 @@
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// BuildConfig contains the controller-wide settings required to build the desired state of a
+// Tailscale device's secret and service.
+type BuildConfig struct {
+	// ManagedBy is the controller name.
+	ManagedBy string
+	// ProxyClass is the ProxyClass to use for Tailscale services.
+	ProxyClass string
+	// Pending builds a secret awaiting approval, which is not registered as ArgoCD cluster.
+	Pending bool
+	// Labels are additional labels computed for the device (e.g. by a policy).
+	Labels map[string]string
+	// ExtraData are additional entries added to the secret data; they cannot override the entries
+	// required by the output flavor (e.g. name, server and config for ArgoCD).
+	ExtraData map[string]string
+	// Config overrides the ArgoCD cluster "config" entry (e.g. for a fleet).
+	Config string
+	// ServiceAnnotations are additional annotations of the service (e.g. for a fleet).
+	ServiceAnnotations map[string]string
+	// Flavor is the kind of secret to generate (defaults to FlavorArgoCD).
+	Flavor string
+	// ServerAddress is how the Kubernetes API server of the device is reached (defaults to ServerAddressMagicDNS).
+	ServerAddress string
+	// TLSServerName is the TLS server name (SNI) of the Kubernetes API server of the device; an
+	// explicit server name of Config takes precedence.
+	TLSServerName string
+	// TagLabels is the handling of the device tags that are not valid label keys (defaults to TagLabelsLenient).
+	TagLabels string
+	// DataMetadata selects the labels and annotations also written into the ArgoCD cluster secret data.
+	DataMetadata DataMetadata
+	// Connectivity annotates the secret with the preferred DERP relay region of the device.
+	Connectivity bool
+	// Annotations are additional annotations of the secret (e.g. ArgoCD sync waves); they cannot
+	// override the annotations written by Argotails.
+	Annotations map[string]string
+	// ConfigHash is the hash of the configuration the secret is written with, when rolled out progressively.
+	ConfigHash string
+	// ServiceName is the name of the service (defaults to the DNS-1035 form of the secret name).
+	ServiceName string
+}
+
+// BuildDesiredSecret returns the ArgoCD cluster secret (or the secret of the configured output flavor)
+// expected for the given Tailscale device.
+// It has no side effect and is shared by the creation, update and diffing code.
+func BuildDesiredSecret(namespacedName types.NamespacedName, device tailscale.Device, cfg BuildConfig) (corev1.Secret, error) {
+	data, err := flavorData(device, cfg.Flavor, ServerURL(device, cfg.ServerAddress), cfg.TLSServerName)
+	if err != nil {
+		return corev1.Secret{}, err
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespacedName.Name,
+			Namespace: namespacedName.Namespace,
+			Annotations: map[string]string{
+				AnnotationDeviceID:       device.NodeID,
+				AnnotationDeviceHostname: device.Hostname,
+			},
+			Labels: map[string]string{
+				argocd.LabelSecretType:          argocd.SecretTypeCluster,
+				"apps.kubernetes.io/managed-by": cfg.ManagedBy,
+
+				LabelDeviceOS:      device.OS,
+				LabelDeviceVersion: device.ClientVersion,
+			},
+		},
+		StringData: map[string]string{},
+	}
+
+	maps.Copy(secret.StringData, cfg.ExtraData)
+	var configured []string
+	for key, value := range cfg.Annotations {
+		if !IsReservedAnnotation(key) {
+			secret.Annotations[key] = value
+			configured = append(configured, key)
+		}
+	}
+	if len(configured) > 0 {
+		slices.Sort(configured)
+		secret.Annotations[AnnotationManagedAnnotations] = strings.Join(configured, ",")
+	}
+	maps.Copy(secret.StringData, data)
+	if _, exists := secret.StringData[argocd.KeyConfig]; exists && cfg.Config != "" {
+		config, err := withServerName(cfg.Config, cfg.TLSServerName)
+		if err != nil {
+			return corev1.Secret{}, err
+		}
+		secret.StringData[argocd.KeyConfig] = config
+	}
+
+	// Only ArgoCD cluster secrets are labeled as such
+	if cfg.Flavor != "" && cfg.Flavor != FlavorArgoCD {
+		delete(secret.Labels, argocd.LabelSecretType)
+	}
+
+	if len(device.Addresses) > 0 {
+		secret.Annotations[AnnotationDeviceAddress] = device.Addresses[0]
+		secret.Annotations[AnnotationDeviceAddresses] = strings.Join(device.Addresses, ",")
+	}
+	if cfg.ConfigHash != "" {
+		secret.Annotations[AnnotationConfigHash] = cfg.ConfigHash
+	}
+	if region, _ := ts.PreferredDERPRegion(device); cfg.Connectivity && region != "" {
+		secret.Annotations[AnnotationDeviceDERPRegion] = region
+	}
+	if rxTailnet.MatchString(device.Name) {
+		secret.Annotations[AnnotationDeviceTailnet] = rxTailnet.FindStringSubmatch(device.Name)[1]
+	}
+	tagLabels := deviceTagLabels(device, cfg.TagLabels)
+	maps.Copy(secret.Labels, tagLabels)
+	maps.Copy(secret.Labels, cfg.Labels)
+	// The computed labels cannot take over the ownership of the secret
+	secret.Labels["apps.kubernetes.io/managed-by"] = cfg.ManagedBy
+	recordManagedLabels(&secret.ObjectMeta, tagLabels, cfg.Labels)
+
+	// Secrets pending approval are not labeled as ArgoCD cluster in order to be ignored by ArgoCD
+	if cfg.Pending {
+		delete(secret.Labels, argocd.LabelSecretType)
+		secret.Annotations[AnnotationApproved] = "false"
+	}
+
+	if cfg.Flavor == "" || cfg.Flavor == FlavorArgoCD || cfg.Flavor == FlavorInventory {
+		if len(cfg.DataMetadata.Labels) > 0 {
+			raw, _ := json.Marshal(selectMetadata(secret.Labels, cfg.DataMetadata.Labels))
+			secret.StringData["labels"] = string(raw)
+		}
+		if len(cfg.DataMetadata.Annotations) > 0 {
+			raw, _ := json.Marshal(selectMetadata(secret.Annotations, cfg.DataMetadata.Annotations))
+			secret.StringData["annotations"] = string(raw)
+		}
+	}
+
+	stampContentHash(&secret)
+	return secret, nil
+}
+
+// stampContentHash stamps the content hash of the given secret, computed on its metadata as it is
+// written, i.e. once truncated by GuardObjectMeta: the hash of the over-long values would never
+// match the written ones, and the secret would be rewritten by every reconciliation.
+func stampContentHash(secret *corev1.Secret) {
+	// NOTE: the hash annotation counts towards the size of the annotations
+	secret.Annotations[AnnotationContentHash] = ContentHash(nil, nil, nil)
+	written := secret.ObjectMeta.DeepCopy()
+	GuardObjectMeta(written, secret.Annotations)
+	secret.Annotations[AnnotationContentHash] = ContentHash(written.Labels, written.Annotations, secret.StringData)
+}
+
+// ContentHash returns the hash of the given labels, annotations (except AnnotationContentHash
+// itself) and data, as stamped in the AnnotationContentHash annotation.
+func ContentHash(labels, annotations, data map[string]string) string {
+	annotations = maps.Clone(annotations)
+	delete(annotations, AnnotationContentHash)
+
+	// JSON encoding sorts the map keys, making the hash independent of the iteration order
+	raw, _ := json.Marshal(struct {
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		Data        map[string]string `json:"data"`
+	}{labels, annotations, data})
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// upToDate returns true if the given secret has the content of the desired one, i.e. its content
+// hash annotation is the desired one and its content still matches it (no drift since the last write).
+func upToDate(current corev1.Secret, desired corev1.Secret) bool {
+	hash := desired.Annotations[AnnotationContentHash]
+	if current.Annotations[AnnotationContentHash] != hash {
+		return false
+	}
+
+	labels := make(map[string]string, len(desired.Labels))
+	for key := range desired.Labels {
+		if value, exists := current.Labels[key]; exists {
+			labels[key] = value
+		}
+	}
+	annotations := make(map[string]string, len(desired.Annotations))
+	for key := range desired.Annotations {
+		if value, exists := current.Annotations[key]; exists {
+			annotations[key] = value
+		}
+	}
+	data := make(map[string]string, len(current.Data)+len(current.StringData))
+	for key, value := range current.Data {
+		data[key] = string(value)
+	}
+	maps.Copy(data, current.StringData)
+
+	return ContentHash(labels, annotations, data) == hash
+}
+
+// BuildDesiredService returns the Kubernetes service expected for the given Tailscale device.
+// It has no side effect and is shared by the creation, update and diffing code.
+func BuildDesiredService(namespacedName types.NamespacedName, device tailscale.Device, cfg BuildConfig) corev1.Service {
+	name := cfg.ServiceName
+	if name == "" {
+		name = toDNS1035Name(namespacedName.Name)
+	}
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespacedName.Namespace,
+			Annotations: map[string]string{
+				"tailscale.com/tailnet-fqdn": device.Name,
+				AnnotationSecret:             namespacedName.String(),
+			},
+			Labels: map[string]string{
+				"apps.kubernetes.io/managed-by": cfg.ManagedBy,
+				LabelDeviceOS:                   device.OS,
+				LabelDeviceVersion:              device.ClientVersion,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: "ts.net",
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "https",
+					Port:       443,
+					Protocol:   corev1.ProtocolTCP,
+					TargetPort: intstr.FromInt(443),
+				},
+			},
+		},
+	}
+
+	if len(device.Addresses) > 0 {
+		service.Annotations[AnnotationDeviceAddresses] = strings.Join(device.Addresses, ",")
+	}
+
+	// Add ProxyClass annotation if specified
+	maps.Copy(service.Annotations, cfg.ServiceAnnotations)
+	if cfg.ProxyClass != "" {
+		service.Annotations["tailscale.com/proxy-class"] = cfg.ProxyClass
+	}
+	tagLabels := deviceTagLabels(device, cfg.TagLabels)
+	maps.Copy(service.Labels, tagLabels)
+	maps.Copy(service.Labels, cfg.Labels)
+	service.Labels["apps.kubernetes.io/managed-by"] = cfg.ManagedBy
+	recordManagedLabels(&service.ObjectMeta, tagLabels, cfg.Labels)
+
+	return service
+}
+
+// recordManagedLabels records the keys of the given computed labels in the AnnotationManagedLabels
+// annotation of the given object, so that mergeObjectMeta removes them once no longer computed.
+func recordManagedLabels(meta *metav1.ObjectMeta, computed ...map[string]string) {
+	var keys []string
+	for _, labels := range computed {
+		for key := range labels {
+			if key != "apps.kubernetes.io/managed-by" && !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		slices.Sort(keys)
+		meta.Annotations[AnnotationManagedLabels] = strings.Join(keys, ",")
+	}
+}
+
+// deviceTagLabels returns the labels representing the tags of the given device.
+func deviceTagLabels(device tailscale.Device, mode string) map[string]string {
+	labels := make(map[string]string, len(device.Tags))
+	for _, label := range SanitizeTagLabels(device.Tags, mode) {
+		if label.Key != "" {
+			labels[label.Key] = ""
+		}
+	}
+	return labels
+}
+
+// conditionalAnnotations are the annotations the controller only writes under some conditions
+// (e.g. while the DERP region of the device is known), removed when no longer desired.
+var conditionalAnnotations = []string{AnnotationDeviceDERPRegion, AnnotationManagedAnnotations, AnnotationManagedLabels}
+
+// mergeObjectMeta merges the desired labels and annotations into the current ones, preserving
+// any label or annotation not managed by the controller. The computed labels and configured
+// annotations recorded by AnnotationManagedLabels and AnnotationManagedAnnotations are removed
+// once no longer desired.
+func mergeObjectMeta(current *metav1.ObjectMeta, desired metav1.ObjectMeta) {
+	if current.Annotations == nil {
+		current.Annotations = map[string]string{}
+	}
+	if current.Labels == nil {
+		current.Labels = map[string]string{}
+	}
+	for key := range strings.SplitSeq(current.Annotations[AnnotationManagedLabels], ",") {
+		if _, exists := desired.Labels[key]; key != "" && !exists {
+			delete(current.Labels, key)
+		}
+	}
+	for key := range strings.SplitSeq(current.Annotations[AnnotationManagedAnnotations], ",") {
+		if _, exists := desired.Annotations[key]; key != "" && !exists {
+			delete(current.Annotations, key)
+		}
+	}
+	for _, key := range conditionalAnnotations {
+		if _, exists := desired.Annotations[key]; !exists {
+			delete(current.Annotations, key)
+		}
+	}
+	maps.Copy(current.Annotations, desired.Annotations)
+	maps.Copy(current.Labels, desired.Labels)
+}
@@ -0,0 +1,450 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the internal helpers */
+package reconciler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	"tailscale.com/client/tailscale/v2"
+
+	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestBuildDesiredSecret(t *testing.T) {
+	secret, err := BuildDesiredSecret(
+		types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"},
+		tailscale.Device{
+			Name:          "A.fake.ts.net",
+			Hostname:      "A",
+			NodeID:        "fake-device-id",
+			Addresses:     []string{"0.0.0.0", "fd7a:115c:a1e0::1"},
+			OS:            "linux",
+			ClientVersion: "v1.2.3",
+			Tags:          []string{"tag:tag1", "tag:tag2"},
+		},
+		BuildConfig{ManagedBy: managedBy},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "A.fake.ts.net", secret.Name)
+	assert.Equal(t, "argocd", secret.Namespace)
+	assert.Equal(t, "fake-device-id", secret.Annotations[AnnotationDeviceID])
+	assert.Equal(t, "A", secret.Annotations[AnnotationDeviceHostname])
+	assert.Equal(t, "fake.ts.net", secret.Annotations[AnnotationDeviceTailnet])
+	assert.Equal(t, "0.0.0.0", secret.Annotations[AnnotationDeviceAddress])
+	assert.Equal(t, "0.0.0.0,fd7a:115c:a1e0::1", secret.Annotations[AnnotationDeviceAddresses])
+	assert.Equal(t, "cluster", secret.Labels["argocd.argoproj.io/secret-type"])
+	assert.Equal(t, managedBy, secret.Labels["apps.kubernetes.io/managed-by"])
+	assert.Equal(t, "linux", secret.Labels[LabelDeviceOS])
+	assert.Equal(t, "v1.2.3", secret.Labels[LabelDeviceVersion])
+	assert.Contains(t, secret.Labels, LabelDeviceTagsPrefix+"tag1")
+	assert.Contains(t, secret.Labels, LabelDeviceTagsPrefix+"tag2")
+	assert.Equal(t, map[string]string{
+		"name":   "A.fake.ts.net",
+		"server": "https://A.fake.ts.net",
+		"config": `{"tlsClientConfig":{"insecure":false}}`,
+	}, secret.StringData)
+}
+
+func TestBuildDesiredSecret_NoAddressNoTailnet(t *testing.T) {
+	secret, err := BuildDesiredSecret(
+		types.NamespacedName{Name: "device", Namespace: "argocd"},
+		tailscale.Device{Name: "device", NodeID: "fake-device-id"},
+		BuildConfig{ManagedBy: managedBy},
+	)
+	require.NoError(t, err)
+
+	assert.NotContains(t, secret.Annotations, AnnotationDeviceAddress)
+	assert.NotContains(t, secret.Annotations, AnnotationDeviceAddresses)
+	assert.NotContains(t, secret.Annotations, AnnotationDeviceTailnet)
+}
+
+func TestBuildDesiredSecret_ExtraData(t *testing.T) {
+	secret, err := BuildDesiredSecret(
+		types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"},
+		tailscale.Device{Name: "A.fake.ts.net"},
+		BuildConfig{ManagedBy: managedBy, ExtraData: map[string]string{"region": "eu", "server": "https://override"}},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "eu", secret.StringData["region"])
+	assert.Equal(t, "https://A.fake.ts.net", secret.StringData["server"])
+}
+
+func TestBuildDesiredSecret_DataMetadata(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux", Tags: []string{"tag:k8s", "tag:prod"}}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	selection := DataMetadata{Labels: []string{LabelDeviceTagsPrefix + "*", LabelDeviceOS}, Annotations: []string{AnnotationDeviceID}}
+
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, DataMetadata: selection})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tag.device.tailscale.com/k8s": "", "tag.device.tailscale.com/prod": "", "device.tailscale.com/os": "linux"}`, secret.StringData["labels"])
+	assert.JSONEq(t, `{"device.tailscale.com/id": "fake-device-id"}`, secret.StringData["annotations"])
+
+	// Only ArgoCD cluster secrets carry the metadata in their data
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, DataMetadata: selection, Flavor: FlavorFlux})
+	require.NoError(t, err)
+	assert.NotContains(t, secret.StringData, "labels")
+	assert.NotContains(t, secret.StringData, "annotations")
+}
+
+func TestBuildDesiredSecret_ContentHash(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux"}
+
+	secret, err := BuildDesiredSecret(namespacedName, device, BuildConfig{ManagedBy: managedBy})
+	require.NoError(t, err)
+	hash := secret.Annotations[AnnotationContentHash]
+	assert.True(t, strings.HasPrefix(hash, "sha256:"))
+	assert.Equal(t, hash, ContentHash(secret.Labels, secret.Annotations, secret.StringData))
+
+	again, err := BuildDesiredSecret(namespacedName, device, BuildConfig{ManagedBy: managedBy})
+	require.NoError(t, err)
+	assert.Equal(t, hash, again.Annotations[AnnotationContentHash], "hash must be stable")
+
+	extra, err := BuildDesiredSecret(namespacedName, device, BuildConfig{ManagedBy: managedBy, ExtraData: map[string]string{"region": "eu"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, extra.Annotations[AnnotationContentHash])
+
+	// The written secret is up to date until its content drifts
+	current := *secret.DeepCopy()
+	current.Labels["team"] = "platform"
+	current.Data = map[string][]byte{}
+	for key, value := range current.StringData {
+		current.Data[key] = []byte(value)
+	}
+	current.StringData = nil
+	assert.True(t, upToDate(current, secret), "labels not managed by the controller are ignored")
+
+	current.Data["server"] = []byte("https://drifted")
+	assert.False(t, upToDate(current, secret))
+}
+
+func TestBuildDesiredService(t *testing.T) {
+	service := BuildDesiredService(
+		types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"},
+		tailscale.Device{
+			Name:          "A.fake.ts.net",
+			Addresses:     []string{"100.64.0.1", "fd7a:115c:a1e0::1"},
+			OS:            "linux",
+			ClientVersion: "v1.2.3",
+			Tags:          []string{"tag:tag1"},
+		},
+		BuildConfig{ManagedBy: managedBy, ProxyClass: "proxy"},
+	)
+
+	assert.Equal(t, "a-fake-ts-net", service.Name)
+	assert.Equal(t, "argocd", service.Namespace)
+	assert.Equal(t, "A.fake.ts.net", service.Annotations["tailscale.com/tailnet-fqdn"])
+	assert.Equal(t, "proxy", service.Annotations["tailscale.com/proxy-class"])
+	assert.Equal(t, "100.64.0.1,fd7a:115c:a1e0::1", service.Annotations[AnnotationDeviceAddresses])
+	assert.Equal(t, managedBy, service.Labels["apps.kubernetes.io/managed-by"])
+	assert.Equal(t, "linux", service.Labels[LabelDeviceOS])
+	assert.Equal(t, "v1.2.3", service.Labels[LabelDeviceVersion])
+	assert.Contains(t, service.Labels, LabelDeviceTagsPrefix+"tag1")
+	assert.Equal(t, corev1.ServiceTypeExternalName, service.Spec.Type)
+	assert.Equal(t, "ts.net", service.Spec.ExternalName)
+}
+
+func TestBuildDesiredSecret_Flavors(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id"}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	for flavor, key := range map[string]string{FlavorFlux: "value", FlavorKubeconfig: "kubeconfig"} {
+		secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Flavor: flavor})
+		require.NoError(t, err)
+
+		assert.NotContains(t, secret.Labels, "argocd.argoproj.io/secret-type", flavor)
+		assert.Equal(t, managedBy, secret.Labels["apps.kubernetes.io/managed-by"], flavor)
+		require.Contains(t, secret.StringData, key, flavor)
+		assert.NotContains(t, secret.StringData, "server", flavor)
+
+		config, err := clientcmd.Load([]byte(secret.StringData[key]))
+		require.NoError(t, err, flavor)
+		assert.Equal(t, "https://A.fake.ts.net", config.Clusters[config.Contexts[config.CurrentContext].Cluster].Server, flavor)
+	}
+
+	_, err := BuildDesiredSecret(nn, device, BuildConfig{Flavor: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestBuildDesiredSecret_Inventory(t *testing.T) {
+	lastSeen := tailscale.Time{Time: time.Now()}
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", Hostname: "a", OS: "linux", Tags: []string{"tag:k8s"}, LastSeen: &lastSeen}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Flavor: FlavorInventory, DataMetadata: DataMetadata{Labels: []string{LabelDeviceOS}}})
+	require.NoError(t, err)
+	assert.NotContains(t, secret.Labels, "argocd.argoproj.io/secret-type")
+	assert.Equal(t, "https://A.fake.ts.net", secret.StringData["server"])
+	assert.JSONEq(t, `{"`+LabelDeviceOS+`":"linux"}`, secret.StringData["labels"])
+
+	var metadata map[string]any
+	require.NoError(t, json.Unmarshal([]byte(secret.StringData[KeyInventoryDevice]), &metadata))
+	assert.Equal(t, "fake-device-id", metadata["nodeId"])
+	assert.Equal(t, "linux", metadata["os"])
+	assert.Equal(t, []any{"tag:k8s"}, metadata["tags"])
+	assert.NotContains(t, metadata, "lastSeen")
+
+	// The volatile fields do not change the secret.
+	lastSeen.Time = lastSeen.Add(time.Minute)
+	updated, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Flavor: FlavorInventory, DataMetadata: DataMetadata{Labels: []string{LabelDeviceOS}}})
+	require.NoError(t, err)
+	assert.Equal(t, secret.Annotations[AnnotationContentHash], updated.Annotations[AnnotationContentHash])
+}
+
+func TestBuildDesiredSecret_Annotations(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id"}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	plain, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy})
+	require.NoError(t, err)
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Annotations: map[string]string{
+		"argocd.argoproj.io/sync-wave": "-1",
+		AnnotationDeviceID:             "overridden",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "-1", secret.Annotations["argocd.argoproj.io/sync-wave"])
+	assert.Equal(t, "fake-device-id", secret.Annotations[AnnotationDeviceID], "reserved annotations are not overridden")
+	assert.NotEqual(t, plain.Annotations[AnnotationContentHash], secret.Annotations[AnnotationContentHash])
+	assert.Equal(t, "argocd.argoproj.io/sync-wave", secret.Annotations[AnnotationManagedAnnotations])
+
+	// The annotations no longer configured are removed, the ones written by others are kept.
+	current := secret.ObjectMeta
+	current.Annotations["example.com/other"] = "value"
+	mergeObjectMeta(&current, plain.ObjectMeta)
+	assert.NotContains(t, current.Annotations, "argocd.argoproj.io/sync-wave")
+	assert.NotContains(t, current.Annotations, AnnotationManagedAnnotations)
+	assert.Equal(t, "value", current.Annotations["example.com/other"])
+
+	inCluster, err := BuildInClusterSecret(types.NamespacedName{Name: "in-cluster", Namespace: "argocd"}, BuildConfig{ManagedBy: managedBy, Annotations: map[string]string{"argocd.argoproj.io/sync-wave": "-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "-1", inCluster.Annotations["argocd.argoproj.io/sync-wave"])
+}
+
+func TestBuildDesiredSecret_ServerAddress(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", EnabledRoutes: []string{"0.0.0.0/0", "10.0.0.0/24", "10.0.0.10/32"}}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, ServerAddress: ServerAddressSubnet})
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.10", secret.StringData["server"])
+	assert.Equal(t, "A.fake.ts.net", secret.StringData["name"])
+
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, ServerAddress: ServerAddressMagicDNS})
+	require.NoError(t, err)
+	assert.Equal(t, "https://A.fake.ts.net", secret.StringData["server"])
+
+	assert.Equal(t, "https://[fd7a::1]", ServerURL(tailscale.Device{EnabledRoutes: []string{"fd7a::1/128"}}, ServerAddressSubnet))
+	assert.Equal(t, "https://B.fake.ts.net", ServerURL(tailscale.Device{Name: "B.fake.ts.net", EnabledRoutes: []string{"10.0.0.0/24"}}, ServerAddressSubnet))
+
+	labels, err := RouteLabels.Labels(device)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{LabelDeviceSubnetRouter: "true", LabelDeviceExitNode: "true"}, labels)
+}
+
+func TestBuildDesiredSecret_TLSServerName(t *testing.T) {
+	r := &reconciler{}
+	WithServerName("*", template.Must(template.New("").Parse("{{ .Hostname }}.k8s.example.com")))(r)
+	WithServerName("lb-eu", template.Must(template.New("").Parse("{{ .Hostname }}.eu.k8s.example.com")))(r)
+	WithServerName("tag:lb-us", template.Must(template.New("").Parse("{{ .Hostname }}.us.k8s.example.com")))(r)
+
+	name, err := r.serverName(tailscale.Device{Hostname: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "a.k8s.example.com", name)
+	name, err = r.serverName(tailscale.Device{Hostname: "a", Tags: []string{"tag:lb-us", "tag:lb-eu"}})
+	require.NoError(t, err)
+	assert.Equal(t, "a.eu.k8s.example.com", name, "the first tag in lexical order is used")
+	_, err = r.serverName(tailscale.Device{Hostname: "A_B"})
+	require.Error(t, err)
+
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id"}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, TLSServerName: "a.k8s.example.com"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tlsClientConfig":{"insecure":false,"serverName":"a.k8s.example.com"}}`, secret.StringData["config"])
+
+	// The fleet configuration is kept, unless it sets its own server name.
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, TLSServerName: "a.k8s.example.com", Config: `{"bearerToken":"token","tlsClientConfig":{"insecure":false}}`})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"bearerToken":"token","tlsClientConfig":{"insecure":false,"serverName":"a.k8s.example.com"}}`, secret.StringData["config"])
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, TLSServerName: "a.k8s.example.com", Config: `{"tlsClientConfig":{"serverName":"fleet.example.com"}}`})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tlsClientConfig":{"serverName":"fleet.example.com"}}`, secret.StringData["config"])
+
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, TLSServerName: "a.k8s.example.com", Flavor: FlavorKubeconfig})
+	require.NoError(t, err)
+	kubeconfig, err := clientcmd.Load([]byte(secret.StringData["kubeconfig"]))
+	require.NoError(t, err)
+	assert.Equal(t, "a.k8s.example.com", kubeconfig.Clusters["A.fake.ts.net"].TLSServerName)
+}
+
+func TestBuildDesiredSecret_Connectivity(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", ClientConnectivity: &tailscale.ClientConnectivity{
+		DERPLatency: map[string]tailscale.DERPRegion{"Frankfurt": {Preferred: true, LatencyMilliseconds: 12}},
+	}}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	secret, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Connectivity: true})
+	require.NoError(t, err)
+	assert.Equal(t, "Frankfurt", secret.Annotations[AnnotationDeviceDERPRegion])
+
+	secret, err = BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy})
+	require.NoError(t, err)
+	assert.NotContains(t, secret.Annotations, AnnotationDeviceDERPRegion)
+
+	// The region is removed from the current secret once lost, the other annotations are kept.
+	current := metav1.ObjectMeta{Annotations: map[string]string{AnnotationDeviceDERPRegion: "Frankfurt", "example.com/other": "value"}}
+	device.ClientConnectivity = nil
+	desired, err := BuildDesiredSecret(nn, device, BuildConfig{ManagedBy: managedBy, Connectivity: true})
+	require.NoError(t, err)
+	mergeObjectMeta(&current, desired.ObjectMeta)
+	assert.NotContains(t, current.Annotations, AnnotationDeviceDERPRegion)
+	assert.Equal(t, "value", current.Annotations["example.com/other"])
+}
+
+func TestBuildDesiredSecret_ContentHashTruncated(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", ClientVersion: strings.Repeat("1.2.3-", 20)}
+
+	secret, err := BuildDesiredSecret(namespacedName, device, BuildConfig{
+		ManagedBy:   managedBy,
+		Annotations: map[string]string{"example.com/large": strings.Repeat("a", 300*1024)},
+	})
+	require.NoError(t, err)
+
+	// The hash covers the metadata as written, once truncated to the Kubernetes limits.
+	written := *secret.DeepCopy()
+	assert.NotEmpty(t, GuardObjectMeta(&written.ObjectMeta, secret.Annotations))
+	assert.Equal(t, secret.Annotations[AnnotationContentHash], ContentHash(written.Labels, written.Annotations, written.StringData))
+	assert.True(t, upToDate(written, secret), "the truncated secret must be up to date")
+}
+
+func TestSanitizeTagLabels(t *testing.T) {
+	tags := []string{"tag:k8s", "tag:Team/Platform", "tag:-_-", "tag:" + strings.Repeat("a", 70)}
+
+	assert.Equal(t, []TagLabel{
+		{Tag: "tag:k8s", Key: LabelDeviceTagsPrefix + "k8s"},
+		{Tag: "tag:Team/Platform", Key: LabelDeviceTagsPrefix + "Team-Platform", Action: TagLabelMangled},
+		{Tag: "tag:-_-", Action: TagLabelDropped},
+		{Tag: tags[3], Key: LabelDeviceTagsPrefix + strings.Repeat("a", 63), Action: TagLabelMangled},
+	}, SanitizeTagLabels(tags, TagLabelsLenient))
+
+	assert.Equal(t, []TagLabel{
+		{Tag: "tag:k8s", Key: LabelDeviceTagsPrefix + "k8s"},
+		{Tag: "tag:Team/Platform", Action: TagLabelDropped},
+		{Tag: "tag:-_-", Action: TagLabelDropped},
+		{Tag: tags[3], Action: TagLabelDropped},
+	}, SanitizeTagLabels(tags, TagLabelsStrict))
+}
+
+func TestGuardObjectMeta(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Labels: map[string]string{
+			LabelDeviceOS:      "linux",
+			LabelDeviceVersion: strings.Repeat("1.2.3-", 20),
+		},
+		Annotations: map[string]string{
+			AnnotationDeviceID: "fake-device-id",
+			"managed":          strings.Repeat("a", 200*1024),
+			"unmanaged":        strings.Repeat("b", 100*1024),
+		},
+	}
+
+	warnings := GuardObjectMeta(&meta, map[string]string{AnnotationDeviceID: "", "managed": ""})
+	assert.Equal(t, []string{
+		"label device.tailscale.com/version truncated from 120 characters",
+		"annotation managed truncated from 204800 characters",
+	}, warnings)
+	assert.Equal(t, "linux", meta.Labels[LabelDeviceOS])
+	assert.LessOrEqual(t, len(meta.Labels[LabelDeviceVersion]), 63)
+	assert.Empty(t, validation.IsValidLabelValue(meta.Labels[LabelDeviceVersion]))
+	assert.LessOrEqual(t, len(meta.Annotations["managed"]), 253)
+	assert.Len(t, meta.Annotations["unmanaged"], 100*1024)
+	assert.Equal(t, "fake-device-id", meta.Annotations[AnnotationDeviceID])
+
+	// Values are truncated deterministically.
+	assert.Empty(t, GuardObjectMeta(&meta, nil))
+}
+
+func TestValidateSecretSize(t *testing.T) {
+	secret := corev1.Secret{
+		Data: map[string][]byte{"server": []byte("https://A.fake.ts.net"), "config": []byte("overridden")},
+		StringData: map[string]string{
+			"name":   "A.fake.ts.net",
+			"config": strings.Repeat("a", 2048),
+			"ca":     strings.Repeat("b", 1024),
+		},
+	}
+	assert.Equal(t, 21+13+2048+1024, SecretDataSize(secret))
+	assert.NoError(t, ValidateSecretSize(secret, corev1.MaxSecretSize))
+
+	err := ValidateSecretSize(secret, 3000)
+	assert.EqualError(t, err, "secret data of 3106 bytes exceeds the limit of 3000 bytes (largest entries: config=2048 bytes, ca=1024 bytes, server=21 bytes)")
+}
+
+func TestNewServiceNamer(t *testing.T) {
+	namer, err := NewServiceNamer("")
+	require.NoError(t, err)
+	name, err := namer("A.fake.ts.net")
+	require.NoError(t, err)
+	assert.Equal(t, "a-fake-ts-net", name)
+
+	namer, err = NewServiceNamer("team-a-{{ .Name | dns1035 | trunc 6 }}-{{ .Name | hash 8 }}")
+	require.NoError(t, err)
+	name, err = namer("A.fake.ts.net")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a-a-fake-245bd24b", name)
+
+	namer, err = NewServiceNamer("{{ .Name }}")
+	require.NoError(t, err)
+	_, err = namer("A.fake.ts.net")
+	assert.ErrorContains(t, err, "is not a valid DNS-1035 label")
+
+	namer, err = NewServiceNamer("{{ .Name | hash 100 }}")
+	require.NoError(t, err)
+	_, err = namer("A.fake.ts.net")
+	assert.ErrorContains(t, err, "hash length must be between 1 and 64")
+
+	namer, err = NewServiceNamer("{{ .Device }}")
+	require.NoError(t, err)
+	_, err = namer("A.fake.ts.net")
+	assert.ErrorContains(t, err, "failed to render the service name")
+
+	_, err = NewServiceNamer("{{ .Name")
+	assert.Error(t, err)
+}
+
+func TestSecretRenderer(t *testing.T) {
+	edge, err := ts.NewRegexpTagFilter("edge")
+	require.NoError(t, err)
+	renderer := NewSecretRenderer(managedBy, "argocd",
+		WithSecretNamer(func(device tailscale.Device) string { return device.NodeID }),
+		WithLabeler(LabelerFunc(func(tailscale.Device) (map[string]string, error) { return map[string]string{"env": "prod"}, nil })),
+		WithAnnotations(map[string]string{"argocd.argoproj.io/sync-wave": "-1"}),
+		WithFleets(Fleet{Name: "edge", Match: edge, Namespace: "argocd-edge"}),
+	)
+
+	secret, warnings, err := renderer.Render(tailscale.Device{Name: "A.fake.ts.net", NodeID: "device-a", Addresses: []string{"0.0.0.0"}, Tags: []string{"tag:edge"}})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, types.NamespacedName{Name: "device-a", Namespace: "argocd-edge"}, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace})
+	assert.Equal(t, "prod", secret.Labels["env"])
+	assert.Equal(t, "edge", secret.Labels[LabelFleet])
+	assert.Equal(t, "-1", secret.Annotations["argocd.argoproj.io/sync-wave"])
+	assert.NotContains(t, secret.Annotations, AnnotationLastSyncTime)
+
+	// The secrets exceeding the maximum size are not rendered.
+	_, _, err = NewSecretRenderer(managedBy, "argocd", WithMaxSecretSize(10)).Render(tailscale.Device{Name: "A.fake.ts.net", NodeID: "device-a"})
+	assert.ErrorContains(t, err, "exceeds the limit of 10 bytes")
+}
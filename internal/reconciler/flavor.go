@@ -0,0 +1,106 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+const (
+	// FlavorArgoCD generates ArgoCD cluster secrets.
+	FlavorArgoCD = "argocd"
+	// FlavorFlux generates kubeconfig secrets consumable by Flux (kubeconfig stored in the "value"
+	// entry, as expected by the Kustomization and HelmRelease kubeConfig.secretRef).
+	FlavorFlux = "flux"
+	// FlavorKubeconfig generates plain kubeconfig secrets (kubeconfig stored in the "kubeconfig"
+	// entry), consumable by tools like Cluster API or Karmada.
+	FlavorKubeconfig = "kubeconfig"
+	// FlavorInventory generates inventory secrets: the data of the ArgoCD cluster secrets and the
+	// device metadata (JSON "device" entry), without the ArgoCD secret-type label so that ArgoCD does
+	// not connect to the devices.
+	FlavorInventory = "inventory"
+
+	// KeyInventoryDevice is the entry of the inventory secrets holding the device metadata.
+	KeyInventoryDevice = "device"
+)
+
+// volatileDeviceFields are the device fields changing on their own (e.g. last seen time), left out
+// of the inventory secrets to not update them on every synchronization.
+var volatileDeviceFields = []string{"lastSeen", "connectedToControl", "clientConnectivity", "updateAvailable"}
+
+// WithFlavor configures the kind of secret generated for each device (defaults to FlavorArgoCD).
+func WithFlavor(flavor string) Option {
+	return func(r *reconciler) { r.flavor = flavor }
+}
+
+// flavorData returns the secret data entries required by the given flavor, reaching the device on
+// the given server URL and TLS server name (optional).
+func flavorData(device tailscale.Device, flavor, server, serverName string) (map[string]string, error) {
+	cluster := argocd.ClusterSecret{
+		Name:   device.Name,
+		Server: server,
+		Config: argocd.ClusterConfig{TLSClientConfig: argocd.TLSClientConfig{ServerName: serverName}},
+	}
+
+	switch flavor {
+	case "", FlavorArgoCD:
+		return cluster.Data()
+	case FlavorInventory:
+		data, err := cluster.Data()
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := inventoryMetadata(device)
+		if err != nil {
+			return nil, err
+		}
+		data[KeyInventoryDevice] = metadata
+		return data, nil
+	case FlavorFlux, FlavorKubeconfig:
+		kubeconfig, err := BuildKubeconfig(device, server, serverName)
+		if err != nil {
+			return nil, err
+		}
+		key := "kubeconfig"
+		if flavor == FlavorFlux {
+			key = "value"
+		}
+		return map[string]string{key: string(kubeconfig)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output flavor %q", flavor)
+	}
+}
+
+// inventoryMetadata returns the JSON metadata of the device stored in the inventory secrets.
+func inventoryMetadata(device tailscale.Device) (string, error) {
+	raw, err := json.Marshal(device)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return "", err
+	}
+	for _, field := range volatileDeviceFields {
+		delete(fields, field)
+	}
+	raw, err = json.Marshal(fields)
+	return string(raw), err
+}
+
+// BuildKubeconfig returns a kubeconfig reaching, on the given server URL and TLS server name
+// (optional), the Kubernetes API server exposed by the given device through Tailscale. No
+// credential is included as the identity is provided by Tailscale.
+func BuildKubeconfig(device tailscale.Device, server, serverName string) ([]byte, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[device.Name] = &clientcmdapi.Cluster{Server: server, TLSServerName: serverName}
+	config.AuthInfos["tailscale"] = &clientcmdapi.AuthInfo{}
+	config.Contexts[device.Name] = &clientcmdapi.Context{Cluster: device.Name, AuthInfo: "tailscale"}
+	config.CurrentContext = device.Name
+	return clientcmd.Write(*config)
+}
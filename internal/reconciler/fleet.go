@@ -0,0 +1,139 @@
+package reconciler
+
+import (
+	"maps"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+const (
+	// LabelFleet is the label key of the fleet a device belongs to.
+	LabelFleet = "argotails.chezmoi.sh/fleet"
+
+	// DeletionPolicyDelete deletes the resources of the devices leaving the fleet (default).
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyRetain leaves the resources of the devices leaving the fleet in place; they
+	// are no longer updated and must be deleted manually.
+	DeletionPolicyRetain = "Retain"
+)
+
+// Fleet is a named group of devices sharing the same settings (e.g. edge, core or lab clusters).
+type Fleet struct {
+	// Name is the fleet name, set as LabelFleet label on the device resources.
+	Name string
+	// Match selects the devices belonging to the fleet.
+	Match ts.TagFilter
+	// Namespace is the namespace of the fleet resources (defaults to the controller namespace).
+	Namespace string
+	// DeletionPolicy is what happens to the resources of the devices leaving the fleet:
+	// DeletionPolicyDelete (default) or DeletionPolicyRetain.
+	DeletionPolicy string
+
+	// Labels renders additional labels of the fleet resources (optional).
+	Labels Renderer
+	// ExtraData renders additional secret data entries of the fleet devices (optional); unlike the
+	// controller extra data, its "config" entry overrides the ArgoCD cluster configuration.
+	ExtraData Renderer
+
+	// ProxyClass is the ProxyClass of the fleet services, overriding the controller one.
+	ProxyClass string
+	// ServiceAnnotations are additional annotations of the fleet services.
+	ServiceAnnotations map[string]string
+}
+
+// WithFleets groups the devices into the given fleets; each device belongs to the first fleet
+// matching it, devices matching no fleet keep the controller settings.
+func WithFleets(fleets ...Fleet) Option {
+	return func(r *reconciler) { r.fleets = fleets }
+}
+
+// FleetOf returns the first of the given fleets matching the device, or nil if none matches.
+func FleetOf(fleets []Fleet, device tailscale.Device) *Fleet {
+	for i := range fleets {
+		if fleets[i].Match.Match(device) {
+			return &fleets[i]
+		}
+	}
+	return nil
+}
+
+// FleetNamespace returns the namespace of the resources of the given device: the namespace of its
+// fleet, or the given default one.
+func FleetNamespace(fleets []Fleet, device tailscale.Device, defaultNamespace string) string {
+	if fleet := FleetOf(fleets, device); fleet != nil && fleet.Namespace != "" {
+		return fleet.Namespace
+	}
+	return defaultNamespace
+}
+
+// inFleetNamespace returns true if the resources of the given device belong to the given namespace:
+// the namespace of its current fleet, or the controller namespace. The resources left in the
+// namespace of a previous fleet of the device do not.
+func (r reconciler) inFleetNamespace(device tailscale.Device, namespace string) bool {
+	return FleetNamespace(r.fleets, device, r.serviceConfig.Namespace) == namespace
+}
+
+// fleetBuildConfig applies the settings of the fleet of the given device to the build settings.
+func (r reconciler) fleetBuildConfig(device tailscale.Device, cfg *BuildConfig) error {
+	fleet := FleetOf(r.fleets, device)
+	if fleet == nil {
+		return nil
+	}
+
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	if fleet.ProxyClass != "" {
+		cfg.ProxyClass = fleet.ProxyClass
+	}
+	cfg.ServiceAnnotations = fleet.ServiceAnnotations
+
+	if fleet.Labels != nil {
+		labels, err := fleet.Labels.Render(device)
+		if err != nil {
+			return err
+		}
+		maps.Copy(cfg.Labels, labels)
+	}
+	// Set after the fleet labels, which cannot move the device resources to another fleet
+	cfg.Labels[LabelFleet] = fleet.Name
+	if fleet.ExtraData != nil {
+		data, err := fleet.ExtraData.Render(device)
+		if err != nil {
+			return err
+		}
+		if config, exists := data[argocd.KeyConfig]; exists {
+			if _, err := argocd.ParseClusterConfig(config); err != nil {
+				return err
+			}
+			cfg.Config = config
+			delete(data, argocd.KeyConfig)
+		}
+
+		if cfg.ExtraData == nil {
+			cfg.ExtraData = map[string]string{}
+		}
+		maps.Copy(cfg.ExtraData, data)
+	}
+	return nil
+}
+
+// retainingFleet returns the fleet retaining the resources of the given secret instead of deleting
+// them, i.e. the fleet of the secret if it has the DeletionPolicyRetain policy and the secret has
+// not expired; empty otherwise.
+func (r reconciler) retainingFleet(secret corev1.Secret) string {
+	if len(r.fleets) == 0 || r.expired(secret, time.Now()) {
+		return ""
+	}
+	for _, fleet := range r.fleets {
+		if fleet.Name == secret.Labels[LabelFleet] && fleet.DeletionPolicy == DeletionPolicyRetain {
+			return fleet.Name
+		}
+	}
+	return ""
+}
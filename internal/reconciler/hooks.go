@@ -0,0 +1,47 @@
+package reconciler
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// Hooks lets embedders mutate or veto the secrets written by the reconciler. The "before" hooks
+// are called with the secret about to be written and may modify it; returning an error vetoes the
+// operation, which fails with this error and is retried later. The "after" hooks are called once
+// the operation succeeded and their errors are reported as reconciliation failures.
+type Hooks interface {
+	OnBeforeCreate(ctx context.Context, device tailscale.Device, secret *corev1.Secret) error
+	OnAfterCreate(ctx context.Context, device tailscale.Device, secret *corev1.Secret) error
+	OnBeforeUpdate(ctx context.Context, device tailscale.Device, secret *corev1.Secret) error
+	OnAfterUpdate(ctx context.Context, device tailscale.Device, secret *corev1.Secret) error
+	// OnBeforeDelete and OnAfterDelete are called without device, as it may no longer exist.
+	OnBeforeDelete(ctx context.Context, secret *corev1.Secret) error
+	OnAfterDelete(ctx context.Context, secret *corev1.Secret) error
+}
+
+// NopHooks implements Hooks without doing anything; it can be embedded to only implement some hooks.
+type NopHooks struct{}
+
+func (NopHooks) OnBeforeCreate(context.Context, tailscale.Device, *corev1.Secret) error { return nil }
+func (NopHooks) OnAfterCreate(context.Context, tailscale.Device, *corev1.Secret) error  { return nil }
+func (NopHooks) OnBeforeUpdate(context.Context, tailscale.Device, *corev1.Secret) error { return nil }
+func (NopHooks) OnAfterUpdate(context.Context, tailscale.Device, *corev1.Secret) error  { return nil }
+func (NopHooks) OnBeforeDelete(context.Context, *corev1.Secret) error                   { return nil }
+func (NopHooks) OnAfterDelete(context.Context, *corev1.Secret) error                    { return nil }
+
+// WithHooks registers hooks called around the secret operations, in the given order.
+func WithHooks(hooks ...Hooks) Option {
+	return func(r *reconciler) { r.hooks = append(r.hooks, hooks...) }
+}
+
+// runHooks calls the given hook of every registered Hooks, stopping at the first error.
+func (r reconciler) runHooks(call func(Hooks) error) error {
+	for _, hooks := range r.hooks {
+		if err := call(hooks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
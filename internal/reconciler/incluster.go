@@ -0,0 +1,136 @@
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+const (
+	// InClusterName is the name of the ArgoCD cluster where ArgoCD itself runs.
+	InClusterName = "in-cluster"
+	// InClusterServer is the URL of the Kubernetes API server of the cluster where ArgoCD runs.
+	InClusterServer = "https://kubernetes.default.svc"
+	// LabelInCluster is the label flagging the in-cluster secret, i.e. the hub cluster.
+	LabelInCluster = "argotails.chezmoi.sh/in-cluster"
+)
+
+// inClusterReconciler manages the ArgoCD in-cluster secret, so that the cluster where ArgoCD runs
+// is declared with the same labels as the Tailscale devices, and forwards every other request.
+type inClusterReconciler struct {
+	next   reconcile.TypedReconciler[reconcile.Request]
+	ks     client.Client
+	reader client.Reader
+	secret types.NamespacedName
+	cfg    BuildConfig
+	pause  *PauseSwitch
+}
+
+// NewInClusterReconciler creates a reconciler maintaining the in-cluster secret with the given name
+// (built from the given configuration labels and data metadata) and forwarding the requests of the
+// other secrets to the given reconciler. The in-cluster secret is read through the given reader
+// and never deleted; an existing secret not managed by the controller is left untouched, as is the
+// secret while the given pause switch (optional) is paused.
+func NewInClusterReconciler(next reconcile.TypedReconciler[reconcile.Request], ks client.Client, reader client.Reader, secret types.NamespacedName, cfg BuildConfig, pause *PauseSwitch) reconcile.TypedReconciler[reconcile.Request] {
+	return inClusterReconciler{next: next, ks: ks, reader: reader, secret: secret, cfg: cfg, pause: pause}
+}
+
+// Reconcile reconciles the in-cluster secret or forwards the request.
+func (r inClusterReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if req.NamespacedName != r.secret {
+		return r.next.Reconcile(ctx, req)
+	}
+
+	log := ctrllog.FromContext(ctx).WithName("in_cluster").WithValues("secret", r.secret)
+	if r.pause.Paused() {
+		log.V(1).Info("Mutations are paused, in-cluster secret left untouched", "reconciliation.outcome", "paused")
+		return reconcile.Result{}, nil
+	}
+	desired, err := BuildInClusterSecret(r.secret, r.cfg)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	var current corev1.Secret
+	err = r.reader.Get(ctx, r.secret, &current)
+	switch {
+	case errors.IsNotFound(err):
+		log.V(1).Info("Create in-cluster secret")
+		return reconcile.Result{}, r.ks.Create(ctx, &desired)
+	case err != nil:
+		return reconcile.Result{Requeue: true}, err
+	case current.Labels["apps.kubernetes.io/managed-by"] != r.cfg.ManagedBy:
+		log.Error(nil, "In-cluster secret already exists and is not managed by this controller, leaving it untouched")
+		return reconcile.Result{}, nil
+	case upToDate(current, desired):
+		log.V(3).Info("In-cluster secret is up to date")
+		return reconcile.Result{}, nil
+	}
+
+	log.V(1).Info("Update in-cluster secret")
+	current.Labels = desired.Labels
+	current.Annotations = desired.Annotations
+	current.Data = nil
+	current.StringData = desired.StringData
+	if err := r.ks.Update(ctx, &current); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// BuildInClusterSecret returns the ArgoCD cluster secret declaring the cluster where ArgoCD runs,
+// labeled as the secrets of the Tailscale devices.
+func BuildInClusterSecret(namespacedName types.NamespacedName, cfg BuildConfig) (corev1.Secret, error) {
+	data, err := argocd.ClusterSecret{Name: InClusterName, Server: InClusterServer}.Data()
+	if err != nil {
+		return corev1.Secret{}, err
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespacedName.Name,
+			Namespace:   namespacedName.Namespace,
+			Annotations: map[string]string{},
+			Labels: map[string]string{
+				argocd.LabelSecretType:          argocd.SecretTypeCluster,
+				"apps.kubernetes.io/managed-by": cfg.ManagedBy,
+				LabelInCluster:                  "true",
+			},
+		},
+		StringData: data,
+	}
+	for key := range cfg.Labels {
+		if _, reserved := secret.Labels[key]; reserved {
+			return corev1.Secret{}, fmt.Errorf("in-cluster label %q cannot be overridden", key)
+		}
+	}
+	maps.Copy(secret.Labels, cfg.Labels)
+	for key, value := range cfg.Annotations {
+		if !IsReservedAnnotation(key) {
+			secret.Annotations[key] = value
+		}
+	}
+
+	if len(cfg.DataMetadata.Labels) > 0 {
+		raw, _ := json.Marshal(selectMetadata(secret.Labels, cfg.DataMetadata.Labels))
+		secret.StringData["labels"] = string(raw)
+	}
+	if len(cfg.DataMetadata.Annotations) > 0 {
+		raw, _ := json.Marshal(selectMetadata(secret.Annotations, cfg.DataMetadata.Annotations))
+		secret.StringData["annotations"] = string(raw)
+	}
+
+	stampContentHash(&secret)
+	return secret, nil
+}
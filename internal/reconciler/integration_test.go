@@ -0,0 +1,136 @@
+package reconciler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	"github.com/chezmoidotsh/argotails/internal/tailscaletest"
+)
+
+const integrationManagedBy = "argotails"
+
+// newIntegrationReconciler returns a reconciler of the devices of the given fake Tailscale server
+// having the "prod" tag, writing into a fake Kubernetes client.
+func newIntegrationReconciler(t *testing.T, srv *tailscaletest.Server) (reconcile.TypedReconciler[reconcile.Request], client.Client, *tailscale.Client) {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	ks := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	ts, err := srv.Client()
+	require.NoError(t, err)
+	filter, err := tsutils.NewRegexpTagFilter("prod")
+	require.NoError(t, err)
+
+	r, err := reconciler.NewReconciler(ks, tsutils.NewDeviceAPI(ts), filter, integrationManagedBy, reconciler.ServiceConfig{Namespace: "argocd"})
+	require.NoError(t, err)
+	return r, ks, ts
+}
+
+// secretNames returns the names of the secrets managed by the reconciler.
+func secretNames(t *testing.T, ks client.Client) []string {
+	t.Helper()
+
+	var secrets corev1.SecretList
+	require.NoError(t, ks.List(context.Background(), &secrets, client.MatchingLabels{"apps.kubernetes.io/managed-by": integrationManagedBy}))
+	names := make([]string, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestIntegration_Webhook follows a device timeline the way the webhook loop does: every signed
+// webhook event reconciles the device it is about.
+func TestIntegration_Webhook(t *testing.T) {
+	srv := tailscaletest.NewServer("example.ts.net")
+	defer srv.Close()
+	r, ks, _ := newIntegrationReconciler(t, srv)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var events []tsutils.WebhookEvent
+		if err := tsutils.VerifyWebhookSignature(req.Context(), req, "secret", tsutils.DefaultWebhookClockSkew, &events); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		for _, event := range events {
+			ctx := context.Background()
+			if event.Type == string(tailscale.WebhookNodeDeleted) {
+				ctx = reconciler.DeviceDeletedContext(ctx)
+			}
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Name: event.Data.DeviceName, Namespace: "argocd"}}
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}))
+	defer webhook.Close()
+	srv.SetWebhook(webhook.URL, "secret")
+
+	srv.Script(
+		tailscaletest.At(1*time.Minute, tailscaletest.AddDevice(tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Hostname: "a", Tags: []string{"tag:prod"}})),
+		tailscaletest.At(1*time.Minute, tailscaletest.AddDevice(tailscale.Device{NodeID: "n2", Name: "b.example.ts.net", Hostname: "b", Tags: []string{"tag:dev"}})),
+		tailscaletest.At(2*time.Minute, tailscaletest.DeleteDevice("n1")),
+	)
+
+	require.NoError(t, srv.Advance(time.Minute))
+	assert.Equal(t, []string{"a.example.ts.net"}, secretNames(t, ks))
+
+	require.NoError(t, srv.Advance(time.Minute))
+	assert.Empty(t, secretNames(t, ks))
+
+	// Events signed with another secret are rejected
+	srv.SetWebhook(webhook.URL, "other")
+	err := srv.Emit(tsutils.WebhookEvent{Type: string(tailscale.WebhookNodeCreated), Data: &tsutils.WebhookEventData{DeviceName: "b.example.ts.net"}})
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+// TestIntegration_DeviceAPI reconciles the devices of an in-memory device API, without any
+// Tailscale API server.
+func TestIntegration_DeviceAPI(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	ks := fake.NewClientBuilder().WithScheme(scheme).Build()
+	api := tailscaletest.NewDeviceAPI(tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Hostname: "a", Tags: []string{"tag:prod"}})
+	filter, err := tsutils.NewRegexpTagFilter("prod")
+	require.NoError(t, err)
+	r, err := reconciler.NewReconciler(ks, api, filter, integrationManagedBy, reconciler.ServiceConfig{Namespace: "argocd"})
+	require.NoError(t, err)
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "a.example.ts.net", Namespace: "argocd"}}
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.example.ts.net"}, secretNames(t, ks))
+
+	// The secret is kept while the device API is unavailable.
+	api.SetError(errors.New("unavailable"))
+	_, err = r.Reconcile(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, []string{"a.example.ts.net"}, secretNames(t, ks))
+
+	api.SetError(nil)
+	api.SetDevices()
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, secretNames(t, ks))
+}
@@ -0,0 +1,148 @@
+package reconciler
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+)
+
+const (
+	// TagLabelsLenient replaces the characters of the device tags invalid in label keys, so that
+	// every tag is represented by a label.
+	TagLabelsLenient = "lenient"
+	// TagLabelsStrict drops the device tags that are not valid label keys as is.
+	TagLabelsStrict = "strict"
+
+	// TagLabelMangled is the action reported for a tag whose label key has been sanitized.
+	TagLabelMangled = "mangled"
+	// TagLabelDropped is the action reported for a tag not represented by any label.
+	TagLabelDropped = "dropped"
+)
+
+var (
+	// rxInvalidLabelChars matches the characters not allowed in the name part of a label key.
+	rxInvalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+	tagLabelsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argotails_device_tag_labels_sanitized_total",
+		Help: "Number of device tags whose label has been mangled or dropped because the tag is not a valid label key.",
+	}, []string{"action"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(tagLabelsTotal)
+}
+
+// TagLabel is the label representing a device tag.
+type TagLabel struct {
+	// Tag is the device tag (e.g. "tag:k8s").
+	Tag string
+	// Key is the label key, empty when the tag has been dropped.
+	Key string
+	// Action is TagLabelMangled or TagLabelDropped when the tag is not a valid label key, empty otherwise.
+	Action string
+}
+
+// WithTagLabelMode configures how the device tags that are not valid label keys are handled:
+// TagLabelsLenient (default) or TagLabelsStrict.
+func WithTagLabelMode(mode string) Option {
+	return func(r *reconciler) { r.tagLabelMode = mode }
+}
+
+// SanitizeTagLabels returns the labels representing the given device tags. Tags are mapped to the
+// "tag.device.tailscale.com/<name>" keys, <name> being the tag without its "tag:" prefix. When
+// <name> is not a valid label name, it is either dropped (TagLabelsStrict) or sanitized
+// (TagLabelsLenient): sequences of characters other than alphanumerics, '-', '_' and '.' are
+// replaced by a single '-', leading and trailing non-alphanumeric characters are trimmed and the
+// name is truncated to 63 characters. Names left empty are dropped.
+func SanitizeTagLabels(tags []string, mode string) []TagLabel {
+	labels := make([]TagLabel, 0, len(tags))
+	for _, tag := range tags {
+		name := strings.TrimPrefix(tag, "tag:")
+		if len(validation.IsQualifiedName(LabelDeviceTagsPrefix+name)) == 0 {
+			labels = append(labels, TagLabel{Tag: tag, Key: LabelDeviceTagsPrefix + name})
+			continue
+		}
+		if mode == TagLabelsStrict {
+			labels = append(labels, TagLabel{Tag: tag, Action: TagLabelDropped})
+			continue
+		}
+
+		name = rxInvalidLabelChars.ReplaceAllString(name, "-")
+		name = strings.Trim(name, "-_.")
+		if len(name) > validation.LabelValueMaxLength {
+			name = strings.TrimRight(name[:validation.LabelValueMaxLength], "-_.")
+		}
+		if name == "" {
+			labels = append(labels, TagLabel{Tag: tag, Action: TagLabelDropped})
+			continue
+		}
+		labels = append(labels, TagLabel{Tag: tag, Key: LabelDeviceTagsPrefix + name, Action: TagLabelMangled})
+	}
+	return labels
+}
+
+// reportTagLabels reports the device tags that have been mangled or dropped, as metric and as
+// Kubernetes event.
+func (r reconciler) reportTagLabels(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) {
+	for _, label := range SanitizeTagLabels(device.Tags, r.tagLabelMode) {
+		switch label.Action {
+		case TagLabelMangled:
+			tagLabelsTotal.WithLabelValues(TagLabelMangled).Inc()
+			r.event(ctx, namespacedName, corev1.EventTypeWarning, "InvalidTagLabel", "Tailscale device tag %s is not a valid label key, labeled as %s", label.Tag, label.Key)
+		case TagLabelDropped:
+			tagLabelsTotal.WithLabelValues(TagLabelDropped).Inc()
+			r.event(ctx, namespacedName, corev1.EventTypeWarning, "InvalidTagLabel", "Tailscale device tag %s is not a valid label key, not labeled", label.Tag)
+		}
+	}
+}
+
+// DataMetadata selects the labels and annotations of the ArgoCD cluster secrets that are also
+// written into their data, as JSON objects in the "labels" and "annotations" entries, for the
+// consumers reading the cluster metadata from the data (e.g. ApplicationSet templates). A key
+// ending with '*' selects every key having the given prefix (e.g. "tag.device.tailscale.com/*").
+type DataMetadata struct {
+	Labels      []string
+	Annotations []string
+}
+
+// WithDataMetadata writes the selected labels and annotations into the data of every ArgoCD cluster secret.
+func WithDataMetadata(selection DataMetadata) Option {
+	return func(r *reconciler) { r.dataMetadata = selection }
+}
+
+// WithAnnotations adds the given annotations to every secret (e.g. the ArgoCD
+// "argocd.argoproj.io/sync-wave" annotation, so that the GitOps-rendered secrets are applied in
+// order by the bootstrap pipelines); they cannot override the annotations written by Argotails.
+// The annotations removed from the configuration are removed from the secrets too.
+func WithAnnotations(annotations map[string]string) Option {
+	return func(r *reconciler) { r.annotations = annotations }
+}
+
+// IsReservedAnnotation returns true if the given annotation key belongs to the prefixes written by
+// Argotails ("argotails.chezmoi.sh/" and "device.tailscale.com/"), which cannot be set by WithAnnotations.
+func IsReservedAnnotation(key string) bool {
+	return strings.HasPrefix(key, "argotails.chezmoi.sh/") || strings.HasPrefix(key, "device.tailscale.com/")
+}
+
+// selectMetadata returns the entries of the given labels or annotations selected by the given keys.
+func selectMetadata(metadata map[string]string, keys []string) map[string]string {
+	selected := map[string]string{}
+	for key, value := range metadata {
+		for _, selector := range keys {
+			prefix, isPrefix := strings.CutSuffix(selector, "*")
+			if key == selector || (isPrefix && strings.HasPrefix(key, prefix)) {
+				selected[key] = value
+				break
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,164 @@
+package reconciler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// secretDataSize records the size of the data of the secrets written by the reconciler.
+var secretDataSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "argotails_secret_data_size_bytes",
+	Help:    "Size of the data of the secrets written by the reconciler, limited to 1MiB by Kubernetes.",
+	Buckets: prometheus.ExponentialBuckets(1024, 4, 6),
+})
+
+func init() {
+	metrics.Registry.MustRegister(secretDataSize)
+}
+
+// maxGuardedAnnotationLength is the length to which the over-long annotations managed by the
+// controller are truncated when the annotations exceed the Kubernetes total size limit.
+const maxGuardedAnnotationLength = 253
+
+// truncateWithHash truncates the given value to limit characters, replacing its end by a hash of the
+// whole value so that distinct values remain distinct. Label values must start and end with an
+// alphanumeric character, which the hash guarantees.
+func truncateWithHash(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	hash := hex.EncodeToString(sum[:])[:10]
+	return strings.TrimRight(value[:limit-len(hash)-1], "-_.") + "-" + hash
+}
+
+// GuardObjectMeta truncates the label values longer than 63 characters and, when the annotations
+// exceed the Kubernetes total size limit (256KiB), the longest annotations among the given managed
+// keys, so that the object is not rejected by the API server. It returns a description of every
+// truncated value.
+func GuardObjectMeta(meta *metav1.ObjectMeta, managedAnnotations map[string]string) []string {
+	var warnings []string
+
+	keys := make([]string, 0, len(meta.Labels))
+	for key := range meta.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := meta.Labels[key]; len(value) > validation.LabelValueMaxLength {
+			meta.Labels[key] = truncateWithHash(value, validation.LabelValueMaxLength)
+			warnings = append(warnings, fmt.Sprintf("label %s truncated from %d characters", key, len(value)))
+		}
+	}
+
+	if apivalidation.ValidateAnnotationsSize(meta.Annotations) == nil {
+		return warnings
+	}
+	keys = keys[:0]
+	for key := range managedAnnotations {
+		if len(meta.Annotations[key]) > maxGuardedAnnotationLength {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(meta.Annotations[keys[i]]) > len(meta.Annotations[keys[j]]) })
+	for _, key := range keys {
+		value := meta.Annotations[key]
+		meta.Annotations[key] = truncateWithHash(value, maxGuardedAnnotationLength)
+		warnings = append(warnings, fmt.Sprintf("annotation %s truncated from %d characters", key, len(value)))
+		if apivalidation.ValidateAnnotationsSize(meta.Annotations) == nil {
+			break
+		}
+	}
+	return warnings
+}
+
+// guardObjectMeta guards the metadata of an object about to be written and reports the truncated
+// values as a warning event.
+func (r reconciler) guardObjectMeta(ctx context.Context, namespacedName types.NamespacedName, meta *metav1.ObjectMeta, managedAnnotations map[string]string) {
+	warnings := GuardObjectMeta(meta, managedAnnotations)
+	if len(warnings) > 0 {
+		r.event(ctx, namespacedName, corev1.EventTypeWarning, "MetadataTruncated", "Metadata exceeding Kubernetes limits: %s", strings.Join(warnings, "; "))
+	}
+}
+
+// WithMaxSecretSize sets the maximum size, in bytes, of the secret data written by the reconciler
+// (defaults to the Kubernetes limit, corev1.MaxSecretSize).
+func WithMaxSecretSize(size int) Option {
+	return func(r *reconciler) { r.maxSecretSize = size }
+}
+
+// SecretDataSize returns the size of the data of the given secret, as counted by the Kubernetes
+// limit: the sum of the value lengths, the StringData entries overriding the Data ones.
+func SecretDataSize(secret corev1.Secret) int {
+	size := 0
+	for key, value := range secret.Data {
+		if _, overridden := secret.StringData[key]; !overridden {
+			size += len(value)
+		}
+	}
+	for _, value := range secret.StringData {
+		size += len(value)
+	}
+	return size
+}
+
+// ValidateSecretSize returns an error naming the largest entries when the data of the given secret
+// exceeds the given limit (e.g. because of embedded CA bundles or exec configurations).
+func ValidateSecretSize(secret corev1.Secret, limit int) error {
+	size := SecretDataSize(secret)
+	if size <= limit {
+		return nil
+	}
+
+	sizes := map[string]int{}
+	for key, value := range secret.Data {
+		sizes[key] = len(value)
+	}
+	for key, value := range secret.StringData {
+		sizes[key] = len(value)
+	}
+	keys := make([]string, 0, len(sizes))
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if sizes[keys[i]] != sizes[keys[j]] {
+			return sizes[keys[i]] > sizes[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	largest := make([]string, 0, 3)
+	for _, key := range keys[:min(3, len(keys))] {
+		largest = append(largest, fmt.Sprintf("%s=%d bytes", key, sizes[key]))
+	}
+	return fmt.Errorf("secret data of %d bytes exceeds the limit of %d bytes (largest entries: %s)", size, limit, strings.Join(largest, ", "))
+}
+
+// guardSecretSize records the size of a secret about to be written and refuses, with a warning
+// event, the secrets exceeding the maximum size instead of letting the API server reject them.
+func (r reconciler) guardSecretSize(ctx context.Context, namespacedName types.NamespacedName, secret corev1.Secret) error {
+	secretDataSize.Observe(float64(SecretDataSize(secret)))
+
+	limit := r.maxSecretSize
+	if limit <= 0 {
+		limit = corev1.MaxSecretSize
+	}
+	if err := ValidateSecretSize(secret, limit); err != nil {
+		r.event(ctx, namespacedName, corev1.EventTypeWarning, "SecretTooLarge", "%s", err)
+		return err
+	}
+	return nil
+}
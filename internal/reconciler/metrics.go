@@ -0,0 +1,45 @@
+package reconciler
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ActionListDevices is the listing of the Tailscale devices, done by every reconciliation.
+	ActionListDevices = "list_devices"
+	// ActionCreateSecret, ActionUpdateSecret, ActionAdoptSecret and ActionDeleteSecret are the
+	// operations on the device secrets.
+	ActionCreateSecret = "create_secret"
+	ActionUpdateSecret = "update_secret"
+	ActionAdoptSecret  = "adopt_secret"
+	ActionDeleteSecret = "delete_secret"
+	// ActionCreateService, ActionUpdateService, ActionAdoptService and ActionDeleteService are the
+	// operations on the device services.
+	ActionCreateService = "create_service"
+	ActionUpdateService = "update_service"
+	ActionAdoptService  = "adopt_service"
+	ActionDeleteService = "delete_service"
+)
+
+var actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "argotails_reconciler_action_duration_seconds",
+	Help:    "Duration of the reconciler actions: listing the Tailscale devices and writing the Kubernetes resources of a device.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"action", "outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(actionDuration)
+}
+
+// observeAction records the duration of the given action started at the given time, with the
+// outcome of the error it returned (meant to be deferred with the named error result).
+func observeAction(action string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "error"
+	}
+	actionDuration.WithLabelValues(action, outcome).Observe(time.Since(start).Seconds())
+}
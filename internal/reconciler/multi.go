@@ -0,0 +1,100 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// multiReconciler fans a reconciliation request out to several reconcilers, one per Kubernetes
+// cluster where the Tailscale devices' secrets must be written.
+type multiReconciler []reconcile.TypedReconciler[reconcile.Request]
+
+// NewMultiReconciler creates a reconciler that forwards every request to all the given reconcilers.
+// All reconcilers are always called, even if one of them fails.
+func NewMultiReconciler(reconcilers ...reconcile.TypedReconciler[reconcile.Request]) reconcile.TypedReconciler[reconcile.Request] {
+	if len(reconcilers) == 1 {
+		return reconcilers[0]
+	}
+	return multiReconciler(reconcilers)
+}
+
+// sharedDevicesKey is the context key of the Tailscale devices shared by the targets.
+type sharedDevicesKey struct{}
+
+// sharedDevices are the Tailscale devices listed once for all the targets reconciling a request.
+type sharedDevices struct {
+	once    sync.Once
+	devices []tailscale.Device
+	err     error
+}
+
+// withSharedDevices returns a context whose reconciliations list the Tailscale devices only once.
+func withSharedDevices(ctx context.Context) context.Context {
+	if _, shared := ctx.Value(sharedDevicesKey{}).(*sharedDevices); shared {
+		return ctx
+	}
+	return context.WithValue(ctx, sharedDevicesKey{}, &sharedDevices{})
+}
+
+// Reconcile reconciles the request on all targets and aggregates their results. The Tailscale
+// devices are listed once for all targets.
+func (m multiReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	ctx = withSharedDevices(ctx)
+
+	var result reconcile.Result
+	var errs *multierror.Error
+	for i, r := range m {
+		res, err := r.Reconcile(ctrllog.IntoContext(ctx, log.WithValues("target", i)), req)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+
+		result.Requeue = result.Requeue || res.Requeue
+		if res.RequeueAfter > 0 && (result.RequeueAfter == 0 || res.RequeueAfter < result.RequeueAfter) {
+			result.RequeueAfter = res.RequeueAfter
+		}
+	}
+	return result, errs.ErrorOrNil()
+}
+
+// Plan plans the changes of the request on all targets.
+func (m multiReconciler) Plan(ctx context.Context, req reconcile.Request, devices []tailscale.Device) ([]Change, error) {
+	var changes []Change
+	for i, r := range m {
+		planner, ok := r.(Planner)
+		if !ok {
+			return nil, fmt.Errorf("target %d cannot plan its changes", i)
+		}
+		planned, err := planner.Plan(ctx, req, devices)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, planned...)
+	}
+	return changes, nil
+}
+
+// ListSecrets lists the managed secrets of all targets.
+func (m multiReconciler) ListSecrets(ctx context.Context) ([]corev1.Secret, error) {
+	var secrets []corev1.Secret
+	for i, r := range m {
+		lister, ok := r.(SecretLister)
+		if !ok {
+			return nil, fmt.Errorf("target %d cannot list its secrets", i)
+		}
+		listed, err := lister.ListSecrets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, listed...)
+	}
+	return secrets, nil
+}
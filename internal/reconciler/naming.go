@@ -0,0 +1,136 @@
+package reconciler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"tailscale.com/client/tailscale/v2"
+)
+
+const (
+	// SecretNamingDeviceName names secrets after the device MagicDNS name.
+	SecretNamingDeviceName = "device-name"
+	// SecretNamingDeviceNameNodeID names secrets after the device MagicDNS name suffixed by its
+	// node ID, so that devices sharing the same name never share the same secret.
+	SecretNamingDeviceNameNodeID = "device-name-node-id"
+	// SecretNamingNodeID names secrets after the device node ID only, which never changes: renaming a
+	// device updates its secret in place instead of replacing it. The device name is still available
+	// in the secret data.
+	SecretNamingNodeID = "node-id"
+)
+
+type (
+	// SecretNamer returns the name of the secret of a Tailscale device.
+	SecretNamer func(device tailscale.Device) string
+
+	// ServiceNamer returns the name of the service of a Tailscale device from the name of its
+	// secret. It only depends on the secret name so that the service of a deleted device, whose
+	// metadata are no longer known, can still be found.
+	ServiceNamer func(secretName string) (string, error)
+
+	// ServiceNameData is the data of the service name templates.
+	ServiceNameData struct {
+		// Name is the name of the secret of the device.
+		Name string
+	}
+)
+
+// NameFuncs are the sanitizers available inside service name templates, in addition to the Go
+// template builtin functions.
+var NameFuncs = template.FuncMap{
+	// dns1035 converts a name to a DNS-1035 label, as done for the default service names.
+	"dns1035": toDNS1035Name,
+	// trunc keeps the first n characters of a name.
+	"trunc": func(n int, s string) string {
+		if n < 0 || len(s) <= n {
+			return s
+		}
+		return s[:n]
+	},
+	// hash returns the first n hexadecimal characters of the SHA-256 of a name, to keep the
+	// truncated names unique.
+	"hash": func(n int, s string) (string, error) {
+		sum := sha256.Sum256([]byte(s))
+		hash := hex.EncodeToString(sum[:])
+		if n <= 0 || n > len(hash) {
+			return "", fmt.Errorf("hash length must be between 1 and %d, got %d", len(hash), n)
+		}
+		return hash[:n], nil
+	},
+	"lower":      strings.ToLower,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// NewSecretNamer returns the secret namer implementing the given naming strategy.
+func NewSecretNamer(strategy string) (SecretNamer, error) {
+	switch strategy {
+	case "", SecretNamingDeviceName:
+		return func(device tailscale.Device) string { return device.Name }, nil
+	case SecretNamingDeviceNameNodeID:
+		return func(device tailscale.Device) string {
+			return device.Name + "-" + strings.ToLower(device.NodeID)
+		}, nil
+	case SecretNamingNodeID:
+		return func(device tailscale.Device) string {
+			return "ts-" + strings.ToLower(device.NodeID)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret naming strategy %q", strategy)
+	}
+}
+
+// WithSecretNamer names the secrets of the devices using the given namer (defaults to the device
+// MagicDNS name).
+func WithSecretNamer(namer SecretNamer) Option {
+	return func(r *reconciler) { r.secretName = namer }
+}
+
+// NewServiceNamer returns the service namer rendering the given Go template over ServiceNameData
+// (e.g. 'team-a-{{ .Name | dns1035 | trunc 40 }}-{{ .Name | hash 8 }}'); the rendered names must be
+// valid DNS-1035 labels. An empty template names the services after the DNS-1035 form of the
+// secret names.
+func NewServiceNamer(expr string) (ServiceNamer, error) {
+	if expr == "" {
+		return func(secretName string) (string, error) { return toDNS1035Name(secretName), nil }, nil
+	}
+
+	tmpl, err := template.New("service-name").Option("missingkey=error").Funcs(NameFuncs).Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service name template: %w", err)
+	}
+	return func(secretName string) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, ServiceNameData{Name: secretName}); err != nil {
+			return "", fmt.Errorf("failed to render the service name of secret %q: %w", secretName, err)
+		}
+		name := strings.TrimSpace(buf.String())
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return "", fmt.Errorf("service name %q of secret %q is not a valid DNS-1035 label: %s", name, secretName, strings.Join(errs, "; "))
+		}
+		return name, nil
+	}, nil
+}
+
+// WithServiceNamer names the services of the devices using the given namer (defaults to the
+// DNS-1035 form of the secret name).
+func WithServiceNamer(namer ServiceNamer) Option {
+	return func(r *reconciler) { r.serviceName = namer }
+}
+
+// serviceNamespacedName returns the namespaced name of the service of the device whose secret has
+// the given namespaced name.
+func (r reconciler) serviceNamespacedName(namespacedName types.NamespacedName) (types.NamespacedName, error) {
+	name, err := r.serviceName(namespacedName.Name)
+	if err != nil {
+		return types.NamespacedName{}, err
+	}
+	return types.NamespacedName{Name: name, Namespace: namespacedName.Namespace}, nil
+}
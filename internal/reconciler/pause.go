@@ -0,0 +1,78 @@
+package reconciler
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var pausedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "argotails_paused",
+	Help: "Whether the mutations of the Kubernetes resources are paused (1) or not (0).",
+})
+
+func init() {
+	metrics.Registry.MustRegister(pausedGauge)
+}
+
+// Sources of the pauses.
+const (
+	// PauseSourceConfigMap is the source of the pauses of the pause ConfigMap.
+	PauseSourceConfigMap = "configmap"
+	// PauseSourceAdmin is the source of the pauses requested through the admin API.
+	PauseSourceAdmin = "admin"
+)
+
+// PauseSwitch pauses the mutations of the reconcilers sharing it (e.g. during a maintenance
+// window); the Tailscale devices are still listed, but no resource is created, updated or deleted.
+// The mutations are paused as long as any of its sources pauses them, so that a source never
+// resumes the mutations paused by another one. It is safe for concurrent use.
+type PauseSwitch struct {
+	mu      sync.Mutex
+	sources map[string]bool
+	paused  atomic.Bool
+}
+
+// Set pauses or resumes the mutations on behalf of the default source.
+func (p *PauseSwitch) Set(paused bool) { p.SetBy("", paused) }
+
+// SetBy pauses or resumes the mutations on behalf of the given source (e.g. PauseSourceAdmin).
+func (p *PauseSwitch) SetBy(source string, paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sources == nil {
+		p.sources = map[string]bool{}
+	}
+	p.sources[source] = paused
+
+	paused = false
+	for _, pausedBy := range p.sources {
+		paused = paused || pausedBy
+	}
+	p.paused.Store(paused)
+	if paused {
+		pausedGauge.Set(1)
+	} else {
+		pausedGauge.Set(0)
+	}
+}
+
+// PausedBy returns true if the given source pauses the mutations.
+func (p *PauseSwitch) PausedBy(source string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sources[source]
+}
+
+// Paused returns true if the mutations are paused.
+func (p *PauseSwitch) Paused() bool { return p != nil && p.paused.Load() }
+
+// WithPauseSwitch makes the reconciler skip every mutation while the given switch is paused.
+func WithPauseSwitch(pause *PauseSwitch) Option {
+	return func(r *reconciler) { r.pause = pause }
+}
@@ -0,0 +1,314 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+
+	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+const (
+	// PlanCreate, PlanUpdate and PlanDelete are the changes planned on a secret.
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanDelete PlanAction = "delete"
+	// PlanKeep leaves a secret untouched, as it is up to date or must not be written (e.g. paused
+	// mutations, retained by its fleet).
+	PlanKeep PlanAction = "keep"
+)
+
+// decision is what the reconciliation of a secret does, the PlanAction being its effect on the
+// secret itself.
+type decision int
+
+const (
+	// decisionUndecided leaves the resources untouched as the filters cannot be evaluated.
+	decisionUndecided decision = iota
+	// decisionCollision leaves the secret shared by several devices untouched.
+	decisionCollision
+	// decisionPaused leaves the resources untouched while the mutations are paused.
+	decisionPaused
+	// decisionInCluster leaves the in-cluster secret to the in-cluster reconciler.
+	decisionInCluster
+	// decisionMalformed leaves the resources of a device that cannot be decoded in place.
+	decisionMalformed
+	// decisionRetained leaves the resources retained by their fleet in place.
+	decisionRetained
+	// decisionDelete deletes the resources of a device that is gone or filtered.
+	decisionDelete
+	// decisionRegister registers the device through its backend instead of writing its secret.
+	decisionRegister
+	// decisionSync creates or updates the resources of the device.
+	decisionSync
+)
+
+type (
+	// PlanAction is the action planned on a secret.
+	PlanAction string
+
+	// Change is the change planned on the secret of a device.
+	Change struct {
+		// Action is the action planned on the secret.
+		Action PlanAction `json:"action"`
+		// Namespace and Name are the secret.
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		// Target is the Kubernetes cluster of the secret, empty for the cluster where Argotails runs.
+		Target string `json:"target,omitempty"`
+		// Device is the name of the Tailscale device of the secret, if any.
+		Device string `json:"device,omitempty"`
+		// Reason explains the action.
+		Reason string `json:"reason"`
+		// Deletions are the other resources deleted along with the change (e.g. the service of a
+		// deleted secret, the secrets left behind by a rename).
+		Deletions []string `json:"deletions,omitempty"`
+
+		// decision is what the reconciliation applying the change does.
+		decision decision
+		// device is the Tailscale device of the secret, if any.
+		device *tailscale.Device
+		// collisions are the Tailscale devices sharing the secret.
+		collisions []tailscale.Device
+		// undecided is the failure of the filters evaluation.
+		undecided error
+		// secret is the secret as read when planned (nil if not found).
+		secret *corev1.Secret
+		// stale are the other secrets of the device to delete (e.g. left behind by a rename).
+		stale []types.NamespacedName
+		// backend is the registration backend of the device, if any.
+		backend string
+	}
+
+	// Plan is the changes planned by a synchronization, computed before any of them is applied.
+	Plan struct {
+		// Time is when the plan has been computed.
+		Time time.Time `json:"time"`
+		// Changes are the changes planned on every secret, sorted by secret.
+		Changes []Change `json:"changes"`
+	}
+
+	// Planner plans the changes of the secret of a device, one per Kubernetes cluster where it is
+	// written, without side effect. Reconcile applies the changes of the context plan (see
+	// WithPlan) instead of planning them again.
+	Planner interface {
+		Plan(ctx context.Context, req reconcile.Request, devices []tailscale.Device) ([]Change, error)
+	}
+
+	// SecretLister lists the secrets managed by a reconciler, on every Kubernetes cluster where it
+	// writes them.
+	SecretLister interface {
+		ListSecrets(ctx context.Context) ([]corev1.Secret, error)
+	}
+
+	// planKey identifies a change in a plan.
+	planKey struct {
+		target string
+		secret types.NamespacedName
+	}
+	planContextKey struct{}
+)
+
+// NewPlan returns the plan of the given changes.
+func NewPlan(changes []Change) Plan {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Namespace+"/"+changes[i].Name < changes[j].Namespace+"/"+changes[j].Name
+	})
+	return Plan{Time: time.Now().UTC(), Changes: changes}
+}
+
+// Count returns the number of changes with the given action.
+func (p Plan) Count(action PlanAction) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Requests returns the secrets of the changes with the given action, on any target.
+func (p Plan) Requests(action PlanAction) []reconcile.Request {
+	var reqs []reconcile.Request
+	for _, change := range p.Changes {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: change.Namespace, Name: change.Name}}
+		if change.Action == action && !slices.Contains(reqs, req) {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// Changed returns the plan without the secrets left untouched along with their resources.
+func (p Plan) Changed() Plan {
+	changed := Plan{Time: p.Time, Changes: []Change{}}
+	for _, change := range p.Changes {
+		if change.Action != PlanKeep || len(change.Deletions) > 0 {
+			changed.Changes = append(changed.Changes, change)
+		}
+	}
+	return changed
+}
+
+// WithPlan returns a context whose reconciliations apply the changes of the given plan, instead of
+// listing the Tailscale devices and planning them again.
+func WithPlan(ctx context.Context, plan Plan) context.Context {
+	changes := make(map[planKey]Change, len(plan.Changes))
+	for _, change := range plan.Changes {
+		changes[planKey{target: change.Target, secret: types.NamespacedName{Namespace: change.Namespace, Name: change.Name}}] = change
+	}
+	return context.WithValue(ctx, planContextKey{}, changes)
+}
+
+// plannedChange returns the change of the context plan for the given secret of the given target.
+func plannedChange(ctx context.Context, target string, namespacedName types.NamespacedName) (Change, bool) {
+	changes, _ := ctx.Value(planContextKey{}).(map[planKey]Change)
+	change, planned := changes[planKey{target: target, secret: namespacedName}]
+	return change, planned
+}
+
+// Plan returns the change the reconciliation of the given secret would apply, the Tailscale devices
+// being the given ones, without writing anything.
+func (r reconciler) Plan(ctx context.Context, req reconcile.Request, devices []tailscale.Device) ([]Change, error) {
+	change, err := r.plan(ctx, req, devices)
+	if err != nil {
+		return nil, err
+	}
+	return []Change{change}, nil
+}
+
+// plan decides the change of the given secret, the Tailscale devices being the given ones. It is
+// the single decision of both Plan and Reconcile, which applies the decided change.
+func (r reconciler) plan(ctx context.Context, req reconcile.Request, devices []tailscale.Device) (Change, error) {
+	change := Change{Action: PlanKeep, Target: r.target, Namespace: req.Namespace, Name: req.Name}
+
+	matches, undecided := r.matchingDevices(req.NamespacedName, devices)
+	switch {
+	case len(matches) == 0 && undecided != nil:
+		change.decision, change.undecided = decisionUndecided, undecided
+		change.Reason = "Tailscale device cannot be evaluated against the filters"
+		return change, nil
+	case len(matches) > 1:
+		change.decision, change.collisions = decisionCollision, matches
+		change.Reason = "several Tailscale devices share the secret"
+		return change, nil
+	case r.pause.Paused():
+		change.decision, change.Reason = decisionPaused, "mutations are paused"
+		return change, nil
+	}
+
+	var secret corev1.Secret
+	err := r.ks.Get(ctx, req.NamespacedName, &secret)
+	if err == nil {
+		change.secret = &secret
+	} else if !errors.IsNotFound(err) {
+		return Change{}, err
+	}
+
+	if len(matches) == 0 {
+		return r.planDeletion(change)
+	}
+
+	device := matches[0]
+	change.device, change.Device = &device, device.Name
+	if backend := r.registrationBackend(device); backend != "" {
+		change.decision, change.backend = decisionRegister, backend
+		if change.secret != nil {
+			change.Action = PlanDelete
+		}
+		change.Reason = fmt.Sprintf("registered through the %s backend", backend)
+		return change, nil
+	}
+
+	change.decision = decisionSync
+	change.stale, err = r.staleSecrets(ctx, req.NamespacedName, device)
+	if err != nil {
+		return Change{}, err
+	}
+	for _, stale := range change.stale {
+		change.Deletions = append(change.Deletions, "secret "+stale.String())
+	}
+	if change.secret == nil {
+		change.Action, change.Reason = PlanCreate, "new Tailscale device"
+		return change, nil
+	}
+
+	if r.rollout != nil && secret.Annotations[AnnotationConfigHash] != r.rollout.ConfigHash && !r.rollout.Allows(device) {
+		change.Reason = "written with another configuration, until the rollout is promoted"
+		return change, nil
+	}
+	cfg, err := r.buildConfig(device)
+	if err != nil {
+		return Change{}, err
+	}
+	cfg.Pending = secret.Annotations[AnnotationApproved] == "false"
+	desired, err := BuildDesiredSecret(req.NamespacedName, device, cfg)
+	if err != nil {
+		return Change{}, err
+	}
+	if upToDate(secret, desired) && r.ttlUpToDate(secret, time.Now()) {
+		change.Reason = "up to date"
+		return change, nil
+	}
+	change.Action, change.Reason = PlanUpdate, "Tailscale device or configuration changed"
+	return change, nil
+}
+
+// isMalformedDevice returns true if the device with the given node ID has been skipped from the last
+// device listing because it could not be decoded (see ts.MalformedDeviceReporter).
+func (r reconciler) isMalformedDevice(nodeID string) bool {
+	reporter, ok := r.ts.(ts.MalformedDeviceReporter)
+	return ok && reporter.IsMalformedDevice(nodeID)
+}
+
+// planDeletion decides the change of a secret matching no Tailscale device.
+func (r reconciler) planDeletion(change Change) (Change, error) {
+	switch {
+	case change.Name == InClusterName:
+		change.decision, change.Reason = decisionInCluster, "in-cluster secret"
+		return change, nil
+	case change.secret != nil && r.isMalformedDevice(change.secret.Annotations[AnnotationDeviceID]):
+		// NOTE: the device has been skipped from the last listing as it could not be decoded, but
+		//       it still exists.
+		change.decision, change.Reason = decisionMalformed, "Tailscale device cannot be decoded"
+		return change, nil
+	case change.secret != nil && r.retainingFleet(*change.secret) != "":
+		change.decision, change.Reason = decisionRetained, "retained by its fleet"
+		return change, nil
+	}
+
+	change.decision, change.Reason = decisionDelete, "Tailscale device not found or filtered"
+	if change.secret != nil {
+		change.Action = PlanDelete
+	} else {
+		change.Reason = "no Tailscale device nor secret"
+	}
+
+	namespacedName := types.NamespacedName{Namespace: change.Namespace, Name: change.Name}
+	if r.serviceConfig.CreateService {
+		service, err := r.serviceNamespacedName(namespacedName)
+		if err != nil {
+			return Change{}, err
+		}
+		change.Deletions = append(change.Deletions, "service "+service.String())
+	}
+	if len(r.backends) > 0 {
+		change.Deletions = append(change.Deletions, "registration "+change.Name)
+	}
+	if r.application != nil {
+		change.Deletions = append(change.Deletions, "application "+namespacedName.String())
+	}
+	if r.clusterResource {
+		change.Deletions = append(change.Deletions, "tailscalecluster "+namespacedName.String())
+	}
+	return change, nil
+}
@@ -0,0 +1,75 @@
+package reconciler
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AnnotationLastSyncTime is the annotation holding the time (RFC 3339) the secret was last
+	// written by the controller.
+	AnnotationLastSyncTime = "argotails.chezmoi.sh/last-sync-time"
+	// AnnotationLastSyncTrigger is the annotation holding what triggered the reconciliation that last
+	// wrote the secret (e.g. TriggerWebhook).
+	AnnotationLastSyncTrigger = "argotails.chezmoi.sh/last-sync-trigger"
+	// AnnotationControllerVersion is the annotation holding the version of the controller that last
+	// wrote the secret.
+	AnnotationControllerVersion = "argotails.chezmoi.sh/controller-version"
+)
+
+const (
+	// TriggerTimer is the trigger of the time-based reconciliations.
+	TriggerTimer = "timer"
+	// TriggerWebhook is the trigger of the reconciliations of the Tailscale webhook events.
+	TriggerWebhook = "webhook"
+	// TriggerKubernetes is the trigger of the reconciliations of the Kubernetes events (e.g. a
+	// secret modified or deleted by someone else).
+	TriggerKubernetes = "k8s"
+	// TriggerAdmin is the trigger of the reconciliations forced through the admin API.
+	TriggerAdmin = "admin"
+	// TriggerArgoCD is the trigger of the reconciliations of the clusters ArgoCD failed to connect to.
+	TriggerArgoCD = "argocd"
+	// TriggerImport is the trigger of the reconciliations adopting the secrets with argotails import.
+	TriggerImport = "import"
+)
+
+type triggerKey struct{}
+
+// TriggerContext returns a context telling the reconciler what triggered the reconciliation, as
+// recorded on the secrets it writes.
+func TriggerContext(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// TriggerFrom returns what triggered the reconciliation of the given context, empty if unknown.
+func TriggerFrom(ctx context.Context) string {
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	return trigger
+}
+
+// WithControllerVersion records the given controller version on the secrets written by the
+// reconciler.
+func WithControllerVersion(version string) Option {
+	return func(r *reconciler) { r.version = version }
+}
+
+// stampProvenance records on the given secret, about to be written, when and why it is written and
+// by which controller version. These annotations are not part of the content hash, so that they
+// never trigger a write on their own.
+func (r reconciler) stampProvenance(ctx context.Context, secret *corev1.Secret, now time.Time) {
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[AnnotationLastSyncTime] = now.UTC().Format(time.RFC3339)
+
+	delete(secret.Annotations, AnnotationLastSyncTrigger)
+	if trigger := TriggerFrom(ctx); trigger != "" {
+		secret.Annotations[AnnotationLastSyncTrigger] = trigger
+	}
+	delete(secret.Annotations, AnnotationControllerVersion)
+	if r.version != "" {
+		secret.Annotations[AnnotationControllerVersion] = r.version
+	}
+}
@@ -2,22 +2,29 @@ package reconciler
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"maps"
+	"math/rand/v2"
 	"regexp"
+	"slices"
 	"strings"
+	"text/template"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"tailscale.com/client/tailscale/v2"
 
+	"github.com/chezmoidotsh/argotails/internal/argocd"
 	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 const (
@@ -25,15 +32,43 @@ const (
 	AnnotationDeviceID = "device.tailscale.com/id"
 	// AnnotationDeviceAddress is the annotation key for the device address.
 	AnnotationDeviceAddress = "device.tailscale.com/address"
+	// AnnotationDeviceAddresses is the annotation key for all the device addresses (IPv4 and IPv6),
+	// comma-separated.
+	AnnotationDeviceAddresses = "device.tailscale.com/addresses"
 	// AnnotationDeviceHostname is the annotation key for the device hostname.
 	AnnotationDeviceHostname = "device.tailscale.com/tailnet-fqdn"
 	// AnnotationDeviceTailnet is the annotation key for the device name.
 	AnnotationDeviceTailnet = "device.tailscale.com/tailnet"
 
+	// AnnotationApproved is the annotation key used to approve the registration of a device as an
+	// ArgoCD cluster when approval is required. Secrets pending approval have this annotation set to
+	// "false" and are ignored by ArgoCD until it is set to "true".
+	AnnotationApproved = "argotails.chezmoi.sh/approved"
+	// AnnotationSecret is the annotation key referencing, as "namespace/name", the ArgoCD cluster
+	// secret an ArgoCD Application or a service has been generated for.
+	AnnotationSecret = "argotails.chezmoi.sh/secret"
+	// AnnotationContentHash is the annotation key of the hash of the labels, annotations and data
+	// of a secret, as written by the controller; it allows detecting drift without comparing
+	// the whole content.
+	AnnotationContentHash = "argotails.chezmoi.sh/content-hash"
+	// AnnotationSkipService is the annotation key used to skip the service creation of a device
+	// when set to "true" on its secret, even when services are created for every device.
+	AnnotationSkipService = "argotails.chezmoi.sh/skip-service"
+	// AnnotationManagedAnnotations is the annotation key listing, comma-separated, the configured
+	// annotations (see WithAnnotations) written on a secret, so that the ones no longer configured
+	// are removed.
+	AnnotationManagedAnnotations = "argotails.chezmoi.sh/managed-annotations"
+	// AnnotationManagedLabels is the annotation key listing, comma-separated, the computed labels
+	// (device tags, labelers and fleets) written on a secret or service, so that the ones no longer
+	// computed are removed.
+	AnnotationManagedLabels = "argotails.chezmoi.sh/managed-labels"
+
 	// LabelDeviceOS is the label key for the device OS.
 	LabelDeviceOS = "device.tailscale.com/os"
 	// LabelDeviceVersion is the label key for the device version.
 	LabelDeviceVersion = "device.tailscale.com/version"
+	// LabelDeviceOutdated is the label key flagging devices running an outdated Tailscale client.
+	LabelDeviceOutdated = "device.tailscale.com/outdated"
 
 	// LabelDeviceTagsPrefix is the label key prefix used for the device tags.
 	LabelDeviceTagsPrefix = "tag.device.tailscale.com/"
@@ -91,10 +126,12 @@ func toDNS1035Name(deviceName string) string {
 
 type (
 	reconciler struct {
-		// ts is the Tailscale client.
-		ts *tailscale.Client
+		// ts is the Tailscale device API.
+		ts ts.DeviceAPI
 		// ks is the Kubernetes client.
 		ks client.Client
+		// reader reads objects directly from the Kubernetes API server, bypassing the cache.
+		reader client.Reader
 
 		// filter filters the devices based on their tags.
 		filter ts.TagFilter
@@ -102,8 +139,82 @@ type (
 		managedBy string
 		// serviceConfig contains service creation configuration.
 		serviceConfig ServiceConfig
+		// requireApproval creates new secrets pending approval instead of live ArgoCD clusters.
+		requireApproval bool
+		// labelers compute additional labels for a device.
+		labelers []Labeler
+		// extraData render additional secret data entries for a device.
+		extraData []Renderer
+		// clusterResource maintains a TailscaleCluster resource per device.
+		clusterResource bool
+		// snapshot keeps the last known Tailscale devices, used when the Tailscale API is unavailable.
+		snapshot *ts.DeviceSnapshot
+		// syncIntervals are the reconciliation intervals of the devices having the given tags.
+		syncIntervals map[string]time.Duration
+		// resyncPerObject is the reconciliation interval of the devices without tag-specific interval.
+		resyncPerObject time.Duration
+		// secretName returns the name of the secret of a device.
+		secretName SecretNamer
+		// serviceName returns the name of the service of a device from the name of its secret.
+		serviceName ServiceNamer
+		// recorder records the Kubernetes events (e.g. device name collisions).
+		recorder events.EventRecorder
+		// flavor is the kind of secret generated for each device.
+		flavor string
+		// application renders the ArgoCD Application created for each registered cluster.
+		application *template.Template
+		// tagLabelMode is the handling of the device tags that are not valid label keys.
+		tagLabelMode string
+		// dataMetadata selects the labels and annotations also written into the secret data.
+		dataMetadata DataMetadata
+		// annotations are additional annotations of every secret.
+		annotations map[string]string
+		// rollout rolls the configuration changes out progressively (optional).
+		rollout *Rollout
+		// hooks are called around the secret operations.
+		hooks []Hooks
+		// statuses records the outcome of every reconciliation (optional).
+		statuses *StatusRecorder
+		// serverAddress is how the Kubernetes API server of each device is reached.
+		serverAddress string
+		// serverNames are the templates of the TLS server name of the devices, by tag.
+		serverNames map[string]*template.Template
+		// connectivity annotates the secrets with the preferred DERP region of their device.
+		connectivity bool
+		// fleets are the groups of devices sharing the same settings.
+		fleets []Fleet
+		// backends are the registration backends used instead of secrets for the devices having the given tags.
+		backends map[string]string
+		// karmadaCredentials is the secret referenced by the Karmada Cluster objects (optional).
+		karmadaCredentials types.NamespacedName
+		// pause skips every mutation while paused.
+		pause *PauseSwitch
+		// maxSecretSize is the maximum size of the secret data (defaults to corev1.MaxSecretSize).
+		maxSecretSize int
+		// ttl is the time after which the secrets must be re-attested (0 to never expire).
+		ttl time.Duration
+		// version is the controller version recorded on the secrets.
+		version string
+		// target is the name of the Kubernetes cluster the secrets are written to, empty for the
+		// cluster where Argotails runs.
+		target string
+		// deviceIDIndex looks the secrets of a device up through the IndexDeviceID field index.
+		deviceIDIndex bool
+	}
+
+	// Renderer renders values for a Tailscale device.
+	Renderer interface {
+		Render(device tailscale.Device) (map[string]string, error)
+	}
+
+	// Labeler computes additional labels for a Tailscale device.
+	Labeler interface {
+		Labels(device tailscale.Device) (map[string]string, error)
 	}
 
+	// LabelerFunc is a function implementing the Labeler interface.
+	LabelerFunc func(device tailscale.Device) (map[string]string, error)
+
 	Config struct {
 		// Tailnet is the Tailscale network name.
 		Tailnet string
@@ -119,212 +230,609 @@ type (
 		CreateService bool
 		// ProxyClass is the ProxyClass to use for Tailscale services.
 		ProxyClass string
-		// Namespace is the namespace where services should be created.
+		// Namespace is the controller namespace, where the resources of the devices belonging to no
+		// fleet (or to a fleet without namespace) are created.
 		Namespace string
+		// OSes restricts the service creation to the devices running one of these operating systems
+		// (all devices when empty).
+		OSes []string
+		// SkipTag is the tag of the devices for which no service is created (optional).
+		SkipTag string
 	}
+
+	// Option configures optional behaviors of the reconciler.
+	Option func(*reconciler)
 )
 
+// createsService returns true if a service must be created for the given device.
+func (r reconciler) createsService(device tailscale.Device) bool {
+	if r.serviceConfig.SkipTag != "" && slices.Contains(device.Tags, "tag:"+strings.TrimPrefix(r.serviceConfig.SkipTag, "tag:")) {
+		return false
+	}
+	return r.serviceConfig.CreateService && ts.HasOS(device, r.serviceConfig.OSes...)
+}
+
+// WithAPIReader configures the reader used to retrieve objects that are not visible through the
+// cache of the Kubernetes client (defaults to the Kubernetes client itself).
+func WithAPIReader(reader client.Reader) Option {
+	return func(r *reconciler) { r.reader = reader }
+}
+
+// WithDeviceIDIndex looks the secrets of a device up through the IndexDeviceID field index, which
+// must be registered (see IndexSecretDeviceID) on the cache the Kubernetes client reads from,
+// instead of going through every managed secret of the namespace.
+func WithDeviceIDIndex() Option {
+	return func(r *reconciler) { r.deviceIDIndex = true }
+}
+
+// WithApproval requires the registration of every new device to be approved (by setting the
+// AnnotationApproved annotation to "true") before the ArgoCD cluster secret becomes live.
+func WithApproval(required bool) Option {
+	return func(r *reconciler) { r.requireApproval = required }
+}
+
+// WithLabeler adds the labels computed by the given labeler to the secrets and services of every device.
+func WithLabeler(labeler Labeler) Option {
+	return func(r *reconciler) { r.labelers = append(r.labelers, labeler) }
+}
+
+// WithExtraData adds the entries rendered by the given renderer to the secret data of every device.
+func WithExtraData(renderer Renderer) Option {
+	return func(r *reconciler) { r.extraData = append(r.extraData, renderer) }
+}
+
+// WithDeviceSnapshot lists the Tailscale devices through the given snapshot, falling back to the
+// last known devices when the Tailscale API is unavailable.
+func WithDeviceSnapshot(snapshot *ts.DeviceSnapshot) Option {
+	return func(r *reconciler) { r.snapshot = snapshot }
+}
+
+// WithSyncInterval periodically reconciles the devices having the given tag (e.g.
+// "tag:argotails-fast-sync"), independently of the controller-wide reconciliation interval. When a
+// device has several such tags, the shortest interval is used.
+func WithSyncInterval(tag string, interval time.Duration) Option {
+	return func(r *reconciler) {
+		if r.syncIntervals == nil {
+			r.syncIntervals = map[string]time.Duration{}
+		}
+		r.syncIntervals["tag:"+strings.TrimPrefix(tag, "tag:")] = interval
+	}
+}
+
+// WithResyncPerObject periodically reconciles every device through the controller queue, in
+// addition to the controller-wide reconciliation. Each reconciliation is delayed by up to a tenth of
+// the interval, so that the devices reconciled together drift apart over time. The intervals given
+// by WithSyncInterval take precedence.
+func WithResyncPerObject(interval time.Duration) Option {
+	return func(r *reconciler) { r.resyncPerObject = interval }
+}
+
+// WithTarget names the Kubernetes cluster the secrets are written to, when it is not the cluster
+// where Argotails runs (see Change.Target).
+func WithTarget(name string) Option {
+	return func(r *reconciler) { r.target = name }
+}
+
+// WithEventRecorder reports noteworthy situations (e.g. device name collisions) as Kubernetes events.
+func WithEventRecorder(recorder events.EventRecorder) Option {
+	return func(r *reconciler) { r.recorder = recorder }
+}
+
+// Labels returns the labels computed by the function.
+func (f LabelerFunc) Labels(device tailscale.Device) (map[string]string, error) { return f(device) }
+
 // NewReconciler creates a new reconciler based on the provided configuration.
-func NewReconciler(ks client.Client, ts *tailscale.Client, filter ts.TagFilter, managedBy string, serviceConfig ServiceConfig) (reconcile.TypedReconciler[reconcile.Request], error) {
-	reconciler := &reconciler{ks: ks, ts: ts, filter: filter, managedBy: managedBy, serviceConfig: serviceConfig}
+func NewReconciler(ks client.Client, api ts.DeviceAPI, filter ts.TagFilter, managedBy string, serviceConfig ServiceConfig, opts ...Option) (reconcile.TypedReconciler[reconcile.Request], error) {
+	reconciler := &reconciler{ks: ks, reader: ks, ts: api, filter: filter, managedBy: managedBy, serviceConfig: serviceConfig}
+	reconciler.secretName, _ = NewSecretNamer(SecretNamingDeviceName)
+	reconciler.serviceName, _ = NewServiceNamer("")
+	for _, opt := range opts {
+		opt(reconciler)
+	}
 	return reconciler, nil
 }
 
+type deviceDeletedKey struct{}
+
+// DeviceDeletedContext returns a context telling the reconciler that the device to reconcile is
+// known to be deleted; its resources are then deleted without querying the Tailscale API.
+func DeviceDeletedContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deviceDeletedKey{}, true)
+}
+
+// isDeviceDeleted returns true if the context tells that the device to reconcile is deleted.
+func isDeviceDeleted(ctx context.Context) bool {
+	deleted, _ := ctx.Value(deviceDeletedKey{}).(bool)
+	return deleted
+}
+
+// IsDeviceSecret returns true if the given object has been written for a Tailscale device, whatever
+// the secret naming scheme is.
+func IsDeviceSecret(obj metav1.Object) bool { return obj.GetAnnotations()[AnnotationDeviceID] != "" }
+
 // Reconcile reconciles a secret with a Tailscale device by creating, updating or deleting the secret
-// based on the device's existence and metadata.
+// based on the device's existence and metadata. The change planned by the context plan, if any, is
+// applied as is (see WithPlan).
 func (r reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	change, res, err := r.reconcile(ctx, req)
+	switch {
+	case r.statuses == nil:
+	case err == nil && change.decision == decisionDelete:
+		// The secret is gone, and so is the device status
+		r.statuses.Delete(req.Name)
+	default:
+		r.statuses.Record(req.Name, err)
+	}
+	return res, err
+}
+
+// reconcile reconciles the secret of the requested device, returning the applied change.
+func (r reconciler) reconcile(ctx context.Context, req reconcile.Request) (Change, reconcile.Result, error) {
 	log := ctrllog.FromContext(ctx).WithName("reconcile_secret")
 	log.V(0).Info("Starting reconciliation of Tailscale device's secret")
 
-	log.V(2).Info("Listing Tailscale devices")
-	devices, err := r.ts.Devices().List(ctx)
-	if err != nil {
-		log.Error(err, "Failed to list Tailscale devices", "reconciliation.outcome", "tailscale_list_error")
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to list devices: %w", err)
+	if change, planned := plannedChange(ctx, r.target, req.NamespacedName); planned {
+		log.V(2).Info("Tailscale device's secret change already planned, skipping Tailscale devices listing", "reconciliation.action", change.Action)
+		res, err := r.apply(ctrllog.IntoContext(ctx, log), change)
+		if !errors.IsConflict(err) {
+			return change, res, err
+		}
+		// NOTE: the secret changed since it has been planned (e.g. by a webhook-triggered
+		//       reconciliation), so the change is planned again.
+		log.V(1).Info("Tailscale device's secret changed since planned, it will be planned again")
 	}
-	log.V(4).Info("Tailscale API call completed", "devices.count", len(devices))
 
-	var device *tailscale.Device
-	for _, _device := range devices {
-		if _device.Name == req.Name {
-			device = &_device
-			log = log.WithValues("device", map[string]any{
-				"id":       _device.NodeID,
-				"name":     _device.Name,
-				"os":       _device.OS,
-				"hostname": _device.Hostname,
-				"version":  _device.ClientVersion,
-			})
-			log.V(2).Info("Found matching Tailscale device")
-			break
+	var devices []tailscale.Device
+	if isDeviceDeleted(ctx) {
+		// The device is known to be deleted (e.g. nodeDeleted webhook event), so there is no need to
+		// list all Tailscale devices to find it out.
+		log.V(2).Info("Tailscale device known as deleted, skipping Tailscale devices listing")
+	} else {
+		log.V(2).Info("Listing Tailscale devices")
+		var err error
+		devices, err = r.listDevices(ctx)
+		if err != nil {
+			log.Error(err, "Failed to list Tailscale devices", "reconciliation.outcome", "tailscale_list_error")
+			return Change{}, reconcile.Result{Requeue: true}, fmt.Errorf("failed to list devices: %w", err)
 		}
+		log.V(4).Info("Tailscale API call completed", "devices.count", len(devices))
 	}
 
-	if device == nil || !r.filter.Match(*device) {
-		log.V(0).Info("Tailscale device not found or filtered, Tailscale device's secret and service will be deleted", "reconciliation.action", "delete")
+	change, err := r.plan(ctx, req, devices)
+	if err != nil {
+		log.Error(err, "Failed to plan the reconciliation of Tailscale device's secret", "reconciliation.outcome", "plan_error")
+		return Change{}, reconcile.Result{Requeue: true}, err
+	}
+	res, err := r.apply(ctrllog.IntoContext(ctx, log), change)
+	return change, res, err
+}
 
-		// Delete secret
-		err := r.DeleteDeviceSecret(ctrllog.IntoContext(ctx, log), req.NamespacedName)
-		if err != nil && !errors.IsNotFound(err) {
-			log.Error(err, "Failed to delete Tailscale device's secret", "reconciliation.outcome", "delete_secret_error")
+// apply applies the given planned change.
+func (r reconciler) apply(ctx context.Context, change Change) (reconcile.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: change.Namespace, Name: change.Name}}
+
+	switch change.decision {
+	case decisionUndecided:
+		// NOTE: a filter that cannot be evaluated (e.g. a policy referencing a missing field)
+		//       must never delete the resources of the devices it fails to decide on.
+		log.Error(change.undecided, "Tailscale device cannot be evaluated against the filters, Tailscale device's resources left untouched", "reconciliation.outcome", "filter_error")
+		return reconcile.Result{}, change.undecided
+	case decisionCollision:
+		// Several devices share the same secret: writing it would make the last device win, so
+		// the collision is reported and the secret left untouched.
+		r.reportCollision(ctx, req.NamespacedName, change.collisions...)
+		log.V(0).Info("Several Tailscale devices share the same secret, Tailscale device's secret left untouched", "reconciliation.outcome", "collision")
+		return reconcile.Result{}, nil
+	}
+
+	device := change.device
+	if device != nil {
+		log = log.WithValues("device", map[string]any{
+			"id":       device.NodeID,
+			"name":     device.Name,
+			"os":       device.OS,
+			"hostname": device.Hostname,
+			"version":  device.ClientVersion,
+		})
+		log.V(2).Info("Found matching Tailscale device")
+	}
+
+	// NOTE: the mutations may have been paused since the change has been planned.
+	if change.decision == decisionPaused || r.pause.Paused() {
+		log.V(1).Info("Mutations are paused, Tailscale device's resources left untouched", "reconciliation.outcome", "paused")
+		return reconcile.Result{}, nil
+	}
+
+	switch change.decision {
+	case decisionInCluster:
+		// NOTE: the in-cluster secret is only maintained by the in-cluster reconciler; it must
+		//       never be deleted, even once --cluster.in-cluster has been disabled.
+		log.V(2).Info("In-cluster secret is not a Tailscale device's secret, left untouched", "reconciliation.outcome", "in_cluster")
+		return reconcile.Result{}, nil
+	case decisionMalformed:
+		log.V(0).Info("Tailscale device cannot be decoded, Tailscale device's resources are left in place", "reconciliation.outcome", "malformed_device")
+		return reconcile.Result{}, nil
+	case decisionRetained:
+		log.V(1).Info("Tailscale device left a fleet retaining its resources, Tailscale device's resources are left in place", "fleet", change.secret.Labels[LabelFleet])
+		// The retained secret is deleted once expired
+		return reconcile.Result{RequeueAfter: r.untilExpiry(*change.secret, time.Now())}, nil
+	case decisionDelete:
+		return r.applyDeletion(ctrllog.IntoContext(ctx, log), req)
+	case decisionRegister:
+		// Register the device with its backend instead of generating a secret
+		return r.reconcileRegistration(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device, change.backend)
+	}
+
+	if len(r.backends) > 0 {
+		err := r.DeleteDeviceRegistration(ctrllog.IntoContext(ctx, log), req.NamespacedName)
+		if err != nil {
+			log.Error(err, "Failed to delete Tailscale device's registration", "reconciliation.outcome", "delete_registration_error")
 			return reconcile.Result{Requeue: true}, err
 		}
+	}
 
-		// Delete service if service creation is enabled
-		if r.serviceConfig.CreateService {
-			err := r.DeleteDeviceService(ctrllog.IntoContext(ctx, log), req.NamespacedName)
-			if err != nil && !errors.IsNotFound(err) {
-				log.Error(err, "Failed to delete Tailscale device's service", "reconciliation.outcome", "delete_service_error")
-				return reconcile.Result{Requeue: true}, err
+	// Report the outcome of the synchronization on the cluster resource, whatever it is
+	var outcome syncOutcome
+	if r.clusterResource {
+		defer func() {
+			err := r.syncDeviceCluster(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device, outcome)
+			if err != nil {
+				log.Error(err, "Failed to synchronize Tailscale device's cluster resource")
 			}
-		}
-
-		log.V(1).Info("Device reconciliation completed with deletion", "reconciliation.outcome", "deleted")
-		return reconcile.Result{}, nil
+		}()
 	}
 
-	var secret corev1.Secret
-	err = r.ks.Get(ctx, req.NamespacedName, &secret)
-	if errors.IsNotFound(err) {
+	if change.secret == nil {
 		log.V(1).Info("Tailscale device's secret not found, Tailscale device's secret will be created", "reconciliation.action", "create")
-		err = r.CreateDeviceSecret(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
-		if err != nil && !errors.IsAlreadyExists(err) {
+		err := r.CreateDeviceSecret(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+		if errors.IsAlreadyExists(err) {
+			// The secret exists but is not visible through the cache: it has either been created
+			// concurrently (e.g. by a webhook-triggered reconciliation) or (re)created without the
+			// controller labels by someone else. Both converge by updating it from the API server.
+			log.V(1).Info("Tailscale device's secret already exists but is not visible through the cache, it will be updated", "reconciliation.action", "adopt")
+			err = r.AdoptDeviceSecret(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+		}
+		if stderrors.Is(err, errAdoptionNotApproved) {
+			log.V(1).Info("Tailscale device's existing secret requires approval before being adopted, left untouched", "reconciliation.outcome", "pending_approval", "annotation", AnnotationApproved)
+			return reconcile.Result{}, nil
+		}
+		outcome.secret = err
+		if err != nil {
 			log.Error(err, "Failed to create Tailscale device's secret", "reconciliation.outcome", "create_secret_error")
 			return reconcile.Result{Requeue: true}, err
 		}
 
 		// Create service if enabled
-		if r.serviceConfig.CreateService {
+		if r.createsService(*device) {
 			err = r.CreateDeviceService(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
-			if err != nil && !errors.IsAlreadyExists(err) {
+			if errors.IsAlreadyExists(err) {
+				// Same as the secret: the service is updated with the latest device data right away
+				// instead of waiting for the next reconciliation.
+				log.V(1).Info("Tailscale device's service already exists, it will be updated", "reconciliation.action", "update_service")
+				err = r.AdoptDeviceService(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+			}
+			outcome.service, outcome.serviceSynced = err, true
+			if err != nil {
 				log.Error(err, "Failed to create Tailscale device's service", "reconciliation.outcome", "create_service_error")
 				return reconcile.Result{Requeue: true}, err
 			}
 		}
 
+		// Delete the resources created under the previous name of a renamed device
+		if err := r.deleteStaleSecrets(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device, change.stale, "Renamed"); err != nil {
+			log.Error(err, "Failed to delete the previous Tailscale device's resources", "reconciliation.outcome", "rename_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+
+		// Create ArgoCD application if enabled, once the cluster is registered
+		if r.application != nil && !r.requireApproval {
+			err = r.SyncDeviceApplication(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+			if err != nil {
+				log.Error(err, "Failed to create Tailscale device's ArgoCD application", "reconciliation.outcome", "create_application_error")
+				return reconcile.Result{Requeue: true}, err
+			}
+		}
+
 		log.V(1).Info("Device reconciliation completed with creation", "reconciliation.outcome", "created")
-		return reconcile.Result{}, nil
-	} else if err != nil {
-		log.Error(err, "Failed to get Tailscale device's secret", "reconciliation.outcome", "get_secret_error")
-		return reconcile.Result{Requeue: true}, err
+		return reconcile.Result{RequeueAfter: r.requeueAfter(corev1.Secret{}, *device)}, nil
 	}
 
+	secret := *change.secret
 	log.V(2).Info("Tailscale device's secret found, Tailscale device's secret will be updated", "reconciliation.action", "update")
-	err = r.UpdateDeviceSecret(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+	err := r.writeDeviceSecret(ctrllog.IntoContext(ctx, log), secret, *device)
+	outcome.secret = err
 	if err != nil {
 		log.Error(err, "Failed to update Tailscale device's secret", "reconciliation.outcome", "update_secret_error")
 		return reconcile.Result{Requeue: true}, err
 	}
 
-	// Update service if enabled
-	if r.serviceConfig.CreateService {
+	// Delete the other secrets of the same device, left behind by past renames
+	if err := r.deleteStaleSecrets(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device, change.stale, "DuplicatePruned"); err != nil {
+		log.Error(err, "Failed to delete the duplicate Tailscale device's secrets", "reconciliation.outcome", "dedupe_error")
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	// Update service if enabled, or delete it if the device no longer runs a supported operating system
+	switch {
+	case r.createsService(*device) && secret.Annotations[AnnotationSkipService] != "true":
 		err = r.UpdateDeviceService(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+		outcome.service, outcome.serviceSynced = err, true
 		if err != nil {
 			log.Error(err, "Failed to update Tailscale device's service", "reconciliation.outcome", "update_service_error")
 			return reconcile.Result{Requeue: true}, err
 		}
+	case r.serviceConfig.CreateService:
+		err = r.DeleteDeviceService(ctrllog.IntoContext(ctx, log), req.NamespacedName)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Tailscale device's service", "reconciliation.outcome", "delete_service_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	// Update ArgoCD application if enabled, once the cluster is registered
+	if r.application != nil && secret.Annotations[AnnotationApproved] != "false" {
+		err = r.SyncDeviceApplication(ctrllog.IntoContext(ctx, log), req.NamespacedName, *device)
+		if err != nil {
+			log.Error(err, "Failed to update Tailscale device's ArgoCD application", "reconciliation.outcome", "update_application_error")
+			return reconcile.Result{Requeue: true}, err
+		}
 	}
 
 	log.V(1).Info("Device reconciliation completed with update", "reconciliation.outcome", "updated")
+	return reconcile.Result{RequeueAfter: r.requeueAfter(secret, *device)}, nil
+}
+
+// applyDeletion deletes the resources of a Tailscale device that is gone or filtered.
+func (r reconciler) applyDeletion(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	log.V(0).Info("Tailscale device not found or filtered, Tailscale device's secret and service will be deleted", "reconciliation.action", "delete")
+
+	// Delete secret
+	err := r.DeleteDeviceSecret(ctx, req.NamespacedName)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Tailscale device's secret", "reconciliation.outcome", "delete_secret_error")
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	// Delete service if service creation is enabled
+	if r.serviceConfig.CreateService {
+		err := r.DeleteDeviceService(ctx, req.NamespacedName)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Tailscale device's service", "reconciliation.outcome", "delete_service_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	// Delete registration objects if any backend is configured
+	if len(r.backends) > 0 {
+		err := r.DeleteDeviceRegistration(ctx, req.NamespacedName)
+		if err != nil {
+			log.Error(err, "Failed to delete Tailscale device's registration", "reconciliation.outcome", "delete_registration_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	// Delete ArgoCD application if enabled
+	if r.application != nil {
+		err := r.DeleteDeviceApplication(ctx, req.NamespacedName)
+		if err != nil {
+			log.Error(err, "Failed to delete Tailscale device's ArgoCD application", "reconciliation.outcome", "delete_application_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	// Delete cluster resource if enabled
+	if r.clusterResource {
+		err := r.DeleteDeviceCluster(ctx, req.NamespacedName)
+		if err != nil {
+			log.Error(err, "Failed to delete Tailscale device's cluster resource", "reconciliation.outcome", "delete_cluster_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	log.V(1).Info("Device reconciliation completed with deletion", "reconciliation.outcome", "deleted")
 	return reconcile.Result{}, nil
 }
 
+// matchingDevices returns the devices, among the given ones, whose secret is the given one and
+// which match the filter. It also returns the error of the filter when it cannot decide whether
+// one of the other devices of the secret matches.
+func (r reconciler) matchingDevices(namespacedName types.NamespacedName, devices []tailscale.Device) ([]tailscale.Device, error) {
+	var matches []tailscale.Device
+	var undecided error
+	for _, device := range devices {
+		if r.secretName(device) != namespacedName.Name || !r.inFleetNamespace(device, namespacedName.Namespace) {
+			continue
+		}
+		matched, err := ts.EvaluateFilter(r.filter, device)
+		if err != nil {
+			undecided = fmt.Errorf("failed to filter device %q: %w", device.Name, err)
+			continue
+		}
+		if matched {
+			matches = append(matches, device)
+		}
+	}
+	return matches, undecided
+}
+
+// listDevices lists the Tailscale devices, recording the duration of the listing. The devices
+// shared by the targets of a request are only listed by the first one.
+func (r reconciler) listDevices(ctx context.Context) ([]tailscale.Device, error) {
+	list := func() (devices []tailscale.Device, err error) {
+		defer observeAction(ActionListDevices, time.Now(), &err)
+		return r.snapshot.List(ctx, r.ts)
+	}
+	shared, ok := ctx.Value(sharedDevicesKey{}).(*sharedDevices)
+	if !ok {
+		return list()
+	}
+	shared.once.Do(func() { shared.devices, shared.err = list() })
+	return shared.devices, shared.err
+}
+
+// ListSecrets lists the secrets managed by the reconciler.
+func (r reconciler) ListSecrets(ctx context.Context) ([]corev1.Secret, error) {
+	var secrets corev1.SecretList
+	if err := r.ks.List(ctx, &secrets, client.MatchingLabels{"apps.kubernetes.io/managed-by": r.managedBy}); err != nil {
+		return nil, err
+	}
+	return secrets.Items, nil
+}
+
 // CreateDeviceSecret creates a new Tailscale device's secret based on the device's metadata.
-func (r reconciler) CreateDeviceSecret(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) error {
+func (r reconciler) CreateDeviceSecret(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) (err error) {
+	defer observeAction(ActionCreateSecret, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("create")
 
-	tailnet := ""
-	if rxTailnet.MatchString(device.Name) {
-		tailnet = rxTailnet.FindStringSubmatch(device.Name)[1]
+	cfg, err := r.buildConfig(device)
+	if err != nil {
+		return err
 	}
-
-	secret := corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      namespacedName.Name,
-			Namespace: namespacedName.Namespace,
-			Annotations: map[string]string{
-				AnnotationDeviceID:       device.NodeID,
-				AnnotationDeviceAddress:  device.Addresses[0],
-				AnnotationDeviceHostname: device.Hostname,
-			},
-			Labels: map[string]string{
-				"argocd.argoproj.io/secret-type": "cluster",
-				"apps.kubernetes.io/managed-by":  r.managedBy,
-
-				LabelDeviceOS:      device.OS,
-				LabelDeviceVersion: device.ClientVersion,
-			},
-		},
-		StringData: map[string]string{
-			"name":   device.Name,
-			"server": fmt.Sprintf("https://%s", device.Name),
-			"config": `{"tlsClientConfig":{"insecure":false}}`,
-		},
+	cfg.Pending = r.requireApproval
+	secret, err := BuildDesiredSecret(namespacedName, device, cfg)
+	if err != nil {
+		return err
 	}
+	now := time.Now()
+	r.stampExpiry(ctx, namespacedName, &secret, now)
+	r.stampProvenance(ctx, &secret, now)
 
-	if tailnet != "" {
-		secret.Annotations[AnnotationDeviceTailnet] = tailnet
+	if cfg.Pending {
+		log.V(1).Info("Tailscale device's secret requires approval before being registered as ArgoCD cluster", "annotation", AnnotationApproved)
+	}
+	if err := r.runHooks(func(h Hooks) error { return h.OnBeforeCreate(ctx, device, &secret) }); err != nil {
+		return err
 	}
 
-	// Process device tags
-	for _, tag := range device.Tags {
-		secret.Labels[LabelDeviceTagsPrefix+strings.TrimPrefix(tag, "tag:")] = ""
+	r.guardObjectMeta(ctx, namespacedName, &secret.ObjectMeta, secret.Annotations)
+	if err := r.guardSecretSize(ctx, namespacedName, secret); err != nil {
+		return err
 	}
 
 	log.V(3).Info("Create Tailscale device secret")
-	return r.ks.Create(ctx, &secret)
+	if err := r.ks.Create(ctx, &secret); err != nil {
+		return err
+	}
+	r.reportTagLabels(ctx, namespacedName, device)
+	return r.runHooks(func(h Hooks) error { return h.OnAfterCreate(ctx, device, &secret) })
 }
 
 // UpdateDeviceSecret updates an existing Tailscale device's secret based on the device's metadata.
 func (r reconciler) UpdateDeviceSecret(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) error {
+	ctrllog.FromContext(ctx).V(3).Info("Retrieving current Tailscale device's secret")
+	var secret corev1.Secret
+	if err := r.ks.Get(ctx, namespacedName, &secret); err != nil {
+		return err
+	}
+	return r.writeDeviceSecret(ctx, secret, device)
+}
+
+// writeDeviceSecret updates the given Tailscale device's secret, as already read, based on the
+// device's metadata.
+func (r reconciler) writeDeviceSecret(ctx context.Context, secret corev1.Secret, device tailscale.Device) (err error) {
+	defer observeAction(ActionUpdateSecret, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("update")
+	return r.updateDeviceSecret(ctrllog.IntoContext(ctx, log), secret, device)
+}
+
+// errAdoptionNotApproved is returned when adopting an existing secret not approved yet while the
+// registrations must be approved.
+var errAdoptionNotApproved = stderrors.New("existing secret not approved")
+
+// AdoptDeviceSecret takes ownership of an existing secret that is not managed by this controller
+// (e.g. deleted and recreated by another tool without the controller labels) by updating it based
+// on the device's metadata. The secret is read directly from the API server as it is not visible
+// through the cache. When the registrations must be approved, a secret not managed by this
+// controller is only adopted once approved, as it would otherwise register the device right away.
+func (r reconciler) AdoptDeviceSecret(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) (err error) {
+	defer observeAction(ActionAdoptSecret, time.Now(), &err)
+	log := ctrllog.FromContext(ctx).WithName("adopt")
 
-	log.V(3).Info("Retrieving current Tailscale device's secret")
 	var secret corev1.Secret
-	err := r.ks.Get(ctx, namespacedName, &secret)
-	if err != nil {
+	if err := r.reader.Get(ctx, namespacedName, &secret); err != nil {
 		return err
 	}
+	if r.requireApproval && secret.Labels["apps.kubernetes.io/managed-by"] != r.managedBy && secret.Annotations[AnnotationApproved] != "true" {
+		return errAdoptionNotApproved
+	}
+	return r.updateDeviceSecret(ctrllog.IntoContext(ctx, log), secret, device)
+}
+
+// updateDeviceSecret updates the given secret, as already read, based on the device's metadata.
+func (r reconciler) updateDeviceSecret(ctx context.Context, secret corev1.Secret, device tailscale.Device) (err error) {
+	log := ctrllog.FromContext(ctx)
+	namespacedName := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
 
-	// Update secret metadata
-	secret.Annotations[AnnotationDeviceID] = device.NodeID
-	secret.Annotations[AnnotationDeviceHostname] = device.Hostname
-	secret.Annotations[AnnotationDeviceAddress] = device.Addresses[0]
-	secret.Labels["argocd.argoproj.io/secret-type"] = "cluster"
-	secret.Labels["apps.kubernetes.io/managed-by"] = r.managedBy
-	secret.Labels[LabelDeviceOS] = device.OS
-	secret.Labels[LabelDeviceVersion] = device.ClientVersion
+	if r.rollout != nil && secret.Annotations[AnnotationConfigHash] != r.rollout.ConfigHash {
+		if !r.rollout.Allows(device) {
+			log.V(1).Info("Tailscale device's secret written with another configuration, left untouched until the canary rollout is promoted", "rollout", map[string]any{"hash": r.rollout.ConfigHash, "failure": r.rollout.Failure()})
+			return nil
+		}
+		if r.rollout.IsCanary(device) {
+			defer func() {
+				if err != nil {
+					log.Error(err, "Canary device failed to apply the new configuration, rollout halted", "rollout", map[string]any{"hash": r.rollout.ConfigHash})
+					r.rollout.fail(ctx, err)
+				}
+			}()
+		}
+	}
 
-	tailnet := ""
-	if rxTailnet.MatchString(device.Name) {
-		tailnet = rxTailnet.FindStringSubmatch(device.Name)[1]
-		secret.Annotations[AnnotationDeviceTailnet] = tailnet
+	// Update secret metadata and content
+	cfg, err := r.buildConfig(device)
+	if err != nil {
+		return err
+	}
+	cfg.Pending = secret.Annotations[AnnotationApproved] == "false"
+	desired, err := BuildDesiredSecret(namespacedName, device, cfg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if upToDate(secret, desired) && r.ttlUpToDate(secret, now) {
+		log.V(3).Info("Tailscale device's secret is up to date", "hash", desired.Annotations[AnnotationContentHash])
+		return nil
+	}
+	mergeObjectMeta(&secret.ObjectMeta, desired.ObjectMeta)
+	r.stampExpiry(ctx, namespacedName, &secret, now)
+	r.stampProvenance(ctx, &secret, now)
+	if cfg.Pending {
+		log.V(2).Info("Tailscale device's secret is still pending approval", "annotation", AnnotationApproved)
+	}
+	if _, exists := desired.Labels[argocd.LabelSecretType]; !exists {
+		delete(secret.Labels, argocd.LabelSecretType)
 	}
+	secret.Data = nil
+	secret.StringData = desired.StringData
 
-	// Process device tags
-	for _, tag := range device.Tags {
-		secret.Labels[LabelDeviceTagsPrefix+strings.TrimPrefix(tag, "tag:")] = ""
+	if err := r.runHooks(func(h Hooks) error { return h.OnBeforeUpdate(ctx, device, &secret) }); err != nil {
+		return err
 	}
 
-	secret.Data = nil
-	secret.StringData = map[string]string{
-		"name":   device.Name,
-		"server": fmt.Sprintf("https://%s", device.Name),
-		"config": `{"tlsClientConfig":{"insecure":false}}`,
+	r.guardObjectMeta(ctx, namespacedName, &secret.ObjectMeta, desired.Annotations)
+	if err := r.guardSecretSize(ctx, namespacedName, secret); err != nil {
+		return err
 	}
 
 	log.V(3).Info("Update Tailscale device secret")
-	return r.ks.Update(ctx, &secret)
+	if err := r.ks.Update(ctx, &secret); err != nil {
+		return err
+	}
+	r.reportTagLabels(ctx, namespacedName, device)
+	return r.runHooks(func(h Hooks) error { return h.OnAfterUpdate(ctx, device, &secret) })
 }
 
 // DeleteDeviceSecret deletes an existing Tailscale device's secret.
-func (r reconciler) DeleteDeviceSecret(ctx context.Context, namespacedName types.NamespacedName) error {
+func (r reconciler) DeleteDeviceSecret(ctx context.Context, namespacedName types.NamespacedName) (err error) {
+	defer observeAction(ActionDeleteSecret, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("delete")
 
 	// Get the secret first to check if it exists and log its metadata
 	log.V(3).Info("Retrieving current Tailscale device's secret")
 	var secret corev1.Secret
-	err := r.ks.Get(ctx, namespacedName, &secret)
+	err = r.ks.Get(ctx, namespacedName, &secret)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Secret does not exist, nothing to do
@@ -334,77 +842,295 @@ func (r reconciler) DeleteDeviceSecret(ctx context.Context, namespacedName types
 		return err
 	}
 
+	if err := r.runHooks(func(h Hooks) error { return h.OnBeforeDelete(ctx, &secret) }); err != nil {
+		return err
+	}
+
 	log.V(3).Info("Delete Tailscale device secret")
-	return r.ks.Delete(ctx, &corev1.Secret{
+	err = r.ks.Delete(ctx, &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      namespacedName.Name,
 			Namespace: namespacedName.Namespace,
 		},
 	})
+	if err != nil {
+		return err
+	}
+	return r.runHooks(func(h Hooks) error { return h.OnAfterDelete(ctx, &secret) })
+}
+
+// buildConfig returns the settings used to build the desired state of the given device's resources.
+func (r reconciler) buildConfig(device tailscale.Device) (BuildConfig, error) {
+	cfg := BuildConfig{ManagedBy: r.managedBy, ProxyClass: r.serviceConfig.ProxyClass, Flavor: r.flavor, TagLabels: r.tagLabelMode, ServerAddress: r.serverAddress, DataMetadata: r.dataMetadata, Annotations: r.annotations, Connectivity: r.connectivity}
+	if r.rollout != nil {
+		cfg.ConfigHash = r.rollout.ConfigHash
+	}
+	for _, labeler := range r.labelers {
+		labels, err := labeler.Labels(device)
+		if err != nil {
+			return BuildConfig{}, fmt.Errorf("failed to compute labels of device %q: %w", device.Name, err)
+		}
+		if cfg.Labels == nil {
+			cfg.Labels = map[string]string{}
+		}
+		maps.Copy(cfg.Labels, labels)
+	}
+	for _, renderer := range r.extraData {
+		data, err := renderer.Render(device)
+		if err != nil {
+			return BuildConfig{}, fmt.Errorf("failed to render extra data of device %q: %w", device.Name, err)
+		}
+		if cfg.ExtraData == nil {
+			cfg.ExtraData = map[string]string{}
+		}
+		maps.Copy(cfg.ExtraData, data)
+	}
+	serverName, err := r.serverName(device)
+	if err != nil {
+		return BuildConfig{}, fmt.Errorf("failed to render TLS server name of device %q: %w", device.Name, err)
+	}
+	cfg.TLSServerName = serverName
+	if err := r.fleetBuildConfig(device, &cfg); err != nil {
+		return BuildConfig{}, fmt.Errorf("failed to render fleet settings of device %q: %w", device.Name, err)
+	}
+	return cfg, nil
+}
+
+// syncInterval returns the shortest reconciliation interval configured for the tags of the given
+// device, the jittered per-object interval if none is configured, or zero if none is enabled.
+func (r reconciler) syncInterval(device tailscale.Device) time.Duration {
+	var interval time.Duration
+	for _, tag := range device.Tags {
+		if i, exists := r.syncIntervals[tag]; exists && i > 0 && (interval == 0 || i < interval) {
+			interval = i
+		}
+	}
+	if interval == 0 && r.resyncPerObject > 0 {
+		interval = r.resyncPerObject
+		if spread := r.resyncPerObject / 10; spread > 0 {
+			interval += rand.N(spread)
+		}
+	}
+	return interval
+}
+
+// requeueAfter returns when the given device's secret, as read before its update (empty when it
+// has just been created), must be reconciled again: after the sync interval of the device, as soon
+// as the rollout holding the secret back is promoted, or once the secret expires.
+func (r reconciler) requeueAfter(secret corev1.Secret, device tailscale.Device) time.Duration {
+	after := r.syncInterval(device)
+	if r.rollout != nil && secret.Annotations[AnnotationConfigHash] != r.rollout.ConfigHash && !r.rollout.Allows(device) {
+		if remaining := r.rollout.Remaining(); remaining > 0 && (after == 0 || remaining < after) {
+			after = remaining
+		}
+	}
+	if expiry := r.untilExpiry(secret, time.Now()); expiry > 0 && (after == 0 || expiry < after) {
+		after = expiry
+	}
+	return after
+}
+
+// reportCollision reports that several devices share the secret with the given name.
+func (r reconciler) reportCollision(ctx context.Context, namespacedName types.NamespacedName, devices ...tailscale.Device) {
+	ids := make([]string, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, fmt.Sprintf("%s (%s)", device.Name, device.NodeID))
+	}
+	ctrllog.FromContext(ctx).Error(nil, "Tailscale device name collision detected", "secret", namespacedName.String(), "devices", ids)
+
+	r.event(ctx, namespacedName, corev1.EventTypeWarning, "DeviceNameCollision",
+		"Tailscale devices %s share the same secret; it is left untouched until the collision is resolved", strings.Join(ids, ", "))
+}
+
+// event records a Kubernetes event regarding the secret with the given name, if an event recorder
+// is configured.
+func (r reconciler) event(ctx context.Context, namespacedName types.NamespacedName, eventtype, reason, note string, args ...any) {
+	if r.recorder == nil {
+		return
+	}
+	var secret corev1.Secret
+	if err := r.reader.Get(ctx, namespacedName, &secret); err != nil {
+		return
+	}
+	r.recorder.Eventf(&secret, nil, eventtype, reason, "Reconcile", note, args...)
+}
+
+// IndexDeviceID is the field index of the secrets by the device ID of their AnnotationDeviceID
+// annotation, used to find the secrets of a device without going through every managed secret.
+const IndexDeviceID = "metadata.annotations.device-id"
+
+// IndexSecretDeviceID is the indexer of the IndexDeviceID field index.
+func IndexSecretDeviceID(obj client.Object) []string {
+	if id := obj.GetAnnotations()[AnnotationDeviceID]; id != "" {
+		return []string{id}
+	}
+	return nil
+}
+
+// staleSecrets returns the other managed secrets of the namespace carrying the ID of the given
+// device (e.g. created under its previous name before a rename), which must be deleted along with
+// their resources as the secret with the given name is the current one of the device.
+func (r reconciler) staleSecrets(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) ([]types.NamespacedName, error) {
+	if device.NodeID == "" {
+		return nil, nil
+	}
+
+	opts := []client.ListOption{
+		client.InNamespace(namespacedName.Namespace),
+		client.MatchingLabels{"apps.kubernetes.io/managed-by": r.managedBy},
+	}
+	if r.deviceIDIndex {
+		opts = append(opts, client.MatchingFields{IndexDeviceID: device.NodeID})
+	}
+	var secrets corev1.SecretList
+	if err := r.ks.List(ctx, &secrets, opts...); err != nil {
+		return nil, err
+	}
+
+	var stale []types.NamespacedName
+	for _, secret := range secrets.Items {
+		if secret.Name != namespacedName.Name && secret.Annotations[AnnotationDeviceID] == device.NodeID {
+			stale = append(stale, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace})
+		}
+	}
+	return stale, nil
+}
+
+// deleteStaleSecrets deletes the resources of the given stale secrets of the device, recording an
+// event with the given reason ("Renamed" when the device secret has just been created under its new
+// name, "DuplicatePruned" otherwise) on its current secret.
+func (r reconciler) deleteStaleSecrets(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device, stale []types.NamespacedName, reason string) error {
+	log := ctrllog.FromContext(ctx).WithName("stale")
+
+	for _, previous := range stale {
+		if reason == "Renamed" {
+			log.V(1).Info("Tailscale device has been renamed, previous Tailscale device's resources will be deleted", "previous", previous.Name)
+		} else {
+			log.V(0).Info("Duplicate Tailscale device's secret found, its resources will be deleted", "duplicate", previous.Name)
+		}
+		if err := r.deleteDeviceResources(ctx, previous); err != nil {
+			return err
+		}
+
+		if reason == "Renamed" {
+			r.event(ctx, namespacedName, corev1.EventTypeNormal, reason, "Tailscale device %s renamed from %s", device.NodeID, previous.Name)
+		} else {
+			r.event(ctx, namespacedName, corev1.EventTypeNormal, reason, "Duplicate secret %s of Tailscale device %s deleted", previous.Name, device.NodeID)
+		}
+	}
+	return nil
+}
+
+// deleteDeviceResources deletes the secret of a device and the resources created alongside it.
+func (r reconciler) deleteDeviceResources(ctx context.Context, namespacedName types.NamespacedName) error {
+	if err := r.DeleteDeviceSecret(ctx, namespacedName); err != nil {
+		return err
+	}
+	if r.serviceConfig.CreateService {
+		if err := r.DeleteDeviceService(ctx, namespacedName); err != nil {
+			return err
+		}
+	}
+	if r.clusterResource {
+		if err := r.DeleteDeviceCluster(ctx, namespacedName); err != nil {
+			return err
+		}
+	}
+	if r.application != nil {
+		if err := r.DeleteDeviceApplication(ctx, namespacedName); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // KubernetesClient returns the Kubernetes client.
 func (r reconciler) KubernetesClient() client.Client { return r.ks }
 
-// TailscaleClient returns the Tailscale client.
-func (r reconciler) TailscaleClient() *tailscale.Client { return r.ts }
+// DeviceAPI returns the Tailscale device API.
+func (r reconciler) DeviceAPI() ts.DeviceAPI { return r.ts }
 
-// CreateDeviceService creates a new Tailscale device's service with Tailscale annotations.
-func (r reconciler) CreateDeviceService(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) error {
+// CreateDeviceService creates a new Tailscale device's service with Tailscale annotations, and
+// deletes the services previously created for the same secret under another name (e.g. before a
+// change of the service name template).
+func (r reconciler) CreateDeviceService(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) (err error) {
+	defer observeAction(ActionCreateService, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("create_service")
 
-	service := corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      toDNS1035Name(namespacedName.Name),
-			Namespace: namespacedName.Namespace,
-			Annotations: map[string]string{
-				"tailscale.com/tailnet-fqdn": device.Name,
-			},
-			Labels: map[string]string{
-				"apps.kubernetes.io/managed-by": r.managedBy,
-				LabelDeviceOS:                   device.OS,
-				LabelDeviceVersion:              device.ClientVersion,
-			},
-		},
-		Spec: corev1.ServiceSpec{
-			Type:         corev1.ServiceTypeExternalName,
-			ExternalName: "ts.net",
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "https",
-					Port:       443,
-					Protocol:   corev1.ProtocolTCP,
-					TargetPort: intstr.FromInt(443),
-				},
-			},
-		},
+	cfg, err := r.buildConfig(device)
+	if err != nil {
+		return err
+	}
+	serviceNamespacedName, err := r.serviceNamespacedName(namespacedName)
+	if err != nil {
+		return err
 	}
+	cfg.ServiceName = serviceNamespacedName.Name
+	service := BuildDesiredService(namespacedName, device, cfg)
+	r.guardObjectMeta(ctx, namespacedName, &service.ObjectMeta, service.Annotations)
 
-	// Add ProxyClass annotation if specified
-	if r.serviceConfig.ProxyClass != "" {
-		service.Annotations["tailscale.com/proxy-class"] = r.serviceConfig.ProxyClass
+	log.V(3).Info("Create Tailscale device service")
+	if err := r.ks.Create(ctx, &service); err != nil {
+		return err
 	}
+	return r.deleteRenamedServices(ctrllog.IntoContext(ctx, log), namespacedName, serviceNamespacedName, device)
+}
 
-	// Process device tags
-	for _, tag := range device.Tags {
-		service.Labels[LabelDeviceTagsPrefix+strings.TrimPrefix(tag, "tag:")] = ""
+// deleteRenamedServices deletes the services of the device whose secret has the given namespaced
+// name that are not named as the current one: those referencing the secret, and those created
+// before the services referenced their secret, for the same device.
+func (r reconciler) deleteRenamedServices(ctx context.Context, namespacedName, current types.NamespacedName, device tailscale.Device) error {
+	var services corev1.ServiceList
+	err := r.ks.List(ctx, &services, client.InNamespace(namespacedName.Namespace), client.MatchingLabels{"apps.kubernetes.io/managed-by": r.managedBy})
+	if err != nil {
+		return err
 	}
 
-	log.V(3).Info("Create Tailscale device service")
-	return r.ks.Create(ctx, &service)
+	for _, service := range services.Items {
+		owner, owned := service.Annotations[AnnotationSecret]
+		switch {
+		case service.Name == current.Name:
+			continue
+		case owned && owner != namespacedName.String():
+			continue
+		case !owned && service.Annotations["tailscale.com/tailnet-fqdn"] != device.Name:
+			continue
+		}
+		ctrllog.FromContext(ctx).V(1).Info("Tailscale device's service has been renamed, previous service will be deleted", "previous", service.Name)
+		if err := r.ks.Delete(ctx, &service); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // UpdateDeviceService updates an existing Tailscale device's service.
-func (r reconciler) UpdateDeviceService(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) error {
+func (r reconciler) UpdateDeviceService(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) (err error) {
+	defer observeAction(ActionUpdateService, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("update_service")
+	return r.updateDeviceService(ctrllog.IntoContext(ctx, log), r.ks, namespacedName, device)
+}
+
+// AdoptDeviceService updates an existing Tailscale device's service that is not visible through
+// the cache (e.g. created concurrently), reading it directly from the API server.
+func (r reconciler) AdoptDeviceService(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device) (err error) {
+	defer observeAction(ActionAdoptService, time.Now(), &err)
+	log := ctrllog.FromContext(ctx).WithName("adopt_service")
+	return r.updateDeviceService(ctrllog.IntoContext(ctx, log), r.reader, namespacedName, device)
+}
+
+// updateDeviceService updates the service retrieved through the given reader, creating it if it
+// does not exist.
+func (r reconciler) updateDeviceService(ctx context.Context, reader client.Reader, namespacedName types.NamespacedName, device tailscale.Device) error {
+	log := ctrllog.FromContext(ctx)
 
 	log.V(3).Info("Retrieving current Tailscale device's service")
 	var service corev1.Service
-	serviceNamespacedName := types.NamespacedName{
-		Name:      toDNS1035Name(namespacedName.Name),
-		Namespace: namespacedName.Namespace,
+	serviceNamespacedName, err := r.serviceNamespacedName(namespacedName)
+	if err != nil {
+		return err
 	}
-	err := r.ks.Get(ctx, serviceNamespacedName, &service)
+	err = reader.Get(ctx, serviceNamespacedName, &service)
 	if errors.IsNotFound(err) {
 		// Service doesn't exist, create it
 		log.V(2).Info("Service not found, creating it")
@@ -412,39 +1138,47 @@ func (r reconciler) UpdateDeviceService(ctx context.Context, namespacedName type
 	} else if err != nil {
 		return err
 	}
-
-	// Update service metadata
-	service.Annotations["tailscale.com/tailnet-fqdn"] = device.Name
-	service.Labels["apps.kubernetes.io/managed-by"] = r.managedBy
-	service.Labels[LabelDeviceOS] = device.OS
-	service.Labels[LabelDeviceVersion] = device.ClientVersion
-
-	// Add ProxyClass annotation if specified
-	if r.serviceConfig.ProxyClass != "" {
-		service.Annotations["tailscale.com/proxy-class"] = r.serviceConfig.ProxyClass
+	if err := serviceOwnedBy(service, namespacedName); err != nil {
+		return err
 	}
 
-	// Process device tags
-	for _, tag := range device.Tags {
-		service.Labels[LabelDeviceTagsPrefix+strings.TrimPrefix(tag, "tag:")] = ""
+	// Update service metadata
+	cfg, err := r.buildConfig(device)
+	if err != nil {
+		return err
 	}
+	cfg.ServiceName = serviceNamespacedName.Name
+	desired := BuildDesiredService(namespacedName, device, cfg)
+	mergeObjectMeta(&service.ObjectMeta, desired.ObjectMeta)
+	r.guardObjectMeta(ctx, namespacedName, &service.ObjectMeta, desired.Annotations)
 
 	log.V(3).Info("Update Tailscale device service")
 	return r.ks.Update(ctx, &service)
 }
 
+// serviceOwnedBy returns an error if the given service has been generated for another secret than
+// the one with the given namespaced name, i.e. the service names of several devices collide.
+// Services not referencing their secret (created by older releases) are owned by any secret.
+func serviceOwnedBy(service corev1.Service, namespacedName types.NamespacedName) error {
+	if owner, owned := service.Annotations[AnnotationSecret]; owned && owner != namespacedName.String() {
+		return fmt.Errorf("service %s/%s already belongs to secret %s: the service names of several devices collide, check --service.name-template", service.Namespace, service.Name, owner)
+	}
+	return nil
+}
+
 // DeleteDeviceService deletes an existing Tailscale device's service.
-func (r reconciler) DeleteDeviceService(ctx context.Context, namespacedName types.NamespacedName) error {
+func (r reconciler) DeleteDeviceService(ctx context.Context, namespacedName types.NamespacedName) (err error) {
+	defer observeAction(ActionDeleteService, time.Now(), &err)
 	log := ctrllog.FromContext(ctx).WithName("delete_service")
 
 	// Get the service first to check if it exists and log its metadata
 	log.V(3).Info("Retrieving current Tailscale device's service")
 	var service corev1.Service
-	serviceNamespacedName := types.NamespacedName{
-		Name:      toDNS1035Name(namespacedName.Name),
-		Namespace: namespacedName.Namespace,
+	serviceNamespacedName, err := r.serviceNamespacedName(namespacedName)
+	if err != nil {
+		return err
 	}
-	err := r.ks.Get(ctx, serviceNamespacedName, &service)
+	err = r.ks.Get(ctx, serviceNamespacedName, &service)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Service does not exist, nothing to do
@@ -453,12 +1187,17 @@ func (r reconciler) DeleteDeviceService(ctx context.Context, namespacedName type
 		}
 		return err
 	}
+	if err := serviceOwnedBy(service, namespacedName); err != nil {
+		// The service of another device must never be deleted
+		log.V(1).Info("Tailscale device's service belongs to another secret, ignoring", "error", err.Error())
+		return nil
+	}
 
 	log.V(3).Info("Delete Tailscale device service")
 	return r.ks.Delete(ctx, &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      toDNS1035Name(namespacedName.Name),
-			Namespace: namespacedName.Namespace,
+			Name:      serviceNamespacedName.Name,
+			Namespace: serviceNamespacedName.Namespace,
 		},
 	})
 }
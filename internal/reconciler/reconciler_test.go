@@ -5,21 +5,37 @@ package reconciler
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"tailscale.com/client/tailscale/v2"
 
+	"github.com/chezmoidotsh/argotails/internal/apis/v1alpha1"
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+	kubeutils "github.com/chezmoidotsh/argotails/internal/kubernetes"
+	"github.com/chezmoidotsh/argotails/internal/policy"
 	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
 
 	"testing"
@@ -257,6 +273,105 @@ func (suite *ReconcilerSuite) TestReconcile_DeletedDevice() {
 	suite.True(errors.IsNotFound(err))
 }
 
+func (suite *ReconcilerSuite) TestReconcile_PolicyError() {
+	devicePolicy, err := policy.NewPolicy(`device.unknown == "value"`, nil)
+	suite.Require().NoError(err)
+	suite.reconciler.filter = devicePolicy
+
+	err = suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "A.fake.ts.net", Namespace: "argocd"},
+	})
+	suite.Require().NoError(err)
+
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "A", NodeID: "fake-device-id", OS: "linux"}},
+		})
+
+		_, _ = w.Write(raw)
+	}
+
+	// The policy cannot be evaluated against the device: the error is reported and the secret kept
+	_, err = suite.reconciler.Reconcile(
+		context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}},
+	)
+	suite.Error(err)
+
+	var secret corev1.Secret
+	suite.NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_OtherNamespace() {
+	other := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd-apps"}
+	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: other.Name, Namespace: other.Namespace}})
+	suite.Require().NoError(err)
+
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "A", NodeID: "fake-device-id", OS: "linux"}},
+		})
+
+		_, _ = w.Write(raw)
+	}
+
+	// The secret of an existing device outside of the controller namespace (e.g. in a cleanup
+	// namespace) is deleted
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: other})
+	suite.Require().NoError(err)
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), other, &corev1.Secret{})))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_KnownDeletedDevice() {
+	// Create a new device secret.
+	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "A.fake.ts.net",
+			Namespace:   "argocd",
+			Annotations: map[string]string{},
+		},
+	})
+	suite.Require().NoError(err)
+
+	// NOTE: the Tailscale API must not be called, the default mock fails the test if it is.
+	res, err := suite.reconciler.Reconcile(
+		DeviceDeletedContext(context.TODO()),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}},
+	)
+	suite.Require().NoError(err)
+	suite.Equal(reconcile.Result{}, res)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret)
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_StatusRecorder() {
+	statuses := NewStatusRecorder()
+	suite.reconciler.statuses = statuses
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "A", NodeID: "fake-device-id", OS: "linux"}},
+		})
+
+		_, _ = w.Write(raw)
+	}
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	status, found := statuses.Get("A.fake.ts.net")
+	suite.True(found)
+	suite.Empty(status.Error)
+
+	// The status of a deleted secret is forgotten
+	_, err = suite.reconciler.Reconcile(DeviceDeletedContext(context.TODO()), req)
+	suite.Require().NoError(err)
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{})))
+	_, found = statuses.Get("A.fake.ts.net")
+	suite.False(found)
+}
+
 func (suite *ReconcilerSuite) TestReconcile_DeleteNonExistingDevice() {
 	// Update the device secret.
 	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
@@ -318,6 +433,16 @@ func (suite *ReconcilerSuite) TestCreateSecretDevice() {
 	suite.Equal(`{"tlsClientConfig":{"insecure":false}}`, secret.StringData["config"])
 }
 
+func (suite *ReconcilerSuite) TestCreateSecretDevice_TooLarge() {
+	WithExtraData(staticRenderer{"ca": strings.Repeat("a", 2048)})(suite.reconciler)
+	WithMaxSecretSize(2048)(suite.reconciler)
+
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	err := suite.reconciler.CreateDeviceSecret(context.TODO(), nn, tailscale.Device{Name: "A.fake.ts.net", Hostname: "A", NodeID: "fake-device-id"})
+	suite.Require().ErrorContains(err, "exceeds the limit of 2048 bytes (largest entries: ca=2048 bytes")
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), nn, &corev1.Secret{})))
+}
+
 func (suite *ReconcilerSuite) TestUpdateSecretDevice() {
 	// Create a new device secret.
 	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
@@ -380,53 +505,1445 @@ func (suite *ReconcilerSuite) TestUpdateSecretDevice() {
 	suite.Equal(`{"tlsClientConfig":{"insecure":false}}`, secret.StringData["config"])
 }
 
-func (suite *ReconcilerSuite) TestDeleteSecretDevice() {
-	// Create a new device secret.
+func (suite *ReconcilerSuite) TestUpdateSecretDevice_OversizedMetadata() {
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	device := tailscale.Device{Name: "A.fake.ts.net", Hostname: "A", NodeID: "fake-device-id", ClientVersion: strings.Repeat("1.2.3-", 20)}
+	suite.Require().NoError(suite.reconciler.CreateDeviceSecret(context.TODO(), nn, device))
+
+	var created corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &created))
+	suite.LessOrEqual(len(created.Labels[LabelDeviceVersion]), 63)
+
+	// The truncated secret is up to date: it is not rewritten by every reconciliation.
+	suite.Require().NoError(suite.reconciler.UpdateDeviceSecret(context.TODO(), nn, device))
+	var updated corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &updated))
+	suite.Equal(created.ResourceVersion, updated.ResourceVersion)
+}
+
+func (suite *ReconcilerSuite) TestUpdateSecretDevice_Rollout() {
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "a"}, {Name: "B.fake.ts.net", NodeID: "b"}}
+	suite.reconciler.rollout = NewRollout("old", nil, 0, 0)
+	for _, device := range devices {
+		suite.Require().NoError(suite.reconciler.CreateDeviceSecret(context.TODO(), types.NamespacedName{Name: device.Name, Namespace: "argocd"}, device))
+	}
+	configHash := func(name string) string {
+		var secret corev1.Secret
+		suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "argocd"}, &secret))
+		return secret.Annotations[AnnotationConfigHash]
+	}
+	update := func() {
+		for _, device := range devices {
+			suite.Require().NoError(suite.reconciler.UpdateDeviceSecret(context.TODO(), types.NamespacedName{Name: device.Name, Namespace: "argocd"}, device))
+		}
+	}
+
+	// Only the canary devices get the new configuration during the soak duration
+	suite.reconciler.rollout = NewRollout("new", []string{"A.fake.ts.net"}, 0, time.Hour)
+	update()
+	suite.Equal("new", configHash("A.fake.ts.net"))
+	suite.Equal("old", configHash("B.fake.ts.net"))
+
+	// A failing canary halts the rollout
+	suite.reconciler.rollout.started = time.Now().Add(-2 * time.Hour)
+	suite.reconciler.rollout.fail(context.TODO(), stderrors.New("failure"))
+	update()
+	suite.Equal("old", configHash("B.fake.ts.net"))
+
+	// Every device gets the new configuration once the soak duration has elapsed
+	suite.reconciler.rollout.failure = nil
+	update()
+	suite.Equal("new", configHash("B.fake.ts.net"))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Rollout() {
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "A", NodeID: "a", Addresses: []string{"0.0.0.0"}}, {Name: "B.fake.ts.net", Hostname: "B", NodeID: "b", Addresses: []string{"0.0.0.0"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	suite.reconciler.rollout = NewRollout("old", nil, 0, 0)
+	for _, device := range devices {
+		suite.Require().NoError(suite.reconciler.CreateDeviceSecret(context.TODO(), types.NamespacedName{Name: device.Name, Namespace: "argocd"}, device))
+	}
+
+	// The secrets held back by the rollout are reconciled again once the soak duration has elapsed
+	suite.reconciler.rollout = NewRollout("new", []string{"A.fake.ts.net"}, 0, time.Hour)
+	result, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+	suite.InDelta(time.Hour, result.RequeueAfter, float64(time.Minute))
+
+	result, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+	suite.Zero(result.RequeueAfter)
+
+	// A canary cluster ArgoCD fails to connect to halts the rollout
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret))
+	var failed RolloutState
+	suite.reconciler.rollout.OnFail = func(_ context.Context, state RolloutState) { failed = state }
+	suite.reconciler.rollout.ReportConnection(context.TODO(), secret, argocd.ConnectionStatusSuccessful)
+	suite.NoError(suite.reconciler.rollout.Failure())
+	suite.reconciler.rollout.ReportConnection(context.TODO(), secret, argocd.ConnectionStatusFailed)
+	suite.Error(suite.reconciler.rollout.Failure())
+	suite.Equal("new", failed.ConfigHash)
+	suite.NotEmpty(failed.Failure)
+
+	// Halted rollouts are not waited for
+	result, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+	suite.Zero(result.RequeueAfter)
+}
+
+func (suite *ReconcilerSuite) TestAdoptSecretDevice() {
+	// Create an unmanaged secret with the same name as the device.
 	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "A.fake.ts.net",
 			Namespace: "argocd",
-			Annotations: map[string]string{
-				AnnotationDeviceID: "fake-device-id",
-			},
 		},
+		Data: map[string][]byte{"existing-binary-key": []byte("existing-binary-value")},
 	})
 	suite.Require().NoError(err)
 
-	// Delete the device secret.
-	err = suite.reconciler.DeleteDeviceSecret(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"})
+	// Adopt the device secret.
+	err = suite.reconciler.AdoptDeviceSecret(
+		context.TODO(),
+		types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"},
+		tailscale.Device{
+			Name:          "A.fake.ts.net",
+			Hostname:      "A",
+			NodeID:        "fake-device-id",
+			Addresses:     []string{"0.0.0.0"},
+			OS:            "linux",
+			ClientVersion: "v1.2.3",
+		},
+	)
 	suite.Require().NoError(err)
 
 	// Check the device secret.
 	var secret corev1.Secret
 	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret)
-	suite.Error(err)
-	suite.True(errors.IsNotFound(err))
+	suite.Require().NoError(err)
+
+	suite.Equal("fake-device-id", secret.Annotations[AnnotationDeviceID])
+	suite.Equal("cluster", secret.Labels["argocd.argoproj.io/secret-type"])
+	suite.Equal(managedBy, secret.Labels["apps.kubernetes.io/managed-by"])
+	suite.Equal("https://A.fake.ts.net", secret.StringData["server"])
 }
 
-func (suite *ReconcilerSuite) SetupTest() {
-	scheme := runtime.NewScheme()
-	err := corev1.AddToScheme(scheme)
+func (suite *ReconcilerSuite) TestReconcile_ApprovalWorkflow() {
+	suite.reconciler.requireApproval = true
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{
+				{
+					Name:      "A.fake.ts.net",
+					Hostname:  "A",
+					NodeID:    "fake-device-id",
+					Addresses: []string{"0.0.0.0"},
+				},
+			},
+		})
+
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	// New devices are pending approval.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
 	suite.Require().NoError(err)
-	ks := fake.NewClientBuilder().WithScheme(scheme).Build()
 
-	suite.testserver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		suite.tailscaleMock(w, r)
-	}))
-	srvURL, err := url.Parse(suite.testserver.URL)
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
 	suite.Require().NoError(err)
-	ts := &tailscale.Client{Tailnet: "fake.ts.net", HTTP: suite.testserver.Client(), BaseURL: srvURL}
+	suite.Equal("false", secret.Annotations[AnnotationApproved])
+	suite.NotContains(secret.Labels, "argocd.argoproj.io/secret-type")
 
-	suite.kubernetesMock = ks
-	suite.tailscaleMock = func(w http.ResponseWriter, r *http.Request) { suite.Fail("request not mocked") }
-	suite.reconciler = &reconciler{ts: ts, ks: ks, filter: tsutils.FuncTagFilter(func(_ tailscale.Device) bool { return true }), managedBy: managedBy}
+	// Pending devices stay pending on update.
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
 
-	suite.kubernetesMock.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd"}})
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.NotContains(secret.Labels, "argocd.argoproj.io/secret-type")
+
+	// Approved devices are registered as ArgoCD cluster.
+	secret.Annotations[AnnotationApproved] = "true"
+	suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Equal("true", secret.Annotations[AnnotationApproved])
+	suite.Equal("cluster", secret.Labels["argocd.argoproj.io/secret-type"])
 }
 
-func (suite *ReconcilerSuite) TearDownTest() {
-	suite.testserver.Close()
-	suite.reconciler = nil
+func (suite *ReconcilerSuite) TestReconcile_ApprovalWorkflow_ExistingSecret() {
+	suite.reconciler.requireApproval = true
+	// Unmanaged secrets are not visible through the cache, so the device's secret is created and
+	// then adopted.
+	suite.reconciler.ks = kubeutils.NewDirectSecretClient(suite.kubernetesMock, suite.kubernetesMock, labels.SelectorFromSet(labels.Set{"apps.kubernetes.io/managed-by": managedBy}))
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{
+				{
+					Name:      "A.fake.ts.net",
+					Hostname:  "A",
+					NodeID:    "fake-device-id",
+					Addresses: []string{"0.0.0.0"},
+				},
+			},
+		})
+
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "A.fake.ts.net", Namespace: "argocd"},
+		Data:       map[string][]byte{"existing-binary-key": []byte("existing-binary-value")},
+	})
+	suite.Require().NoError(err)
+
+	// Unapproved existing secrets are left untouched.
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Empty(secret.Labels)
+	suite.Empty(secret.Annotations)
+	suite.Equal([]byte("existing-binary-value"), secret.Data["existing-binary-key"])
+
+	var services corev1.ServiceList
+	suite.Require().NoError(suite.kubernetesMock.List(context.TODO(), &services))
+	suite.Empty(services.Items)
+
+	// Approved existing secrets are adopted and registered as ArgoCD cluster.
+	secret.Annotations = map[string]string{AnnotationApproved: "true"}
+	suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Equal(managedBy, secret.Labels["apps.kubernetes.io/managed-by"])
+	suite.Equal("cluster", secret.Labels["argocd.argoproj.io/secret-type"])
+	suite.Equal("fake-device-id", secret.Annotations[AnnotationDeviceID])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_LabelsRemoved() {
+	devicePolicy, err := policy.NewPolicy("", map[string]string{"env": `device.os == "linux" ? "prod" : ""`})
+	suite.Require().NoError(err)
+	WithLabeler(devicePolicy)(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux", Tags: []string{"tag:k8s"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret))
+	suite.Equal("prod", secret.Labels["env"])
+	suite.Contains(secret.Labels, LabelDeviceTagsPrefix+"k8s")
+
+	// The labels no longer computed are removed, the ones added by others are kept.
+	secret.Labels["example.com/other"] = "value"
+	suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+	devices[0].OS, devices[0].Tags = "windows", []string{"tag:lab"}
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret))
+	suite.NotContains(secret.Labels, "env")
+	suite.NotContains(secret.Labels, LabelDeviceTagsPrefix+"k8s")
+	suite.Contains(secret.Labels, LabelDeviceTagsPrefix+"lab")
+	suite.Equal("value", secret.Labels["example.com/other"])
+}
+
+func (suite *ReconcilerSuite) TestCreateSecretDevice_Labelers() {
+	WithLabeler(LabelerFunc(func(device tailscale.Device) (map[string]string, error) {
+		return map[string]string{"fleet": "edge"}, nil
+	}))(suite.reconciler)
+	WithLabeler(LabelerFunc(func(device tailscale.Device) (map[string]string, error) {
+		return map[string]string{LabelDeviceOutdated: "true"}, nil
+	}))(suite.reconciler)
+
+	err := suite.reconciler.CreateDeviceSecret(
+		context.TODO(),
+		types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"},
+		tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id"},
+	)
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret)
+	suite.Require().NoError(err)
+	suite.Equal("edge", secret.Labels["fleet"])
+	suite.Equal("true", secret.Labels[LabelDeviceOutdated])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ClusterResource() {
+	suite.reconciler.clusterResource = true
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", ConnectedToControl: true}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	// The cluster resource is created along with the secret.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var cluster v1alpha1.TailscaleCluster
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &cluster)
+	suite.Require().NoError(err)
+	suite.Equal(v1alpha1.TailscaleClusterSpec{DeviceID: "fake-device-id", DeviceName: "A.fake.ts.net", SecretName: "A.fake.ts.net"}, cluster.Spec)
+	suite.Equal(managedBy, cluster.Labels["apps.kubernetes.io/managed-by"])
+	suite.True(meta.IsStatusConditionTrue(cluster.Status.Conditions, v1alpha1.ConditionDeviceOnline))
+	suite.True(meta.IsStatusConditionTrue(cluster.Status.Conditions, v1alpha1.ConditionSecretSynced))
+	suite.Equal("Disabled", meta.FindStatusCondition(cluster.Status.Conditions, v1alpha1.ConditionServiceSynced).Reason)
+	suite.Equal("NotProbed", meta.FindStatusCondition(cluster.Status.Conditions, v1alpha1.ConditionAPIServerReachable).Reason)
+
+	// The status is left untouched while the conditions are unchanged.
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	var unchanged v1alpha1.TailscaleCluster
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &unchanged))
+	suite.Equal(cluster.ResourceVersion, unchanged.ResourceVersion)
+
+	// The ArgoCD connection state is reported on the cluster resource.
+	err = ReportAPIServerReachability(context.TODO(), suite.kubernetesMock, req.NamespacedName, "Failed")
+	suite.Require().NoError(err)
+
+	// Conditions follow the device state.
+	devices[0].ConnectedToControl = false
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &cluster)
+	suite.Require().NoError(err)
+	suite.True(meta.IsStatusConditionFalse(cluster.Status.Conditions, v1alpha1.ConditionDeviceOnline))
+	suite.True(meta.IsStatusConditionFalse(cluster.Status.Conditions, v1alpha1.ConditionAPIServerReachable))
+
+	// The cluster resource is deleted along with the secret.
+	devices = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &cluster)
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_SyncInterval() {
+	WithSyncInterval("argotails-fast-sync", time.Minute)(suite.reconciler)
+	WithSyncInterval("tag:argotails-faster-sync", 30*time.Second)(suite.reconciler)
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{
+				{Name: "A.fake.ts.net", NodeID: "a", Tags: []string{"tag:argotails-fast-sync"}},
+				{Name: "B.fake.ts.net", NodeID: "b", Tags: []string{"tag:argotails-fast-sync", "tag:argotails-faster-sync"}},
+				{Name: "C.fake.ts.net", NodeID: "c", Tags: []string{"tag:other"}},
+			},
+		})
+		_, _ = w.Write(raw)
+	}
+
+	for name, expected := range map[string]time.Duration{"A.fake.ts.net": time.Minute, "B.fake.ts.net": 30 * time.Second, "C.fake.ts.net": 0} {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "argocd"}}
+
+		// Both creation and update are requeued.
+		for range 2 {
+			res, err := suite.reconciler.Reconcile(context.TODO(), req)
+			suite.Require().NoError(err)
+			suite.Equal(reconcile.Result{RequeueAfter: expected}, res, name)
+		}
+	}
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ResyncPerObject() {
+	WithResyncPerObject(10 * time.Minute)(suite.reconciler)
+	WithSyncInterval("argotails-fast-sync", time.Minute)(suite.reconciler)
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{
+			"devices": []tailscale.Device{
+				{Name: "A.fake.ts.net", NodeID: "a", Tags: []string{"tag:argotails-fast-sync"}},
+				{Name: "B.fake.ts.net", NodeID: "b", Tags: []string{"tag:other"}},
+			},
+		})
+		_, _ = w.Write(raw)
+	}
+
+	res, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+	suite.Equal(reconcile.Result{RequeueAfter: time.Minute}, res)
+
+	// Untagged devices are requeued after the per-object interval, delayed by up to a tenth of it.
+	for range 2 {
+		res, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}})
+		suite.Require().NoError(err)
+		suite.GreaterOrEqual(res.RequeueAfter, 10*time.Minute)
+		suite.Less(res.RequeueAfter, 11*time.Minute)
+	}
+}
+
+func (suite *ReconcilerSuite) TestReconcile_TTL() {
+	WithTTL(time.Hour)(suite.reconciler)
+	WithFleets(Fleet{Name: "edge", Match: tsutils.FuncTagFilter(func(tailscale.Device) bool { return true }), DeletionPolicy: DeletionPolicyRetain})(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "a"}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	reconcileAndGet := func() (corev1.Secret, reconcile.Result, error) {
+		res, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: nn})
+		suite.Require().NoError(err)
+		var secret corev1.Secret
+		return secret, res, suite.kubernetesMock.Get(context.TODO(), nn, &secret)
+	}
+	expire := func(secret corev1.Secret, in time.Duration) {
+		secret.Annotations[AnnotationExpiresAt] = time.Now().Add(in).UTC().Format(time.RFC3339)
+		suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+	}
+
+	// New secrets are stamped with their expiry date, kept until expired, and reconciled again
+	// once expired.
+	secret, res, err := reconcileAndGet()
+	suite.Require().NoError(err)
+	expiry, err := time.Parse(time.RFC3339, secret.Annotations[AnnotationExpiresAt])
+	suite.Require().NoError(err)
+	suite.WithinDuration(time.Now().Add(time.Hour), expiry, time.Minute)
+	suite.InDelta(time.Hour, res.RequeueAfter, float64(time.Minute))
+	expire(secret, 10*time.Minute)
+	secret, res, err = reconcileAndGet()
+	suite.Require().NoError(err)
+	suite.WithinDuration(time.Now().Add(10*time.Minute), ExpiresAt(secret), time.Minute)
+	suite.InDelta(10*time.Minute, res.RequeueAfter, float64(time.Minute))
+
+	// Expired secrets of present devices are refreshed.
+	expire(secret, -time.Minute)
+	secret, res, err = reconcileAndGet()
+	suite.Require().NoError(err)
+	expiry, err = time.Parse(time.RFC3339, secret.Annotations[AnnotationExpiresAt])
+	suite.Require().NoError(err)
+	suite.True(expiry.After(time.Now()))
+	suite.InDelta(time.Hour, res.RequeueAfter, float64(time.Minute))
+
+	// Secrets of devices that are gone are retained by their fleet until expired.
+	devices = nil
+	expire(secret, 10*time.Minute)
+	secret, res, err = reconcileAndGet()
+	suite.Require().NoError(err)
+	suite.InDelta(10*time.Minute, res.RequeueAfter, float64(time.Minute))
+	expire(secret, -time.Minute)
+	_, _, err = reconcileAndGet()
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Provenance() {
+	WithControllerVersion("v1.2.3")(suite.reconciler)
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "a", OS: "linux"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	reconcileAndGet := func(trigger string) corev1.Secret {
+		_, err := suite.reconciler.Reconcile(TriggerContext(context.TODO(), trigger), reconcile.Request{NamespacedName: nn})
+		suite.Require().NoError(err)
+		var secret corev1.Secret
+		suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &secret))
+		return secret
+	}
+
+	secret := reconcileAndGet(TriggerWebhook)
+	suite.Equal(TriggerWebhook, secret.Annotations[AnnotationLastSyncTrigger])
+	suite.Equal("v1.2.3", secret.Annotations[AnnotationControllerVersion])
+	synced, err := time.Parse(time.RFC3339, secret.Annotations[AnnotationLastSyncTime])
+	suite.Require().NoError(err)
+	suite.WithinDuration(time.Now(), synced, time.Minute)
+
+	// Unchanged secrets are not written again, keeping the provenance of their last change.
+	secret = reconcileAndGet(TriggerTimer)
+	suite.Equal(TriggerWebhook, secret.Annotations[AnnotationLastSyncTrigger])
+
+	device.OS = "windows"
+	secret = reconcileAndGet(TriggerTimer)
+	suite.Equal(TriggerTimer, secret.Annotations[AnnotationLastSyncTrigger])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_InCluster() {
+	nn := types.NamespacedName{Name: InClusterName, Namespace: "argocd"}
+	pause := &PauseSwitch{}
+	r := NewInClusterReconciler(suite.reconciler, suite.kubernetesMock, suite.kubernetesMock, nn, BuildConfig{
+		ManagedBy:    managedBy,
+		Labels:       map[string]string{"env": "hub"},
+		DataMetadata: DataMetadata{Labels: []string{"env"}},
+	}, pause)
+
+	// Nothing is written while paused
+	pause.Set(true)
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: nn})
+	suite.Require().NoError(err)
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), nn, &corev1.Secret{})))
+	pause.Set(false)
+
+	// Created, then updated once changed, without calling Tailscale
+	for range 2 {
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: nn})
+		suite.Require().NoError(err)
+
+		var secret corev1.Secret
+		suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &secret))
+		suite.Equal("cluster", secret.Labels[argocd.LabelSecretType])
+		suite.Equal("true", secret.Labels[LabelInCluster])
+		suite.Equal("hub", secret.Labels["env"])
+		suite.Equal(InClusterServer, secret.StringData[argocd.KeyServer])
+		suite.Equal(`{"env":"hub"}`, secret.StringData["labels"])
+
+		secret.Labels["env"] = "tampered"
+		suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+	}
+
+	// The in-cluster secret is never deleted as a secret without Tailscale device
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte(`{"devices":[]}`)) }
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: nn})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &corev1.Secret{}))
+
+	// Unmanaged in-cluster secrets are left untouched
+	unmanaged := types.NamespacedName{Name: InClusterName, Namespace: "other"}
+	suite.Require().NoError(suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: unmanaged.Name, Namespace: unmanaged.Namespace},
+		StringData: map[string]string{argocd.KeyServer: InClusterServer},
+	}))
+	r = NewInClusterReconciler(suite.reconciler, suite.kubernetesMock, suite.kubernetesMock, unmanaged, BuildConfig{ManagedBy: managedBy}, nil)
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: unmanaged})
+	suite.Require().NoError(err)
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), unmanaged, &secret))
+	suite.NotContains(secret.Labels, LabelInCluster)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_NameCollision() {
+	recorder := events.NewFakeRecorder(10)
+	suite.reconciler.recorder = recorder
+	devices := []tailscale.Device{
+		{Name: "A.fake.ts.net", NodeID: "a1", Addresses: []string{"100.64.0.1"}},
+		{Name: "A.fake.ts.net", NodeID: "a2", Addresses: []string{"100.64.0.2"}},
+	}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "A.fake.ts.net",
+			Namespace:   "argocd",
+			Annotations: map[string]string{AnnotationDeviceID: "a1", AnnotationDeviceAddress: "100.64.0.1"},
+		},
+	})
+	suite.Require().NoError(err)
+
+	// Colliding devices never overwrite the secret of each other.
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Equal("a1", secret.Annotations[AnnotationDeviceID])
+	suite.Require().Len(recorder.Events, 1)
+	suite.Contains(<-recorder.Events, "DeviceNameCollision")
+
+	// Suffixing the secret names with the node ID removes the collision.
+	suite.reconciler.secretName, _ = NewSecretNamer(SecretNamingDeviceNameNodeID)
+	for _, name := range []string{"A.fake.ts.net-a1", "A.fake.ts.net-a2"} {
+		_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "argocd"}})
+		suite.Require().NoError(err)
+
+		err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "argocd"}, &secret)
+		suite.Require().NoError(err)
+		suite.Equal("https://A.fake.ts.net", secret.StringData["server"])
+	}
+}
+
+func (suite *ReconcilerSuite) TestReconcile_NodeIDNaming() {
+	suite.reconciler.secretName, _ = NewSecretNamer(SecretNamingNodeID)
+	device := tailscale.Device{Name: "A.fake.ts.net", Hostname: "A", NodeID: "nFake1CNTRL"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "ts-nfake1cntrl", Namespace: "argocd"}}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	// Renaming the device updates its secret in place.
+	device.Name, device.Hostname = "B.fake.ts.net", "B"
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Equal("B.fake.ts.net", secret.StringData["name"])
+	suite.Equal("https://B.fake.ts.net", secret.StringData["server"])
+	suite.Equal("B", secret.Annotations[AnnotationDeviceHostname])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_RenamedDevice() {
+	recorder := events.NewFakeRecorder(10)
+	suite.reconciler.recorder = recorder
+	suite.reconciler.serviceConfig.CreateService = true
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+
+	// The device is renamed: the new resources are created and the previous ones deleted.
+	device.Name = "B.fake.ts.net"
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}, &secret)
+	suite.Require().NoError(err)
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret)
+	suite.True(errors.IsNotFound(err))
+
+	var service corev1.Service
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "b-fake-ts-net", Namespace: "argocd"}, &service)
+	suite.Require().NoError(err)
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "a-fake-ts-net", Namespace: "argocd"}, &service)
+	suite.True(errors.IsNotFound(err))
+
+	suite.Require().Len(recorder.Events, 1)
+	suite.Equal("Normal Renamed Tailscale device fake-device-id renamed from A.fake.ts.net", <-recorder.Events)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_TailnetRename() {
+	devices := []tailscale.Device{
+		{Name: "A.fake.ts.net", NodeID: "device-a"},
+		{Name: "B.fake.ts.net", NodeID: "device-b"},
+	}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	for _, device := range devices {
+		_, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: device.Name, Namespace: "argocd"}})
+		suite.Require().NoError(err)
+	}
+
+	// The MagicDNS domain of the tailnet changes: every device is renamed at once.
+	devices = []tailscale.Device{
+		{Name: "A.renamed.ts.net", NodeID: "device-a"},
+		{Name: "B.renamed.ts.net", NodeID: "device-b"},
+	}
+	var secrets corev1.SecretList
+	suite.Require().NoError(suite.kubernetesMock.List(context.TODO(), &secrets))
+	rename := NewTailnetRename(suite.reconciler.secretName)
+	from, to, renamed := rename.Detect(secrets.Items, devices)
+	suite.Equal("fake.ts.net", from)
+	suite.Equal("renamed.ts.net", to)
+	suite.Equal(2, renamed)
+
+	suite.reconciler.secretName = rename.Namer()
+	suite.Equal("A.fake.ts.net", suite.reconciler.secretName(devices[0]))
+	suite.Equal("C.renamed.ts.net", suite.reconciler.secretName(tailscale.Device{Name: "C.renamed.ts.net", NodeID: "device-c"}))
+	_, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+
+	// The secret is updated in place, with the new server URL.
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret))
+	suite.Equal("https://A.renamed.ts.net", secret.StringData["server"])
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.renamed.ts.net", Namespace: "argocd"}, &secret)
+	suite.True(errors.IsNotFound(err))
+
+	// Devices renamed to different domains are renamed as usual.
+	devices[1].Name = "B.other.ts.net"
+	_, _, renamed = rename.Detect(secrets.Items, devices)
+	suite.Zero(renamed)
+}
+
+func (suite *ReconcilerSuite) TestPlan() {
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "device-a", Addresses: []string{"0.0.0.0"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	reqA := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	reqB := reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}}
+	plan := func(req reconcile.Request, devices []tailscale.Device) Change {
+		changes, err := suite.reconciler.Plan(context.TODO(), req, devices)
+		suite.Require().NoError(err)
+		suite.Require().Len(changes, 1)
+		return changes[0]
+	}
+
+	// A device without secret is created.
+	change := plan(reqA, devices)
+	suite.Equal(PlanCreate, change.Action)
+	suite.Equal([]string{"argocd", "A.fake.ts.net", "A.fake.ts.net", "new Tailscale device"}, []string{change.Namespace, change.Name, change.Device, change.Reason})
+
+	// Planning writes nothing; once reconciled, the secret is up to date.
+	var secret corev1.Secret
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), reqA.NamespacedName, &secret)))
+	_, err := suite.reconciler.Reconcile(context.TODO(), reqA)
+	suite.Require().NoError(err)
+	suite.Equal(PlanKeep, plan(reqA, devices).Action)
+
+	// A changed device is updated, a removed one is deleted along with its resources.
+	changed := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "device-a", Addresses: []string{"1.1.1.1"}}}
+	suite.Equal(PlanUpdate, plan(reqA, changed).Action)
+	suite.reconciler.serviceConfig.CreateService = true
+	change = plan(reqA, nil)
+	suite.Equal(PlanDelete, change.Action)
+	suite.Equal([]string{"service argocd/a-fake-ts-net"}, change.Deletions)
+	suite.reconciler.serviceConfig.CreateService = false
+
+	// Neither device nor secret: nothing to do.
+	suite.Equal(PlanKeep, plan(reqB, devices).Action)
+
+	// A renamed device is created under its new name, its previous secret being deleted.
+	renamed := []tailscale.Device{{Name: "B.fake.ts.net", NodeID: "device-a", Addresses: []string{"0.0.0.0"}}}
+	change = plan(reqB, renamed)
+	suite.Equal(PlanCreate, change.Action)
+	suite.Equal([]string{"secret argocd/A.fake.ts.net"}, change.Deletions)
+
+	// The planned change is applied as is, without listing the Tailscale devices again.
+	suite.tailscaleMock = func(w http.ResponseWriter, r *http.Request) { suite.Fail("request not expected") }
+	_, err = suite.reconciler.Reconcile(WithPlan(context.TODO(), NewPlan([]Change{change})), reqB)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), reqB.NamespacedName, &secret))
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), reqA.NamespacedName, &secret)))
+
+	plan2 := NewPlan([]Change{
+		{Action: PlanKeep, Namespace: "argocd", Name: "C"},
+		{Action: PlanDelete, Namespace: "argocd", Name: "B"},
+		{Action: PlanDelete, Namespace: "argocd", Name: "B", Target: "remote"},
+		{Action: PlanKeep, Namespace: "argocd", Name: "D", Deletions: []string{"secret argocd/E"}},
+		{Action: PlanCreate, Namespace: "argocd", Name: "A"},
+	})
+	suite.Equal(2, plan2.Count(PlanDelete))
+	suite.Equal([]reconcile.Request{{NamespacedName: types.NamespacedName{Name: "B", Namespace: "argocd"}}}, plan2.Requests(PlanDelete))
+	suite.Len(plan2.Changed().Changes, 4)
+}
+
+func (suite *ReconcilerSuite) TestPlan_Targets() {
+	remote := *suite.reconciler
+	remote.target = "remote.kubeconfig"
+	multi := NewMultiReconciler(suite.reconciler, &remote)
+
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "device-a", Addresses: []string{"0.0.0.0"}}}
+	changes, err := multi.(Planner).Plan(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}, devices)
+	suite.Require().NoError(err)
+	suite.Require().Len(changes, 2)
+	suite.Equal([]string{"", "remote.kubeconfig"}, []string{changes[0].Target, changes[1].Target})
+
+	// Targets unable to plan their changes are reported.
+	_, err = NewMultiReconciler(suite.reconciler, reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+		return reconcile.Result{}, nil
+	})).(Planner).Plan(context.TODO(), reconcile.Request{}, devices)
+	suite.Error(err)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Targets() {
+	remoteClient := fake.NewClientBuilder().Build()
+	remote := *suite.reconciler
+	remote.target, remote.ks, remote.reader = "remote.kubeconfig", remoteClient, remoteClient
+	multi := NewMultiReconciler(suite.reconciler, &remote)
+
+	listings := 0
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		listings++
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "device-a", Addresses: []string{"0.0.0.0"}}}})
+		_, _ = w.Write(raw)
+	}
+
+	// The devices are listed once for all the targets.
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	_, err := multi.Reconcile(context.TODO(), reconcile.Request{NamespacedName: nn})
+	suite.Require().NoError(err)
+	suite.Equal(1, listings)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), nn, &corev1.Secret{}))
+	suite.Require().NoError(remoteClient.Get(context.TODO(), nn, &corev1.Secret{}))
+
+	// The secrets of every target are listed, so that the orphans are swept from all of them.
+	suite.Require().NoError(remoteClient.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "orphan", Namespace: "argocd", Labels: map[string]string{"apps.kubernetes.io/managed-by": managedBy},
+	}}))
+	secrets, err := multi.(SecretLister).ListSecrets(context.TODO())
+	suite.Require().NoError(err)
+	var names []string
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+	suite.ElementsMatch([]string{"A.fake.ts.net", "A.fake.ts.net", "orphan"}, names)
+
+	orphan := types.NamespacedName{Name: "orphan", Namespace: "argocd"}
+	_, err = multi.Reconcile(context.TODO(), reconcile.Request{NamespacedName: orphan})
+	suite.Require().NoError(err)
+	suite.True(errors.IsNotFound(remoteClient.Get(context.TODO(), orphan, &corev1.Secret{})))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_DuplicateSecrets() {
+	recorder := events.NewFakeRecorder(10)
+	suite.reconciler.recorder = recorder
+	device := tailscale.Device{Name: "B.fake.ts.net", NodeID: "fake-device-id"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}}
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	// Secrets of the same device left behind by past renames, and the secret of another device
+	for name, id := range map[string]string{"A.fake.ts.net": "fake-device-id", "Z.fake.ts.net": "fake-device-id", "C.fake.ts.net": "other-device-id"} {
+		suite.Require().NoError(suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "argocd",
+			Labels:      map[string]string{"apps.kubernetes.io/managed-by": managedBy},
+			Annotations: map[string]string{AnnotationDeviceID: id},
+		}}))
+	}
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{}))
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "C.fake.ts.net", Namespace: "argocd"}, &corev1.Secret{}))
+	for _, name := range []string{"A.fake.ts.net", "Z.fake.ts.net"} {
+		err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "argocd"}, &corev1.Secret{})
+		suite.True(errors.IsNotFound(err), name)
+	}
+
+	suite.Require().Len(recorder.Events, 2)
+	suite.Equal("Normal DuplicatePruned Duplicate secret A.fake.ts.net of Tailscale device fake-device-id deleted", <-recorder.Events)
+	suite.Equal("Normal DuplicatePruned Duplicate secret Z.fake.ts.net of Tailscale device fake-device-id deleted", <-recorder.Events)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_DuplicateSecretsIndexed() {
+	WithDeviceIDIndex()(suite.reconciler)
+	suite.TestReconcile_DuplicateSecrets()
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ServiceOS() {
+	suite.reconciler.serviceConfig.CreateService = true
+	suite.reconciler.serviceConfig.OSes = []string{"linux"}
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	serviceName := types.NamespacedName{Name: "a-fake-ts-net", Namespace: "argocd"}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{}))
+
+	// A device running another operating system keeps its secret but loses its service.
+	device.OS = "windows"
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{}))
+	err = suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{})
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ServiceNameTemplate() {
+	suite.reconciler.serviceConfig.CreateService = true
+	suite.reconciler.serviceName, _ = NewServiceNamer("team-a-{{ .Name | dns1035 }}")
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	serviceName := types.NamespacedName{Name: "team-a-a-fake-ts-net", Namespace: "argocd"}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{}))
+
+	// The service of a deleted device is found from its secret name only.
+	suite.Require().NoError(suite.reconciler.DeleteDeviceService(context.TODO(), req.NamespacedName))
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{})))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ServiceNameTemplateChange() {
+	suite.reconciler.serviceConfig.CreateService = true
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	// A service created for the device before the services referenced their secret, and the
+	// service of another device.
+	for name, fqdn := range map[string]string{"legacy": "A.fake.ts.net", "other": "B.fake.ts.net"} {
+		suite.Require().NoError(suite.kubernetesMock.Create(context.TODO(), &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "argocd",
+			Labels:      map[string]string{"apps.kubernetes.io/managed-by": managedBy},
+			Annotations: map[string]string{"tailscale.com/tailnet-fqdn": fqdn},
+		}}))
+	}
+
+	// The services named after the previous template are deleted once created under the new name.
+	suite.reconciler.serviceName, _ = NewServiceNamer("team-a-{{ .Name | dns1035 }}")
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var services corev1.ServiceList
+	suite.Require().NoError(suite.kubernetesMock.List(context.TODO(), &services))
+	var names []string
+	for _, service := range services.Items {
+		names = append(names, service.Name)
+	}
+	suite.ElementsMatch([]string{"other", "team-a-a-fake-ts-net"}, names)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ServiceNameCollision() {
+	suite.reconciler.serviceConfig.CreateService = true
+	suite.reconciler.serviceName, _ = NewServiceNamer("team-a")
+	devices := []tailscale.Device{
+		{Name: "A.fake.ts.net", NodeID: "a", OS: "linux"},
+		{Name: "B.fake.ts.net", NodeID: "b", OS: "linux"},
+	}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	reqA := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	reqB := reconcile.Request{NamespacedName: types.NamespacedName{Name: "B.fake.ts.net", Namespace: "argocd"}}
+	serviceName := types.NamespacedName{Name: "team-a", Namespace: "argocd"}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), reqA)
+	suite.Require().NoError(err)
+
+	// The service of the first device is neither taken over nor deleted by the second one.
+	_, err = suite.reconciler.Reconcile(context.TODO(), reqB)
+	suite.ErrorContains(err, "service argocd/team-a already belongs to secret argocd/A.fake.ts.net")
+	suite.Require().NoError(suite.reconciler.DeleteDeviceService(context.TODO(), reqB.NamespacedName))
+
+	var service corev1.Service
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), serviceName, &service))
+	suite.Equal("A.fake.ts.net", service.Annotations["tailscale.com/tailnet-fqdn"])
+	suite.Equal("argocd/A.fake.ts.net", service.Annotations[AnnotationSecret])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ServiceAlreadyExists() {
+	suite.reconciler.serviceConfig.CreateService = true
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", OS: "linux"}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	serviceName := types.NamespacedName{Name: "a-fake-ts-net", Namespace: "argocd"}
+
+	// A stale service created concurrently is updated within the same reconciliation.
+	suite.Require().NoError(suite.kubernetesMock.Create(context.TODO(), &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: serviceName.Name, Namespace: serviceName.Namespace, Annotations: map[string]string{"tailscale.com/tailnet-fqdn": "stale.fake.ts.net"}},
+	}))
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var service corev1.Service
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), serviceName, &service))
+	suite.Equal("A.fake.ts.net", service.Annotations["tailscale.com/tailnet-fqdn"])
+	suite.Equal(managedBy, service.Labels["apps.kubernetes.io/managed-by"])
+}
+
+func (suite *ReconcilerSuite) TestReconcile_SkipService() {
+	suite.reconciler.serviceConfig.CreateService = true
+	suite.reconciler.serviceConfig.SkipTag = "argotails-no-service"
+	device := tailscale.Device{Name: "A.fake.ts.net", NodeID: "fake-device-id", Tags: []string{"tag:argotails-no-service"}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{device}})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	serviceName := types.NamespacedName{Name: "a-fake-ts-net", Namespace: "argocd"}
+
+	// Devices having the skip tag get no service.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{}))
+	err = suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{})
+	suite.True(errors.IsNotFound(err))
+
+	device.Tags = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{}))
+
+	// Secrets annotated to skip the service lose it.
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret))
+	secret.Annotations[AnnotationSkipService] = "true"
+	suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), &secret))
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	err = suite.kubernetesMock.Get(context.TODO(), serviceName, &corev1.Service{})
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Paused() {
+	pause := &PauseSwitch{}
+	WithPauseSwitch(pause)(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id"}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	// Nothing is created while paused.
+	pause.Set(true)
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{})
+	suite.True(errors.IsNotFound(err))
+
+	pause.Set(false)
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{}))
+
+	// Nothing is deleted while paused.
+	devices = nil
+	pause.Set(true)
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{}))
+	pause.Set(false)
+
+	// Mutations stay paused until every source has resumed them.
+	pause.SetBy(PauseSourceAdmin, true)
+	pause.SetBy(PauseSourceConfigMap, true)
+	pause.SetBy(PauseSourceConfigMap, false)
+	suite.True(pause.Paused())
+	suite.True(pause.PausedBy(PauseSourceAdmin))
+	suite.False(pause.PausedBy(PauseSourceConfigMap))
+	pause.SetBy(PauseSourceAdmin, false)
+	suite.False(pause.Paused())
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Fleets() {
+	suite.reconciler.serviceConfig.CreateService = true
+	WithFleets(
+		Fleet{
+			Name:               "edge",
+			Match:              tsutils.FuncTagFilter(func(device tailscale.Device) bool { return slices.Contains(device.Tags, "tag:edge") }),
+			Namespace:          "argocd-edge",
+			DeletionPolicy:     DeletionPolicyRetain,
+			ExtraData:          staticRenderer{"config": `{"tlsClientConfig":{"insecure":true}}`, "region": "eu"},
+			ProxyClass:         "edge",
+			ServiceAnnotations: map[string]string{"example.com/edge": "true"},
+		},
+		Fleet{Name: "core", Match: tsutils.FuncTagFilter(func(tailscale.Device) bool { return true })},
+	)(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", Tags: []string{"tag:edge"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	edge := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd-edge"}
+
+	// Requests outside the fleet namespace do not match the device.
+	_, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}})
+	suite.Require().NoError(err)
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &corev1.Secret{})
+	suite.True(errors.IsNotFound(err))
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: edge})
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), edge, &secret))
+	suite.Equal("edge", secret.Labels[LabelFleet])
+	suite.Equal(`{"tlsClientConfig":{"insecure":true}}`, secret.StringData["config"])
+	suite.Equal("eu", secret.StringData["region"])
+
+	var service corev1.Service
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "a-fake-ts-net", Namespace: "argocd-edge"}, &service))
+	suite.Equal("edge", service.Annotations["tailscale.com/proxy-class"])
+	suite.Equal("true", service.Annotations["example.com/edge"])
+
+	// The device leaving the edge fleet keeps its resources, as retained by the fleet.
+	devices[0].Tags = []string{"tag:core"}
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: edge})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), edge, &corev1.Secret{}))
+}
+
+func (suite *ReconcilerSuite) TestReconcile_FleetChange() {
+	WithFleets(
+		Fleet{
+			Name:      "edge",
+			Match:     tsutils.FuncTagFilter(func(device tailscale.Device) bool { return slices.Contains(device.Tags, "tag:edge") }),
+			Namespace: "argocd-edge",
+			Labels:    staticRenderer{LabelFleet: "core", "tier": "edge"},
+		},
+		Fleet{Name: "core", Match: tsutils.FuncTagFilter(func(device tailscale.Device) bool { return slices.Contains(device.Tags, "tag:core") })},
+	)(suite.reconciler)
+	WithLabeler(LabelerFunc(func(tailscale.Device) (map[string]string, error) {
+		return map[string]string{"apps.kubernetes.io/managed-by": "someone-else"}, nil
+	}))(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", Tags: []string{"tag:edge"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	edge := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd-edge"}
+	core := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: edge})
+	suite.Require().NoError(err)
+
+	// The computed labels cannot override the fleet and the owner of the resources
+	var secret corev1.Secret
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), edge, &secret))
+	suite.Equal("edge", secret.Labels[LabelFleet])
+	suite.Equal("edge", secret.Labels["tier"])
+	suite.Equal(managedBy, secret.Labels["apps.kubernetes.io/managed-by"])
+
+	// The device moving to a fleet without namespace gets its resources in the controller
+	// namespace, the ones left in the namespace of its previous fleet are deleted.
+	devices[0].Tags = []string{"tag:core"}
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: edge})
+	suite.Require().NoError(err)
+	suite.True(errors.IsNotFound(suite.kubernetesMock.Get(context.TODO(), edge, &corev1.Secret{})))
+
+	_, err = suite.reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: core})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.kubernetesMock.Get(context.TODO(), core, &secret))
+	suite.Equal("core", secret.Labels[LabelFleet])
+}
+
+// staticRenderer renders the same values for every device.
+type staticRenderer map[string]string
+
+func (r staticRenderer) Render(tailscale.Device) (map[string]string, error) {
+	return maps.Clone(r), nil
+}
+
+func (suite *ReconcilerSuite) TestReconcile_RegistrationBackend() {
+	WithRegistrationBackend("karmada", BackendKarmada)(suite.reconciler)
+	WithRegistrationBackend("tag:ocm", BackendOCM)(suite.reconciler)
+	WithKarmadaCredentials(types.NamespacedName{Namespace: "karmada-system", Name: "member-credentials"})(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", Tags: []string{"tag:karmada"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	registration := func(backend string) (*unstructured.Unstructured, error) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(RegistrationBackends[backend])
+		return obj, suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "a-fake-ts-net"}, obj)
+	}
+
+	// The device is registered as a Karmada cluster instead of an ArgoCD cluster secret.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	cluster, err := registration(BackendKarmada)
+	suite.Require().NoError(err)
+	suite.Equal(managedBy, cluster.GetLabels()["apps.kubernetes.io/managed-by"])
+	endpoint, _, _ := unstructured.NestedString(cluster.Object, "spec", "apiEndpoint")
+	suite.Equal("https://A.fake.ts.net", endpoint)
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{})
+	suite.True(errors.IsNotFound(err))
+	secretRef, _, _ := unstructured.NestedStringMap(cluster.Object, "spec", "secretRef")
+	suite.Equal(map[string]string{"namespace": "karmada-system", "name": "member-credentials"}, secretRef)
+
+	// The registered devices are listed through their registration object, as their secret is deleted
+	registered, err := ListRegistrations(context.TODO(), suite.kubernetesMock, managedBy, BackendKarmada, BackendOCM)
+	suite.Require().NoError(err)
+	suite.Equal([]types.NamespacedName{req.NamespacedName}, registered)
+
+	// Changing the device tag moves it to the other backend.
+	devices[0].Tags = []string{"tag:ocm"}
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	_, err = registration(BackendKarmada)
+	suite.True(errors.IsNotFound(err))
+	managedCluster, err := registration(BackendOCM)
+	suite.Require().NoError(err)
+	configs, _, _ := unstructured.NestedSlice(managedCluster.Object, "spec", "managedClusterClientConfigs")
+	suite.Equal([]any{map[string]any{"url": "https://A.fake.ts.net"}}, configs)
+
+	// Without backend tag, the device gets back its secret.
+	devices[0].Tags = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	_, err = registration(BackendOCM)
+	suite.True(errors.IsNotFound(err))
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &corev1.Secret{})
+	suite.NoError(err)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_RegistrationBackend_DeletedDevice() {
+	WithRegistrationBackend("ocm", BackendOCM)(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", NodeID: "fake-device-id", Tags: []string{"tag:ocm"}}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	registered, err := ListRegistrations(context.TODO(), suite.kubernetesMock, managedBy, BackendOCM)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]types.NamespacedName{req.NamespacedName}, registered)
+
+	// The registration of the device deleted from the tailnet is deleted when its secret is reconciled
+	devices = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+	registered, err = ListRegistrations(context.TODO(), suite.kubernetesMock, managedBy, BackendOCM)
+	suite.Require().NoError(err)
+	suite.Empty(registered)
+}
+
+func (suite *ReconcilerSuite) TestReconcile_ApplicationTemplate() {
+	suite.reconciler.application = template.Must(policy.Parse("application", `
+metadata:
+  name: bootstrap-{{ .Device.Hostname }}
+spec:
+  project: default
+  source:
+    repoURL: https://git.example.com/bootstrap.git
+    path: clusters/{{ .Device.Hostname }}
+  destination:
+    server: {{ .Server }}
+`))
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "a", NodeID: "fake-device-id"}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+	application := func() (*unstructured.Unstructured, error) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(ApplicationGVK)
+		return obj, suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "bootstrap-a", Namespace: "argocd"}, obj)
+	}
+
+	// The application is created along with the secret.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	app, err := application()
+	suite.Require().NoError(err)
+	suite.Equal(managedBy, app.GetLabels()["apps.kubernetes.io/managed-by"])
+	suite.Equal("argocd/A.fake.ts.net", app.GetAnnotations()[AnnotationSecret])
+	server, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "server")
+	suite.Equal("https://A.fake.ts.net", server)
+
+	// The application is updated along with the secret, keeping the fields set by ArgoCD.
+	suite.Require().NoError(unstructured.SetNestedField(app.Object, "Synced", "status", "sync", "status"))
+	suite.Require().NoError(suite.kubernetesMock.Update(context.TODO(), app))
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	app, err = application()
+	suite.Require().NoError(err)
+	status, _, _ := unstructured.NestedString(app.Object, "status", "sync", "status")
+	suite.Equal("Synced", status)
+
+	// The application is deleted along with the secret.
+	devices = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	_, err = application()
+	suite.True(errors.IsNotFound(err))
+}
+
+// annotatingHooks annotates the created secrets and vetoes their deletion.
+type annotatingHooks struct {
+	NopHooks
+	calls []string
+}
+
+func (h *annotatingHooks) OnBeforeCreate(_ context.Context, device tailscale.Device, secret *corev1.Secret) error {
+	h.calls = append(h.calls, "before-create")
+	secret.Annotations["example.com/owner"] = device.Hostname
+	return nil
+}
+
+func (h *annotatingHooks) OnAfterCreate(context.Context, tailscale.Device, *corev1.Secret) error {
+	h.calls = append(h.calls, "after-create")
+	return nil
+}
+
+func (h *annotatingHooks) OnBeforeDelete(context.Context, *corev1.Secret) error {
+	h.calls = append(h.calls, "before-delete")
+	return stderrors.New("deletion vetoed")
+}
+
+func (suite *ReconcilerSuite) TestReconcile_Hooks() {
+	hooks := &annotatingHooks{}
+	WithHooks(hooks)(suite.reconciler)
+	devices := []tailscale.Device{{Name: "A.fake.ts.net", Hostname: "a", NodeID: "fake-device-id"}}
+	suite.tailscaleMock = func(w http.ResponseWriter, _ *http.Request) {
+		raw, _ := json.Marshal(map[string]any{"devices": devices})
+		_, _ = w.Write(raw)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}}
+
+	// The hooks mutate the created secret.
+	_, err := suite.reconciler.Reconcile(context.TODO(), req)
+	suite.Require().NoError(err)
+
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.Require().NoError(err)
+	suite.Equal("a", secret.Annotations["example.com/owner"])
+
+	// The hooks veto the deletion.
+	devices = nil
+	_, err = suite.reconciler.Reconcile(context.TODO(), req)
+	suite.EqualError(err, "deletion vetoed")
+
+	err = suite.kubernetesMock.Get(context.TODO(), req.NamespacedName, &secret)
+	suite.NoError(err)
+	suite.Equal([]string{"before-create", "after-create", "before-delete"}, hooks.calls)
+}
+
+func (suite *ReconcilerSuite) TestDeleteSecretDevice() {
+	// Create a new device secret.
+	err := suite.kubernetesMock.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "A.fake.ts.net",
+			Namespace: "argocd",
+			Annotations: map[string]string{
+				AnnotationDeviceID: "fake-device-id",
+			},
+		},
+	})
+	suite.Require().NoError(err)
+
+	// Delete the device secret.
+	err = suite.reconciler.DeleteDeviceSecret(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"})
+	suite.Require().NoError(err)
+
+	// Check the device secret.
+	var secret corev1.Secret
+	err = suite.kubernetesMock.Get(context.TODO(), types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}, &secret)
+	suite.Error(err)
+	suite.True(errors.IsNotFound(err))
+}
+
+func (suite *ReconcilerSuite) TestActionDuration() {
+	actionDuration.Reset()
+
+	nn := types.NamespacedName{Name: "A.fake.ts.net", Namespace: "argocd"}
+	suite.Require().NoError(suite.reconciler.DeleteDeviceSecret(context.TODO(), nn))
+	suite.Require().NoError(suite.reconciler.CreateDeviceSecret(context.TODO(), nn, tailscale.Device{Name: "A.fake.ts.net"}))
+	suite.Require().Error(suite.reconciler.CreateDeviceSecret(context.TODO(), nn, tailscale.Device{Name: "A.fake.ts.net"}))
+
+	// One histogram per action and outcome: delete_secret/success, create_secret/success and create_secret/error
+	suite.Equal(3, testutil.CollectAndCount(actionDuration))
+}
+
+func (suite *ReconcilerSuite) SetupTest() {
+	scheme := runtime.NewScheme()
+	err := corev1.AddToScheme(scheme)
+	suite.Require().NoError(err)
+	err = v1alpha1.AddToScheme(scheme)
+	suite.Require().NoError(err)
+	for _, gvk := range []schema.GroupVersionKind{RegistrationBackends[BackendKarmada], RegistrationBackends[BackendOCM], ApplicationGVK} {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	ks := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1alpha1.TailscaleCluster{}).WithIndex(&corev1.Secret{}, IndexDeviceID, IndexSecretDeviceID).Build()
+
+	suite.testserver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.tailscaleMock(w, r)
+	}))
+	srvURL, err := url.Parse(suite.testserver.URL)
+	suite.Require().NoError(err)
+	ts := &tailscale.Client{Tailnet: "fake.ts.net", HTTP: suite.testserver.Client(), BaseURL: srvURL}
+
+	suite.kubernetesMock = ks
+	suite.tailscaleMock = func(w http.ResponseWriter, r *http.Request) { suite.Fail("request not mocked") }
+	suite.reconciler = &reconciler{ts: tsutils.NewDeviceAPI(ts), ks: ks, reader: ks, filter: tsutils.FuncTagFilter(func(_ tailscale.Device) bool { return true }), managedBy: managedBy}
+	suite.reconciler.serviceConfig.Namespace = "argocd"
+	suite.reconciler.secretName, _ = NewSecretNamer(SecretNamingDeviceName)
+	suite.reconciler.serviceName, _ = NewServiceNamer("")
+
+	suite.kubernetesMock.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd"}})
+}
+
+func (suite *ReconcilerSuite) TearDownTest() {
+	suite.testserver.Close()
+	suite.reconciler = nil
+}
+
+func TestIsDeviceSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   metav1.ObjectMeta
+		expected bool
+	}{
+		{
+			name:     "named after the device",
+			object:   metav1.ObjectMeta{Name: "A.fake.ts.net", Annotations: map[string]string{AnnotationDeviceID: "fake-device-id"}},
+			expected: true,
+		},
+		{
+			name:     "named after the node ID",
+			object:   metav1.ObjectMeta{Name: "nodeid-123", Annotations: map[string]string{AnnotationDeviceID: "nodeid-123"}},
+			expected: true,
+		},
+		{
+			name:     "without device ID",
+			object:   metav1.ObjectMeta{Name: "A.fake.ts.net"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := IsDeviceSecret(&tt.object); result != tt.expected {
+				t.Errorf("IsDeviceSecret(%q) = %v, want %v", tt.object.Name, result, tt.expected)
+			}
+		})
+	}
 }
 
 func TestToDNS1035Name(t *testing.T) {
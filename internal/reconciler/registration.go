@@ -0,0 +1,242 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/client/tailscale/v2"
+)
+
+const (
+	// BackendKarmada registers the devices as Karmada clusters.
+	BackendKarmada = "karmada"
+	// BackendOCM registers the devices as Open Cluster Management managed clusters.
+	BackendOCM = "ocm"
+)
+
+// RegistrationBackends are the kinds of registration objects that can replace the secret of a device.
+var RegistrationBackends = map[string]schema.GroupVersionKind{
+	BackendKarmada: {Group: "cluster.karmada.io", Version: "v1alpha1", Kind: "Cluster"},
+	BackendOCM:     {Group: "cluster.open-cluster-management.io", Version: "v1", Kind: "ManagedCluster"},
+}
+
+// RegistrationResources are the resources of the registration objects, by backend.
+var RegistrationResources = map[string]string{
+	BackendKarmada: "clusters",
+	BackendOCM:     "managedclusters",
+}
+
+// WithRegistrationBackend registers the devices having the given tag (e.g. "tag:karmada") with the
+// given backend instead of generating a secret. When a device has several such tags, the backend
+// of the first tag in lexical order is used.
+func WithRegistrationBackend(tag, backend string) Option {
+	return func(r *reconciler) {
+		if r.backends == nil {
+			r.backends = map[string]string{}
+		}
+		r.backends["tag:"+strings.TrimPrefix(tag, "tag:")] = backend
+	}
+}
+
+// WithKarmadaCredentials references the given secret, holding the credentials ("caBundle" and
+// "token" entries) Karmada uses to reach the member clusters in Push mode, from every Karmada
+// Cluster object.
+func WithKarmadaCredentials(secret types.NamespacedName) Option {
+	return func(r *reconciler) { r.karmadaCredentials = secret }
+}
+
+// registrationBackend returns the backend used to register the given device, if any.
+func (r reconciler) registrationBackend(device tailscale.Device) string {
+	var tag string
+	for _, t := range device.Tags {
+		if _, exists := r.backends[t]; exists && (tag == "" || t < tag) {
+			tag = t
+		}
+	}
+	return r.backends[tag]
+}
+
+// buildDesiredRegistration returns the registration object expected for the given device by the
+// given backend. Registration objects are cluster-scoped, so their name is derived from the
+// secret name as a DNS-1035 label and the secret is referenced by the AnnotationSecret annotation.
+func buildDesiredRegistration(namespacedName types.NamespacedName, device tailscale.Device, backend, server, managedBy string, credentials types.NamespacedName) (*unstructured.Unstructured, error) {
+	gvk, exists := RegistrationBackends[backend]
+	if !exists {
+		return nil, fmt.Errorf("unknown registration backend %q", backend)
+	}
+
+	var spec map[string]any
+	switch backend {
+	case BackendKarmada:
+		spec = map[string]any{"apiEndpoint": server, "syncMode": "Push"}
+		if credentials.Name != "" {
+			spec["secretRef"] = map[string]any{"namespace": credentials.Namespace, "name": credentials.Name}
+		}
+	case BackendOCM:
+		spec = map[string]any{
+			"hubAcceptsClient":            true,
+			"managedClusterClientConfigs": []any{map[string]any{"url": server}},
+		}
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(toDNS1035Name(namespacedName.Name))
+	obj.SetLabels(map[string]string{"apps.kubernetes.io/managed-by": managedBy})
+	obj.SetAnnotations(map[string]string{
+		AnnotationDeviceID:       device.NodeID,
+		AnnotationDeviceHostname: device.Hostname,
+		AnnotationSecret:         namespacedName.String(),
+	})
+	return obj, nil
+}
+
+// SyncDeviceRegistration creates or updates the registration object of the given device and
+// deletes the objects left by the other backends (e.g. after a tag change).
+func (r reconciler) SyncDeviceRegistration(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device, backend string) error {
+	log := ctrllog.FromContext(ctx).WithName("sync_registration").WithValues("backend", backend)
+
+	desired, err := buildDesiredRegistration(namespacedName, device, backend, ServerURL(device, r.serverAddress), r.managedBy, r.karmadaCredentials)
+	if err != nil {
+		return err
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(desired.GroupVersionKind())
+	err = r.ks.Get(ctx, types.NamespacedName{Name: desired.GetName()}, current)
+	switch {
+	case errors.IsNotFound(err):
+		log.V(3).Info("Create Tailscale device registration")
+		err = r.ks.Create(ctx, desired)
+	case err != nil:
+	case current.GetLabels()["apps.kubernetes.io/managed-by"] != r.managedBy:
+		err = fmt.Errorf("%s %q is not managed by %s", desired.GetKind(), desired.GetName(), r.managedBy)
+	default:
+		log.V(3).Info("Update Tailscale device registration")
+		desired.SetResourceVersion(current.GetResourceVersion())
+		err = r.ks.Update(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	for other := range RegistrationBackends {
+		if other == backend {
+			continue
+		}
+		if err := r.deleteDeviceRegistration(ctx, namespacedName, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDeviceRegistration deletes the registration objects of the given device, whatever their backend.
+func (r reconciler) DeleteDeviceRegistration(ctx context.Context, namespacedName types.NamespacedName) error {
+	for backend := range RegistrationBackends {
+		if err := r.deleteDeviceRegistration(ctx, namespacedName, backend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRegistrations returns the secrets whose devices are registered, by the given controller, with
+// one of the given backends. As their secret is deleted, these devices would otherwise never be
+// reconciled again once deleted from the tailnet. Backends whose API is not installed on the
+// cluster are ignored.
+func ListRegistrations(ctx context.Context, reader client.Reader, managedBy string, backends ...string) ([]types.NamespacedName, error) {
+	var secrets []types.NamespacedName
+	for _, backend := range backends {
+		gvk, exists := RegistrationBackends[backend]
+		if !exists {
+			return nil, fmt.Errorf("unknown registration backend %q", backend)
+		}
+
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := reader.List(ctx, &list, client.MatchingLabels{"apps.kubernetes.io/managed-by": managedBy})
+		switch {
+		case meta.IsNoMatchError(err):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to list %s registrations: %w", backend, err)
+		}
+
+		for _, obj := range list.Items {
+			namespace, name, found := strings.Cut(obj.GetAnnotations()[AnnotationSecret], "/")
+			if !found {
+				continue
+			}
+			secrets = append(secrets, types.NamespacedName{Namespace: namespace, Name: name})
+		}
+	}
+	return secrets, nil
+}
+
+// deleteDeviceRegistration deletes the registration object of the given device created by the
+// given backend. Backends whose API is not installed on the cluster are ignored.
+func (r reconciler) deleteDeviceRegistration(ctx context.Context, namespacedName types.NamespacedName, backend string) error {
+	log := ctrllog.FromContext(ctx).WithName("delete_registration").WithValues("backend", backend)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(RegistrationBackends[backend])
+	err := r.ks.Get(ctx, types.NamespacedName{Name: toDNS1035Name(namespacedName.Name)}, obj)
+	switch {
+	case errors.IsNotFound(err), meta.IsNoMatchError(err):
+		return nil
+	case err != nil:
+		return err
+	case obj.GetLabels()["apps.kubernetes.io/managed-by"] != r.managedBy:
+		// Not created by this controller, left untouched
+		return nil
+	}
+
+	log.V(3).Info("Delete Tailscale device registration")
+	err = r.ks.Delete(ctx, obj)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// reconcileRegistration registers the given device with the given backend and deletes its secret,
+// service and ArgoCD application, which are replaced by the registration object.
+func (r reconciler) reconcileRegistration(ctx context.Context, namespacedName types.NamespacedName, device tailscale.Device, backend string) (reconcile.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	log.V(1).Info("Tailscale device registered through a backend, Tailscale device's registration will be synchronized", "reconciliation.action", "register", "backend", backend)
+
+	if err := r.SyncDeviceRegistration(ctx, namespacedName, device, backend); err != nil {
+		log.Error(err, "Failed to synchronize Tailscale device's registration", "reconciliation.outcome", "registration_error")
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	if err := r.DeleteDeviceSecret(ctx, namespacedName); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Tailscale device's secret", "reconciliation.outcome", "delete_secret_error")
+		return reconcile.Result{Requeue: true}, err
+	}
+	if r.serviceConfig.CreateService {
+		if err := r.DeleteDeviceService(ctx, namespacedName); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Tailscale device's service", "reconciliation.outcome", "delete_service_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	if r.application != nil {
+		if err := r.DeleteDeviceApplication(ctx, namespacedName); err != nil {
+			log.Error(err, "Failed to delete Tailscale device's ArgoCD application", "reconciliation.outcome", "delete_application_error")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	log.V(1).Info("Device reconciliation completed with registration", "reconciliation.outcome", "registered")
+	return reconcile.Result{RequeueAfter: r.syncInterval(device)}, nil
+}
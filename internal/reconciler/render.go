@@ -0,0 +1,55 @@
+package reconciler
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// SecretRenderer renders the secrets of the Tailscale devices as the reconciler writes them,
+// without reading nor writing any Kubernetes resource (e.g. to commit them to Git).
+type SecretRenderer struct {
+	r *reconciler
+}
+
+// NewSecretRenderer creates a secret renderer configured with the same options as the reconciler,
+// rendering the secrets in the given namespace (or in the namespace of the device fleet).
+func NewSecretRenderer(managedBy, namespace string, opts ...Option) *SecretRenderer {
+	r := &reconciler{managedBy: managedBy, serviceConfig: ServiceConfig{Namespace: namespace}}
+	r.secretName, _ = NewSecretNamer(SecretNamingDeviceName)
+	r.serviceName, _ = NewServiceNamer("")
+	for _, opt := range opts {
+		opt(r)
+	}
+	return &SecretRenderer{r: r}
+}
+
+// Render returns the secret of the given device, along with the warnings about its metadata
+// exceeding the Kubernetes limits, truncated as the reconciler does. Unlike the written secrets,
+// the rendered ones carry neither provenance nor expiry annotations, which would change on every
+// rendering.
+func (s *SecretRenderer) Render(device tailscale.Device) (corev1.Secret, []string, error) {
+	namespacedName := types.NamespacedName{
+		Name:      s.r.secretName(device),
+		Namespace: FleetNamespace(s.r.fleets, device, s.r.serviceConfig.Namespace),
+	}
+	cfg, err := s.r.buildConfig(device)
+	if err != nil {
+		return corev1.Secret{}, nil, err
+	}
+	cfg.Pending = s.r.requireApproval
+	secret, err := BuildDesiredSecret(namespacedName, device, cfg)
+	if err != nil {
+		return corev1.Secret{}, nil, err
+	}
+
+	warnings := GuardObjectMeta(&secret.ObjectMeta, secret.Annotations)
+	limit := s.r.maxSecretSize
+	if limit <= 0 {
+		limit = corev1.MaxSecretSize
+	}
+	if err := ValidateSecretSize(secret, limit); err != nil {
+		return corev1.Secret{}, nil, err
+	}
+	return secret, warnings, nil
+}
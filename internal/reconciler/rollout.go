@@ -0,0 +1,164 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+
+	"github.com/chezmoidotsh/argotails/internal/argocd"
+)
+
+// AnnotationConfigHash is the annotation key of the hash of the configuration a secret has been
+// written with, used to roll configuration changes out progressively.
+const AnnotationConfigHash = "argotails.chezmoi.sh/config-hash"
+
+// RolloutState is the state of a rollout, persisted so that a restarted controller neither restarts
+// the soak duration nor forgets the canary failure halting the rollout.
+type RolloutState struct {
+	ConfigHash string    `json:"configHash"`
+	Started    time.Time `json:"started"`
+	Failure    string    `json:"failure,omitempty"`
+}
+
+// Rollout rolls a configuration change (e.g. a new template or filter) out progressively: the
+// secrets written with another configuration are first updated for the canary devices only, then
+// for every device once the soak duration has elapsed without any canary failure. It protects
+// against a bad configuration breaking every cluster secret at once.
+type Rollout struct {
+	// ConfigHash identifies the current configuration.
+	ConfigHash string
+	// Canaries are the names, hostnames or IDs of the devices updated first.
+	Canaries []string
+	// Percentage is the share of the devices (0 to 100) updated first, selected by their ID.
+	Percentage int
+	// Soak is how long the canary devices run the new configuration before it is rolled out to
+	// every device.
+	Soak time.Duration
+	// OnFail is called when a canary failure halts the rollout, e.g. to persist its state (optional).
+	OnFail func(ctx context.Context, state RolloutState)
+
+	mu      sync.Mutex
+	started time.Time
+	failure error
+}
+
+// NewRollout starts the rollout of the configuration with the given hash.
+func NewRollout(configHash string, canaries []string, percentage int, soak time.Duration) *Rollout {
+	return &Rollout{ConfigHash: configHash, Canaries: canaries, Percentage: percentage, Soak: soak, started: time.Now()}
+}
+
+// WithRollout rolls the configuration out progressively (see Rollout).
+func WithRollout(rollout *Rollout) Option {
+	return func(r *reconciler) { r.rollout = rollout }
+}
+
+// State returns the current state of the rollout.
+func (r *Rollout) State() RolloutState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := RolloutState{ConfigHash: r.ConfigHash, Started: r.started}
+	if r.failure != nil {
+		state.Failure = r.failure.Error()
+	}
+	return state
+}
+
+// Restore resumes the rollout from the given persisted state; states of another configuration are
+// ignored, the rollout of the current configuration starting over.
+func (r *Rollout) Restore(state RolloutState) bool {
+	if state.ConfigHash != r.ConfigHash {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = state.Started
+	if state.Failure != "" {
+		r.failure = errors.New(state.Failure)
+	}
+	return true
+}
+
+// IsCanary returns true if the given device is updated first.
+func (r *Rollout) IsCanary(device tailscale.Device) bool {
+	if slices.ContainsFunc(r.Canaries, func(name string) bool {
+		return name == device.Name || name == device.Hostname || name == device.NodeID
+	}) {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(device.NodeID))
+	return int(h.Sum32()%100) < r.Percentage
+}
+
+// Promoted returns true once the configuration is rolled out to every device, i.e. once the soak
+// duration has elapsed without any canary failure.
+func (r *Rollout) Promoted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failure == nil && time.Since(r.started) >= r.Soak
+}
+
+// Remaining returns how long the canary devices still run the new configuration before it is rolled
+// out to every device; zero once promoted or halted by a canary failure.
+func (r *Rollout) Remaining() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failure != nil {
+		return 0
+	}
+	return max(r.Soak-time.Since(r.started), 0)
+}
+
+// Failure returns the failure of a canary device halting the rollout, if any.
+func (r *Rollout) Failure() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failure
+}
+
+// Allows returns true if the given device can be updated with the new configuration.
+func (r *Rollout) Allows(device tailscale.Device) bool {
+	return r.IsCanary(device) || r.Promoted()
+}
+
+// ReportConnection halts the rollout when ArgoCD fails to connect to a canary cluster written with
+// the new configuration during the soak duration, as its secret may be broken by the new
+// configuration even though it has been written successfully.
+func (r *Rollout) ReportConnection(ctx context.Context, secret corev1.Secret, state string) {
+	if state != argocd.ConnectionStatusFailed || secret.Annotations[AnnotationConfigHash] != r.ConfigHash || r.Remaining() == 0 {
+		return
+	}
+	device := tailscale.Device{
+		NodeID:   secret.Annotations[AnnotationDeviceID],
+		Hostname: secret.Annotations[AnnotationDeviceHostname],
+		Name:     secret.Annotations[AnnotationDeviceHostname] + "." + secret.Annotations[AnnotationDeviceTailnet],
+	}
+	if !r.IsCanary(device) {
+		return
+	}
+	r.fail(ctx, fmt.Errorf("ArgoCD failed to connect to the canary cluster %q", secret.Name))
+}
+
+// fail halts the rollout after the failure of a canary device; the configuration must be fixed
+// (restarting the rollout) for the other devices to be updated.
+func (r *Rollout) fail(ctx context.Context, err error) {
+	r.mu.Lock()
+	halted := r.failure == nil
+	if halted {
+		r.failure = err
+	}
+	r.mu.Unlock()
+
+	if halted && r.OnFail != nil {
+		r.OnFail(ctx, r.State())
+	}
+}
@@ -0,0 +1,56 @@
+package reconciler
+
+import (
+	"fmt"
+	"strconv"
+
+	"tailscale.com/client/tailscale/v2"
+
+	ts "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+const (
+	// LabelDeviceSubnetRouter is the label key flagging devices with enabled subnet routes.
+	LabelDeviceSubnetRouter = "device.tailscale.com/subnet-router"
+	// LabelDeviceExitNode is the label key flagging devices approved as exit node.
+	LabelDeviceExitNode = "device.tailscale.com/exit-node"
+
+	// ServerAddressMagicDNS reaches the Kubernetes API server through the MagicDNS name of the device.
+	ServerAddressMagicDNS = "magicdns"
+	// ServerAddressSubnet reaches the Kubernetes API server through the first single host route
+	// (/32 or /128) of the device enabled in the tailnet, for clusters reachable through a subnet
+	// router rather than running tailscaled on the API server node. Devices with no such route fall
+	// back to their MagicDNS name.
+	ServerAddressSubnet = "subnet"
+)
+
+// RouteLabels is a Labeler flagging the devices with enabled subnet routes or approved as exit
+// node. It requires the devices to be listed with their routes.
+var RouteLabels = LabelerFunc(func(device tailscale.Device) (map[string]string, error) {
+	return map[string]string{
+		LabelDeviceSubnetRouter: strconv.FormatBool(len(ts.SubnetRoutes(device)) > 0),
+		LabelDeviceExitNode:     strconv.FormatBool(ts.IsExitNode(device)),
+	}, nil
+})
+
+// WithServerAddress configures how the Kubernetes API server of each device is reached:
+// ServerAddressMagicDNS (default) or ServerAddressSubnet.
+func WithServerAddress(mode string) Option {
+	return func(r *reconciler) { r.serverAddress = mode }
+}
+
+// ServerURL returns the URL of the Kubernetes API server exposed by the given device, according
+// to the given addressing mode.
+func ServerURL(device tailscale.Device, mode string) string {
+	if mode == ServerAddressSubnet {
+		for _, route := range ts.SubnetRoutes(device) {
+			switch {
+			case route.IsSingleIP() && route.Addr().Is6():
+				return fmt.Sprintf("https://[%s]", route.Addr())
+			case route.IsSingleIP():
+				return fmt.Sprintf("https://%s", route.Addr())
+			}
+		}
+	}
+	return fmt.Sprintf("https://%s", device.Name)
+}
@@ -0,0 +1,84 @@
+package reconciler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// ServerNameAnyTag is the WithServerName tag matching every device without a more specific tag.
+const ServerNameAnyTag = "*"
+
+// WithServerName sets the TLS server name (SNI) used to reach the Kubernetes API server of the
+// devices having the given tag (e.g. "tag:lb-eu", or ServerNameAnyTag for every device), rendered
+// from the given template over the device (e.g. "{{ .Hostname }}.k8s.example.com"), so that the
+// TLS validation works for devices fronted by a shared load balancer routing on SNI, even when the
+// server URL is an IP. When a device has several such tags, the template of the first tag in
+// lexical order is used.
+func WithServerName(tag string, tmpl *template.Template) Option {
+	return func(r *reconciler) {
+		if r.serverNames == nil {
+			r.serverNames = map[string]*template.Template{}
+		}
+		if tag != ServerNameAnyTag {
+			tag = "tag:" + strings.TrimPrefix(tag, "tag:")
+		}
+		r.serverNames[tag] = tmpl
+	}
+}
+
+// serverName returns the TLS server name of the given device, or an empty string if none is
+// configured for its tags.
+func (r reconciler) serverName(device tailscale.Device) (string, error) {
+	tag := ServerNameAnyTag
+	for _, t := range device.Tags {
+		if _, exists := r.serverNames[t]; exists && (tag == ServerNameAnyTag || t < tag) {
+			tag = t
+		}
+	}
+	tmpl, exists := r.serverNames[tag]
+	if !exists {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, device); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(buf.String())
+	if errs := validation.IsDNS1123Subdomain(name); name != "" && len(errs) > 0 {
+		return "", fmt.Errorf("invalid TLS server name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// withServerName returns the given JSON ArgoCD cluster config with the given TLS server name,
+// unless it already sets one. The other fields are kept as is, including the ones unknown to
+// argocd.ClusterConfig.
+func withServerName(config, serverName string) (string, error) {
+	if serverName == "" {
+		return config, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(config), &fields); err != nil {
+		return "", fmt.Errorf("invalid cluster config: %w", err)
+	}
+	tls, _ := fields["tlsClientConfig"].(map[string]any)
+	if tls == nil {
+		tls = map[string]any{}
+	}
+	if name, _ := tls["serverName"].(string); name != "" {
+		return config, nil
+	}
+	tls["serverName"] = serverName
+	fields["tlsClientConfig"] = tls
+
+	raw, err := json.Marshal(fields)
+	return string(raw), err
+}
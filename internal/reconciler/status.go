@@ -0,0 +1,69 @@
+package reconciler
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+type (
+	// Status is the outcome of the last reconciliation of a Tailscale device.
+	Status struct {
+		// Time is when the last reconciliation finished.
+		Time time.Time `json:"time"`
+		// Error is the error returned by the last reconciliation, if any.
+		Error string `json:"error,omitempty"`
+	}
+
+	// StatusRecorder keeps track of the last reconciliation status of every Tailscale device.
+	// It is safe for concurrent use.
+	StatusRecorder struct {
+		mu       sync.RWMutex
+		statuses map[string]Status
+	}
+)
+
+// NewStatusRecorder creates a new empty status recorder.
+func NewStatusRecorder() *StatusRecorder {
+	return &StatusRecorder{statuses: map[string]Status{}}
+}
+
+// Record records the outcome of the reconciliation of the given device.
+func (s *StatusRecorder) Record(name string, err error) {
+	status := Status{Time: time.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[name] = status
+}
+
+// Get returns the last reconciliation status of the given device.
+func (s *StatusRecorder) Get(name string) (Status, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.statuses[name]
+	return status, ok
+}
+
+// All returns a snapshot of the last reconciliation status of all devices.
+func (s *StatusRecorder) All() map[string]Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.statuses)
+}
+
+// Delete forgets the status of the given device, e.g. once its secret has been deleted.
+func (s *StatusRecorder) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.statuses, name)
+}
+
+// WithStatusRecorder records the status of every reconciliation in the given recorder; the status
+// of a device is forgotten once its secret has been deleted.
+func WithStatusRecorder(recorder *StatusRecorder) Option {
+	return func(r *reconciler) { r.statuses = recorder }
+}
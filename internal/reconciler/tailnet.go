@@ -0,0 +1,107 @@
+package reconciler
+
+import (
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// TailnetRename keeps the secrets of the devices in place when the MagicDNS domain of the tailnet
+// changes. Every device is then renamed at once (same node ID, new suffix), which would otherwise
+// create a secret under every new name and delete every previous one; instead, the secrets of the
+// renamed devices keep their name and are updated in place with the new device names and server
+// URLs.
+type TailnetRename struct {
+	namer SecretNamer
+
+	mu sync.RWMutex
+	// aliases are the names of the secrets kept in place, by device node ID.
+	aliases map[string]string
+}
+
+// NewTailnetRename returns a TailnetRename for the secrets named by the given namer; no secret is
+// kept in place until a tailnet rename is detected.
+func NewTailnetRename(namer SecretNamer) *TailnetRename {
+	return &TailnetRename{namer: namer}
+}
+
+// Namer returns the secret namer naming the renamed devices after the secret kept in place, and
+// the other devices with the wrapped namer.
+func (t *TailnetRename) Namer() SecretNamer {
+	return func(device tailscale.Device) string {
+		t.mu.RLock()
+		name, exists := t.aliases[device.NodeID]
+		t.mu.RUnlock()
+		if exists {
+			return name
+		}
+		return t.namer(device)
+	}
+}
+
+// Detect detects, from the existing secrets and the current devices, the devices whose secret is
+// named after their current name but with a previous MagicDNS domain, and keeps their secret in
+// place. It returns the previous and the new domains along with the number of renamed devices. The
+// secrets are only kept in place when every renamed device moved from and to the same domains;
+// otherwise, the devices are renamed as usual.
+func (t *TailnetRename) Detect(secrets []corev1.Secret, devices []tailscale.Device) (from, to string, renamed int) {
+	names := make(map[string][]string, len(secrets))
+	for _, secret := range secrets {
+		if id := secret.Annotations[AnnotationDeviceID]; id != "" {
+			names[id] = append(names[id], secret.Name)
+		}
+	}
+
+	type move struct{ from, to string }
+	moves := map[move]map[string]string{}
+	for _, device := range devices {
+		if device.NodeID == "" || !rxTailnet.MatchString(device.Name) {
+			continue
+		}
+		domain := rxTailnet.FindStringSubmatch(device.Name)[1]
+		name := t.namer(device)
+		for _, secret := range names[device.NodeID] {
+			previous, exists := previousDomain(name, secret, domain)
+			if !exists {
+				continue
+			}
+			m := move{from: previous, to: domain}
+			if moves[m] == nil {
+				moves[m] = map[string]string{}
+			}
+			moves[m][device.NodeID] = secret
+		}
+	}
+
+	aliases := map[string]string{}
+	if len(moves) == 1 {
+		for m, names := range moves {
+			from, to, aliases = m.from, m.to, names
+		}
+	}
+	t.mu.Lock()
+	t.aliases = aliases
+	t.mu.Unlock()
+	return from, to, len(aliases)
+}
+
+// previousDomain returns the MagicDNS domain the given secret name has been computed with, if it
+// only differs by this domain from the given name, computed with the given domain.
+func previousDomain(name, secret, domain string) (string, bool) {
+	i := strings.Index(name, "."+domain)
+	if name == secret || i < 0 {
+		return "", false
+	}
+	prefix, suffix := name[:i+1], name[i+1+len(domain):]
+	if len(secret) <= len(prefix)+len(suffix) || !strings.HasPrefix(secret, prefix) || !strings.HasSuffix(secret, suffix) {
+		return "", false
+	}
+
+	previous := secret[len(prefix) : len(secret)-len(suffix)]
+	if !strings.HasSuffix(previous, ".ts.net") || strings.Count(previous, ".") != 2 {
+		return "", false
+	}
+	return previous, true
+}
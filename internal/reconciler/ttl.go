@@ -0,0 +1,79 @@
+package reconciler
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// AnnotationExpiresAt is the annotation holding the time (RFC 3339) after which the secret must be
+// re-attested, i.e. refreshed if its device still exists or deleted otherwise.
+const AnnotationExpiresAt = "argotails.chezmoi.sh/expires-at"
+
+// WithTTL stamps the secrets with an expiry date, the given time after they have been written or
+// last refreshed. Once expired, the secret of a device still present is refreshed with a new expiry
+// date, while the secret of a device that is gone is deleted, even if its fleet retains it.
+func WithTTL(ttl time.Duration) Option {
+	return func(r *reconciler) { r.ttl = ttl }
+}
+
+// ExpiresAt returns the expiry date of the given secret; secrets without or with an invalid expiry
+// date are expired.
+func ExpiresAt(secret corev1.Secret) time.Time {
+	expiry, err := time.Parse(time.RFC3339, secret.Annotations[AnnotationExpiresAt])
+	if err != nil {
+		return time.Time{}
+	}
+	return expiry
+}
+
+// expired returns true if the given secret is past its TTL; secrets never expire without TTL.
+func (r reconciler) expired(secret corev1.Secret, now time.Time) bool {
+	return r.ttl > 0 && !now.Before(ExpiresAt(secret))
+}
+
+// untilExpiry returns the time left before the given secret, as read before its update, expires:
+// until its expiry date while valid, or a whole TTL once (re)stamped; zero without TTL.
+func (r reconciler) untilExpiry(secret corev1.Secret, now time.Time) time.Duration {
+	if r.ttl <= 0 {
+		return 0
+	}
+	if r.expired(secret, now) {
+		return r.ttl
+	}
+	return ExpiresAt(secret).Sub(now)
+}
+
+// ttlUpToDate returns true if the expiry date of the given secret does not need to be written: it
+// is still valid, or absent without TTL.
+func (r reconciler) ttlUpToDate(secret corev1.Secret, now time.Time) bool {
+	if r.ttl <= 0 {
+		_, exists := secret.Annotations[AnnotationExpiresAt]
+		return !exists
+	}
+	return !r.expired(secret, now)
+}
+
+// stampExpiry sets the expiry date of the given secret, keeping the current one while it is valid.
+// The expiry date is removed without TTL.
+func (r reconciler) stampExpiry(ctx context.Context, namespacedName types.NamespacedName, secret *corev1.Secret, now time.Time) {
+	if r.ttl <= 0 {
+		delete(secret.Annotations, AnnotationExpiresAt)
+		return
+	}
+	if !r.expired(*secret, now) {
+		return
+	}
+
+	if _, exists := secret.Annotations[AnnotationExpiresAt]; exists {
+		ctrllog.FromContext(ctx).V(1).Info("Tailscale device's secret expired, refreshed with a new expiry date", "ttl", r.ttl)
+		r.event(ctx, namespacedName, corev1.EventTypeNormal, "SecretRefreshed", "Secret expired and re-attested, valid for %s", r.ttl)
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[AnnotationExpiresAt] = now.Add(r.ttl).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,89 @@
+// Package report builds the report of a synchronization cycle, listing the secrets left without
+// matching Tailscale device and the devices that failed to produce their secret, and publishes it
+// as metrics and, optionally, as a ConfigMap.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ConfigMapKey is the ConfigMap entry holding the JSON report.
+const ConfigMapKey = "report.json"
+
+var (
+	orphanSecrets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argotails_report_orphan_secrets",
+		Help: "Number of managed secrets left without matching Tailscale device at the end of the last synchronization cycle.",
+	})
+	failedDevices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argotails_report_failed_devices",
+		Help: "Number of Tailscale devices that failed to produce their secret during the last synchronization cycle.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphanSecrets, failedDevices)
+}
+
+type (
+	// Report is the report of a synchronization cycle.
+	Report struct {
+		// Time is when the cycle finished.
+		Time time.Time `json:"time"`
+		// Orphans are the managed secrets left without matching Tailscale device.
+		Orphans []Entry `json:"orphans"`
+		// Failures are the Tailscale devices that failed to produce their secret.
+		Failures []Entry `json:"failures"`
+	}
+
+	// Entry is a secret or a device reported with the reason it is reported.
+	Entry struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		Device    string `json:"device,omitempty"`
+		Reason    string `json:"reason"`
+	}
+)
+
+// AddOrphan reports a managed secret left without matching Tailscale device.
+func (r *Report) AddOrphan(namespace, name, reason string) {
+	r.Orphans = append(r.Orphans, Entry{Namespace: namespace, Name: name, Reason: reason})
+}
+
+// AddFailure reports a Tailscale device that failed to produce its secret.
+func (r *Report) AddFailure(namespace, name, device string, err error) {
+	r.Failures = append(r.Failures, Entry{Namespace: namespace, Name: name, Device: device, Reason: err.Error()})
+}
+
+// Publish finalizes the report, updates the report metrics and, if name is not empty, writes the
+// report into the given ConfigMap using server-side apply.
+func (r *Report) Publish(ctx context.Context, c client.Client, namespace, name, managedBy string) error {
+	r.Time = time.Now().UTC()
+	for _, entries := range [][]Entry{r.Orphans, r.Failures} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Namespace+"/"+entries[i].Name < entries[j].Namespace+"/"+entries[j].Name
+		})
+	}
+	orphanSecrets.Set(float64(len(r.Orphans)))
+	failedDevices.Set(float64(len(r.Failures)))
+
+	if name == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	cm := corev1ac.ConfigMap(name, namespace).
+		WithLabels(map[string]string{"apps.kubernetes.io/managed-by": managedBy}).
+		WithData(map[string]string{ConfigMapKey: string(raw)})
+	return c.Apply(ctx, cm, client.FieldOwner(managedBy), client.ForceOwnership)
+}
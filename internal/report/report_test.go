@@ -0,0 +1,40 @@
+package report_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/chezmoidotsh/argotails/internal/report"
+)
+
+func TestReport_Publish(t *testing.T) {
+	ks := fake.NewClientBuilder().Build()
+
+	var r report.Report
+	r.AddOrphan("argocd", "B.fake.ts.net", "no matching Tailscale device")
+	r.AddOrphan("argocd", "A.fake.ts.net", "failed to delete secret: forbidden")
+	r.AddFailure("argocd", "C.fake.ts.net", "C.fake.ts.net", errors.New("failed to create secret: forbidden"))
+	require.NoError(t, r.Publish(context.Background(), ks, "argocd", "argotails-report", "argotails"))
+
+	var cm corev1.ConfigMap
+	require.NoError(t, ks.Get(context.Background(), types.NamespacedName{Name: "argotails-report", Namespace: "argocd"}, &cm))
+	assert.Equal(t, "argotails", cm.Labels["apps.kubernetes.io/managed-by"])
+
+	var published report.Report
+	require.NoError(t, json.Unmarshal([]byte(cm.Data[report.ConfigMapKey]), &published))
+	require.Len(t, published.Orphans, 2)
+	assert.Equal(t, "A.fake.ts.net", published.Orphans[0].Name)
+	assert.Equal(t, []report.Entry{{Namespace: "argocd", Name: "C.fake.ts.net", Device: "C.fake.ts.net", Reason: "failed to create secret: forbidden"}}, published.Failures)
+	assert.False(t, published.Time.IsZero())
+
+	// Without ConfigMap name, only the metrics are updated.
+	require.NoError(t, (&report.Report{}).Publish(context.Background(), ks, "argocd", "", "argotails"))
+}
@@ -0,0 +1,214 @@
+// Package schedule computes the times of the time-based synchronizations: fixed intervals aligned
+// on the wall clock, so that they do not drift, or standard 5-field cron expressions, optionally
+// delayed by a random jitter to spread the synchronizations of several instances.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoNextRun is returned when a schedule has no run within the search window.
+var ErrNoNextRun = errors.New("no next run")
+
+// searchWindow is the period within which every valid cron expression matches at least once,
+// e.g. February 29th, which is skipped in 2100.
+const searchWindow = 8
+
+// Schedule returns the time of the next run strictly after the given time.
+type Schedule interface {
+	Next(after time.Time) (time.Time, error)
+}
+
+// Every runs at every multiple of the interval since the Unix epoch (e.g. at :00 and :30 of every
+// minute for 30s), whatever the start time of the controller and the duration of the runs.
+type Every time.Duration
+
+func (e Every) Next(after time.Time) (time.Time, error) {
+	d := time.Duration(e)
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("%w: invalid interval %s", ErrNoNextRun, d)
+	}
+	return after.Truncate(d).Add(d), nil
+}
+
+// Jitter delays every run of the schedule by a random duration up to Max.
+type Jitter struct {
+	Schedule Schedule
+	Max      time.Duration
+}
+
+func (j Jitter) Next(after time.Time) (time.Time, error) {
+	// NOTE: the next run is computed from the un-jittered time of the last run; otherwise, a jitter
+	//       close to the interval could skip a run.
+	next, err := j.Schedule.Next(after.Add(-j.Max))
+	for err == nil && !next.After(after) {
+		next, err = j.Schedule.Next(next)
+	}
+	if err != nil || j.Max <= 0 {
+		return next, err
+	}
+	return next.Add(rand.N(j.Max)), nil
+}
+
+// MaxInterval returns the longest time between two of the n runs following the given time, e.g.
+// to detect late runs.
+func MaxInterval(s Schedule, from time.Time, n int) (time.Duration, error) {
+	var longest time.Duration
+	prev, err := s.Next(from)
+	if err != nil {
+		return 0, err
+	}
+	for range n {
+		next, err := s.Next(prev)
+		if err != nil {
+			return 0, err
+		}
+		longest = max(longest, next.Sub(prev))
+		prev = next
+	}
+	return longest, nil
+}
+
+// Cron is a standard 5-field cron expression (minute, hour, day of month, month and day of week),
+// evaluated in the local time zone. Fields accept '*', values, ranges ('1-5'), lists ('1,15') and
+// steps ('*/5', '0-30/10').
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are true when the day of month or of week is exactly '*' (a stepped
+	// '*/2' is not): as for cron, a day matches when it matches both fields if one of them is '*',
+	// either of them otherwise.
+	domStar, dowStar bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a standard 5-field cron expression, which must match at least once (e.g.
+// '0 0 30 2 *' is rejected).
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s: %w", expr, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is either 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+	cron := &Cron{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: fields[2] == "*", dowStar: fields[4] == "*",
+	}
+	if _, err := cron.Next(time.Now()); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	return cron, nil
+}
+
+// parseCronField returns the set of values of a cron field, as a bitset.
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+
+		start, end := lo, hi
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("%q out of range [%d-%d]", rng, lo, hi)
+		}
+		for v := start; v <= end; v += n {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *Cron) Next(after time.Time) (time.Time, error) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(searchWindow, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w within %d years", ErrNoNextRun, searchWindow)
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Parse returns the schedule of the given cron expression, or of the given interval if the
+// expression is empty, delayed by a random jitter up to the given duration.
+func Parse(expr string, interval, jitter time.Duration) (Schedule, error) {
+	if expr == "" && interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %s: must be positive", interval)
+	}
+	var s Schedule = Every(interval)
+	if expr != "" {
+		cron, err := ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		s = cron
+	}
+	if jitter > 0 {
+		s = Jitter{Schedule: s, Max: jitter}
+	}
+	return s, nil
+}
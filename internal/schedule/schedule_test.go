@@ -0,0 +1,127 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chezmoidotsh/argotails/internal/schedule"
+)
+
+func date(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func next(t *testing.T, s schedule.Schedule, after string) time.Time {
+	t.Helper()
+	n, err := s.Next(date(after))
+	require.NoError(t, err)
+	return n
+}
+
+func TestEvery(t *testing.T) {
+	s := schedule.Every(30 * time.Second)
+	assert.Equal(t, date("2026-10-16 10:00:30"), next(t, s, "2026-10-16 10:00:12"))
+	assert.Equal(t, date("2026-10-16 10:01:00"), next(t, s, "2026-10-16 10:00:30"))
+
+	_, err := schedule.Every(0).Next(date("2026-10-16 10:00:12"))
+	assert.ErrorIs(t, err, schedule.ErrNoNextRun)
+}
+
+func TestCron(t *testing.T) {
+	tests := []struct {
+		expr     string
+		after    string
+		expected string
+	}{
+		{expr: "*/5 * * * *", after: "2026-10-16 10:03:12", expected: "2026-10-16 10:05:00"},
+		{expr: "*/5 * * * *", after: "2026-10-16 10:55:00", expected: "2026-10-16 11:00:00"},
+		{expr: "0 9 * * 1-5", after: "2026-10-16 10:00:00", expected: "2026-10-19 09:00:00"}, // Friday to Monday
+		{expr: "30 2 1 * *", after: "2026-12-15 00:00:00", expected: "2027-01-01 02:30:00"},
+		{expr: "0 0 29 2 *", after: "2026-03-01 00:00:00", expected: "2028-02-29 00:00:00"},
+		{expr: "0 12 1 * 0", after: "2026-10-16 00:00:00", expected: "2026-10-18 12:00:00"}, // 1st of the month or Sunday
+		{expr: "0 12 * * 7", after: "2026-10-16 00:00:00", expected: "2026-10-18 12:00:00"}, // Sunday as 7
+		{expr: "15,45 8-9 * * *", after: "2026-10-16 08:20:00", expected: "2026-10-16 08:45:00"},
+		{expr: "0-30/10 * * * *", after: "2026-10-16 08:31:00", expected: "2026-10-16 09:00:00"},
+		{expr: "0 0 29 2 *", after: "2096-03-01 00:00:00", expected: "2104-02-29 00:00:00"},   // no February 29th in 2100
+		{expr: "0 12 1 * */2", after: "2026-10-16 00:00:00", expected: "2026-10-17 12:00:00"}, // stepped day of week is not '*'
+		{expr: "0 12 */2 * 1", after: "2026-10-16 00:00:00", expected: "2026-10-17 12:00:00"}, // stepped day of month is not '*'
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := schedule.ParseCron(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, date(tt.expected), next(t, s, tt.after))
+		})
+	}
+}
+
+func TestParseCron_Error(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "* * 0 * *"} {
+		_, err := schedule.ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+
+	// Expressions that never match are rejected instead of never running.
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *", "0 0 30,31 2 *"} {
+		_, err := schedule.ParseCron(expr)
+		assert.ErrorIs(t, err, schedule.ErrNoNextRun, expr)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	s := schedule.Jitter{Schedule: schedule.Every(time.Minute), Max: 20 * time.Second}
+
+	next := date("2026-10-16 10:00:10")
+	for range 100 {
+		previous := next
+		var err error
+		next, err = s.Next(previous)
+		require.NoError(t, err)
+
+		// Every run happens within the jitter after the following minute, none is skipped.
+		base := previous.Add(-20 * time.Second).Truncate(time.Minute).Add(time.Minute)
+		if !base.After(previous) {
+			base = base.Add(time.Minute)
+		}
+		assert.True(t, !next.Before(base) && next.Before(base.Add(20*time.Second)), "%s after %s", next, previous)
+		assert.Less(t, next.Sub(previous), time.Minute+20*time.Second)
+	}
+}
+
+func TestMaxInterval(t *testing.T) {
+	s, err := schedule.ParseCron("0 9 * * 1-5")
+	require.NoError(t, err)
+	interval, err := schedule.MaxInterval(s, date("2026-10-16 00:00:00"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 72*time.Hour, interval)
+	interval, err = schedule.MaxInterval(schedule.Every(30*time.Second), date("2026-10-16 00:00:00"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	_, err = schedule.MaxInterval(schedule.Jitter{Schedule: schedule.Every(0), Max: time.Second}, date("2026-10-16 00:00:00"), 10)
+	assert.ErrorIs(t, err, schedule.ErrNoNextRun)
+}
+
+func TestParse(t *testing.T) {
+	s, err := schedule.Parse("", 30*time.Second, 0)
+	require.NoError(t, err)
+	assert.Equal(t, schedule.Every(30*time.Second), s)
+
+	s, err = schedule.Parse("*/5 * * * *", 30*time.Second, time.Minute)
+	require.NoError(t, err)
+	assert.IsType(t, schedule.Jitter{}, s)
+
+	_, err = schedule.Parse("* * *", 30*time.Second, 0)
+	assert.Error(t, err)
+
+	_, err = schedule.Parse("", 0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,140 @@
+// Package sealedsecrets encrypts Kubernetes secrets into Bitnami SealedSecrets, which can only be
+// decrypted by the sealed-secrets controller owning the given certificate and can therefore be
+// safely committed to Git.
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SealedSecret is a Bitnami SealedSecret (bitnami.com/v1alpha1), restricted to the fields
+// generated by Argotails.
+type SealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec SealedSecretSpec `json:"spec"`
+}
+
+// SealedSecretSpec is the specification of a SealedSecret.
+type SealedSecretSpec struct {
+	// EncryptedData contains the encrypted secret entries, base64 encoded.
+	EncryptedData map[string]string `json:"encryptedData"`
+	// Template is the metadata of the secret generated by the sealed-secrets controller.
+	Template SecretTemplate `json:"template"`
+}
+
+// SecretTemplate describes the secret generated by the sealed-secrets controller.
+type SecretTemplate struct {
+	metav1.ObjectMeta `json:"metadata"`
+
+	Type corev1.SecretType `json:"type,omitempty"`
+}
+
+// ParseCertificate returns the RSA public key of the given PEM encoded sealed-secrets certificate
+// (e.g. as returned by `kubeseal --fetch-cert`).
+func ParseCertificate(raw []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("certificate does not contain an RSA public key")
+	}
+	return key, nil
+}
+
+// Seal encrypts the given secret for the sealed-secrets controller owning the given key. The
+// secret is sealed with the strict scope: it can only be decrypted with the same name and namespace.
+func Seal(secret corev1.Secret, key *rsa.PublicKey) (SealedSecret, error) {
+	data := map[string][]byte{}
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	for k, v := range secret.StringData {
+		data[k] = []byte(v)
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	label := []byte(secret.Namespace + "/" + secret.Name)
+	encrypted := make(map[string]string, len(data))
+	for _, k := range keys {
+		ciphertext, err := hybridEncrypt(key, data[k], label)
+		if err != nil {
+			return SealedSecret{}, fmt.Errorf("failed to seal entry %q: %w", k, err)
+		}
+		encrypted[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	return SealedSecret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "bitnami.com/v1alpha1", Kind: "SealedSecret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secret.Name,
+			Namespace: secret.Namespace,
+		},
+		Spec: SealedSecretSpec{
+			EncryptedData: encrypted,
+			Template: SecretTemplate{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        secret.Name,
+					Namespace:   secret.Namespace,
+					Labels:      secret.Labels,
+					Annotations: secret.Annotations,
+				},
+				Type: secret.Type,
+			},
+		},
+	}, nil
+}
+
+// hybridEncrypt encrypts the plaintext with the sealed-secrets hybrid scheme: a random AES-256-GCM
+// session key, itself encrypted with RSA-OAEP (SHA-256) using the scope label, is prepended (with
+// its 2-byte length) to the AES-GCM ciphertext. As the session key is never reused, the GCM nonce
+// is always zero.
+func hybridEncrypt(key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := binary.BigEndian.AppendUint16(nil, uint16(len(encryptedKey)))
+	ciphertext = append(ciphertext, encryptedKey...)
+	return aead.Seal(ciphertext, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
@@ -0,0 +1,71 @@
+package sealedsecrets_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chezmoidotsh/argotails/internal/sealedsecrets"
+)
+
+// unseal decrypts a value sealed with the sealed-secrets hybrid scheme.
+func unseal(t *testing.T, key *rsa.PrivateKey, value string, label []byte) string {
+	t.Helper()
+
+	raw, err := base64.StdEncoding.DecodeString(value)
+	require.NoError(t, err)
+	size := int(binary.BigEndian.Uint16(raw))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, raw[2:2+size], label)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(sessionKey)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), raw[2+size:], nil)
+	require.NoError(t, err)
+	return string(plaintext)
+}
+
+func TestSeal(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sealed-secret"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	pub, err := sealedsecrets.ParseCertificate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.NoError(t, err)
+
+	sealed, err := sealedsecrets.Seal(corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "a.fake.ts.net", Namespace: "argocd", Labels: map[string]string{"argocd.argoproj.io/secret-type": "cluster"}},
+		StringData: map[string]string{"server": "https://a.fake.ts.net"},
+		Data:       map[string][]byte{"name": []byte("a.fake.ts.net")},
+	}, pub)
+	require.NoError(t, err)
+
+	assert.Equal(t, "SealedSecret", sealed.Kind)
+	assert.Equal(t, "cluster", sealed.Spec.Template.Labels["argocd.argoproj.io/secret-type"])
+	assert.Equal(t, "https://a.fake.ts.net", unseal(t, key, sealed.Spec.EncryptedData["server"], []byte("argocd/a.fake.ts.net")))
+	assert.Equal(t, "a.fake.ts.net", unseal(t, key, sealed.Spec.EncryptedData["name"], []byte("argocd/a.fake.ts.net")))
+}
+
+func TestParseCertificate_Error(t *testing.T) {
+	_, err := sealedsecrets.ParseCertificate([]byte("not a certificate"))
+	assert.EqualError(t, err, "no PEM encoded certificate found")
+}
@@ -0,0 +1,234 @@
+// Package sops encrypts Kubernetes objects into SOPS (https://getsops.io) documents for age
+// recipients, which can only be decrypted with the matching age identities and can therefore be
+// safely committed to Git.
+//
+// The documents are encrypted as `sops --encrypt --encrypted-regex='^(data|stringData)$'
+// --mac-only-encrypted` would: only the secret entries are encrypted, the metadata being left in
+// plain text for the GitOps tools, and the documents of a same file share their data key and MAC.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.yaml.in/yaml/v3"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// EncryptedRegex selects the encrypted entries of the documents: the secret data.
+	EncryptedRegex = `^(data|stringData)$`
+	// Version is the SOPS version the documents are compatible with (mac_only_encrypted requires
+	// SOPS 3.9.0 or later).
+	Version = "3.9.0"
+
+	// nonceSize is the size of the AES-GCM nonces used by SOPS.
+	nonceSize = 32
+)
+
+var rxEncrypted = regexp.MustCompile(EncryptedRegex)
+
+// macOnlyEncryptedInitialization initializes the MAC of the documents whose MAC only covers the
+// encrypted values, so that it always differs from a MAC covering every value.
+var macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// ParseRecipients parses the given age recipients (e.g. 'age1...', as printed by `age-keygen`).
+func ParseRecipients(raw ...string) ([]*age.X25519Recipient, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("no age recipient")
+	}
+	recipients := make([]*age.X25519Recipient, 0, len(raw))
+	for _, r := range raw {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", r, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// Encrypt marshals the given objects as a multi-document YAML file encrypted for the given age
+// recipients.
+func Encrypt(objects []any, recipients []*age.X25519Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no age recipient")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	mac := sha512.New()
+	mac.Write(macOnlyEncryptedInitialization)
+	docs := make([]*yaml.Node, 0, len(objects))
+	for _, obj := range objects {
+		raw, err := sigsyaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("object of type %T is not a mapping", obj)
+		}
+		if err := encryptNode(doc.Content[0], nil, false, dataKey, mac); err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encryptedMAC, err := encryptValue(fmt.Sprintf("%X", mac.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	keys := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, recipient := range recipients {
+		enc, err := encryptDataKey(dataKey, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt the data key for %s: %w", recipient, err)
+		}
+		keys.Content = append(keys.Content, mapping(
+			"recipient", scalar(recipient.String()),
+			"enc", scalar(enc),
+		))
+	}
+	metadata := mapping(
+		"age", keys,
+		"lastmodified", scalar(lastModified),
+		"mac", scalar(encryptedMAC),
+		"encrypted_regex", scalar(EncryptedRegex),
+		"mac_only_encrypted", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+		"version", scalar(Version),
+	)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		root := doc.Content[0]
+		root.Content = append(root.Content, scalar("sops"), metadata)
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// encryptNode encrypts in place the values of the given node, at the given path, whose path
+// matches EncryptedRegex, adding them to the MAC in document order.
+func encryptNode(node *yaml.Node, path []string, encrypted bool, dataKey []byte, mac hash.Hash) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "sops" && len(path) == 0 {
+				return errors.New("object already has a 'sops' entry")
+			}
+			err := encryptNode(node.Content[i+1], append(path, key), encrypted || rxEncrypted.MatchString(key), dataKey, mac)
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := encryptNode(item, path, encrypted, dataKey, mac); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !encrypted || node.ShortTag() == "!!null" {
+			return nil
+		}
+		if node.ShortTag() != "!!str" {
+			return fmt.Errorf("entry %q: only strings can be encrypted, got %s", strings.Join(path, "."), node.ShortTag())
+		}
+		mac.Write([]byte(node.Value))
+		value, err := encryptValue(node.Value, dataKey, strings.Join(path, ":")+":")
+		if err != nil {
+			return err
+		}
+		node.Value, node.Style = value, 0
+	}
+	return nil
+}
+
+// encryptValue encrypts the given string value with AES-256-GCM, authenticating the given
+// additional data (the path of the value), in the SOPS format. Empty values are not encrypted.
+func encryptValue(value string, dataKey []byte, additionalData string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, nonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	out := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	ciphertext, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(ciphertext),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+	), nil
+}
+
+// encryptDataKey encrypts the data key for the given recipient, as an armored age file.
+func encryptDataKey(dataKey []byte, recipient age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// scalar returns a string YAML node.
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// mapping returns a YAML mapping node of the given key and value pairs.
+func mapping(pairs ...any) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		node.Content = append(node.Content, scalar(pairs[i].(string)), pairs[i+1].(*yaml.Node))
+	}
+	return node
+}
@@ -0,0 +1,149 @@
+package sops_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.yaml.in/yaml/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chezmoidotsh/argotails/internal/sops"
+)
+
+var rxValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:str\]$`)
+
+// decryptValue decrypts a value encrypted in the SOPS format.
+func decryptValue(t *testing.T, dataKey []byte, value, additionalData string) string {
+	t.Helper()
+
+	match := rxValue.FindStringSubmatch(value)
+	require.NotNil(t, match, "value %q is not encrypted", value)
+	var raw [3][]byte
+	for i := range raw {
+		var err error
+		raw[i], err = base64.StdEncoding.DecodeString(match[i+1])
+		require.NoError(t, err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(raw[1]))
+	require.NoError(t, err)
+	plaintext, err := gcm.Open(nil, raw[1], append(raw[0], raw[2]...), []byte(additionalData))
+	require.NoError(t, err)
+	return string(plaintext)
+}
+
+// decrypt decrypts the SOPS documents with the given identity, checking their MAC, and returns
+// their decrypted data entries by document and entry path.
+func decrypt(t *testing.T, raw []byte, identity age.Identity) []map[string]string {
+	t.Helper()
+
+	var docs []map[string]any
+	var nodes []yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		var doc map[string]any
+		require.NoError(t, node.Decode(&doc))
+		docs, nodes = append(docs, doc), append(nodes, node)
+	}
+	require.NotEmpty(t, docs)
+
+	metadata := docs[0]["sops"].(map[string]any)
+	assert.Equal(t, sops.EncryptedRegex, metadata["encrypted_regex"])
+	assert.Equal(t, true, metadata["mac_only_encrypted"])
+	var dataKey []byte
+	for _, key := range metadata["age"].([]any) {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(key.(map[string]any)["enc"].(string))), identity)
+		if err == nil {
+			dataKey, err = io.ReadAll(r)
+			require.NoError(t, err)
+		}
+	}
+	require.NotNil(t, dataKey, "no data key encrypted for the identity")
+
+	mac := sha512.New()
+	mac.Write([]byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69})
+	entries := make([]map[string]string, len(nodes))
+	for i, node := range nodes {
+		entries[i] = map[string]string{}
+		root := node.Content[0]
+		for k := 0; k+1 < len(root.Content); k += 2 {
+			key, value := root.Content[k].Value, root.Content[k+1]
+			if key != "data" && key != "stringData" {
+				continue
+			}
+			for e := 0; e+1 < len(value.Content); e += 2 {
+				path := key + ":" + value.Content[e].Value + ":"
+				plaintext := value.Content[e+1].Value
+				if plaintext != "" {
+					plaintext = decryptValue(t, dataKey, plaintext, path)
+				}
+				mac.Write([]byte(plaintext))
+				entries[i][strings.TrimSuffix(path, ":")] = plaintext
+			}
+		}
+	}
+	assert.Equal(t, fmt.Sprintf("%X", mac.Sum(nil)), decryptValue(t, dataKey, metadata["mac"].(string), metadata["lastmodified"].(string)))
+	return entries
+}
+
+func TestEncrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	recipients, err := sops.ParseRecipients(identity.Recipient().String(), " "+other.Recipient().String()+"\n")
+	require.NoError(t, err)
+
+	raw, err := sops.Encrypt([]any{
+		corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "a.fake.ts.net", Namespace: "argocd", Labels: map[string]string{"argocd.argoproj.io/secret-type": "cluster"}},
+			StringData: map[string]string{"server": "https://a.fake.ts.net", "empty": ""},
+		},
+		corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "b.fake.ts.net", Namespace: "argocd"},
+			Data:       map[string][]byte{"name": []byte("b.fake.ts.net")},
+		},
+	}, recipients)
+	require.NoError(t, err)
+
+	// The metadata is left in plain text, the secret data is not.
+	assert.Contains(t, string(raw), "argocd.argoproj.io/secret-type: cluster")
+	assert.NotContains(t, string(raw), "https://a.fake.ts.net")
+
+	for _, id := range []age.Identity{identity, other} {
+		assert.Equal(t, []map[string]string{
+			{"stringData:empty": "", "stringData:server": "https://a.fake.ts.net"},
+			{"data:name": base64.StdEncoding.EncodeToString([]byte("b.fake.ts.net"))},
+		}, decrypt(t, raw, id))
+	}
+}
+
+func TestParseRecipients_Error(t *testing.T) {
+	_, err := sops.ParseRecipients()
+	assert.EqualError(t, err, "no age recipient")
+
+	_, err = sops.ParseRecipients("not a recipient")
+	assert.ErrorContains(t, err, `invalid age recipient "not a recipient"`)
+}
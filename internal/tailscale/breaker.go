@@ -0,0 +1,126 @@
+package tsutils
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// BreakerClosed is the state of a circuit breaker letting every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen is the state of a circuit breaker letting a single probe through.
+	BreakerHalfOpen
+	// BreakerOpen is the state of a circuit breaker rejecting every call.
+	BreakerOpen
+)
+
+// ErrBreakerOpen is returned when the Tailscale API is not called because the circuit breaker is open.
+var ErrBreakerOpen = errors.New("tailscale API circuit breaker is open")
+
+var breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "argotails_tailscale_circuit_breaker_state",
+	Help: "State of the circuit breaker around the Tailscale API: 0 (closed), 1 (half-open) or 2 (open).",
+})
+
+func init() {
+	metrics.Registry.MustRegister(breakerState)
+}
+
+type (
+	// BreakerState is the state of a circuit breaker.
+	BreakerState int
+
+	// CircuitBreaker stops calling the Tailscale API after consecutive failures, in order to avoid
+	// retry storms during Tailscale outages. Once open, a single probe is let through after a
+	// cooldown doubling on every failed probe (up to a maximum); a successful probe closes it.
+	// It is safe for concurrent use.
+	CircuitBreaker struct {
+		threshold   int
+		cooldown    time.Duration
+		maxCooldown time.Duration
+		now         func() time.Time
+
+		mu       sync.Mutex
+		state    BreakerState
+		failures int
+		wait     time.Duration
+		openedAt time.Time
+	}
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// NewCircuitBreaker creates a circuit breaker opening after threshold consecutive failures and
+// probing again after the given cooldown, doubled on every failed probe up to maxCooldown.
+func NewCircuitBreaker(threshold int, cooldown, maxCooldown time.Duration) *CircuitBreaker {
+	breakerState.Set(float64(BreakerClosed))
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, maxCooldown: max(cooldown, maxCooldown), now: time.Now}
+}
+
+// Allow returns true if the call can be done: always when closed, once the cooldown elapsed when
+// open (the call is then the probe of the half-open breaker).
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.wait {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		return true
+	case BreakerHalfOpen:
+		// Only a single probe is in flight
+		return false
+	}
+	return true
+}
+
+// Record records the outcome of an allowed call.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures, b.wait = 0, 0
+		b.setState(BreakerClosed)
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.wait = min(2*b.wait, b.maxCooldown)
+	case b.failures >= b.threshold:
+		b.wait = b.cooldown
+	default:
+		return
+	}
+	b.openedAt = b.now()
+	b.setState(BreakerOpen)
+}
+
+// State returns the current state of the circuit breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	breakerState.Set(float64(state))
+}
@@ -0,0 +1,91 @@
+package tsutils_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := tsutils.NewCircuitBreaker(2, 20*time.Second, 40*time.Second)
+	breaker.SetClock(func() time.Time { return now })
+	failure := errors.New("unavailable")
+
+	// The breaker opens after consecutive failures only.
+	require.True(t, breaker.Allow())
+	breaker.Record(failure)
+	breaker.Record(nil)
+	breaker.Record(failure)
+	assert.Equal(t, tsutils.BreakerClosed, breaker.State())
+	breaker.Record(failure)
+	assert.Equal(t, tsutils.BreakerOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	// A single probe is let through after the cooldown...
+	now = now.Add(20 * time.Second)
+	require.True(t, breaker.Allow())
+	assert.Equal(t, tsutils.BreakerHalfOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	// ... and a failed probe doubles the cooldown, up to the maximum one.
+	breaker.Record(failure)
+	assert.Equal(t, tsutils.BreakerOpen, breaker.State())
+	now = now.Add(39 * time.Second)
+	assert.False(t, breaker.Allow())
+	now = now.Add(time.Second)
+	require.True(t, breaker.Allow())
+
+	breaker.Record(failure)
+	now = now.Add(40 * time.Second)
+	require.True(t, breaker.Allow())
+
+	breaker.Record(nil)
+	assert.Equal(t, tsutils.BreakerClosed, breaker.State())
+	assert.True(t, breaker.Allow())
+}
+
+func TestDeviceSnapshot_CircuitBreaker(t *testing.T) {
+	available, calls := true, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"message":"unavailable"}`))
+			return
+		}
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{{Name: "A.fake.ts.net"}}})
+		_, _ = w.Write(raw)
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	ts := tsutils.NewDeviceAPI(&tailscale.Client{Tailnet: "fake.ts.net", HTTP: srv.Client(), BaseURL: srvURL})
+
+	snapshot, err := tsutils.NewDeviceSnapshot("")
+	require.NoError(t, err)
+	snapshot.SetCircuitBreaker(tsutils.NewCircuitBreaker(1, time.Hour, time.Hour))
+
+	_, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+
+	// Once open, the Tailscale API is no longer called and the last known devices are served.
+	available = false
+	for range 3 {
+		devices, err := snapshot.List(context.TODO(), ts)
+		require.NoError(t, err)
+		assert.Len(t, devices, 1)
+	}
+	assert.Equal(t, 2, calls)
+}
@@ -1,10 +1,18 @@
 package tsutils
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"tailscale.com/client/tailscale/v2"
 )
 
@@ -13,26 +21,224 @@ var (
 	rxOAuthKey = regexp.MustCompile(`^tskey-client-(?P<client_id>[a-zA-Z0-9]+CNTRL)-[a-zA-Z0-9]+$`)
 )
 
+// defaultHTTPTimeout is the timeout applied to every request sent to the Tailscale API.
+const defaultHTTPTimeout = time.Minute
+
+const (
+	// ScopeDevicesCoreRead is the OAuth scope required to list the Tailscale devices.
+	ScopeDevicesCoreRead = "devices:core:read"
+	// ScopeDevicesPostureAttributes is the OAuth scope required to write device posture attributes.
+	ScopeDevicesPostureAttributes = "devices:posture_attributes"
+	// ScopeWebhooks is the OAuth scope required to manage the webhook endpoints of the tailnet.
+	ScopeWebhooks = "webhooks"
+	// ScopeWebhooksRead is the OAuth scope required to check the webhook endpoints of the tailnet.
+	ScopeWebhooksRead = "webhooks:read"
+)
+
+type (
+	// ClientOption configures optional behaviors of the Tailscale client.
+	ClientOption func(*clientOptions)
+
+	clientOptions struct {
+		// proxy returns the proxy to use for a given request.
+		proxy func(*http.Request) (*url.URL, error)
+		// scopes are the OAuth scopes requested for the client.
+		scopes []string
+		// authKeyFile is the file the OAuth key is reloaded from.
+		authKeyFile string
+	}
+)
+
+// WithProxyURL routes all requests to the Tailscale API (including OAuth token requests) through
+// the given HTTP(S) or SOCKS5 proxy. When no proxy URL is provided, the standard HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY environment variables are honored.
+func WithProxyURL(proxyURL *url.URL) ClientOption {
+	return func(o *clientOptions) {
+		if proxyURL != nil {
+			o.proxy = http.ProxyURL(proxyURL)
+		}
+	}
+}
+
+// WithScopes requests the given OAuth scopes instead of the read-only device access
+// (ScopeDevicesCoreRead) required by the controller; use HasScope to check that they cover the
+// enabled features.
+func WithScopes(scopes ...string) ClientOption {
+	return func(o *clientOptions) {
+		if len(scopes) > 0 {
+			o.scopes = scopes
+		}
+	}
+}
+
+// HasScope returns true if the required OAuth scope is granted by one of the given scopes: a
+// scope grants itself, its read-only variant and its sub-scopes (e.g. "devices" grants
+// "devices:core:read"), "all" grants every scope and "all:read" every read-only one.
+func HasScope(granted []string, required string) bool {
+	r, rRead := strings.CutSuffix(required, ":read")
+	for _, scope := range granted {
+		g, gRead := strings.CutSuffix(scope, ":read")
+		if gRead && !rRead {
+			continue
+		}
+		if g == "all" || g == r || strings.HasPrefix(r, g+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// WithAuthKeyFile reloads the OAuth key from the given file every time a new OAuth token is
+// requested, so that a rotated OAuth client is used without restarting the controller. The cached
+// token is dropped as soon as the Tailscale API rejects it.
+func WithAuthKeyFile(path string) ClientOption {
+	return func(o *clientOptions) { o.authKeyFile = path }
+}
+
 // NewTailscaleClient creates a new Tailscale client based on the provided configuration.
-func NewTailscaleClient(baseURL *url.URL, tailnet, authkey string) (*tailscale.Client, error) {
+func NewTailscaleClient(baseURL *url.URL, tailnet, authkey string, opts ...ClientOption) (*tailscale.Client, error) {
 	if tailnet == "" {
 		return nil, fmt.Errorf("tailnet is required")
 	}
 
+	options := clientOptions{proxy: http.ProxyFromEnvironment, scopes: []string{ScopeDevicesCoreRead}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	ts := &tailscale.Client{Tailnet: tailnet, BaseURL: baseURL}
 
+	if err := validateAuthKey(authkey); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = options.proxy
+	base := &http.Client{Transport: transport, Timeout: defaultHTTPTimeout}
+
+	// NOTE: the base HTTP client is injected into the context to be also used when fetching
+	//       OAuth tokens; otherwise, tokens would be requested without going through the proxy.
+	source := &reloadingTokenSource{
+		ctx:      context.WithValue(context.Background(), oauth2.HTTPClient, base),
+		tokenURL: baseURL.JoinPath("/api/v2/oauth/token").String(),
+		scopes:   options.scopes,
+		file:     options.authKeyFile,
+		authkey:  authkey,
+	}
+	ts.HTTP = &http.Client{
+		Transport: &tolerantTransport{
+			base: &fieldsTransport{
+				base: &conditionalTransport{
+					base: &invalidatingTransport{
+						source: source,
+						base:   &oauth2.Transport{Source: source, Base: transport},
+					},
+				},
+			},
+		},
+		Timeout: defaultHTTPTimeout,
+	}
+	return ts, nil
+}
+
+// transportOf returns the transport of the given type of the client created by NewTailscaleClient,
+// looked up along its transport chain.
+func transportOf[T http.RoundTripper](ts *tailscale.Client) (T, bool) {
+	var rt http.RoundTripper
+	if ts != nil && ts.HTTP != nil {
+		rt = ts.HTTP.Transport
+	}
+	for rt != nil {
+		if t, ok := rt.(T); ok {
+			return t, true
+		}
+		wrapper, ok := rt.(interface{ unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapper.unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// validateAuthKey returns an error if the given key is not a Tailscale OAuth key.
+func validateAuthKey(authkey string) error {
 	switch {
 	case rxAPIKey.MatchString(authkey):
-		return nil, fmt.Errorf("API keys are not supported in this controller, use OAuth keys instead")
+		return fmt.Errorf("API keys are not supported in this controller, use OAuth keys instead")
 	case rxOAuthKey.MatchString(authkey):
-		ts.HTTP = tailscale.OAuthConfig{
-			ClientID:     rxOAuthKey.FindStringSubmatch(authkey)[1],
-			ClientSecret: authkey,
-			Scopes:       []string{"devices:core:read"},
-		}.HTTPClient()
+		return nil
 	default:
-		return nil, fmt.Errorf("invalid auth key format")
+		return fmt.Errorf("invalid auth key format")
 	}
+}
 
-	return ts, nil
+// reloadingTokenSource fetches OAuth tokens using the OAuth key, optionally reloaded from a file
+// before each token request. Tokens are cached until they expire or are invalidated.
+type reloadingTokenSource struct {
+	ctx      context.Context
+	tokenURL string
+	scopes   []string
+	file     string
+
+	mu      sync.Mutex
+	authkey string
+	token   *oauth2.Token
+}
+
+// Token returns the cached token if still valid, or requests a new one.
+func (s *reloadingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	if s.file != "" {
+		raw, err := os.ReadFile(s.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reload OAuth key: %w", err)
+		}
+		authkey := strings.TrimSpace(string(raw))
+		if err := validateAuthKey(authkey); err != nil {
+			return nil, fmt.Errorf("failed to reload OAuth key from %q: %w", s.file, err)
+		}
+		s.authkey = authkey
+	}
+
+	oauth := &clientcredentials.Config{
+		ClientID:     rxOAuthKey.FindStringSubmatch(s.authkey)[1],
+		ClientSecret: s.authkey,
+		Scopes:       s.scopes,
+		TokenURL:     s.tokenURL,
+	}
+	token, err := oauth.Token(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// invalidate drops the cached token, forcing a new one to be requested.
+func (s *reloadingTokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = nil
+}
+
+// invalidatingTransport drops the cached OAuth token when the Tailscale API rejects it (e.g. the
+// OAuth client has been revoked), so that the next request uses a fresh token.
+type invalidatingTransport struct {
+	source *reloadingTokenSource
+	base   http.RoundTripper
+}
+
+func (t *invalidatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		t.source.invalidate()
+	}
+	return res, err
 }
@@ -0,0 +1,171 @@
+package tsutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// conditionalTransport sends conditional GET requests (If-None-Match, If-Modified-Since) when the
+// Tailscale API (or Headscale) returned cache validators for the same URL. A "304 Not Modified"
+// answer is replaced by the previously received response, so that the Tailscale client is not
+// aware of it.
+type conditionalTransport struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string]cachedResponse
+}
+
+// cachedResponse is the last response received for a URL having cache validators.
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	t.mu.Lock()
+	cached, exists := t.responses[key]
+	t.mu.Unlock()
+
+	if exists {
+		// NOTE: a RoundTripper must not modify the given request.
+		req = req.Clone(req.Context())
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && exists:
+		_ = res.Body.Close()
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         res.Proto,
+			ProtoMajor:    res.ProtoMajor,
+			ProtoMinor:    res.ProtoMinor,
+			Header:        cached.header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       req,
+		}, nil
+
+	case res.StatusCode == http.StatusOK:
+		etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+		if etag == "" && lastModified == "" {
+			return res, nil
+		}
+
+		body, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+
+		t.mu.Lock()
+		if t.responses == nil {
+			t.responses = map[string]cachedResponse{}
+		}
+		t.responses[key] = cachedResponse{etag: etag, lastModified: lastModified, header: res.Header.Clone(), body: body}
+		t.mu.Unlock()
+	}
+	return res, nil
+}
+
+// DeviceHashFields selects the optional device fields covered by DevicesHash, depending on the
+// enabled features.
+type DeviceHashFields struct {
+	// Routes covers the advertised and enabled routes of the devices.
+	Routes bool
+	// Connectivity covers the preferred DERP region of the devices.
+	Connectivity bool
+}
+
+// hashedDevice are the fields of a device covered by DevicesHash: the ones the Kubernetes
+// resources are rendered from or filtered on. The fields changing on their own (last seen time,
+// connection to the control plane, node keys, DERP latencies) are ignored.
+type hashedDevice struct {
+	Addresses                 []string          `json:"addresses"`
+	Name                      string            `json:"name"`
+	NodeID                    string            `json:"nodeId"`
+	Authorized                bool              `json:"authorized"`
+	User                      string            `json:"user"`
+	Tags                      []string          `json:"tags"`
+	KeyExpiryDisabled         bool              `json:"keyExpiryDisabled"`
+	BlocksIncomingConnections bool              `json:"blocksIncomingConnections"`
+	ClientVersion             string            `json:"clientVersion"`
+	Created                   tailscale.Time    `json:"created"`
+	Expires                   tailscale.Time    `json:"expires"`
+	Hostname                  string            `json:"hostname"`
+	IsEphemeral               bool              `json:"isEphemeral"`
+	IsExternal                bool              `json:"isExternal"`
+	OS                        string            `json:"os"`
+	UpdateAvailable           bool              `json:"updateAvailable"`
+	SSHEnabled                bool              `json:"sshEnabled"`
+	Distro                    *tailscale.Distro `json:"distro,omitempty"`
+	AdvertisedRoutes          []string          `json:"advertisedRoutes,omitempty"`
+	EnabledRoutes             []string          `json:"enabledRoutes,omitempty"`
+	PreferredDERPRegion       string            `json:"preferredDerpRegion,omitempty"`
+}
+
+// DevicesHash returns a hash of the given devices, only covering the fields the Kubernetes
+// resources are rendered from (see hashedDevice), along with the given optional ones. Two listings
+// having the same hash produce the same Kubernetes resources.
+func DevicesHash(devices []tailscale.Device, fields DeviceHashFields) string {
+	stable := make([]hashedDevice, len(devices))
+	for i, device := range devices {
+		stable[i] = hashedDevice{
+			Addresses:                 device.Addresses,
+			Name:                      device.Name,
+			NodeID:                    device.NodeID,
+			Authorized:                device.Authorized,
+			User:                      device.User,
+			Tags:                      device.Tags,
+			KeyExpiryDisabled:         device.KeyExpiryDisabled,
+			BlocksIncomingConnections: device.BlocksIncomingConnections,
+			ClientVersion:             device.ClientVersion,
+			Created:                   device.Created,
+			Expires:                   device.Expires,
+			Hostname:                  device.Hostname,
+			IsEphemeral:               device.IsEphemeral,
+			IsExternal:                device.IsExternal,
+			OS:                        device.OS,
+			UpdateAvailable:           device.UpdateAvailable,
+			SSHEnabled:                device.SSHEnabled,
+			Distro:                    device.Distro,
+		}
+		if fields.Routes {
+			stable[i].AdvertisedRoutes, stable[i].EnabledRoutes = device.AdvertisedRoutes, device.EnabledRoutes
+		}
+		if fields.Connectivity {
+			stable[i].PreferredDERPRegion, _ = PreferredDERPRegion(device)
+		}
+	}
+
+	raw, _ := json.Marshal(stable)
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
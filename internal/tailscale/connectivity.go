@@ -0,0 +1,64 @@
+package tsutils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+)
+
+var derpLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "argotails_device_derp_latency_seconds",
+	Help: "Latency between the registered Tailscale devices and their preferred DERP relay region, as last reported by the Tailscale API.",
+}, []string{"device", "region"})
+
+var (
+	// recordedMu guards recorded.
+	recordedMu sync.Mutex
+	// recorded are the label values (device and region) of the DERP latencies reported by the
+	// metrics.
+	recorded = map[[2]string]struct{}{}
+)
+
+func init() {
+	metrics.Registry.MustRegister(derpLatency)
+}
+
+// PreferredDERPRegion returns the name of the preferred DERP relay region of the device (e.g.
+// "Frankfurt") and its latency to this region. The connectivity is only returned by the Tailscale
+// API when listing the devices with all their fields; an empty name is returned without it.
+func PreferredDERPRegion(device tailscale.Device) (string, time.Duration) {
+	if device.ClientConnectivity == nil {
+		return "", 0
+	}
+	for region, derp := range device.ClientConnectivity.DERPLatency {
+		if derp.Preferred {
+			return region, time.Duration(derp.LatencyMilliseconds * float64(time.Millisecond))
+		}
+	}
+	return "", 0
+}
+
+// RecordConnectivity replaces the DERP latencies reported by the metrics with the ones of the given
+// devices, so that the removed devices, and the regions the devices moved away from, are no
+// longer reported. The metrics are updated in place, never being empty while scraped.
+func RecordConnectivity(devices []tailscale.Device) {
+	recordedMu.Lock()
+	defer recordedMu.Unlock()
+
+	current := make(map[[2]string]struct{}, len(devices))
+	for _, device := range devices {
+		if region, latency := PreferredDERPRegion(device); region != "" {
+			derpLatency.WithLabelValues(device.Name, region).Set(latency.Seconds())
+			current[[2]string{device.Name, region}] = struct{}{}
+		}
+	}
+	for labels := range recorded {
+		if _, exists := current[labels]; !exists {
+			derpLatency.DeleteLabelValues(labels[0], labels[1])
+		}
+	}
+	recorded = current
+}
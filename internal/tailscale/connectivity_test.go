@@ -0,0 +1,54 @@
+package tsutils_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestConnectivity(t *testing.T) {
+	device := tailscale.Device{Name: "A.fake.ts.net", ClientConnectivity: &tailscale.ClientConnectivity{
+		DERPLatency: map[string]tailscale.DERPRegion{
+			"Frankfurt": {Preferred: true, LatencyMilliseconds: 12.5},
+			"Paris":     {LatencyMilliseconds: 20},
+		},
+	}}
+	region, latency := tsutils.PreferredDERPRegion(device)
+	assert.Equal(t, "Frankfurt", region)
+	assert.Equal(t, 12500*time.Microsecond, latency)
+
+	region, _ = tsutils.PreferredDERPRegion(tailscale.Device{Name: "B.fake.ts.net"})
+	assert.Empty(t, region, "connectivity not listed")
+
+	tsutils.RecordConnectivity([]tailscale.Device{device, {Name: "B.fake.ts.net"}})
+	expected := `
+# HELP argotails_device_derp_latency_seconds Latency between the registered Tailscale devices and their preferred DERP relay region, as last reported by the Tailscale API.
+# TYPE argotails_device_derp_latency_seconds gauge
+argotails_device_derp_latency_seconds{device="A.fake.ts.net",region="Frankfurt"} 0.0125
+`
+	require.NoError(t, testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "argotails_device_derp_latency_seconds"))
+
+	// The regions the devices moved away from are no longer reported...
+	device.ClientConnectivity.DERPLatency = map[string]tailscale.DERPRegion{"Paris": {Preferred: true, LatencyMilliseconds: 20}}
+	tsutils.RecordConnectivity([]tailscale.Device{device})
+	expected = `
+# HELP argotails_device_derp_latency_seconds Latency between the registered Tailscale devices and their preferred DERP relay region, as last reported by the Tailscale API.
+# TYPE argotails_device_derp_latency_seconds gauge
+argotails_device_derp_latency_seconds{device="A.fake.ts.net",region="Paris"} 0.02
+`
+	require.NoError(t, testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "argotails_device_derp_latency_seconds"))
+
+	// ... nor are the removed devices.
+	tsutils.RecordConnectivity(nil)
+	count, err := testutil.GatherAndCount(metrics.Registry, "argotails_device_derp_latency_seconds")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
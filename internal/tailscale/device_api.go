@@ -0,0 +1,61 @@
+package tsutils
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// DeviceAPI is the part of the Tailscale API used to manage the devices, so that the reconciler
+// and the loops do not depend on the Tailscale client itself and can run against an alternate
+// implementation (e.g. Headscale) or an in-memory fake in tests.
+type DeviceAPI interface {
+	// List lists the devices of the tailnet.
+	List(ctx context.Context, opts ...tailscale.ListDevicesOptions) ([]tailscale.Device, error)
+	// Get returns the device with the given ID.
+	Get(ctx context.Context, deviceID string) (*tailscale.Device, error)
+	// SetAttributes sets the given posture attributes, by key, of the device with the given ID.
+	SetAttributes(ctx context.Context, deviceID string, attributes map[string]tailscale.DevicePostureAttributeRequest) error
+}
+
+// MalformedDeviceReporter is implemented by the DeviceAPIs skipping from their listings the devices
+// that cannot be decoded.
+type MalformedDeviceReporter interface {
+	// IsMalformedDevice returns true if the device with the given node ID has been skipped from the
+	// last device listing because it could not be decoded; its resources must be left untouched,
+	// as the device still exists.
+	IsMalformedDevice(nodeID string) bool
+}
+
+// tailscaleDeviceAPI is the DeviceAPI of the Tailscale API.
+type tailscaleDeviceAPI struct {
+	*tailscale.DevicesResource
+	// tolerant skips the malformed devices from the listings, if the client has been created by
+	// NewTailscaleClient.
+	tolerant *tolerantTransport
+}
+
+// NewDeviceAPI returns the DeviceAPI of the given Tailscale client.
+func NewDeviceAPI(ts *tailscale.Client) DeviceAPI {
+	tolerant, _ := transportOf[*tolerantTransport](ts)
+	return tailscaleDeviceAPI{DevicesResource: ts.Devices(), tolerant: tolerant}
+}
+
+// IsMalformedDevice implements MalformedDeviceReporter.
+func (api tailscaleDeviceAPI) IsMalformedDevice(nodeID string) bool {
+	return api.tolerant != nil && api.tolerant.isMalformed(nodeID)
+}
+
+// SetAttributes sets the posture attributes one by one, in the order of their keys, as the
+// Tailscale API only sets a single attribute per request.
+func (api tailscaleDeviceAPI) SetAttributes(ctx context.Context, deviceID string, attributes map[string]tailscale.DevicePostureAttributeRequest) error {
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		if err := api.SetPostureAttribute(ctx, deviceID, key, attributes[key]); err != nil {
+			return fmt.Errorf("failed to set posture attribute %q: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package tsutils
+
+import "time"
+
+// SetClock replaces the clock of the circuit breaker.
+func (b *CircuitBreaker) SetClock(now func() time.Time) { b.now = now }
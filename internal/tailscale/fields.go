@@ -0,0 +1,112 @@
+package tsutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// rxDevicesPath matches the Tailscale API paths returning devices: the device listing and a
+// single device.
+var rxDevicesPath = regexp.MustCompile(`/api/v2/(tailnet/[^/]+/devices|device/[^/]+)$`)
+
+// fieldsTransport retains the raw JSON fields of the devices returned by the Tailscale API, so
+// that new fields can be used before being supported by the Tailscale client library.
+type fieldsTransport struct {
+	base http.RoundTripper
+
+	mu sync.RWMutex
+	// devices are the fields of the devices, by node ID, as last returned by the Tailscale API,
+	// including the fields unknown to the Tailscale client library.
+	devices map[string]map[string]any
+}
+
+func (t *fieldsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || res.StatusCode != http.StatusOK || !rxDevicesPath.MatchString(req.URL.Path) {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	t.store(body)
+	return res, nil
+}
+
+func (t *fieldsTransport) unwrap() http.RoundTripper { return t.base }
+
+// store stores the fields of the devices of the given device listing or single device. A listing
+// replaces all the stored devices. Invalid payloads are ignored: they are reported by the
+// Tailscale client itself.
+func (t *fieldsTransport) store(body []byte) {
+	var listing struct {
+		Devices []map[string]any `json:"devices"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if listing.Devices != nil {
+		t.devices = make(map[string]map[string]any, len(listing.Devices))
+		for _, device := range listing.Devices {
+			if id, ok := device["nodeId"].(string); ok {
+				t.devices[id] = device
+			}
+		}
+		return
+	}
+
+	var device map[string]any
+	if err := json.Unmarshal(body, &device); err != nil {
+		return
+	}
+	if id, ok := device["nodeId"].(string); ok {
+		if t.devices == nil {
+			t.devices = map[string]map[string]any{}
+		}
+		t.devices[id] = device
+	}
+}
+
+// DeviceFields returns the fields of the given device known to the Tailscale client library,
+// keyed by their Tailscale API name (e.g. "clientVersion").
+func DeviceFields(device tailscale.Device) map[string]any {
+	fields := map[string]any{}
+	raw, _ := json.Marshal(device)
+	_ = json.Unmarshal(raw, &fields)
+	return fields
+}
+
+// DeviceFieldsOf returns a function returning the fields of the given device (see DeviceFields),
+// including the fields last returned for it by the Tailscale API to the given client but unknown
+// to the Tailscale client library. The known fields take precedence, so that they always match the
+// given device, and the devices that have not been returned to the client (e.g. loaded from a
+// snapshot) only have the known fields.
+func DeviceFieldsOf(ts *tailscale.Client) func(tailscale.Device) map[string]any {
+	t, found := transportOf[*fieldsTransport](ts)
+	if !found {
+		return DeviceFields
+	}
+	return func(device tailscale.Device) map[string]any {
+		t.mu.RLock()
+		fields := maps.Clone(t.devices[device.NodeID])
+		t.mu.RUnlock()
+		if fields == nil {
+			fields = map[string]any{}
+		}
+		maps.Copy(fields, DeviceFields(device))
+		return fields
+	}
+}
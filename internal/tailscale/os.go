@@ -0,0 +1,32 @@
+package tsutils
+
+import (
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// HasOS returns true if the device runs one of the given operating systems (e.g. "linux",
+// "windows", "macOS"), compared case-insensitively. Every device matches an empty list.
+func HasOS(device tailscale.Device, oses ...string) bool {
+	return len(oses) == 0 || slices.ContainsFunc(oses, func(os string) bool { return strings.EqualFold(os, device.OS) })
+}
+
+// NewOSFilter creates a new filter matching only the devices running one of the given operating
+// systems. It matches all devices when no operating system is given.
+func NewOSFilter(oses ...string) TagFilter {
+	return FuncTagFilter(func(device tailscale.Device) bool { return HasOS(device, oses...) })
+}
+
+// IsTagged returns true if the device is owned by tags (i.e. a machine, typically a server),
+// rather than by the user who authenticated it.
+func IsTagged(device tailscale.Device) bool {
+	return len(device.Tags) > 0
+}
+
+// NewOwnershipFilter creates a new filter matching only the tagged devices, unless the devices
+// owned by a user are also allowed.
+func NewOwnershipFilter(allowUserOwned bool) TagFilter {
+	return FuncTagFilter(func(device tailscale.Device) bool { return allowUserOwned || IsTagged(device) })
+}
@@ -0,0 +1,33 @@
+package tsutils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestNewOSFilter_Match(t *testing.T) {
+	filter := tsutils.NewOSFilter("linux", "freebsd")
+
+	assert.True(t, filter.Match(tailscale.Device{OS: "linux"}))
+	assert.True(t, filter.Match(tailscale.Device{OS: "Linux"}))
+	assert.True(t, filter.Match(tailscale.Device{OS: "freebsd"}))
+	assert.False(t, filter.Match(tailscale.Device{OS: "windows"}))
+	assert.False(t, filter.Match(tailscale.Device{OS: "macOS"}))
+	assert.False(t, filter.Match(tailscale.Device{OS: ""}))
+
+	assert.True(t, tsutils.NewOSFilter().Match(tailscale.Device{OS: "windows"}))
+}
+
+func TestNewOwnershipFilter_Match(t *testing.T) {
+	tagged := tailscale.Device{Tags: []string{"tag:k8s"}}
+	owned := tailscale.Device{User: "alice@example.com"}
+
+	assert.True(t, tsutils.NewOwnershipFilter(false).Match(tagged))
+	assert.False(t, tsutils.NewOwnershipFilter(false).Match(owned))
+	assert.True(t, tsutils.NewOwnershipFilter(true).Match(tagged))
+	assert.True(t, tsutils.NewOwnershipFilter(true).Match(owned))
+}
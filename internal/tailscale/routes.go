@@ -0,0 +1,31 @@
+package tsutils
+
+import (
+	"net/netip"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// IsExitNode returns true if the device is an approved exit node (i.e. its 0.0.0.0/0 or ::/0
+// routes are enabled). Routes are only returned by the Tailscale API when listing the devices with
+// all their fields.
+func IsExitNode(device tailscale.Device) bool {
+	for _, route := range device.EnabledRoutes {
+		if prefix, err := netip.ParsePrefix(route); err == nil && prefix.Bits() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SubnetRoutes returns the subnet routes of the device enabled in the tailnet, exit node routes
+// excluded: the routes it advertises are not reachable until approved. Invalid routes are ignored.
+func SubnetRoutes(device tailscale.Device) []netip.Prefix {
+	var routes []netip.Prefix
+	for _, route := range device.EnabledRoutes {
+		if prefix, err := netip.ParsePrefix(route); err == nil && prefix.Bits() > 0 {
+			routes = append(routes, prefix.Masked())
+		}
+	}
+	return routes
+}
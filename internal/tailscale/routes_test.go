@@ -0,0 +1,29 @@
+package tsutils_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestRoutes(t *testing.T) {
+	router := tailscale.Device{EnabledRoutes: []string{"10.0.0.0/24", "10.1.0.10/32", "invalid"}}
+	assert.False(t, tsutils.IsExitNode(router))
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24"), netip.MustParsePrefix("10.1.0.10/32")}, tsutils.SubnetRoutes(router))
+
+	exitNode := tailscale.Device{EnabledRoutes: []string{"0.0.0.0/0", "::/0"}}
+	assert.True(t, tsutils.IsExitNode(exitNode))
+	assert.Empty(t, tsutils.SubnetRoutes(exitNode))
+
+	// Routes advertised but not approved are not reachable.
+	pending := tailscale.Device{AdvertisedRoutes: []string{"10.0.0.0/24", "0.0.0.0/0"}}
+	assert.False(t, tsutils.IsExitNode(pending))
+	assert.Empty(t, tsutils.SubnetRoutes(pending))
+
+	assert.False(t, tsutils.IsExitNode(tailscale.Device{}))
+	assert.Empty(t, tsutils.SubnetRoutes(tailscale.Device{}))
+}
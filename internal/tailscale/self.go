@@ -0,0 +1,40 @@
+package tsutils
+
+import (
+	"slices"
+	"sync"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// IsSelf returns true if the device is one of the given devices, identified by their node ID.
+func IsSelf(device tailscale.Device, nodeIDs ...string) bool {
+	return device.NodeID != "" && slices.Contains(nodeIDs, device.NodeID)
+}
+
+// SelfFilter is a filter excluding the devices of the cluster where Argotails runs (see IsSelf),
+// which must not be registered as remote clusters. The node IDs only known at runtime, e.g. the
+// one of the Argotails node joining the tailnet, are added once known.
+type SelfFilter struct {
+	mu      sync.RWMutex
+	nodeIDs []string
+}
+
+// NewSelfFilter creates a new filter excluding the devices with the given node IDs.
+func NewSelfFilter(nodeIDs ...string) *SelfFilter {
+	return &SelfFilter{nodeIDs: slices.Clone(nodeIDs)}
+}
+
+// Add excludes the device with the given node ID too.
+func (f *SelfFilter) Add(nodeID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodeIDs = append(f.nodeIDs, nodeID)
+}
+
+// Match returns false if the device is one of the devices of the cluster where Argotails runs.
+func (f *SelfFilter) Match(device tailscale.Device) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !IsSelf(device, f.nodeIDs...)
+}
@@ -0,0 +1,31 @@
+package tsutils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestNewSelfFilter_Match(t *testing.T) {
+	hub := tailscale.Device{ID: "123", NodeID: "nHub", Hostname: "hub", Name: "hub-1.example.ts.net"}
+	spoke := tailscale.Device{ID: "456", NodeID: "nSpoke", Hostname: "spoke", Name: "spoke.example.ts.net"}
+
+	filter := tsutils.NewSelfFilter("nHub")
+	assert.False(t, filter.Match(hub))
+	assert.True(t, filter.Match(spoke))
+
+	// The devices are only matched by node ID, never by hostname or name.
+	for _, identity := range []string{"123", "hub", "hub-1", "hub-1.example.ts.net"} {
+		assert.True(t, tsutils.NewSelfFilter(identity).Match(hub), identity)
+	}
+	assert.True(t, tsutils.NewSelfFilter().Match(hub))
+	assert.True(t, tsutils.NewSelfFilter("").Match(tailscale.Device{}))
+
+	// The node IDs known at runtime are excluded once added.
+	filter = tsutils.NewSelfFilter()
+	filter.Add("nSpoke")
+	assert.False(t, filter.Match(spoke))
+}
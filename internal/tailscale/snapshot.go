@@ -0,0 +1,231 @@
+package tsutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"tailscale.com/client/tailscale/v2"
+)
+
+type (
+	// DeviceSnapshot keeps the last known list of Tailscale devices, optionally persisted into a
+	// local file, in order to be used when the Tailscale API is unavailable (e.g. right after a
+	// restart during a Tailscale outage).
+	// A nil DeviceSnapshot is valid and always lists the devices from the Tailscale API.
+	DeviceSnapshot struct {
+		// path is the file where the snapshot is persisted (empty to keep it in memory only).
+		path string
+		// opts are the options used to list the devices (e.g. to include their routes).
+		opts []tailscale.ListDevicesOptions
+		// breaker stops calling the Tailscale API during outages (optional).
+		breaker *CircuitBreaker
+		// maxAge is the age after which the snapshot is no longer served (0 for no limit).
+		maxAge time.Duration
+
+		mu       sync.RWMutex
+		snapshot deviceSnapshot
+		// digest is the digest of the persisted devices, used to only rewrite the snapshot file
+		// when the devices change.
+		digest [sha256.Size]byte
+	}
+
+	// deviceSnapshot is the persisted form of a DeviceSnapshot.
+	deviceSnapshot struct {
+		Time    time.Time          `json:"time"`
+		Devices []tailscale.Device `json:"devices"`
+	}
+)
+
+// NewDeviceSnapshot creates a device snapshot persisted into the given file, loading the previous
+// snapshot if it exists. The devices are listed with the given options.
+func NewDeviceSnapshot(path string, opts ...tailscale.ListDevicesOptions) (*DeviceSnapshot, error) {
+	s := &DeviceSnapshot{path: path, opts: opts}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read device snapshot: %w", err)
+	}
+	if err := json.Unmarshal(raw, &s.snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode device snapshot %q: %w", path, err)
+	}
+	s.digest, err = digestDevices(s.snapshot.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode device snapshot %q: %w", path, err)
+	}
+	// NOTE: the snapshot file is only rewritten when the devices change; its modification time
+	//       is bumped instead, and tells when the devices were last listed.
+	if info, err := os.Stat(path); err == nil && info.ModTime().After(s.snapshot.Time) {
+		s.snapshot.Time = info.ModTime()
+	}
+	return s, nil
+}
+
+// SetCircuitBreaker guards the Tailscale API calls with the given circuit breaker; the devices
+// from the last snapshot are returned while it is open.
+func (s *DeviceSnapshot) SetCircuitBreaker(breaker *CircuitBreaker) { s.breaker = breaker }
+
+// SetMaxAge stops serving the snapshot once older than the given age (0 for no limit).
+func (s *DeviceSnapshot) SetMaxAge(maxAge time.Duration) { s.maxAge = maxAge }
+
+// List lists the Tailscale devices using the given API and updates the snapshot. If the
+// Tailscale API is temporarily unavailable (see IsTransientError), the devices from the last
+// snapshot are returned instead, as long as it is not older than its maximum age.
+func (s *DeviceSnapshot) List(ctx context.Context, api DeviceAPI) ([]tailscale.Device, error) {
+	if s == nil {
+		return api.List(ctx)
+	}
+
+	var devices []tailscale.Device
+	err := ErrBreakerOpen
+	if s.breaker == nil || s.breaker.Allow() {
+		devices, err = api.List(ctx, s.opts...)
+		if s.breaker != nil {
+			s.breaker.Record(err)
+		}
+	}
+
+	log := ctrllog.FromContext(ctx)
+	if err != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.snapshot.Time.IsZero() || !IsTransientError(err) {
+			return nil, err
+		}
+
+		snapshot := map[string]any{"time": s.snapshot.Time, "count": len(s.snapshot.Devices)}
+		if s.stale() {
+			log.Error(err, "Failed to list Tailscale devices, the last known devices are too old to be used", "snapshot", snapshot, "maxAge", s.maxAge)
+			return nil, err
+		}
+		if errors.Is(err, ErrBreakerOpen) {
+			log.V(1).Info("Tailscale API circuit breaker is open, using the last known devices instead", "snapshot", snapshot)
+		} else {
+			log.Error(err, "Failed to list Tailscale devices, using the last known devices instead", "snapshot", snapshot)
+		}
+		return s.snapshot.Devices, nil
+	}
+
+	if err := s.store(devices); err != nil {
+		log.Error(err, "Failed to persist Tailscale devices snapshot")
+	}
+	return devices, nil
+}
+
+// Time returns when the snapshot was taken (zero if no snapshot has been taken yet).
+func (s *DeviceSnapshot) Time() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot.Time
+}
+
+// Check is a health check failing while the snapshot is older than its maximum age, i.e. the
+// Tailscale devices could not be listed for too long.
+func (s *DeviceSnapshot) Check(*http.Request) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.snapshot.Time.IsZero() || !s.stale() {
+		return nil
+	}
+	return fmt.Errorf("tailscale devices last listed %s ago (max age %s)", time.Since(s.snapshot.Time).Round(time.Second), s.maxAge)
+}
+
+// stale returns true if the snapshot is older than its maximum age.
+func (s *DeviceSnapshot) stale() bool {
+	return s.maxAge > 0 && time.Since(s.snapshot.Time) > s.maxAge
+}
+
+// store updates the snapshot with the given devices and persists it, only rewriting the snapshot
+// file when the devices changed.
+func (s *DeviceSnapshot) store(devices []tailscale.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = deviceSnapshot{Time: time.Now(), Devices: devices}
+	if s.path == "" {
+		return nil
+	}
+
+	digest, err := digestDevices(devices)
+	if err != nil {
+		return err
+	}
+	if digest == s.digest {
+		if err := os.Chtimes(s.path, s.snapshot.Time, s.snapshot.Time); !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	raw, err := json.Marshal(s.snapshot)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the snapshot is written into a temporary file first to never leave a partially
+	//       written snapshot behind.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.digest = digest
+	return nil
+}
+
+// digestDevices returns the digest of the given devices.
+func digestDevices(devices []tailscale.Device) ([sha256.Size]byte, error) {
+	raw, err := json.Marshal(devices)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(raw), nil
+}
+
+// IsTransientError returns true if the error may be resolved by retrying the Tailscale API call
+// later (outage, network failure, throttling, ...), as opposed to the errors requiring an action
+// from the operator (revoked or insufficiently scoped OAuth client, invalid request, ...).
+func IsTransientError(err error) bool {
+	status := 0
+	var apiErr tailscale.APIError
+	var oauthErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &apiErr):
+		// NOTE: the status of the Tailscale API errors is not exported.
+		status = int(reflect.ValueOf(apiErr).FieldByName("status").Int())
+	case errors.As(err, &oauthErr) && oauthErr.Response != nil:
+		status = oauthErr.Response.StatusCode
+	}
+	return status < http.StatusBadRequest || status >= http.StatusInternalServerError ||
+		status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,172 @@
+package tsutils_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// newSnapshotAPI returns a device API listing a single device, or failing with the status pointed
+// to by failure when it is not zero.
+func newSnapshotAPI(t *testing.T, failure *int) tsutils.DeviceAPI {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if *failure != 0 {
+			w.WriteHeader(*failure)
+			_, _ = w.Write([]byte(`{"message":"failure"}`))
+			return
+		}
+		raw, _ := json.Marshal(map[string]any{"devices": []tailscale.Device{{Name: "A.fake.ts.net"}}})
+		_, _ = w.Write(raw)
+	}))
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return tsutils.NewDeviceAPI(&tailscale.Client{Tailnet: "fake.ts.net", HTTP: srv.Client(), BaseURL: srvURL})
+}
+
+func TestDeviceSnapshot(t *testing.T) {
+	failure := 0
+	ts := newSnapshotAPI(t, &failure)
+	path := filepath.Join(t.TempDir(), "devices.json")
+
+	// Without snapshot, API errors are returned.
+	failure = http.StatusServiceUnavailable
+	snapshot, err := tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	_, err = snapshot.List(context.TODO(), ts)
+	require.Error(t, err)
+
+	// Successful listings are persisted...
+	failure = 0
+	devices, err := snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.False(t, snapshot.Time().IsZero())
+
+	// ... and used after a restart when the API is unavailable.
+	failure = http.StatusServiceUnavailable
+	snapshot, err = tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	devices, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "A.fake.ts.net", devices[0].Name)
+
+	// ... but not when the OAuth client is rejected.
+	failure = http.StatusUnauthorized
+	_, err = snapshot.List(context.TODO(), ts)
+	require.Error(t, err)
+
+	// A nil snapshot only uses the API.
+	_, err = (*tsutils.DeviceSnapshot)(nil).List(context.TODO(), ts)
+	require.Error(t, err)
+}
+
+func TestDeviceSnapshot_MaxAge(t *testing.T) {
+	failure := 0
+	ts := newSnapshotAPI(t, &failure)
+	path := filepath.Join(t.TempDir(), "devices.json")
+
+	snapshot, err := tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	snapshot.SetMaxAge(time.Hour)
+	_, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Check(nil))
+
+	// Once older than its maximum age, the snapshot is no longer served and fails the health check.
+	old := time.Now().Add(-2 * time.Hour)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var persisted map[string]any
+	require.NoError(t, json.Unmarshal(raw, &persisted))
+	persisted["time"] = old
+	raw, err = json.Marshal(persisted)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, raw, 0o600))
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	failure = http.StatusServiceUnavailable
+	snapshot, err = tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	snapshot.SetMaxAge(time.Hour)
+	_, err = snapshot.List(context.TODO(), ts)
+	require.Error(t, err)
+	assert.ErrorContains(t, snapshot.Check(nil), "max age 1h0m0s")
+
+	// A successful listing refreshes it.
+	failure = 0
+	_, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Check(nil))
+}
+
+func TestDeviceSnapshot_UnchangedDevices(t *testing.T) {
+	failure := 0
+	ts := newSnapshotAPI(t, &failure)
+	path := filepath.Join(t.TempDir(), "devices.json")
+
+	snapshot, err := tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	_, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	// The snapshot file is not rewritten when the devices did not change, only touched.
+	_, err = snapshot.List(context.TODO(), ts)
+	require.NoError(t, err)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, written, raw)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+
+	// The modification time tells when the devices were last listed.
+	snapshot, err = tsutils.NewDeviceSnapshot(path)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), snapshot.Time(), time.Minute)
+}
+
+func TestIsTransientError(t *testing.T) {
+	failure := 0
+	ts := newSnapshotAPI(t, &failure)
+	for status, transient := range map[int]bool{
+		http.StatusUnauthorized:        false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusServiceUnavailable:  true,
+	} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			failure = status
+			_, err := ts.List(context.TODO())
+			require.Error(t, err)
+			assert.Equal(t, transient, tsutils.IsTransientError(fmt.Errorf("wrapped: %w", err)))
+		})
+	}
+
+	assert.False(t, tsutils.IsTransientError(&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}))
+	assert.True(t, tsutils.IsTransientError(&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadGateway}}))
+	assert.True(t, tsutils.IsTransientError(tsutils.ErrBreakerOpen))
+	assert.True(t, tsutils.IsTransientError(errors.New("connection refused")))
+}
@@ -13,9 +13,19 @@ type (
 		Match(device tailscale.Device) bool
 	}
 
+	// FallibleTagFilter is a tag filter that may be unable to decide whether a device matches
+	// (e.g. a policy that cannot be evaluated against it); such devices must be neither
+	// registered nor deleted.
+	FallibleTagFilter interface {
+		TagFilter
+		Evaluate(device tailscale.Device) (bool, error)
+	}
+
 	rxTagFilter regexp.Regexp
 
 	FuncTagFilter func(device tailscale.Device) bool
+
+	allTagFilter []TagFilter
 )
 
 // NewRegexpTagFilter creates a new tag filter based on the provided regular expressions.
@@ -39,6 +49,25 @@ func NewRegexpTagFilter(patterns ...string) (TagFilter, error) {
 	return (*rxTagFilter)(rx), nil
 }
 
+// LiteralTags returns the tags matched by the given tag filter patterns when none of them is a
+// regular expression, so that the devices can be filtered by the Tailscale API itself. It returns
+// false if a pattern can only be evaluated client-side (or if there is no pattern at all).
+func LiteralTags(patterns ...string) ([]string, bool) {
+	if len(patterns) == 0 {
+		return nil, false
+	}
+
+	tags := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		tag := strings.Trim(pattern, "^$")
+		if tag == "" || regexp.QuoteMeta(tag) != tag {
+			return nil, false
+		}
+		tags = append(tags, "tag:"+tag)
+	}
+	return tags, true
+}
+
 // Match returns true if the device matches the tag filter.
 func (rx *rxTagFilter) Match(device tailscale.Device) bool {
 	tags := strings.Join(device.Tags, ",")
@@ -52,3 +81,42 @@ func (f FuncTagFilter) Match(device tailscale.Device) bool {
 	}
 	return f(device)
 }
+
+// AllTagFilters creates a new tag filter matching devices matched by all the given filters.
+func AllTagFilters(filters ...TagFilter) TagFilter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return allTagFilter(filters)
+}
+
+// Match returns true if the device matches all the tag filters.
+func (filters allTagFilter) Match(device tailscale.Device) bool {
+	for _, filter := range filters {
+		if !filter.Match(device) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate returns true if the device matches all the tag filters, or an error if one of them
+// cannot decide before another one rejects the device.
+func (filters allTagFilter) Evaluate(device tailscale.Device) (bool, error) {
+	for _, filter := range filters {
+		matched, err := EvaluateFilter(filter, device)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// EvaluateFilter returns true if the device matches the tag filter, or an error if the filter is a
+// FallibleTagFilter unable to decide.
+func EvaluateFilter(filter TagFilter, device tailscale.Device) (bool, error) {
+	if fallible, ok := filter.(FallibleTagFilter); ok {
+		return fallible.Evaluate(device)
+	}
+	return filter.Match(device), nil
+}
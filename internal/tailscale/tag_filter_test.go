@@ -140,3 +140,35 @@ func TestFuncTagFilter_Match(t *testing.T) {
 		})
 	}
 }
+
+func TestAllTagFilters_Match(t *testing.T) {
+	yes := tsutils.FuncTagFilter(func(tailscale.Device) bool { return true })
+	no := tsutils.FuncTagFilter(func(tailscale.Device) bool { return false })
+
+	assert.True(t, tsutils.AllTagFilters(yes).Match(tailscale.Device{}))
+	assert.True(t, tsutils.AllTagFilters(yes, yes).Match(tailscale.Device{}))
+	assert.False(t, tsutils.AllTagFilters(yes, no).Match(tailscale.Device{}))
+	assert.False(t, tsutils.AllTagFilters(no, yes).Match(tailscale.Device{}))
+}
+
+func TestLiteralTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		tags     []string
+		literal  bool
+	}{
+		{name: "NoPattern", patterns: nil, literal: false},
+		{name: "PlainTags", patterns: []string{"prod", "^edge-cluster$"}, tags: []string{"tag:prod", "tag:edge-cluster"}, literal: true},
+		{name: "RegularExpression", patterns: []string{"prod", "edge-.*"}, literal: false},
+		{name: "Alternation", patterns: []string{"prod|staging"}, literal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, literal := tsutils.LiteralTags(tt.patterns...)
+			assert.Equal(t, tt.literal, literal)
+			assert.Equal(t, tt.tags, tags)
+		})
+	}
+}
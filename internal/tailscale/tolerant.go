@@ -0,0 +1,126 @@
+package tsutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// rxDeviceListingPath matches the Tailscale API path of the device listing.
+var rxDeviceListingPath = regexp.MustCompile(`/api/v2/tailnet/[^/]+/devices$`)
+
+var skippedDevices = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "argotails_tailscale_skipped_devices_total",
+	Help: "Number of Tailscale devices skipped from the device listings because they could not be decoded.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(skippedDevices)
+}
+
+// tolerantTransport removes from the device listings the devices that cannot be decoded (e.g. a
+// field whose type changed in the Tailscale API), so that a single malformed device does not fail
+// the whole listing. The skipped devices are logged, with their raw payload at high verbosity.
+type tolerantTransport struct {
+	base http.RoundTripper
+
+	mu sync.RWMutex
+	// malformed are the node IDs of the devices skipped from the last device listing.
+	malformed map[string]struct{}
+}
+
+func (t *tolerantTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || res.StatusCode != http.StatusOK || !rxDeviceListingPath.MatchString(req.URL.Path) {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	tolerant, skipped := skipMalformedDevices(body)
+	ids := make(map[string]struct{}, len(skipped))
+	for _, raw := range skipped {
+		var device struct {
+			NodeID string `json:"nodeId"`
+		}
+		if json.Unmarshal(raw, &device) == nil && device.NodeID != "" {
+			ids[device.NodeID] = struct{}{}
+		}
+	}
+	t.mu.Lock()
+	t.malformed = ids
+	t.mu.Unlock()
+
+	if len(skipped) > 0 {
+		log := ctrllog.FromContext(req.Context())
+		for _, device := range skipped {
+			log.V(4).Info("Malformed Tailscale device skipped", "payload", string(device))
+		}
+		log.V(0).Info("Skipped the Tailscale devices that cannot be decoded", "devices", map[string]any{"skipped": len(skipped)})
+		skippedDevices.Add(float64(len(skipped)))
+
+		body = tolerant
+		res.ContentLength = int64(len(body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+func (t *tolerantTransport) unwrap() http.RoundTripper { return t.base }
+
+// isMalformed returns true if the device with the given node ID has been skipped from the last
+// device listing.
+func (t *tolerantTransport) isMalformed(nodeID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, exists := t.malformed[nodeID]
+	return exists
+}
+
+// skipMalformedDevices returns the given device listing without the devices that cannot be
+// decoded, and the raw payloads of these devices. Invalid listings are returned unchanged: they
+// are reported by the Tailscale client itself.
+func skipMalformedDevices(body []byte) ([]byte, []json.RawMessage) {
+	var listing map[string]json.RawMessage
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return body, nil
+	}
+	var devices []json.RawMessage
+	if err := json.Unmarshal(listing["devices"], &devices); err != nil {
+		return body, nil
+	}
+
+	valid := make([]json.RawMessage, 0, len(devices))
+	var skipped []json.RawMessage
+	for _, raw := range devices {
+		var device tailscale.Device
+		if err := json.Unmarshal(raw, &device); err != nil {
+			skipped = append(skipped, raw)
+			continue
+		}
+		valid = append(valid, raw)
+	}
+	if len(skipped) == 0 {
+		return body, nil
+	}
+
+	listing["devices"], _ = json.Marshal(valid)
+	tolerant, err := json.Marshal(listing)
+	if err != nil {
+		return body, nil
+	}
+	return tolerant, skipped
+}
@@ -0,0 +1,61 @@
+package tsutils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+// rxClientVersion extracts the semantic version from a Tailscale client version
+// (e.g. "1.58.2-t0e2e7b1a4-g25d6d8ce3").
+var rxClientVersion = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ClientVersion is the semantic version of a Tailscale client.
+type ClientVersion [3]int
+
+// ParseClientVersion parses the semantic version of a Tailscale client version.
+func ParseClientVersion(raw string) (ClientVersion, error) {
+	matches := rxClientVersion.FindStringSubmatch(raw)
+	if matches == nil {
+		return ClientVersion{}, fmt.Errorf("invalid client version %q", raw)
+	}
+
+	var version ClientVersion
+	for i, part := range matches[1:] {
+		if part == "" {
+			continue
+		}
+		version[i], _ = strconv.Atoi(part)
+	}
+	return version, nil
+}
+
+// Less returns true if the version is older than the other one.
+func (v ClientVersion) Less(other ClientVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// String returns the version formatted as "MAJOR.MINOR.PATCH".
+func (v ClientVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// IsOutdated returns true if the device runs a Tailscale client older than the given version.
+// Devices with an unparsable client version are considered outdated.
+func IsOutdated(device tailscale.Device, minVersion ClientVersion) bool {
+	version, err := ParseClientVersion(device.ClientVersion)
+	return err != nil || version.Less(minVersion)
+}
+
+// NewMinClientVersionFilter creates a new filter matching only the devices running a Tailscale client
+// at least as recent as the given version.
+func NewMinClientVersionFilter(minVersion ClientVersion) TagFilter {
+	return FuncTagFilter(func(device tailscale.Device) bool { return !IsOutdated(device, minVersion) })
+}
@@ -0,0 +1,44 @@
+package tsutils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+func TestParseClientVersion(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected tsutils.ClientVersion
+	}{
+		{raw: "1.58.2-t0e2e7b1a4-g25d6d8ce3", expected: tsutils.ClientVersion{1, 58, 2}},
+		{raw: "v1.2.3", expected: tsutils.ClientVersion{1, 2, 3}},
+		{raw: "1.80", expected: tsutils.ClientVersion{1, 80, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			version, err := tsutils.ParseClientVersion(tt.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, version)
+		})
+	}
+
+	_, err := tsutils.ParseClientVersion("unknown")
+	assert.Error(t, err)
+}
+
+func TestNewMinClientVersionFilter_Match(t *testing.T) {
+	filter := tsutils.NewMinClientVersionFilter(tsutils.ClientVersion{1, 58, 0})
+
+	assert.True(t, filter.Match(tailscale.Device{ClientVersion: "1.58.0"}))
+	assert.True(t, filter.Match(tailscale.Device{ClientVersion: "1.60.1-t0e2e7b1a4"}))
+	assert.True(t, filter.Match(tailscale.Device{ClientVersion: "2.0.0"}))
+	assert.False(t, filter.Match(tailscale.Device{ClientVersion: "1.56.9"}))
+	assert.False(t, filter.Match(tailscale.Device{ClientVersion: "0.99.0"}))
+	assert.False(t, filter.Match(tailscale.Device{ClientVersion: ""}))
+}
@@ -40,14 +40,41 @@ type (
 
 var ErrWebhookNotSigned = fmt.Errorf("webhook has no signature")
 var ErrWebhookInvalidSignature = fmt.Errorf("webhook has invalid signature")
-var ErrWebhookSignatureExpired = fmt.Errorf("webhook signature as expired: timestamp older than 5 minutes")
+var ErrWebhookSignatureExpired = fmt.Errorf("webhook signature has expired: timestamp outside of the allowed clock skew")
 var ErrWebhookSignatureMismatch = fmt.Errorf("webhook signature does not match")
 
+// DefaultWebhookClockSkew is the default maximum difference allowed between the webhook signature
+// timestamp and the local clock.
+const DefaultWebhookClockSkew = 5 * time.Minute
+
+// signatureLength is the length of a hex-encoded HMAC-SHA256 signature.
+const signatureLength = sha256.Size * 2
+
+// WebhookSignatureHeader is the header carrying the signature of the Tailscale webhook events.
+const WebhookSignatureHeader = "Tailscale-Webhook-Signature"
+
+// SignWebhook returns the WebhookSignatureHeader value signing the given events payload with the
+// given secret at the given time, as sent by Tailscale (e.g. to replay stored events).
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), webhookSignature(secret, timestamp, body))
+}
+
+// webhookSignature returns the hex-encoded HMAC-SHA256 of the given payload signed at the given
+// time.
+func webhookSignature(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprint(timestamp.Unix())))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // VerifyWebhookSignature checks the request's "Tailscale-Webhook-Signature"
-// header to verify that the events were signed by your webhook secret.
+// header to verify that the events were signed by your webhook secret, less
+// than clockSkew ago (or ahead).
 // If verification fails, an error is reported.
 // If verification succeeds, the events are unmarshaled into the object.
-func VerifyWebhookSignature[T any](ctx context.Context, req *http.Request, secret string, object *T) error {
+func VerifyWebhookSignature[T any](ctx context.Context, req *http.Request, secret string, clockSkew time.Duration, object *T) error {
 	log := log.FromContext(ctx)
 
 	defer func(Body io.ReadCloser) {
@@ -58,14 +85,13 @@ func VerifyWebhookSignature[T any](ctx context.Context, req *http.Request, secre
 	}(req.Body)
 
 	// Grab the signature sent on the request header.
-	timestamp, signatures, err := parseSignatureHeader(req.Header.Get("Tailscale-Webhook-Signature"))
+	timestamp, signatures, err := parseSignatureHeader(req.Header.Get(WebhookSignatureHeader))
 	if err != nil {
 		return err
 	}
 
-	// Verify that the timestamp is recent.
-	// Here, we use a threshold of 5 minutes.
-	if timestamp.Before(time.Now().Add(-time.Minute * 5)) {
+	// Verify that the timestamp is recent (and not too far in the future).
+	if age := time.Since(timestamp); age > clockSkew || age < -clockSkew {
 		return ErrWebhookSignatureExpired
 	}
 
@@ -74,11 +100,7 @@ func VerifyWebhookSignature[T any](ctx context.Context, req *http.Request, secre
 	if err != nil {
 		return err
 	}
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(fmt.Sprint(timestamp.Unix())))
-	mac.Write([]byte("."))
-	mac.Write(b)
-	want := hex.EncodeToString(mac.Sum(nil))
+	want := webhookSignature(secret, timestamp, b)
 
 	// Verify that the signatures match.
 	var match bool
@@ -100,28 +122,39 @@ func VerifyWebhookSignature[T any](ctx context.Context, req *http.Request, secre
 // parseSignatureHeader splits header into its timestamp and included signatures.
 // The signatures are reported as a map of version (e.g. "v1") to a list of signatures
 // found with that version.
+// The header must contain exactly one timestamp and at least one "v1" signature; every
+// "v1" signature must be a hex-encoded HMAC-SHA256.
 func parseSignatureHeader(header string) (timestamp time.Time, signatures map[string][]string, err error) {
 	if header == "" {
 		return time.Time{}, nil, ErrWebhookNotSigned
 	}
 
+	var hasTimestamp bool
 	signatures = make(map[string][]string)
-	pairs := strings.Split(header, ",")
-	for _, pair := range pairs {
-		parts := strings.Split(pair, "=")
-		if len(parts) != 2 {
-			return time.Time{}, nil, ErrWebhookNotSigned
+	for _, pair := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return time.Time{}, nil, ErrWebhookInvalidSignature
 		}
 
-		switch parts[0] {
+		switch key {
 		case "t":
-			tsint, err := strconv.ParseInt(parts[1], 10, 64)
-			if err != nil {
+			if hasTimestamp {
+				return time.Time{}, nil, ErrWebhookInvalidSignature
+			}
+			tsint, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || tsint <= 0 {
 				return time.Time{}, nil, ErrWebhookInvalidSignature
 			}
-			timestamp = time.Unix(tsint, 0)
+			timestamp, hasTimestamp = time.Unix(tsint, 0), true
 		case "v1":
-			signatures[parts[0]] = append(signatures[parts[0]], parts[1])
+			if len(value) != signatureLength {
+				return time.Time{}, nil, ErrWebhookInvalidSignature
+			}
+			if _, err := hex.DecodeString(value); err != nil {
+				return time.Time{}, nil, ErrWebhookInvalidSignature
+			}
+			signatures[key] = append(signatures[key], value)
 		default:
 			// Ignore unknown parts of the header.
 			continue
@@ -131,5 +164,8 @@ func parseSignatureHeader(header string) (timestamp time.Time, signatures map[st
 	if len(signatures) == 0 {
 		return time.Time{}, nil, ErrWebhookNotSigned
 	}
-	return
+	if !hasTimestamp {
+		return time.Time{}, nil, ErrWebhookInvalidSignature
+	}
+	return timestamp, signatures, nil
 }
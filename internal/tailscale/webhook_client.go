@@ -0,0 +1,163 @@
+package tsutils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// WebhookSubscriptions are the Tailscale webhook events handled by Argotails.
+var WebhookSubscriptions = []tailscale.WebhookSubscriptionType{tailscale.WebhookNodeCreated, tailscale.WebhookNodeDeleted}
+
+const (
+	// WebhookHealthy is the health of a webhook endpoint registered with the expected URL and
+	// subscribed to the events handled by Argotails.
+	WebhookHealthy WebhookHealth = "healthy"
+	// WebhookMissing is the health of a webhook endpoint not registered in the tailnet.
+	WebhookMissing WebhookHealth = "missing"
+	// WebhookWrongURL is the health of a webhook endpoint registered with another URL.
+	WebhookWrongURL WebhookHealth = "wrong_url"
+	// WebhookUnsubscribed is the health of a webhook endpoint not subscribed to every event
+	// handled by Argotails.
+	WebhookUnsubscribed WebhookHealth = "unsubscribed"
+	// WebhookUnknown is the health of a webhook endpoint that could not be checked (e.g. the
+	// Tailscale API is unavailable).
+	WebhookUnknown WebhookHealth = "unknown"
+)
+
+// WebhookHealths are all the possible healths of a webhook endpoint.
+var WebhookHealths = []WebhookHealth{WebhookHealthy, WebhookMissing, WebhookWrongURL, WebhookUnsubscribed, WebhookUnknown}
+
+var webhookHealth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "argotails_tailscale_webhook_health",
+	Help: "Health of the Tailscale webhook endpoint delivering the device events (1 for the current health): healthy, missing, wrong_url, unsubscribed or unknown.",
+}, []string{"health"})
+
+func init() {
+	metrics.Registry.MustRegister(webhookHealth)
+}
+
+// WebhookHealth is the health of the webhook endpoint delivering the device events to Argotails.
+type WebhookHealth string
+
+// WebhookClient manages the Tailscale webhook endpoint delivering the device events to Argotails.
+type WebhookClient struct {
+	webhooks    *tailscale.WebhooksResource
+	endpointURL string
+}
+
+// NewWebhookClient returns a client managing the webhook endpoint with the given URL.
+func NewWebhookClient(ts *tailscale.Client, endpointURL string) *WebhookClient {
+	return &WebhookClient{webhooks: ts.Webhooks(), endpointURL: endpointURL}
+}
+
+// Find returns the webhook endpoint registered with the URL of the client, or nil if there is none.
+func (c *WebhookClient) Find(ctx context.Context) (*tailscale.Webhook, error) {
+	webhooks, err := c.webhooks.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Tailscale webhooks: %w", err)
+	}
+	for _, webhook := range webhooks {
+		if webhook.EndpointURL == c.endpointURL {
+			return &webhook, nil
+		}
+	}
+	return nil, nil
+}
+
+// Provision registers the webhook endpoint if it does not exist yet and subscribes it to the
+// events handled by Argotails, keeping its other subscriptions. The secret of the endpoint is only
+// set on the returned webhook when the endpoint is created or, if rotate is true, when its secret
+// is rotated.
+func (c *WebhookClient) Provision(ctx context.Context, rotate bool) (*tailscale.Webhook, error) {
+	webhook, err := c.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		webhook, err = c.webhooks.Create(ctx, tailscale.CreateWebhookRequest{
+			EndpointURL:   c.endpointURL,
+			ProviderType:  tailscale.WebhookEmptyProviderType,
+			Subscriptions: WebhookSubscriptions,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Tailscale webhook: %w", err)
+		}
+		return webhook, nil
+	}
+
+	subscriptions := webhook.Subscriptions
+	for _, subscription := range WebhookSubscriptions {
+		if !slices.Contains(subscriptions, subscription) && !slices.Contains(subscriptions, tailscale.WebhookCategoryTailnetManagement) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if len(subscriptions) != len(webhook.Subscriptions) {
+		webhook, err = c.webhooks.Update(ctx, webhook.EndpointID, subscriptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update Tailscale webhook subscriptions: %w", err)
+		}
+	}
+
+	if rotate {
+		return c.Rotate(ctx, webhook.EndpointID)
+	}
+	webhook.Secret = nil
+	return webhook, nil
+}
+
+// Rotate generates a new secret for the webhook endpoint with the given ID, set on the returned
+// webhook.
+func (c *WebhookClient) Rotate(ctx context.Context, endpointID string) (*tailscale.Webhook, error) {
+	webhook, err := c.webhooks.RotateSecret(ctx, endpointID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate Tailscale webhook secret: %w", err)
+	}
+	return webhook, nil
+}
+
+// Check returns the health of the webhook endpoint with the given ID (or, if unknown, with the URL
+// of the client), also exported as the argotails_tailscale_webhook_health metric. The health is
+// WebhookUnknown when the webhook endpoints cannot be listed.
+func (c *WebhookClient) Check(ctx context.Context, endpointID string) (WebhookHealth, error) {
+	webhooks, err := c.webhooks.List(ctx)
+	if err != nil {
+		setWebhookHealth(WebhookUnknown)
+		return WebhookUnknown, fmt.Errorf("failed to list Tailscale webhooks: %w", err)
+	}
+
+	health := WebhookMissing
+webhooks:
+	for _, webhook := range webhooks {
+		switch {
+		case endpointID != "" && webhook.EndpointID != endpointID:
+			continue
+		case webhook.EndpointURL != c.endpointURL && endpointID == "":
+			continue
+		case webhook.EndpointURL != c.endpointURL:
+			health = WebhookWrongURL
+		case slices.Contains(webhook.Subscriptions, tailscale.WebhookCategoryTailnetManagement):
+			health = WebhookHealthy
+		case slices.ContainsFunc(WebhookSubscriptions, func(s tailscale.WebhookSubscriptionType) bool { return !slices.Contains(webhook.Subscriptions, s) }):
+			health = WebhookUnsubscribed
+		default:
+			health = WebhookHealthy
+		}
+		break webhooks
+	}
+
+	setWebhookHealth(health)
+	return health, nil
+}
+
+// setWebhookHealth exports the given health as the argotails_tailscale_webhook_health metric.
+func setWebhookHealth(health WebhookHealth) {
+	for _, h := range WebhookHealths {
+		webhookHealth.WithLabelValues(string(h)).Set(0)
+	}
+	webhookHealth.WithLabelValues(string(health)).Set(1)
+}
@@ -0,0 +1,165 @@
+package tsutils_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// webhooksAPI fakes the webhooks endpoints of the Tailscale API.
+func webhooksAPI(t *testing.T, webhooks map[string]*tailscale.Webhook) *tailscale.Client {
+	t.Helper()
+
+	secret := func(value string) *string { return &value }
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/tailnet/fake.ts.net/webhooks", func(w http.ResponseWriter, _ *http.Request) {
+		list := []tailscale.Webhook{}
+		for _, webhook := range webhooks {
+			list = append(list, *webhook)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"webhooks": list})
+	})
+	mux.HandleFunc("POST /api/v2/tailnet/fake.ts.net/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		var req tailscale.CreateWebhookRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		webhooks["new"] = &tailscale.Webhook{EndpointID: "new", EndpointURL: req.EndpointURL, Subscriptions: req.Subscriptions}
+		_ = json.NewEncoder(w).Encode(tailscale.Webhook{EndpointID: "new", EndpointURL: req.EndpointURL, Subscriptions: req.Subscriptions, Secret: secret("created")})
+	})
+	mux.HandleFunc("PATCH /api/v2/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Subscriptions []tailscale.WebhookSubscriptionType `json:"subscriptions"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		webhooks[r.PathValue("id")].Subscriptions = req.Subscriptions
+		_ = json.NewEncoder(w).Encode(webhooks[r.PathValue("id")])
+	})
+	mux.HandleFunc("POST /api/v2/webhooks/{id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		webhook := *webhooks[r.PathValue("id")]
+		webhook.Secret = secret("rotated")
+		_ = json.NewEncoder(w).Encode(webhook)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return &tailscale.Client{Tailnet: "fake.ts.net", HTTP: srv.Client(), BaseURL: srvURL}
+}
+
+func TestWebhookClient_Provision_Create(t *testing.T) {
+	webhooks := map[string]*tailscale.Webhook{
+		"other": {EndpointID: "other", EndpointURL: "https://example.com/other"},
+	}
+	c := tsutils.NewWebhookClient(webhooksAPI(t, webhooks), "https://argotails.example.com/webhook")
+
+	webhook, err := c.Provision(context.TODO(), false)
+	require.NoError(t, err)
+	assert.Equal(t, "new", webhook.EndpointID)
+	require.NotNil(t, webhook.Secret)
+	assert.Equal(t, "created", *webhook.Secret)
+	assert.Equal(t, tsutils.WebhookSubscriptions, webhooks["new"].Subscriptions)
+}
+
+func TestWebhookClient_Provision_Existing(t *testing.T) {
+	webhooks := map[string]*tailscale.Webhook{
+		"argotails": {EndpointID: "argotails", EndpointURL: "https://argotails.example.com/webhook", Subscriptions: []tailscale.WebhookSubscriptionType{tailscale.WebhookPolicyUpdate, tailscale.WebhookNodeCreated}},
+	}
+	c := tsutils.NewWebhookClient(webhooksAPI(t, webhooks), "https://argotails.example.com/webhook")
+
+	// The missing subscriptions are added, the others kept; the secret is unknown.
+	webhook, err := c.Provision(context.TODO(), false)
+	require.NoError(t, err)
+	assert.Equal(t, "argotails", webhook.EndpointID)
+	assert.Nil(t, webhook.Secret)
+	assert.Equal(t, []tailscale.WebhookSubscriptionType{tailscale.WebhookPolicyUpdate, tailscale.WebhookNodeCreated, tailscale.WebhookNodeDeleted}, webhooks["argotails"].Subscriptions)
+	assert.NotContains(t, webhooks, "new")
+
+	// Rotating the secret returns the new one.
+	webhook, err = c.Provision(context.TODO(), true)
+	require.NoError(t, err)
+	require.NotNil(t, webhook.Secret)
+	assert.Equal(t, "rotated", *webhook.Secret)
+}
+
+func TestWebhookClient_Check(t *testing.T) {
+	all := []tailscale.WebhookSubscriptionType{tailscale.WebhookNodeCreated, tailscale.WebhookNodeDeleted}
+	tcs := map[string]struct {
+		webhooks   map[string]*tailscale.Webhook
+		endpointID string
+		expected   tsutils.WebhookHealth
+	}{
+		"healthy": {
+			webhooks: map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://argotails.example.com/webhook", Subscriptions: all}},
+			expected: tsutils.WebhookHealthy,
+		},
+		"healthy with category": {
+			webhooks: map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://argotails.example.com/webhook", Subscriptions: []tailscale.WebhookSubscriptionType{tailscale.WebhookCategoryTailnetManagement}}},
+			expected: tsutils.WebhookHealthy,
+		},
+		"missing": {
+			webhooks: map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://example.com/other", Subscriptions: all}},
+			expected: tsutils.WebhookMissing,
+		},
+		"missing by ID": {
+			webhooks:   map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://argotails.example.com/webhook", Subscriptions: all}},
+			endpointID: "b",
+			expected:   tsutils.WebhookMissing,
+		},
+		"wrong URL": {
+			webhooks:   map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://old.example.com/webhook", Subscriptions: all}},
+			endpointID: "a",
+			expected:   tsutils.WebhookWrongURL,
+		},
+		"unsubscribed": {
+			webhooks: map[string]*tailscale.Webhook{"a": {EndpointID: "a", EndpointURL: "https://argotails.example.com/webhook", Subscriptions: []tailscale.WebhookSubscriptionType{tailscale.WebhookNodeCreated}}},
+			expected: tsutils.WebhookUnsubscribed,
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			c := tsutils.NewWebhookClient(webhooksAPI(t, tc.webhooks), "https://argotails.example.com/webhook")
+			health, err := c.Check(context.TODO(), tc.endpointID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, health)
+		})
+	}
+}
+
+func TestWebhookClient_Check_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	ts := &tailscale.Client{Tailnet: "fake.ts.net", HTTP: srv.Client(), BaseURL: srvURL}
+
+	// A failed check is exported as such, rather than keeping the last known health.
+	health, err := tsutils.NewWebhookClient(ts, "https://argotails.example.com/webhook").Check(context.TODO(), "")
+	require.ErrorContains(t, err, "failed to list Tailscale webhooks")
+	assert.Equal(t, tsutils.WebhookUnknown, health)
+
+	expected := `
+# HELP argotails_tailscale_webhook_health Health of the Tailscale webhook endpoint delivering the device events (1 for the current health): healthy, missing, wrong_url, unsubscribed or unknown.
+# TYPE argotails_tailscale_webhook_health gauge
+argotails_tailscale_webhook_health{health="healthy"} 0
+argotails_tailscale_webhook_health{health="missing"} 0
+argotails_tailscale_webhook_health{health="unknown"} 1
+argotails_tailscale_webhook_health{health="unsubscribed"} 0
+argotails_tailscale_webhook_health{health="wrong_url"} 0
+`
+	require.NoError(t, testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "argotails_tailscale_webhook_health"))
+}
@@ -0,0 +1,170 @@
+/* trunk-ignore(golangci-lint/testpackage): Need to access to the signature header parser */
+package tsutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSignature = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseSignatureHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		err    error
+	}{
+		{name: "valid", header: "t=1700000000,v1=" + testSignature},
+		{name: "multiple signatures", header: "t=1700000000,v1=" + testSignature + ",v1=" + testSignature},
+		{name: "unknown parts ignored", header: "t=1700000000,v0=foo,v1=" + testSignature},
+		{name: "empty", header: "", err: ErrWebhookNotSigned},
+		{name: "no signature", header: "t=1700000000", err: ErrWebhookNotSigned},
+		{name: "no timestamp", header: "v1=" + testSignature, err: ErrWebhookInvalidSignature},
+		{name: "duplicate timestamp", header: "t=1700000000,t=1700000001,v1=" + testSignature, err: ErrWebhookInvalidSignature},
+		{name: "invalid timestamp", header: "t=now,v1=" + testSignature, err: ErrWebhookInvalidSignature},
+		{name: "negative timestamp", header: "t=-1,v1=" + testSignature, err: ErrWebhookInvalidSignature},
+		{name: "empty signature", header: "t=1700000000,v1=", err: ErrWebhookInvalidSignature},
+		{name: "short signature", header: "t=1700000000,v1=abcdef", err: ErrWebhookInvalidSignature},
+		{name: "non-hex signature", header: "t=1700000000,v1=" + strings.Repeat("z", 64), err: ErrWebhookInvalidSignature},
+		{name: "malformed pair", header: "t=1700000000,v1", err: ErrWebhookInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp, signatures, err := parseSignatureHeader(tt.header)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, time.Unix(1700000000, 0), timestamp)
+			assert.NotEmpty(t, signatures["v1"])
+		})
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`[{"type":"nodeCreated"}]`)
+	sign := func(timestamp time.Time, secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = fmt.Fprintf(mac, "%d.%s", timestamp.Unix(), body)
+		return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), hex.EncodeToString(mac.Sum(nil)))
+	}
+	request := func(header string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Tailscale-Webhook-Signature", header)
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		skew   time.Duration
+		err    error
+	}{
+		{name: "valid", header: sign(time.Now(), "secret"), skew: DefaultWebhookClockSkew},
+		{name: "wrong secret", header: sign(time.Now(), "other"), skew: DefaultWebhookClockSkew, err: ErrWebhookSignatureMismatch},
+		{name: "too old", header: sign(time.Now().Add(-10*time.Minute), "secret"), skew: DefaultWebhookClockSkew, err: ErrWebhookSignatureExpired},
+		{name: "too far in the future", header: sign(time.Now().Add(10*time.Minute), "secret"), skew: DefaultWebhookClockSkew, err: ErrWebhookSignatureExpired},
+		{name: "within custom clock skew", header: sign(time.Now().Add(-10*time.Minute), "secret"), skew: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []WebhookEvent
+			err := VerifyWebhookSignature(context.TODO(), request(tt.header), "secret", tt.skew, &events)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []WebhookEvent{{Type: "nodeCreated"}}, events)
+		})
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`[{"type":"nodeCreated"}]`)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook("secret", time.Now(), body))
+
+	var events []WebhookEvent
+	require.NoError(t, VerifyWebhookSignature(context.TODO(), req, "secret", DefaultWebhookClockSkew, &events))
+	assert.Equal(t, []WebhookEvent{{Type: "nodeCreated"}}, events)
+}
+
+// TestWebhookVectors checks the canonical signed webhook payloads of testdata/webhook_vectors.json,
+// also usable to reproduce webhook issues with 'argotails webhook replay'.
+func TestWebhookVectors(t *testing.T) {
+	raw, err := os.ReadFile("testdata/webhook_vectors.json")
+	require.NoError(t, err)
+	var file struct {
+		Secret  string `json:"secret"`
+		Vectors []struct {
+			Name   string `json:"name"`
+			Header string `json:"header"`
+			Body   string `json:"body"`
+			Events int    `json:"events"`
+			Error  string `json:"error"`
+		} `json:"vectors"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &file))
+	require.NotEmpty(t, file.Vectors)
+
+	errs := map[string]error{"mismatch": ErrWebhookSignatureMismatch, "not_signed": ErrWebhookNotSigned, "invalid": ErrWebhookInvalidSignature}
+	for _, vector := range file.Vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(vector.Body))
+			req.Header.Set(WebhookSignatureHeader, vector.Header)
+
+			// NOTE: the vectors are signed at a fixed time, so the clock skew check is disabled.
+			var events []WebhookEvent
+			err := VerifyWebhookSignature(context.TODO(), req, file.Secret, time.Duration(math.MaxInt64), &events)
+			if vector.Error != "" {
+				require.Contains(t, errs, vector.Error)
+				assert.ErrorIs(t, err, errs[vector.Error])
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, events, vector.Events)
+		})
+	}
+}
+
+func FuzzParseSignatureHeader(f *testing.F) {
+	f.Add("t=1700000000,v1=" + testSignature)
+	f.Add("t=1700000000,t=1700000000,v1=" + testSignature)
+	f.Add("t=,v1=")
+	f.Add("v1=" + testSignature + ",t=1700000000,foo=bar=baz")
+
+	f.Fuzz(func(t *testing.T, header string) {
+		timestamp, signatures, err := parseSignatureHeader(header)
+		if err != nil {
+			return
+		}
+
+		// A successfully parsed header always has a timestamp and valid signatures.
+		assert.False(t, timestamp.IsZero())
+		require.NotEmpty(t, signatures["v1"])
+		for _, signature := range signatures["v1"] {
+			_, err := hex.DecodeString(signature)
+			assert.NoError(t, err)
+			assert.Len(t, signature, signatureLength)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package tailscaletest
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+// DeviceAPI is an in-memory Tailscale device API, to test the code managing the Tailscale devices
+// without any Tailscale API server.
+type DeviceAPI struct {
+	mu         sync.Mutex
+	devices    []tailscale.Device
+	attributes map[string]map[string]tailscale.DevicePostureAttributeRequest
+	err        error
+}
+
+var _ tsutils.DeviceAPI = (*DeviceAPI)(nil)
+
+// NewDeviceAPI returns an in-memory device API serving the given devices.
+func NewDeviceAPI(devices ...tailscale.Device) *DeviceAPI {
+	return &DeviceAPI{devices: slices.Clone(devices), attributes: map[string]map[string]tailscale.DevicePostureAttributeRequest{}}
+}
+
+// SetDevices replaces the devices served by the device API.
+func (api *DeviceAPI) SetDevices(devices ...tailscale.Device) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.devices = slices.Clone(devices)
+}
+
+// SetError makes every call fail with the given error (nil to recover), e.g. to simulate a
+// Tailscale API outage.
+func (api *DeviceAPI) SetError(err error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.err = err
+}
+
+// Attributes returns the posture attributes set on the device with the given ID, by key.
+func (api *DeviceAPI) Attributes(deviceID string) map[string]tailscale.DevicePostureAttributeRequest {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return maps.Clone(api.attributes[deviceID])
+}
+
+// List returns the devices; the options are ignored as the devices are served with all their
+// fields.
+func (api *DeviceAPI) List(_ context.Context, _ ...tailscale.ListDevicesOptions) ([]tailscale.Device, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.err != nil {
+		return nil, api.err
+	}
+	return slices.Clone(api.devices), nil
+}
+
+// Get returns the device with the given ID.
+func (api *DeviceAPI) Get(_ context.Context, deviceID string) (*tailscale.Device, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.err != nil {
+		return nil, api.err
+	}
+	for _, device := range api.devices {
+		if device.NodeID == deviceID || device.ID == deviceID {
+			return &device, nil
+		}
+	}
+	return nil, fmt.Errorf("device %q not found", deviceID)
+}
+
+// SetAttributes records the given posture attributes of the device with the given ID.
+func (api *DeviceAPI) SetAttributes(_ context.Context, deviceID string, attributes map[string]tailscale.DevicePostureAttributeRequest) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.err != nil {
+		return api.err
+	}
+	if !slices.ContainsFunc(api.devices, func(device tailscale.Device) bool { return device.NodeID == deviceID || device.ID == deviceID }) {
+		return fmt.Errorf("device %q not found", deviceID)
+	}
+	if api.attributes[deviceID] == nil {
+		api.attributes[deviceID] = map[string]tailscale.DevicePostureAttributeRequest{}
+	}
+	maps.Copy(api.attributes[deviceID], attributes)
+	return nil
+}
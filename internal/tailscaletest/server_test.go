@@ -0,0 +1,69 @@
+package tailscaletest_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+	"github.com/chezmoidotsh/argotails/internal/tailscaletest"
+)
+
+func TestServer_Devices(t *testing.T) {
+	srv := tailscaletest.NewServer("example.ts.net",
+		tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Tags: []string{"tag:prod"}, EnabledRoutes: []string{"10.0.0.0/24"}},
+		tailscale.Device{NodeID: "n2", Name: "b.example.ts.net", Tags: []string{"tag:dev"}},
+	)
+	defer srv.Close()
+	ts, err := srv.Client()
+	require.NoError(t, err)
+
+	devices, err := ts.Devices().List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	assert.Empty(t, devices[0].EnabledRoutes, "routes are only listed with all fields")
+
+	devices, err = ts.Devices().List(context.Background(), tailscale.WithFields(tailscale.IncludeFieldsAll), tailscale.WithFilter("tags", []string{"tag:prod"}))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, []string{"10.0.0.0/24"}, devices[0].EnabledRoutes)
+
+	srv.SetFailure(http.StatusServiceUnavailable)
+	_, err = ts.Devices().List(context.Background())
+	assert.Error(t, err)
+}
+
+func TestServer_Advance(t *testing.T) {
+	srv := tailscaletest.NewServer("example.ts.net")
+	defer srv.Close()
+	srv.Script(
+		tailscaletest.At(2*time.Minute, tailscaletest.RetagDevice("n1", "tag:dev")),
+		tailscaletest.At(1*time.Minute, tailscaletest.AddDevice(tailscale.Device{NodeID: "n1", Name: "a.example.ts.net", Tags: []string{"tag:prod"}})),
+		tailscaletest.At(3*time.Minute, tailscaletest.DeleteDevice("unknown")),
+	)
+
+	require.NoError(t, srv.Advance(90*time.Second))
+	assert.Equal(t, []tailscale.Device{{NodeID: "n1", Name: "a.example.ts.net", Tags: []string{"tag:prod"}}}, srv.Devices())
+
+	require.NoError(t, srv.Advance(time.Minute))
+	assert.Equal(t, []string{"tag:dev"}, srv.Devices()[0].Tags)
+
+	assert.EqualError(t, srv.Advance(time.Minute), `step at 3m0s: device "unknown" not found`)
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`[{"type":"nodeCreated"}]`)
+	req, err := http.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Tailscale-Webhook-Signature", tailscaletest.Sign("secret", time.Now(), body))
+
+	var events []tsutils.WebhookEvent
+	require.NoError(t, tsutils.VerifyWebhookSignature(context.Background(), req, "secret", tsutils.DefaultWebhookClockSkew, &events))
+	assert.Equal(t, "nodeCreated", events[0].Type)
+}
@@ -0,0 +1,45 @@
+//go:build tsnet
+
+package tsnetutils
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"tailscale.com/tsnet"
+)
+
+// Listen joins the tailnet and returns a TLS listener on port 443 of the `<hostname>.<tailnet>.ts.net`
+// domain, using the certificates issued by Tailscale. The returned node must be closed to leave the
+// tailnet.
+func Listen(ctx context.Context, cfg Config) (net.Listener, *Node, error) {
+	srv := &tsnet.Server{
+		Hostname:   cfg.Hostname,
+		Dir:        cfg.StateDir,
+		AuthKey:    cfg.AuthKey,
+		ControlURL: cfg.ControlURL,
+	}
+
+	status, err := srv.Up(ctx)
+	if err != nil {
+		_ = srv.Close()
+		return nil, nil, fmt.Errorf("failed to join the tailnet: %w", err)
+	}
+
+	var ln net.Listener
+	if cfg.Funnel {
+		ln, err = srv.ListenFunnel("tcp", ":443")
+	} else {
+		ln, err = srv.ListenTLS("tcp", ":443")
+	}
+	if err != nil {
+		_ = srv.Close()
+		return nil, nil, fmt.Errorf("failed to listen on the tailnet: %w", err)
+	}
+	node := &Node{Closer: srv}
+	if status.Self != nil {
+		node.ID = string(status.Self.ID)
+	}
+	return ln, node, nil
+}
@@ -0,0 +1,13 @@
+//go:build !tsnet
+
+package tsnetutils
+
+import (
+	"context"
+	"net"
+)
+
+// Listen always fails as Argotails has been built without tsnet support.
+func Listen(context.Context, Config) (net.Listener, *Node, error) {
+	return nil, nil, ErrNotSupported
+}
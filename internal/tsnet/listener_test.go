@@ -0,0 +1,60 @@
+//go:build tsnet
+
+package tsnetutils_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/net/netns"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest/integration"
+	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/logger"
+
+	tsnetutils "github.com/chezmoidotsh/argotails/internal/tsnet"
+)
+
+// startControl starts a local coordination server, with its own DERP relay, the listener can
+// register with.
+func startControl(t *testing.T) string {
+	netns.SetEnabled(false)
+	t.Cleanup(func() { netns.SetEnabled(true) })
+
+	control := &testcontrol.Server{
+		DERPMap:        integration.RunDERPAndSTUN(t, logger.Discard, "127.0.0.1"),
+		DNSConfig:      &tailcfg.DNSConfig{Proxied: true},
+		MagicDNSDomain: "argotails.ts.net",
+		Logf:           logger.Discard,
+	}
+	control.HTTPTestServer = httptest.NewUnstartedServer(control)
+	control.HTTPTestServer.Start()
+	t.Cleanup(control.HTTPTestServer.Close)
+	return control.HTTPTestServer.URL
+}
+
+func TestListen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ln, node, err := tsnetutils.Listen(ctx, tsnetutils.Config{
+		Hostname:   "argotails",
+		StateDir:   t.TempDir(),
+		ControlURL: startControl(t),
+	})
+	require.NoError(t, err)
+	defer node.Close()
+	defer ln.Close()
+
+	// The node ID is the one the Tailscale API reports, e.g. to exclude the node from the registered
+	// devices.
+	assert.NotEmpty(t, node.ID)
+
+	// The listener is bound to the port 443 of the tailnet address of the node.
+	assert.True(t, strings.HasSuffix(ln.Addr().String(), ":443"), "unexpected listener address %q", ln.Addr())
+}
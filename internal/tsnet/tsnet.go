@@ -0,0 +1,33 @@
+// Package tsnetutils allows Argotails to join the tailnet by itself and serve its endpoints over
+// Tailscale, without relying on a public ingress.
+package tsnetutils
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSupported is returned when Argotails has been built without tsnet support.
+var ErrNotSupported = errors.New("argotails was built without tsnet support (rebuild it with '-tags tsnet')")
+
+// Config contains the configuration used to join the tailnet.
+type Config struct {
+	// Hostname is the hostname of the Argotails node on the tailnet.
+	Hostname string
+	// StateDir is the directory where the Tailscale node state is stored.
+	StateDir string
+	// AuthKey is the Tailscale auth key used to register the node.
+	AuthKey string
+	// ControlURL is the URL of the coordination server; empty means the Tailscale control plane.
+	ControlURL string
+	// Funnel exposes the listener publicly through Tailscale Funnel, which is required to receive
+	// webhooks sent by the Tailscale control plane.
+	Funnel bool
+}
+
+// Node is the Argotails node joined to the tailnet, which must be closed to leave it.
+type Node struct {
+	io.Closer
+	// ID is the stable node ID of the node, as the Tailscale API reports it.
+	ID string
+}
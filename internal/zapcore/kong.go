@@ -2,13 +2,88 @@ package zapcoreutils
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/alecthomas/kong"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
 )
 
+// levelNames are the symbolic verbosity levels, mapped to their V-level; errors and warnings are
+// logged above V(0) and are therefore mapped to negative V-levels.
+var levelNames = map[string]int{
+	"error": -2,
+	"warn":  -1,
+	"info":  0,
+	"debug": 2,
+	"trace": 3,
+}
+
+// LevelName returns the symbolic name of the given level, if any.
+func LevelName(level zapcore.Level) (string, bool) {
+	for name, verbosity := range levelNames {
+		if verbosity == int(level)*-1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// nearestLevelName returns the symbolic name of the given level or, without any, the name of the
+// closest more verbose level (e.g. 'debug' for V(1)); the levels beyond 'trace' are named 'trace'.
+func nearestLevelName(level zapcore.Level) string {
+	name, verbosity := "trace", levelNames["trace"]
+	for n, v := range levelNames {
+		if v >= int(level)*-1 && v < verbosity {
+			name, verbosity = n, v
+		}
+	}
+	return name
+}
+
+// levelEncoder adds the symbolic name of their level to the entries as the 'level' field, next to
+// their numeric verbosity.
+type levelEncoder struct {
+	zapcore.Encoder
+}
+
+func (e levelEncoder) Clone() zapcore.Encoder {
+	return levelEncoder{Encoder: e.Encoder.Clone()}
+}
+
+func (e levelEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	return e.Encoder.EncodeEntry(entry, append([]zapcore.Field{zap.String("level", nearestLevelName(entry.Level))}, fields...))
+}
+
+// levelNamesList returns the symbolic verbosity levels, from the least to the most verbose.
+func levelNamesList() string {
+	names := slices.Collect(maps.Keys(levelNames))
+	slices.SortFunc(names, func(a, b string) int { return levelNames[a] - levelNames[b] })
+	return "'" + strings.Join(names, "', '") + "'"
+}
+
+// ParseVerbosity returns the level of the given verbosity: a number between 0 and 128 or one of
+// the symbolic level names.
+func ParseVerbosity(value string) (zapcore.Level, error) {
+	if named, exists := levelNames[strings.ToLower(value)]; exists {
+		return zapcore.Level(named * -1), nil
+	}
+
+	verbosity, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a number between 0 and 128 or one of %s but got '%s'", levelNamesList(), value)
+	}
+	if verbosity < 0 || verbosity > 128 {
+		return 0, fmt.Errorf("must be between 0 and 128")
+	}
+	return zapcore.Level(verbosity * -1), nil
+}
+
 var (
 	// LevelEnablerMapper is a type that controls log level enabled for a logger.
 	LevelEnablerMapper = kong.TypeMapper(reflect.TypeFor[zapcore.LevelEnabler](), kong.MapperFunc(func(ctx *kong.DecodeContext, target reflect.Value) error {
@@ -20,10 +95,12 @@ var (
 		var verbosity int
 		switch v := t.Value.(type) {
 		case string:
-			verbosity, err = strconv.Atoi(v)
+			level, err := ParseVerbosity(v)
 			if err != nil {
 				return err
 			}
+			target.Set(reflect.ValueOf(level))
+			return nil
 		case int:
 			verbosity = v
 		case int8:
@@ -48,8 +125,8 @@ var (
 			return fmt.Errorf("unsupported type %T", t.Value)
 		}
 
-		if verbosity < 1 || verbosity > 128 {
-			return fmt.Errorf("must be between 1 and 127")
+		if verbosity < 0 || verbosity > 128 {
+			return fmt.Errorf("must be between 0 and 128")
 		}
 
 		target.Set(reflect.ValueOf(zapcore.Level(verbosity * -1)))
@@ -66,7 +143,9 @@ var (
 		var encoder zapcore.Encoder
 		switch encoding {
 		case "json":
-			encoder = zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			// NOTE: 'v' is always the numeric verbosity (negative for the warnings and errors), so
+			//       that it can be compared; its symbolic name is logged as 'level'.
+			encoder = levelEncoder{Encoder: zapcore.NewJSONEncoder(zapcore.EncoderConfig{
 				EncodeLevel: func(level zapcore.Level, encoder zapcore.PrimitiveArrayEncoder) {
 					encoder.AppendInt(int(level) * -1)
 				},
@@ -75,11 +154,15 @@ var (
 				MessageKey: "msg",
 				NameKey:    "component",
 				TimeKey:    "time",
-			})
+			})}
 		case "console":
 			encoder = zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
 				EncodeLevel: func(level zapcore.Level, encoder zapcore.PrimitiveArrayEncoder) {
-					encoder.AppendString(fmt.Sprintf("V(%d)", int(level)*-1))
+					if name, exists := LevelName(level); exists {
+						encoder.AppendString(strings.ToUpper(name))
+					} else {
+						encoder.AppendString(fmt.Sprintf("V(%d)", int(level)*-1))
+					}
 				},
 				EncodeTime: zapcore.ISO8601TimeEncoder,
 				LevelKey:   "v",
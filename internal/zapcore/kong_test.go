@@ -0,0 +1,100 @@
+package zapcoreutils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
+)
+
+func TestLevelEnablerMapper(t *testing.T) {
+	var cli struct {
+		Verbosity zapcore.LevelEnabler `name:"v" default:"2"`
+	}
+	parser, err := kong.New(&cli, zapcoreutils.LevelEnablerMapper)
+	require.NoError(t, err)
+
+	for value, expected := range map[string]zapcore.Level{
+		"0":     zapcore.Level(0),
+		"2":     zapcore.Level(-2),
+		"128":   zapcore.Level(-128),
+		"error": zapcore.ErrorLevel,
+		"warn":  zapcore.WarnLevel,
+		"INFO":  zapcore.InfoLevel,
+		"debug": zapcore.Level(-2),
+		"trace": zapcore.Level(-3),
+	} {
+		_, err := parser.Parse([]string{"--v=" + value})
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, cli.Verbosity, value)
+	}
+
+	for _, value := range []string{"-1", "129", "verbose"} {
+		_, err := parser.Parse([]string{"--v=" + value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLevelName(t *testing.T) {
+	name, exists := zapcoreutils.LevelName(zapcore.ErrorLevel)
+	assert.True(t, exists)
+	assert.Equal(t, "error", name)
+
+	name, exists = zapcoreutils.LevelName(zapcore.Level(-3))
+	assert.True(t, exists)
+	assert.Equal(t, "trace", name)
+
+	_, exists = zapcoreutils.LevelName(zapcore.Level(-1))
+	assert.False(t, exists)
+}
+
+func TestRuntimeLevel(t *testing.T) {
+	level := zapcoreutils.NewRuntimeLevel(zapcore.Level(-2))
+	assert.True(t, level.Enabled(zapcore.Level(-2)))
+	assert.False(t, level.Enabled(zapcore.Level(-4)))
+
+	verbose, err := zapcoreutils.ParseVerbosity("4")
+	require.NoError(t, err)
+	level.Set(verbose, 50*time.Millisecond)
+	assert.True(t, level.Enabled(zapcore.Level(-4)))
+	assert.False(t, level.Until().IsZero())
+
+	assert.Eventually(t, func() bool { return level.Level() == zapcore.Level(-2) }, time.Second, 10*time.Millisecond)
+	assert.True(t, level.Until().IsZero())
+
+	// Without duration, the level is kept
+	level.Set(zapcore.ErrorLevel, 0)
+	assert.Equal(t, zapcore.ErrorLevel, level.Level())
+	assert.True(t, level.Until().IsZero())
+
+	_, err = zapcoreutils.ParseVerbosity("verbose")
+	assert.Error(t, err)
+}
+
+func TestEncoderMapper_JSON(t *testing.T) {
+	var cli struct {
+		Format zapcore.Encoder `name:"format" default:"json"`
+	}
+	parser, err := kong.New(&cli, zapcoreutils.EncoderMapper)
+	require.NoError(t, err)
+	_, err = parser.Parse(nil)
+	require.NoError(t, err)
+
+	// The verbosity is always numeric, its symbolic name being logged as the level.
+	for level, expected := range map[zapcore.Level]string{
+		zapcore.ErrorLevel: `"v":-2,"msg":"message","level":"error"`,
+		zapcore.InfoLevel:  `"v":0,"msg":"message","level":"info"`,
+		zapcore.Level(-1):  `"v":1,"msg":"message","level":"debug"`,
+		zapcore.Level(-3):  `"v":3,"msg":"message","level":"trace"`,
+		zapcore.Level(-5):  `"v":5,"msg":"message","level":"trace"`,
+	} {
+		buf, err := cli.Format.Clone().EncodeEntry(zapcore.Entry{Level: level, Message: "message"}, nil)
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), expected, level)
+	}
+}
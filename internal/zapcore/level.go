@@ -0,0 +1,64 @@
+package zapcoreutils
+
+import (
+	"sync"
+	"time"
+
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RuntimeLevel is a log level that can be changed at runtime (e.g. to log at V(4) during an
+// incident), optionally for a limited time after which the initial level is restored.
+type RuntimeLevel struct {
+	level   uberzap.AtomicLevel
+	initial zapcore.Level
+
+	mu     sync.Mutex
+	revert *time.Timer
+	until  time.Time
+}
+
+// NewRuntimeLevel creates a runtime level starting at the given level.
+func NewRuntimeLevel(initial zapcore.Level) *RuntimeLevel {
+	return &RuntimeLevel{level: uberzap.NewAtomicLevelAt(initial), initial: initial}
+}
+
+// Enabled returns true if the given level is enabled.
+func (l *RuntimeLevel) Enabled(level zapcore.Level) bool { return l.level.Enabled(level) }
+
+// Level returns the current level.
+func (l *RuntimeLevel) Level() zapcore.Level { return l.level.Level() }
+
+// Until returns when the initial level is restored (zero if the current level is kept).
+func (l *RuntimeLevel) Until() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.until
+}
+
+// Set changes the level; if duration is positive, the initial level is restored after it.
+func (l *RuntimeLevel) Set(level zapcore.Level, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert, l.until = nil, time.Time{}
+	}
+	l.level.SetLevel(level)
+	if duration > 0 {
+		l.until = time.Now().Add(duration)
+		var revert *time.Timer
+		revert = time.AfterFunc(duration, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			// NOTE: the timer may fire while being replaced by a newer Set call.
+			if l.revert == revert {
+				l.level.SetLevel(l.initial)
+				l.revert, l.until = nil, time.Time{}
+			}
+		})
+		l.revert = revert
+	}
+}
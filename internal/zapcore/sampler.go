@@ -0,0 +1,69 @@
+package zapcoreutils
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// verboseSampler is a core sampling the verbose entries (more verbose than V(0)) only; errors,
+	// warnings and V(0) entries are always logged. Unlike the zap sampler, it supports every V-level.
+	verboseSampler struct {
+		zapcore.Core
+		counter *sampleCounter
+	}
+
+	// sampleCounter counts the entries logged per level and message during the current tick.
+	sampleCounter struct {
+		mu         sync.Mutex
+		tick       time.Duration
+		first      int
+		thereafter int
+		reset      time.Time
+		counts     map[sampleKey]int
+	}
+
+	sampleKey struct {
+		level   zapcore.Level
+		message string
+	}
+)
+
+// NewVerboseSampler wraps the given core to rate limit its verbose entries per message: every
+// tick, only the first entries sharing the same level and message are logged, then every
+// thereafter-th one (none if thereafter is 0).
+func NewVerboseSampler(core zapcore.Core, tick time.Duration, first, thereafter int) zapcore.Core {
+	return &verboseSampler{
+		Core:    core,
+		counter: &sampleCounter{tick: tick, first: first, thereafter: thereafter, counts: map[sampleKey]int{}},
+	}
+}
+
+func (s *verboseSampler) With(fields []zapcore.Field) zapcore.Core {
+	return &verboseSampler{Core: s.Core.With(fields), counter: s.counter}
+}
+
+func (s *verboseSampler) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < zapcore.InfoLevel && s.Enabled(entry.Level) && !s.counter.sample(entry) {
+		return checked
+	}
+	return s.Core.Check(entry, checked)
+}
+
+// sample returns true if the given entry must be logged.
+func (c *sampleCounter) sample(entry zapcore.Entry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.Time.Sub(c.reset) >= c.tick {
+		c.reset = entry.Time
+		clear(c.counts)
+	}
+
+	key := sampleKey{level: entry.Level, message: entry.Message}
+	c.counts[key]++
+	n := c.counts[key]
+	return n <= c.first || (c.thereafter > 0 && (n-c.first)%c.thereafter == 0)
+}
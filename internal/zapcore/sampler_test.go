@@ -0,0 +1,29 @@
+package zapcoreutils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	zapcoreutils "github.com/chezmoidotsh/argotails/internal/zapcore"
+)
+
+func TestVerboseSampler(t *testing.T) {
+	core, logs := observer.New(zapcore.Level(-3))
+	log := zap.New(zapcoreutils.NewVerboseSampler(core, time.Minute, 2, 5)).With(zap.String("component", "test"))
+
+	for range 12 {
+		log.Log(zapcore.Level(-3), "Reconciling device")
+		log.Info("Synchronized")
+	}
+	log.Log(zapcore.Level(-3), "Listing devices")
+
+	assert.Equal(t, 4, logs.FilterMessage("Reconciling device").Len(), "first 2, then every 5th")
+	assert.Equal(t, 12, logs.FilterMessage("Synchronized").Len(), "V(0) entries are never sampled")
+	assert.Equal(t, 1, logs.FilterMessage("Listing devices").Len(), "messages are sampled independently")
+	assert.Equal(t, "test", logs.All()[0].ContextMap()["component"])
+}
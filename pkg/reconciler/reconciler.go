@@ -0,0 +1,64 @@
+// Package reconciler exposes the Argotails reconciler to programs embedding it, e.g. to inject
+// organization-specific metadata through hooks:
+//
+//	type annotate struct{ reconciler.NopHooks }
+//
+//	func (annotate) OnBeforeCreate(_ context.Context, _ tailscale.Device, secret *corev1.Secret) error {
+//		secret.Annotations["example.com/owner"] = "platform"
+//		return nil
+//	}
+//
+//	r, err := reconciler.NewReconciler(ks, reconciler.NewDeviceAPI(ts), filter, "argotails", reconciler.ServiceConfig{Namespace: "argocd"},
+//		reconciler.WithHooks(annotate{}),
+//	)
+package reconciler
+
+import (
+	internal "github.com/chezmoidotsh/argotails/internal/reconciler"
+	tsutils "github.com/chezmoidotsh/argotails/internal/tailscale"
+)
+
+type (
+	// Option configures optional behaviors of the reconciler.
+	Option = internal.Option
+	// ServiceConfig contains the service creation configuration.
+	ServiceConfig = internal.ServiceConfig
+	// Hooks lets embedders mutate or veto the secrets written by the reconciler.
+	Hooks = internal.Hooks
+	// NopHooks implements Hooks without doing anything; it can be embedded to only implement some hooks.
+	NopHooks = internal.NopHooks
+	// SecretNamer returns the name of the secret of a Tailscale device.
+	SecretNamer = internal.SecretNamer
+
+	// DeviceAPI is the Tailscale device API used by the reconciler; it can be implemented to
+	// reconcile the devices of another control server (e.g. Headscale).
+	DeviceAPI = tsutils.DeviceAPI
+	// TagFilter selects the Tailscale devices managed by the reconciler.
+	TagFilter = tsutils.TagFilter
+	// FuncTagFilter is a function implementing the TagFilter interface.
+	FuncTagFilter = tsutils.FuncTagFilter
+)
+
+var (
+	// NewReconciler returns the reconciler of the secrets of the Tailscale devices.
+	NewReconciler = internal.NewReconciler
+	// NewDeviceAPI returns the DeviceAPI of a Tailscale client.
+	NewDeviceAPI = tsutils.NewDeviceAPI
+	// NewRegexpTagFilter returns a TagFilter matching the devices having a tag matching one of the patterns.
+	NewRegexpTagFilter = tsutils.NewRegexpTagFilter
+	// NewSecretNamer returns the SecretNamer of the given naming strategy.
+	NewSecretNamer = internal.NewSecretNamer
+
+	// WithHooks registers hooks called around the secret operations, in the given order.
+	WithHooks = internal.WithHooks
+	// WithApproval creates new secrets pending approval.
+	WithApproval = internal.WithApproval
+	// WithSecretNamer configures the naming strategy of the secrets.
+	WithSecretNamer = internal.WithSecretNamer
+	// WithFlavor configures the kind of secret generated for each device.
+	WithFlavor = internal.WithFlavor
+	// WithAPIReader configures the reader of the objects not visible through the cache.
+	WithAPIReader = internal.WithAPIReader
+	// WithEventRecorder reports noteworthy situations as Kubernetes events.
+	WithEventRecorder = internal.WithEventRecorder
+)